@@ -17,9 +17,11 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"path"
@@ -27,9 +29,12 @@ import (
 	"runtime"
 	"strings"
 
+	crv1 "git.openstack.org/openstack/stackube/pkg/apis/v1"
+	crdClient "git.openstack.org/openstack/stackube/pkg/kubecrd"
 	"git.openstack.org/openstack/stackube/pkg/kubestack/plugins"
 	kubestacktypes "git.openstack.org/openstack/stackube/pkg/kubestack/types"
 	"git.openstack.org/openstack/stackube/pkg/openstack"
+	drivertypes "git.openstack.org/openstack/stackube/pkg/openstack/types"
 	"git.openstack.org/openstack/stackube/pkg/util"
 
 	"github.com/containernetworking/cni/pkg/skel"
@@ -38,6 +43,13 @@ import (
 	cniSpecVersion "github.com/containernetworking/cni/pkg/version"
 	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/golang/glog"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
 
 	// import plugins
 	_ "git.openstack.org/openstack/stackube/pkg/kubestack/plugins/openvswitch"
@@ -52,8 +64,9 @@ var (
 
 // OpenStack describes openstack client and its plugins.
 type OpenStack struct {
-	Client openstack.Interface
-	Plugin plugins.PluginInterface
+	Client     openstack.Interface
+	Plugin     plugins.PluginInterface
+	KubeClient kubernetes.Interface
 }
 
 func init() {
@@ -71,17 +84,278 @@ func loadNetConf(bytes []byte) (*kubestacktypes.NetConf, string, error) {
 	return n, n.CNIVersion, nil
 }
 
-func (os *OpenStack) getNetworkIDByNamespace(namespace string) (string, error) {
-	// Only support one network and network's name is same with namespace.
-	// TODO: make it general after multi-network is supported.
-	networkName := util.BuildNetworkName(namespace, namespace)
-	network, err := os.Client.GetNetworkByName(networkName)
+// getNetworkForPod gets the network which pod is placed in.
+func (os *OpenStack) getNetworkForPod(pod *v1.Pod) (*drivertypes.Network, error) {
+	name, err := crdClient.GetPodNetworkName(os.Client.GetCRDClient(), pod.Namespace, pod.Annotations)
 	if err != nil {
-		glog.Errorf("Get network by name %q failed: %v", networkName, err)
-		return "", err
+		glog.Errorf("Get network name of pod %s/%s failed: %v", pod.Namespace, pod.Name, err)
+		return nil, err
 	}
 
-	return network.Uid, nil
+	network, err := openstack.GetNetworkByCRDName(os.Client, pod.Namespace, name)
+	if err != nil {
+		glog.Errorf("Get network %s/%s failed: %v", pod.Namespace, name, err)
+		return nil, err
+	}
+
+	return network, nil
+}
+
+// getSubnetIDForPod gets the ID of subnet selected by pod's annotation.
+// Empty subnetID is returned if pod doesn't select any subnet.
+func getSubnetIDForPod(network *drivertypes.Network, pod *v1.Pod) (string, error) {
+	name, ok := pod.Annotations[util.SubnetAnnotation]
+	if !ok || name == "" {
+		return "", nil
+	}
+
+	subnetName := util.BuildSubnetName(network.Name, name)
+	for _, subnet := range network.Subnets {
+		if subnet.Name == subnetName {
+			return subnet.Uid, nil
+		}
+	}
+
+	return "", fmt.Errorf("subnet %q of pod %s/%s not found in network %s", name, pod.Namespace, pod.Name, network.Name)
+}
+
+// getSubnetIDForIP gets the ID of subnet in network whose CIDR contains ip.
+func getSubnetIDForIP(network *drivertypes.Network, ip net.IP) (string, error) {
+	for _, subnet := range network.Subnets {
+		_, ipNet, err := net.ParseCIDR(subnet.Cidr)
+		if err == nil && ipNet.Contains(ip) {
+			return subnet.Uid, nil
+		}
+	}
+
+	return "", fmt.Errorf("IP address %s is not in any subnet of network %s", ip, network.Name)
+}
+
+// getIPRangeForPod gets the IP range requested by pod's annotation from the
+// Network CRD object which pod is placed in. Nil is returned if pod doesn't
+// request any.
+func (os *OpenStack) getIPRangeForPod(pod *v1.Pod) (*crv1.IPRange, error) {
+	name, ok := pod.Annotations[util.IPRangeAnnotation]
+	if !ok || name == "" {
+		return nil, nil
+	}
+
+	networkName, err := crdClient.GetPodNetworkName(os.Client.GetCRDClient(), pod.Namespace, pod.Annotations)
+	if err != nil {
+		return nil, err
+	}
+	kubeNetwork, err := os.Client.GetCRDClient().GetNetwork(pod.Namespace, networkName)
+	if err != nil {
+		return nil, fmt.Errorf("get network %s/%s failed: %v", pod.Namespace, networkName, err)
+	}
+	for i := range kubeNetwork.Spec.IPRanges {
+		if kubeNetwork.Spec.IPRanges[i].Name == name {
+			return &kubeNetwork.Spec.IPRanges[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("IP range %q of pod %s/%s not found in network %s", name, pod.Namespace, pod.Name, networkName)
+}
+
+// getRequestedIPForPod gets the IP address or IP range requested by pod's
+// annotations, and the subnet which the address is allocated from. subnetID
+// is the one selected by pod, the requested address must be in it if it is
+// not empty.
+func (os *OpenStack) getRequestedIPForPod(network *drivertypes.Network, subnetID string, pod *v1.Pod) (string, string, *crv1.IPRange, error) {
+	ipAddress := pod.Annotations[util.IPAnnotation]
+	ipRange, err := os.getIPRangeForPod(pod)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	var ip net.IP
+	switch {
+	case ipAddress != "" && ipRange != nil:
+		return "", "", nil, fmt.Errorf("annotations %s and %s can not be both set", util.IPAnnotation, util.IPRangeAnnotation)
+	case ipAddress != "":
+		if ip = net.ParseIP(ipAddress); ip == nil {
+			return "", "", nil, fmt.Errorf("invalid IP address %q requested by pod %s/%s", ipAddress, pod.Namespace, pod.Name)
+		}
+	case ipRange != nil:
+		ip = net.ParseIP(ipRange.Start)
+	default:
+		return subnetID, "", nil, nil
+	}
+
+	ipSubnetID, err := getSubnetIDForIP(network, ip)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if subnetID != "" && subnetID != ipSubnetID {
+		return "", "", nil, fmt.Errorf("requested IP address %s is not in subnet %q selected by pod %s/%s",
+			ip, pod.Annotations[util.SubnetAnnotation], pod.Namespace, pod.Name)
+	}
+
+	return ipSubnetID, ipAddress, ipRange, nil
+}
+
+// getPortSecurityForPod gets allowed address pairs and port security requested
+// by pod. They weaken the anti-spoofing of Neutron, so only pods of tenants
+// allowing port security override could request them.
+func (os *OpenStack) getPortSecurityForPod(pod *v1.Pod) ([]ports.AddressPair, bool, error) {
+	var allowedAddressPairs []ports.AddressPair
+	for _, address := range strings.Split(pod.Annotations[util.AllowedAddressPairsAnnotation], ",") {
+		address = strings.TrimSpace(address)
+		if address == "" {
+			continue
+		}
+		if net.ParseIP(address) == nil {
+			if _, _, err := net.ParseCIDR(address); err != nil {
+				return nil, false, fmt.Errorf("invalid allowed address pair %q of pod %s/%s", address, pod.Namespace, pod.Name)
+			}
+		}
+		allowedAddressPairs = append(allowedAddressPairs, ports.AddressPair{IPAddress: address})
+	}
+	portSecurityDisabled := util.IsPortSecurityDisabled(pod.Annotations)
+	if len(allowedAddressPairs) == 0 && !portSecurityDisabled {
+		return nil, false, nil
+	}
+	if len(allowedAddressPairs) > 0 && portSecurityDisabled {
+		return nil, false, fmt.Errorf("allowed address pairs of pod %s/%s require port security", pod.Namespace, pod.Name)
+	}
+
+	tenantName := pod.Namespace
+	if util.IsSystemNamespace(tenantName) {
+		tenantName = util.SystemTenant
+	}
+	tenant, err := os.Client.GetCRDClient().GetTenant(tenantName)
+	if err != nil {
+		return nil, false, fmt.Errorf("get tenant %s failed: %v", tenantName, err)
+	}
+	if !tenant.Spec.AllowPortSecurityOverride {
+		return nil, false, fmt.Errorf("tenant %s doesn't allow pod %s/%s to override port security by annotations %s or %s",
+			tenantName, pod.Namespace, pod.Name, util.AllowedAddressPairsAnnotation, util.PortSecurityAnnotation)
+	}
+
+	return allowedAddressPairs, portSecurityDisabled, nil
+}
+
+// getBandwidthLimitForPod gets bandwidth limit requested by pod, nil is
+// returned if the bandwidth is not limited.
+func getBandwidthLimitForPod(pod *v1.Pod) (*drivertypes.BandwidthLimit, error) {
+	ingressKbps, err := parseBandwidth(pod, util.IngressBandwidthAnnotation)
+	if err != nil {
+		return nil, err
+	}
+	egressKbps, err := parseBandwidth(pod, util.EgressBandwidthAnnotation)
+	if err != nil {
+		return nil, err
+	}
+	if ingressKbps == 0 && egressKbps == 0 {
+		return nil, nil
+	}
+
+	return &drivertypes.BandwidthLimit{
+		IngressKbps: ingressKbps,
+		EgressKbps:  egressKbps,
+	}, nil
+}
+
+// parseBandwidth parses the bandwidth annotation of pod in bits per second to
+// kbps, zero is returned if it is not set.
+func parseBandwidth(pod *v1.Pod, annotation string) (int64, error) {
+	value, ok := pod.Annotations[annotation]
+	if !ok {
+		return 0, nil
+	}
+
+	bandwidth, err := resource.ParseQuantity(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q of pod %s/%s: %v", annotation, value, pod.Namespace, pod.Name, err)
+	}
+	kbps := bandwidth.Value() / 1000
+	if kbps < 1 {
+		return 0, fmt.Errorf("%s %q of pod %s/%s is less than 1k", annotation, value, pod.Namespace, pod.Name)
+	}
+	return kbps, nil
+}
+
+// ipInRange checks whether ip is in the IP range.
+func ipInRange(ip net.IP, ipRange *crv1.IPRange) bool {
+	start, end := net.ParseIP(ipRange.Start), net.ParseIP(ipRange.End)
+	if ip == nil || start == nil || end == nil {
+		return false
+	}
+	return bytes.Compare(ip.To16(), start.To16()) >= 0 && bytes.Compare(ip.To16(), end.To16()) <= 0
+}
+
+// nextIP returns the IP address next to ip.
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip.To16()))
+	copy(next, ip.To16())
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// buildIPConfigs builds IP configs for all fixed IPs of the port.
+func buildIPConfigs(client openstack.Interface, port *ports.Port) ([]plugins.IPConfig, []*current.IPConfig, error) {
+	var ips []plugins.IPConfig
+	var ipConfigs []*current.IPConfig
+
+	subnets := make(map[string]*drivertypes.Subnet)
+	for _, fixedIP := range port.FixedIPs {
+		subnet, ok := subnets[fixedIP.SubnetID]
+		if !ok {
+			var err error
+			subnet, err = client.GetProviderSubnet(fixedIP.SubnetID)
+			if err != nil {
+				glog.Errorf("Get info of subnet %s failed: %v", fixedIP.SubnetID, err)
+				return nil, nil, err
+			}
+			subnets[fixedIP.SubnetID] = subnet
+		}
+
+		_, cidr, err := net.ParseCIDR(subnet.Cidr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid CIDR %q of subnet %s: %v", subnet.Cidr, subnet.Uid, err)
+		}
+		ip := net.ParseIP(fixedIP.IPAddress)
+		if ip == nil {
+			return nil, nil, fmt.Errorf("invalid IP %q of port %s", fixedIP.IPAddress, port.ID)
+		}
+
+		version := "4"
+		if ip.To4() == nil {
+			version = "6"
+		}
+		prefixSize, _ := cidr.Mask.Size()
+		ipConfig := plugins.IPConfig{
+			Address: fmt.Sprintf("%s/%d", ip.String(), prefixSize),
+			Gateway: subnet.Gateway,
+			IPv6:    version == "6",
+		}
+		for _, route := range subnet.Routes {
+			ipConfig.Routes = append(ipConfig.Routes, plugins.Route{
+				Destination: route.DestinationCIDR,
+				Nexthop:     route.Nexthop,
+			})
+		}
+		ips = append(ips, ipConfig)
+		ipConfigs = append(ipConfigs, &current.IPConfig{
+			Version: version,
+			Address: net.IPNet{
+				IP:   ip,
+				Mask: cidr.Mask,
+			},
+			Gateway: net.ParseIP(subnet.Gateway),
+		})
+	}
+
+	if len(ips) == 0 {
+		return nil, nil, fmt.Errorf("port %s has no fixed IPs", port.ID)
+	}
+
+	return ips, ipConfigs, nil
 }
 
 func getHostName() string {
@@ -121,8 +395,19 @@ func initOpenstack(stdinData []byte) (OpenStack, string, error) {
 		return OpenStack{}, "", err
 	}
 
+	// Init kubernetes client
+	k8sConfig, err := util.NewClusterConfig(n.KubernetesConfig)
+	if err != nil {
+		return OpenStack{}, "", fmt.Errorf("failed to build kubeconfig: %v", err)
+	}
+	kubeClient, err := kubernetes.NewForConfig(k8sConfig)
+	if err != nil {
+		return OpenStack{}, "", fmt.Errorf("failed to create kubernetes client: %v", err)
+	}
+
 	os := OpenStack{
-		Client: openStackClient,
+		Client:     openStackClient,
+		KubeClient: kubeClient,
 	}
 
 	// Init plugin
@@ -144,141 +429,532 @@ func initOpenstack(stdinData []byte) (OpenStack, string, error) {
 	return os, cniVersion, nil
 }
 
-func cmdAdd(args *skel.CmdArgs) error {
-	osClient, cniVersion, err := initOpenstack(args.StdinData)
+// podInterface describes an interface of pod, which is attached to a Neutron port.
+type podInterface struct {
+	network  *drivertypes.Network
+	subnetID string
+	// ipAddress or ipRange is requested by pod, they are only set for the
+	// primary interface.
+	ipAddress string
+	ipRange   *crv1.IPRange
+	// allowedAddressPairs, portSecurityDisabled and bandwidthLimit are
+	// requested by pod, they are only set for the primary interface.
+	allowedAddressPairs  []ports.AddressPair
+	portSecurityDisabled bool
+	bandwidthLimit       *drivertypes.BandwidthLimit
+	portName             string
+	ifName               string
+	// primary is true if it is the interface for pod's default route.
+	primary bool
+}
+
+// portOptions builds options of the interface's port with ipAddress.
+func (iface *podInterface) portOptions(ipAddress string) *openstack.PortOptions {
+	return &openstack.PortOptions{
+		SubnetID:             iface.subnetID,
+		IPAddress:            ipAddress,
+		AllowedAddressPairs:  iface.allowedAddressPairs,
+		PortSecurityDisabled: iface.portSecurityDisabled,
+	}
+}
+
+// getPodInterfaces gets all interfaces of the pod. The primary interface is
+// placed in the network selected by pod, and additional interfaces are placed
+// in networks listed by annotation stackube.kubernetes.io/networks.
+func (os *OpenStack) getPodInterfaces(pod *v1.Pod, ifName string) ([]*podInterface, error) {
+	// Get network selected by pod
+	network, err := os.getNetworkForPod(pod)
 	if err != nil {
-		glog.Errorf("Init OpenStack failed: %v", err)
-		return err
+		glog.Errorf("Get network failed: %v", err)
+		return nil, err
 	}
 
-	// Get k8s args
-	podName, podNamespace, err := getK8sArgs(args.Args)
+	// Get subnet selected by pod
+	subnetID, err := getSubnetIDForPod(network, pod)
 	if err != nil {
-		glog.Errorf("GetK8sArgs failed: %v", err)
-		return err
+		glog.Errorf("Get subnet failed: %v", err)
+		return nil, err
 	}
 
-	// Get tenantID
-	tenantID, err := osClient.Client.GetTenantIDFromName(podNamespace)
+	// Get IP address requested by pod
+	subnetID, ipAddress, ipRange, err := os.getRequestedIPForPod(network, subnetID, pod)
 	if err != nil {
-		glog.Errorf("Get tenantID failed: %v", err)
-		return err
+		glog.Errorf("Get requested IP address failed: %v", err)
+		return nil, err
 	}
 
-	// Get networkID
-	networkID, err := osClient.getNetworkIDByNamespace(podNamespace)
+	// Get port security requested by pod
+	allowedAddressPairs, portSecurityDisabled, err := os.getPortSecurityForPod(pod)
 	if err != nil {
-		glog.Errorf("Get networkID failed: %v", err)
-		return err
+		glog.Errorf("Get port security failed: %v", err)
+		return nil, err
 	}
 
-	// Build port name
-	portName := util.BuildPortName(podNamespace, podName)
-	podFullName := util.BuildFullPodName(podNamespace, podName)
+	// Get bandwidth limit requested by pod
+	bandwidthLimit, err := getBandwidthLimitForPod(pod)
+	if err != nil {
+		glog.Errorf("Get bandwidth limit failed: %v", err)
+		return nil, err
+	}
 
-	// Get port from openstack.
-	port, err := osClient.Client.GetPort(portName)
-	if err == util.ErrNotFound || port == nil {
-		// Port not found, create a new one.
-		portWithBinding, err := osClient.Client.CreatePort(networkID, tenantID, portName)
+	interfaces := []*podInterface{{
+		network:              network,
+		subnetID:             subnetID,
+		ipAddress:            ipAddress,
+		ipRange:              ipRange,
+		allowedAddressPairs:  allowedAddressPairs,
+		portSecurityDisabled: portSecurityDisabled,
+		bandwidthLimit:       bandwidthLimit,
+		portName:             util.BuildPortName(pod.Namespace, pod.Name),
+		ifName:               ifName,
+		primary:              true,
+	}}
+
+	for i, name := range util.ParseNetworksAnnotation(pod.Annotations) {
+		network, err := openstack.GetNetworkByCRDName(os.Client, pod.Namespace, name)
 		if err != nil {
-			glog.Errorf("CreatePort failed: %v", err)
-			return err
+			glog.Errorf("Get network %s/%s failed: %v", pod.Namespace, name, err)
+			return nil, err
 		}
-		port = &portWithBinding.Port
-	} else if err != nil {
+
+		secondaryIfName := util.BuildSecondaryIfName(i + 1)
+		interfaces = append(interfaces, &podInterface{
+			network:  network,
+			portName: util.BuildSecondaryPortName(pod.Namespace, pod.Name, secondaryIfName),
+			ifName:   secondaryIfName,
+		})
+	}
+
+	return interfaces, nil
+}
+
+// ensurePort gets port of the interface, a new one is created if not found.
+// It also reports whether the port is created by it.
+func (os *OpenStack) ensurePort(iface *podInterface, tenantID string) (*openstack.Port, bool, error) {
+	port, err := os.Client.GetPort(iface.portName)
+	if err != nil && err != util.ErrNotFound {
+		// Other errors may be transient, creating a port then would make a
+		// duplicate one.
 		glog.Errorf("GetPort failed: %v", err)
-		return err
+		return nil, false, err
+	}
+	if err == nil && !portHasRequestedIP(&port.Port, iface) {
+		// The requested address is changed, the port is created again.
+		glog.V(4).Infof("Port %s doesn't have the requested IP address, deleting it", iface.portName)
+		if err := os.Client.DeletePortByID(port.ID); err != nil {
+			glog.Errorf("Delete port %s failed: %v", iface.portName, err)
+			return nil, false, err
+		}
+		err = util.ErrNotFound
 	}
-	defer func() {
+	created := false
+	if err == util.ErrNotFound {
+		// Port not found, create a new one.
+		created = true
+		port, err = os.createPort(iface, tenantID)
 		if err != nil {
-			if osClient.Client.DeletePortByID(port.ID) != nil {
-				glog.Warningf("Delete port %s failed", port.ID)
-			}
+			glog.Errorf("CreatePort failed: %v", err)
+			return nil, false, err
 		}
-	}()
+	} else if err := os.ensurePortSecurity(&port.Port, iface, tenantID); err != nil {
+		glog.Errorf("Update port security of port %s failed: %v", iface.portName, err)
+		return port, created, err
+	}
 
 	deviceOwner := fmt.Sprintf("compute:%s", getHostName())
 	if port.DeviceOwner != deviceOwner {
-		err := osClient.Client.UpdatePortsBinding(port.ID, deviceOwner)
+		err := os.Client.UpdatePortsBinding(port.ID, deviceOwner)
+		if err != nil {
+			glog.Errorf("Update port %s failed: %v", iface.portName, err)
+			return port, created, err
+		}
+		port.DeviceOwner = deviceOwner
+	}
+
+	// Bandwidth of the pod is limited by the QoS policy of its primary port,
+	// which is named after the port. A new port has no policy to remove if
+	// no limit is requested, while a retained port may still have one.
+	if iface.primary && (iface.bandwidthLimit != nil || !created) {
+		err := os.Client.EnsurePortQoSPolicy(port.ID, tenantID, iface.portName, iface.bandwidthLimit)
+		if err != nil {
+			glog.Errorf("Update QoS policy of port %s failed: %v", iface.portName, err)
+			return port, created, err
+		}
+	}
+
+	return port, created, nil
+}
+
+// cleanupPort undoes ensurePort of the interface when the pod fails to be set
+// up. Ports created by ensurePort are deleted along with their QoS policies,
+// while reused ones are released the same as teardown, so that retained ports
+// of sticky pods are kept.
+func (os *OpenStack) cleanupPort(iface *podInterface, port *openstack.Port, created bool, podNamespace, podName string) error {
+	sticky := false
+	if created {
+		if err := os.Client.DeletePortByID(port.ID); err != nil {
+			return err
+		}
+	} else {
+		var err error
+		sticky, err = os.isStickyPod(podNamespace, podName)
 		if err != nil {
-			glog.Errorf("Update port %s failed: %v", portName, err)
 			return err
 		}
+		if err := os.releasePort(&port.Port, sticky); err != nil {
+			return err
+		}
+	}
+
+	// The QoS policy is named after the primary port, and kept with it.
+	if iface.primary && !sticky {
+		return os.Client.DeleteQoSPolicy(iface.portName)
+	}
+	return nil
+}
+
+// createPort creates port of the interface with the IP address requested by pod.
+func (os *OpenStack) createPort(iface *podInterface, tenantID string) (*openstack.Port, error) {
+	if iface.ipRange == nil {
+		port, err := os.Client.CreatePort(iface.network.Uid, tenantID, iface.portName, iface.portOptions(iface.ipAddress))
+		if err == openstack.ErrIPAddressInUse {
+			return nil, fmt.Errorf("requested IP address %s is already in use in network %s", iface.ipAddress, iface.network.Name)
+		} else if err != nil {
+			return nil, err
+		}
+		return port, nil
+	}
+
+	// Try free addresses of the range in order. Addresses may still be taken
+	// by others in the meantime, the next one is tried then.
+	portList, err := os.Client.ListPorts(iface.network.Uid, "")
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[string]bool)
+	for _, port := range portList {
+		for _, fixedIP := range port.FixedIPs {
+			used[net.ParseIP(fixedIP.IPAddress).String()] = true
+		}
+	}
+	for ip := net.ParseIP(iface.ipRange.Start); ipInRange(ip, iface.ipRange); ip = nextIP(ip) {
+		if used[ip.String()] {
+			continue
+		}
+		port, err := os.Client.CreatePort(iface.network.Uid, tenantID, iface.portName, iface.portOptions(ip.String()))
+		if err == openstack.ErrIPAddressInUse {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return port, nil
+	}
+
+	return nil, fmt.Errorf("no free IP address in range %q (%s-%s) of network %s",
+		iface.ipRange.Name, iface.ipRange.Start, iface.ipRange.End, iface.network.Name)
+}
+
+// ensurePortSecurity makes allowed address pairs and port security of an
+// existing port match the ones requested by pod.
+func (os *OpenStack) ensurePortSecurity(port *ports.Port, iface *podInterface, tenantID string) error {
+	enabled, err := os.Client.GetPortSecurityEnabled(port.ID)
+	if err != nil {
+		return err
+	}
+
+	existingPairs := sets.NewString()
+	for _, pair := range port.AllowedAddressPairs {
+		existingPairs.Insert(pair.IPAddress)
+	}
+	desiredPairs := sets.NewString()
+	for _, pair := range iface.allowedAddressPairs {
+		desiredPairs.Insert(pair.IPAddress)
+	}
+	if enabled != iface.portSecurityDisabled && existingPairs.Equal(desiredPairs) {
+		return nil
+	}
+
+	glog.V(4).Infof("Updating port security of port %s to allowed address pairs %v, port security disabled %v",
+		iface.portName, desiredPairs.List(), iface.portSecurityDisabled)
+	return os.Client.UpdatePortSecurity(port.ID, tenantID, iface.portOptions(""))
+}
+
+// portHasRequestedIP checks whether port has the IP address requested by pod.
+func portHasRequestedIP(port *ports.Port, iface *podInterface) bool {
+	if iface.ipAddress == "" && iface.ipRange == nil {
+		return true
+	}
+
+	for _, fixedIP := range port.FixedIPs {
+		ip := net.ParseIP(fixedIP.IPAddress)
+		if iface.ipRange != nil && ipInRange(ip, iface.ipRange) {
+			return true
+		}
+		if iface.ipAddress != "" && ip.Equal(net.ParseIP(iface.ipAddress)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isStickyPod checks whether ports of the pod should be kept when it is stopped.
+// Only pods of StatefulSets keep their names, hence their ports, across runs.
+func (os *OpenStack) isStickyPod(podNamespace, podName string) (bool, error) {
+	pod, err := os.KubeClient.CoreV1().Pods(podNamespace).Get(podName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		// The pod is gone, there is no next run to keep the ports for.
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil || ref.Kind != "StatefulSet" {
+		return false, nil
+	}
+
+	var namespaceAnnotations map[string]string
+	namespace, err := os.KubeClient.CoreV1().Namespaces().Get(podNamespace, metav1.GetOptions{})
+	if err == nil {
+		namespaceAnnotations = namespace.Annotations
+	} else if !apierrors.IsNotFound(err) {
+		return false, err
+	}
+
+	return util.IsStickyPort(pod.Annotations, namespaceAnnotations), nil
+}
+
+// getMTU gets MTU of the interface. MTU of the port takes precedence over the
+// one of its network, zero means the kernel default.
+func getMTU(iface *podInterface, port *openstack.Port) int {
+	if port.MTU > 0 {
+		return port.MTU
 	}
-	glog.V(4).Infof("Pod %s's port is %v", podName, port)
 
-	// Get subnet and gateway
-	subnet, err := osClient.Client.GetProviderSubnet(port.FixedIPs[0].SubnetID)
+	return iface.network.MTU
+}
+
+// releasePort deletes the port of the pod, or only unbinds it from this host
+// if the port is sticky. The retained port is bound again by the next run of
+// the pod, and deleted by the port GC controller once the pod is gone for good.
+func (os *OpenStack) releasePort(port *ports.Port, sticky bool) error {
+	if !sticky {
+		return os.Client.DeletePortByID(port.ID)
+	}
+
+	if port.DeviceOwner != fmt.Sprintf("compute:%s", getHostName()) {
+		// The port has been bound to another host by the next run of the pod.
+		glog.V(4).Infof("Port %s is owned by %s, skip unbinding it", port.Name, port.DeviceOwner)
+		return nil
+	}
+	return os.Client.UnbindPort(port.ID)
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	osClient, cniVersion, err := initOpenstack(args.StdinData)
 	if err != nil {
-		glog.Errorf("Get info of subnet %s failed: %v", port.FixedIPs[0].SubnetID, err)
+		glog.Errorf("Init OpenStack failed: %v", err)
 		return err
 	}
 
+	result, mtus, err := osClient.setupPod(args)
+	if err != nil {
+		return err
+	}
+
+	// Print result to stdout, in the format defined by the requested cniVersion.
+	return printResult(os.Stdout, result, mtus, cniVersion)
+}
+
+// setupPod plugs all interfaces of the pod into its netns, and returns the
+// result with MTUs of result.Interfaces keyed by their indexes.
+func (osClient *OpenStack) setupPod(args *skel.CmdArgs) (result *current.Result, mtus map[int]int, err error) {
+	// Get k8s args
+	podName, podNamespace, err := getK8sArgs(args.Args)
+	if err != nil {
+		glog.Errorf("GetK8sArgs failed: %v", err)
+		return nil, nil, err
+	}
+
+	// Get tenantID
+	tenantID, err := osClient.Client.GetTenantIDFromName(podNamespace)
+	if err != nil {
+		glog.Errorf("Get tenantID failed: %v", err)
+		return nil, nil, err
+	}
+
+	// Get pod
+	pod, err := osClient.KubeClient.CoreV1().Pods(podNamespace).Get(podName, metav1.GetOptions{})
+	if err != nil {
+		glog.Errorf("Get pod %s/%s failed: %v", podNamespace, podName, err)
+		return nil, nil, err
+	}
+
+	// Get interfaces of the pod
+	interfaces, err := osClient.getPodInterfaces(pod, args.IfName)
+	if err != nil {
+		glog.Errorf("Get interfaces of pod %s failed: %v", podName, err)
+		return nil, nil, err
+	}
+
+	podFullName := util.BuildFullPodName(podNamespace, podName)
+
 	// Get network namespace.
 	netns, err := ns.GetNS(args.Netns)
 	if err != nil {
-		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+		return nil, nil, fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
 	}
 	defer netns.Close()
 
-	// Setup interface for pod
+	// Plugins find the netns by its name in netnsBasePath.
 	var netnsName string
-	_, cidr, _ := net.ParseCIDR(subnet.Cidr)
-	prefixSize, _ := cidr.Mask.Size()
-	if strings.HasPrefix(netnsBasePath, netns.Path()) {
+	if strings.HasPrefix(netns.Path(), netnsBasePath) {
 		// container runtime has already made the symlink for netns.
 		netnsName = path.Base(netns.Path())
 	} else {
 		netnsName = podFullName
 		destPath := filepath.Join(netnsBasePath, netnsName)
-		if err := util.NetnsSymlink(netns.Path(), destPath); err != nil {
-			return fmt.Errorf("error of symlink %q: %v", destPath, err)
+		if err = util.NetnsSymlink(netns.Path(), destPath); err != nil {
+			return nil, nil, fmt.Errorf("error of symlink %q: %v", destPath, err)
 		}
 
 		defer func() {
 			if err != nil {
-				if _, err := os.Stat(destPath); !os.IsNotExist(err) {
-					if err = os.Remove(destPath); err != nil && !os.IsNotExist(err) {
-						glog.Warningf("Failed to remove netns symlink %q: %v", destPath, err)
-					}
+				if err := os.Remove(destPath); err != nil && !os.IsNotExist(err) {
+					glog.Warningf("Failed to remove netns symlink %q: %v", destPath, err)
 				}
 			}
 		}()
 	}
 
-	brInterface, conInterface, err := osClient.Plugin.SetupInterface(portName, args.ContainerID, port,
-		fmt.Sprintf("%s/%d", port.FixedIPs[0].IPAddress, prefixSize),
-		subnet.Gateway, args.IfName, netnsName)
-	if err != nil {
-		glog.Errorf("SetupInterface failed: %v", err)
-		return err
+	result = &current.Result{}
+	mtus = make(map[int]int)
+
+	for _, iface := range interfaces {
+		// Get port from openstack.
+		var port *openstack.Port
+		var created bool
+		port, created, err = osClient.ensurePort(iface, tenantID)
+		if port != nil {
+			defer func(iface *podInterface, port *openstack.Port, created bool) {
+				if err != nil {
+					if err := osClient.cleanupPort(iface, port, created, podNamespace, podName); err != nil {
+						glog.Warningf("Clean up port %s failed: %v", port.ID, err)
+					}
+				}
+			}(iface, port, created)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		glog.V(4).Infof("Pod %s's port for %s is %v", podName, iface.ifName, port)
+
+		// Get IP addresses and gateways
+		var ips []plugins.IPConfig
+		var ipConfigs []*current.IPConfig
+		ips, ipConfigs, err = buildIPConfigs(osClient.Client, &port.Port)
+		if err != nil {
+			glog.Errorf("Get IP configs of port %s failed: %v", iface.portName, err)
+			return nil, nil, err
+		}
+		if !iface.primary {
+			// Default route only goes via the primary interface.
+			for i := range ips {
+				ips[i].Gateway = ""
+			}
+		}
+
+		// Setup interface for pod
+		mtu := getMTU(iface, port)
+		var brInterface, conInterface *current.Interface
+		brInterface, conInterface, err = osClient.Plugin.SetupInterface(iface.portName, args.ContainerID, &port.Port,
+			ips, mtu, iface.ifName, netnsName)
+		if err != nil {
+			glog.Errorf("SetupInterface failed: %v", err)
+			return nil, nil, err
+		}
+
+		// Populate container interface sandbox path
+		conInterface.Sandbox = netns.Path()
+
+		// Populate result.Interfaces
+		result.Interfaces = append(result.Interfaces, brInterface, conInterface)
+		if mtu > 0 {
+			mtus[len(result.Interfaces)-2] = mtu
+			mtus[len(result.Interfaces)-1] = mtu
+		}
+		// Populate result.IPs
+		for _, ipConfig := range ipConfigs {
+			ipConfig.Interface = current.Int(len(result.Interfaces) - 1)
+			result.IPs = append(result.IPs, ipConfig)
+		}
+		// Populate result.Routes
+		for _, ip := range ips {
+			for _, route := range ip.Routes {
+				_, dst, err := net.ParseCIDR(route.Destination)
+				if err != nil {
+					continue
+				}
+				result.Routes = append(result.Routes, &types.Route{
+					Dst: *dst,
+					GW:  net.ParseIP(route.Nexthop),
+				})
+			}
+		}
 	}
 
-	// Collect the result in this variable - this is ultimately what gets "returned"
-	// by this function by printing it to stdout.
-	result := &current.Result{}
-	// Populate container interface sandbox path
-	conInterface.Sandbox = netns.Path()
+	return result, mtus, nil
+}
+
+// interfaceWithMTU is an interface of the CNI result with its MTU, which is
+// reported by later CNI specs but missing from the result types of the CNI
+// library yet.
+type interfaceWithMTU struct {
+	*current.Interface
+	MTU int `json:"mtu,omitempty"`
+}
+
+// resultWithMTU is current.Result with MTUs of its interfaces.
+type resultWithMTU struct {
+	CNIVersion string              `json:"cniVersion,omitempty"`
+	Interfaces []*interfaceWithMTU `json:"interfaces,omitempty"`
+	IPs        []*current.IPConfig `json:"ips,omitempty"`
+	Routes     []*types.Route      `json:"routes,omitempty"`
+	DNS        types.DNS           `json:"dns,omitempty"`
+}
 
-	// Populate result.Interfaces
-	result.Interfaces = []*current.Interface{brInterface, conInterface}
-	// Populate result.IPs
-	ip := net.ParseIP(port.FixedIPs[0].IPAddress)
-	ipCidr := net.IPNet{
-		IP:   ip,
-		Mask: cidr.Mask,
+// printResult prints result to w in the format defined by cniVersion, along
+// with MTUs of interfaces if the format has interfaces.
+func printResult(w io.Writer, result *current.Result, mtus map[int]int, cniVersion string) error {
+	versionedResult, err := result.GetAsVersion(cniVersion)
+	if err != nil {
+		return err
 	}
-	gateway := net.ParseIP(subnet.Gateway)
-	containerIPConfig := &current.IPConfig{
-		Version: "4",
-		Address: ipCidr,
-		Gateway: gateway,
+
+	var output interface{} = versionedResult
+	if r, ok := versionedResult.(*current.Result); ok {
+		// Results before 0.3.0 have no interfaces.
+		withMTU := &resultWithMTU{
+			CNIVersion: r.CNIVersion,
+			IPs:        r.IPs,
+			Routes:     r.Routes,
+			DNS:        r.DNS,
+		}
+		for i, iface := range r.Interfaces {
+			withMTU.Interfaces = append(withMTU.Interfaces, &interfaceWithMTU{
+				Interface: iface,
+				MTU:       mtus[i],
+			})
+		}
+		output = withMTU
 	}
-	result.IPs = []*current.IPConfig{containerIPConfig}
 
-	// Print result to stdout, in the format defined by the requested cniVersion.
-	return types.PrintResult(result, cniVersion)
+	data, err := json.MarshalIndent(output, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
 }
 
 func cmdDel(args *skel.CmdArgs) error {
@@ -288,6 +964,11 @@ func cmdDel(args *skel.CmdArgs) error {
 		return err
 	}
 
+	return osClient.teardownPod(args)
+}
+
+// teardownPod unplugs all interfaces of the pod, and releases their ports.
+func (osClient *OpenStack) teardownPod(args *skel.CmdArgs) error {
 	// Get k8s args
 	podName, podNamespace, err := getK8sArgs(args.Args)
 	if err != nil {
@@ -295,34 +976,59 @@ func cmdDel(args *skel.CmdArgs) error {
 		return err
 	}
 
-	// Build port name
-	portName := util.BuildPortName(podNamespace, podName)
 	podFullName := util.BuildFullPodName(podNamespace, podName)
 
-	// Get port from openstack
-	port, err := osClient.Client.GetPort(portName)
+	sticky, err := osClient.isStickyPod(podNamespace, podName)
 	if err != nil {
-		glog.Errorf("GetPort %s failed: %v", portName, err)
+		glog.Errorf("Check sticky port of pod %s failed: %v", podName, err)
 		return err
 	}
-	if port == nil {
-		glog.Warningf("Port %s already deleted", portName)
-		return nil
-	}
-	glog.V(4).Infof("Pod %s's port is %v", podName, port)
 
-	// Delete interface
-	err = osClient.Plugin.DestroyInterface(portName, args.ContainerID, port)
-	if err != nil {
-		glog.Errorf("DestroyInterface for pod %s failed: %v", podName, err)
-		return err
-	}
+	// Delete interfaces and ports of the pod, additional ones are named eth1, eth2...
+	for i := 0; ; i++ {
+		portName := util.BuildPortName(podNamespace, podName)
+		if i > 0 {
+			portName = util.BuildSecondaryPortName(podNamespace, podName, util.BuildSecondaryIfName(i))
+		}
 
-	// Delete port from openstack
-	err = osClient.Client.DeletePortByName(portName)
-	if err != nil {
-		glog.Errorf("Delete port %s failed: %v", portName, err)
-		return err
+		// Get port from openstack
+		port, err := osClient.Client.GetPort(portName)
+		if err != nil && err != util.ErrNotFound {
+			glog.Errorf("GetPort %s failed: %v", portName, err)
+			return err
+		}
+		if port == nil && i > 0 {
+			break
+		}
+		if port == nil {
+			// Additional ports and the QoS policy may still be left.
+			glog.Warningf("Port %s already deleted", portName)
+		} else {
+			glog.V(4).Infof("Pod %s's port is %v", podName, port)
+
+			// Delete interface
+			err = osClient.Plugin.DestroyInterface(portName, args.ContainerID, &port.Port)
+			if err != nil {
+				glog.Errorf("DestroyInterface %s for pod %s failed: %v", portName, podName, err)
+				return err
+			}
+
+			// Delete port from openstack, or keep it for the next run of the pod
+			err = osClient.releasePort(&port.Port, sticky)
+			if err != nil {
+				glog.Errorf("Release port %s failed: %v", portName, err)
+				return err
+			}
+		}
+
+		// Delete QoS policy of the primary port, retained ports keep theirs.
+		if i == 0 && !sticky {
+			err = osClient.Client.DeleteQoSPolicy(portName)
+			if err != nil {
+				glog.Errorf("Delete QoS policy %s failed: %v", portName, err)
+				return err
+			}
+		}
 	}
 
 	// Remove netns symlink.
@@ -332,12 +1038,10 @@ func cmdDel(args *skel.CmdArgs) error {
 		return nil
 	}
 	defer netns.Close()
-	if !strings.HasPrefix(netnsBasePath, netns.Path()) {
+	if !strings.HasPrefix(netns.Path(), netnsBasePath) {
 		destPath := filepath.Join(netnsBasePath, podFullName)
-		if _, err := os.Stat(destPath); !os.IsNotExist(err) {
-			if err = os.Remove(destPath); err != nil {
-				glog.Warningf("failed to remove %q: %v", destPath, err)
-			}
+		if err := os.Remove(destPath); err != nil && !os.IsNotExist(err) {
+			glog.Warningf("failed to remove %q: %v", destPath, err)
 		}
 	}
 
@@ -0,0 +1,580 @@
+/*
+Copyright (c) 2017 OpenStack Foundation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	crv1 "git.openstack.org/openstack/stackube/pkg/apis/v1"
+	crdClient "git.openstack.org/openstack/stackube/pkg/kubecrd"
+	"git.openstack.org/openstack/stackube/pkg/kubestack/plugins"
+	"git.openstack.org/openstack/stackube/pkg/kubestack/plugins/openvswitch"
+	"git.openstack.org/openstack/stackube/pkg/openstack"
+	drivertypes "git.openstack.org/openstack/stackube/pkg/openstack/types"
+	"git.openstack.org/openstack/stackube/pkg/util"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	types020 "github.com/containernetworking/cni/pkg/types/020"
+	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	namespace = "test"
+	tenantID  = "123"
+	// testNetns is a netns path outside of netnsBasePath, so that a symlink
+	// is made for it.
+	testNetns = "/proc/self/ns/net"
+)
+
+// fakeInterface records an interface set up by fakePlugin.
+type fakeInterface struct {
+	portName string
+	ifName   string
+	netns    string
+	mtu      int
+	ips      []plugins.IPConfig
+}
+
+// fakePlugin is a plugin recording interfaces, which are not plugged.
+type fakePlugin struct {
+	interfaces []fakeInterface
+	destroyed  []string
+	// failIfName is the interface failed to set up.
+	failIfName string
+}
+
+func (p *fakePlugin) SetupInterface(podName, podInfraContainerID string, port *ports.Port, ips []plugins.IPConfig, mtu int, ifName, netns string) (*current.Interface, *current.Interface, error) {
+	if ifName == p.failIfName {
+		return nil, nil, fmt.Errorf("setup %s failed", ifName)
+	}
+	p.interfaces = append(p.interfaces, fakeInterface{
+		portName: podName,
+		ifName:   ifName,
+		netns:    netns,
+		mtu:      mtu,
+		ips:      ips,
+	})
+	return &current.Interface{Name: "qvo" + port.ID}, &current.Interface{Name: ifName}, nil
+}
+
+func (p *fakePlugin) DestroyInterface(podName, podInfraContainerID string, port *ports.Port) error {
+	p.destroyed = append(p.destroyed, podName)
+	return nil
+}
+
+func (p *fakePlugin) Init(integrationBridge string) error {
+	return nil
+}
+
+func newPod(name string, annotations map[string]string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Annotations: annotations,
+		},
+	}
+}
+
+func newNetwork(name, uid, cidr, gateway string, mtu int) (*crv1.Network, *drivertypes.Network) {
+	kubeNetwork := &crv1.Network{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+	network := &drivertypes.Network{
+		Name: util.BuildNetworkName(namespace, name),
+		Uid:  uid,
+		MTU:  mtu,
+		Subnets: []*drivertypes.Subnet{{
+			Uid:     uid + "-subnet",
+			Cidr:    cidr,
+			Gateway: gateway,
+		}},
+	}
+	return kubeNetwork, network
+}
+
+func newArgs(podName string) *skel.CmdArgs {
+	return &skel.CmdArgs{
+		ContainerID: "container-" + podName,
+		Netns:       testNetns,
+		IfName:      "eth0",
+		Args:        fmt.Sprintf("K8S_POD_NAMESPACE=%s;K8S_POD_NAME=%s", namespace, podName),
+	}
+}
+
+// newOpenStack creates an OpenStack with fakes, and a temporary netnsBasePath
+// which should be removed by the caller.
+func newOpenStack(t *testing.T, pods ...*v1.Pod) (*OpenStack, *openstack.FakeOSClient, *fakePlugin, string) {
+	kubeCRDClient, err := crdClient.NewFake()
+	if err != nil {
+		t.Fatalf("Failed create fake CRD client: %v", err)
+	}
+	kubeCRDClient.SetTenants(&crv1.Tenant{
+		ObjectMeta: metav1.ObjectMeta{Name: namespace},
+		Spec:       crv1.TenantSpec{TenantID: tenantID},
+	})
+	osClient := openstack.NewFake(kubeCRDClient)
+	for _, n := range []struct {
+		name, uid, cidr, gateway string
+		mtu                      int
+	}{
+		{name: namespace, uid: "net0", cidr: "10.244.0.0/16", gateway: "10.244.0.1", mtu: 1450},
+		{name: "net1", uid: "net1", cidr: "192.168.0.0/24", gateway: "192.168.0.1", mtu: 1400},
+	} {
+		kubeNetwork, network := newNetwork(n.name, n.uid, n.cidr, n.gateway, n.mtu)
+		kubeCRDClient.SetNetworks(kubeNetwork)
+		osClient.SetNetwork(network)
+	}
+
+	var objects []runtime.Object
+	for _, pod := range pods {
+		objects = append(objects, pod)
+	}
+	plugin := &fakePlugin{}
+	osStack := &OpenStack{
+		Client:     osClient,
+		Plugin:     plugin,
+		KubeClient: fake.NewSimpleClientset(objects...),
+	}
+
+	dir, err := ioutil.TempDir("", "netns")
+	if err != nil {
+		t.Fatalf("Failed create netns base path: %v", err)
+	}
+	netnsBasePath = dir
+	return osStack, osClient, plugin, dir
+}
+
+func portNames(osClient *openstack.FakeOSClient) map[string]ports.Port {
+	results := make(map[string]ports.Port)
+	for _, portList := range osClient.Ports {
+		for _, port := range portList {
+			results[port.Name] = port
+		}
+	}
+	return results
+}
+
+func TestSetupAndTeardownMultiInterfacePod(t *testing.T) {
+	// Pod pod1-eth1 has a primary port with the same prefix as the port of
+	// pod1's additional interface.
+	pod := newPod("pod1", map[string]string{util.NetworksAnnotation: "net1"})
+	other := newPod("pod1-eth1", nil)
+	osStack, osClient, plugin, dir := newOpenStack(t, pod, other)
+	defer os.RemoveAll(dir)
+
+	if _, _, err := osStack.setupPod(newArgs(other.Name)); err != nil {
+		t.Fatalf("Setup pod %s failed: %v", other.Name, err)
+	}
+	otherPort := portNames(osClient)[util.BuildPortName(namespace, other.Name)]
+	plugin.interfaces = nil
+
+	result, mtus, err := osStack.setupPod(newArgs(pod.Name))
+	if err != nil {
+		t.Fatalf("Setup pod %s failed: %v", pod.Name, err)
+	}
+
+	primaryName := util.BuildPortName(namespace, pod.Name)
+	secondaryName := util.BuildSecondaryPortName(namespace, pod.Name, "eth1")
+	if secondaryName == otherPort.Name {
+		t.Fatalf("Port %s of additional interface collides with port of pod %s", secondaryName, other.Name)
+	}
+	podPorts := portNames(osClient)
+	for name, networkID := range map[string]string{primaryName: "net0", secondaryName: "net1"} {
+		port, ok := podPorts[name]
+		if !ok {
+			t.Errorf("Expected port %s to be created, got none", name)
+		} else if port.NetworkID != networkID {
+			t.Errorf("Expected port %s in network %s, got %s", name, networkID, port.NetworkID)
+		}
+	}
+
+	// Interfaces are plugged into the same netns, with their own MTUs and
+	// only the primary one has the gateway.
+	podFullName := util.BuildFullPodName(namespace, pod.Name)
+	expected := []fakeInterface{
+		{portName: primaryName, ifName: "eth0", netns: podFullName, mtu: 1450},
+		{portName: secondaryName, ifName: "eth1", netns: podFullName, mtu: 1400},
+	}
+	if len(plugin.interfaces) != len(expected) {
+		t.Fatalf("Expected interfaces %v, got %v", expected, plugin.interfaces)
+	}
+	for i, iface := range plugin.interfaces {
+		e := expected[i]
+		if iface.portName != e.portName || iface.ifName != e.ifName || iface.netns != e.netns || iface.mtu != e.mtu {
+			t.Errorf("Expected interface %v, got %v", e, iface)
+		}
+		hasGateway := len(iface.ips) > 0 && iface.ips[0].Gateway != ""
+		if hasGateway != (i == 0) {
+			t.Errorf("Interface %s expected gateway %v, got %v", iface.ifName, i == 0, iface.ips)
+		}
+	}
+	if dest, err := os.Readlink(filepath.Join(dir, podFullName)); err != nil || dest != testNetns {
+		t.Errorf("Expected netns symlink to %s, got %s: %v", testNetns, dest, err)
+	}
+	if len(result.Interfaces) != 4 || len(result.IPs) != 2 {
+		t.Errorf("Expected 4 interfaces and 2 IPs in result, got %v", result)
+	}
+	for i, mtu := range []int{1450, 1450, 1400, 1400} {
+		if mtus[i] != mtu {
+			t.Errorf("Expected MTU %d of result interface %d, got %d", mtu, i, mtus[i])
+		}
+	}
+
+	// ADD is retried with the stale symlink.
+	if _, _, err := osStack.setupPod(newArgs(pod.Name)); err != nil {
+		t.Fatalf("Setup pod %s again failed: %v", pod.Name, err)
+	}
+
+	if err := osStack.teardownPod(newArgs(pod.Name)); err != nil {
+		t.Fatalf("Teardown pod %s failed: %v", pod.Name, err)
+	}
+	podPorts = portNames(osClient)
+	for _, name := range []string{primaryName, secondaryName} {
+		if _, ok := podPorts[name]; ok {
+			t.Errorf("Expected port %s to be deleted", name)
+		}
+	}
+	if _, ok := podPorts[otherPort.Name]; !ok {
+		t.Errorf("Expected port %s of pod %s to be kept", otherPort.Name, other.Name)
+	}
+	if len(plugin.destroyed) != 2 {
+		t.Errorf("Expected 2 interfaces to be destroyed, got %v", plugin.destroyed)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, podFullName)); !os.IsNotExist(err) {
+		t.Errorf("Expected netns symlink to be removed, got %v", err)
+	}
+
+	// DEL is idempotent.
+	if err := osStack.teardownPod(newArgs(pod.Name)); err != nil {
+		t.Errorf("Teardown deleted pod %s failed: %v", pod.Name, err)
+	}
+}
+
+// fakeRunCommand runs the commands of OVSPlugin against netns symlinks in
+// netnsBasePath, other commands are ignored.
+func fakeRunCommand(cmd string, args ...string) ([]string, error) {
+	switch {
+	case cmd == "ip" && len(args) == 5 && args[1] == "set" && args[3] == "netns":
+		// ip finds the netns by its name in netnsBasePath.
+		if _, err := os.Lstat(filepath.Join(netnsBasePath, args[4])); err != nil {
+			return nil, fmt.Errorf("netns %s not found: %v", args[4], err)
+		}
+	case cmd == "rm" && len(args) == 2:
+		os.Remove(filepath.Join(netnsBasePath, filepath.Base(args[1])))
+	case cmd == "ip" && len(args) == 3 && args[1] == "show":
+		return []string{"3: bridge: <BROADCAST,MULTICAST,UP,LOWER_UP>", "    link/ether 00:11:22:33:44:55 brd ff:ff:ff:ff:ff:ff"}, nil
+	}
+	return nil, nil
+}
+
+func TestSetupMultiInterfacePodWithOVSPlugin(t *testing.T) {
+	pod := newPod("pod1", map[string]string{util.NetworksAnnotation: "net1"})
+	osStack, _, _, dir := newOpenStack(t, pod)
+	defer os.RemoveAll(dir)
+	osStack.Plugin = openvswitch.NewOVSPlugin()
+
+	origin := openvswitch.RunCommand
+	openvswitch.RunCommand = fakeRunCommand
+	defer func() { openvswitch.RunCommand = origin }()
+
+	result, _, err := osStack.setupPod(newArgs(pod.Name))
+	if err != nil {
+		t.Fatalf("Setup pod %s failed: %v", pod.Name, err)
+	}
+	if len(result.Interfaces) != 4 {
+		t.Errorf("Expected 4 interfaces in result, got %v", result.Interfaces)
+	}
+	// The symlink is owned by kubestack, and kept until teardown.
+	podFullName := util.BuildFullPodName(namespace, pod.Name)
+	if _, err := os.Lstat(filepath.Join(dir, podFullName)); err != nil {
+		t.Errorf("Expected netns symlink to be kept, got %v", err)
+	}
+
+	if err := osStack.teardownPod(newArgs(pod.Name)); err != nil {
+		t.Fatalf("Teardown pod %s failed: %v", pod.Name, err)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, podFullName)); !os.IsNotExist(err) {
+		t.Errorf("Expected netns symlink to be removed, got %v", err)
+	}
+}
+
+func TestSetupPodFailed(t *testing.T) {
+	pod := newPod("pod1", map[string]string{
+		util.NetworksAnnotation:         "net1",
+		util.IngressBandwidthAnnotation: "10M",
+	})
+	osStack, osClient, plugin, dir := newOpenStack(t, pod)
+	defer os.RemoveAll(dir)
+
+	// The additional interface fails, all ports should be cleaned up.
+	plugin.failIfName = "eth1"
+
+	if _, _, err := osStack.setupPod(newArgs(pod.Name)); err == nil {
+		t.Fatalf("Expected setup of pod %s to fail", pod.Name)
+	}
+	if podPorts := portNames(osClient); len(podPorts) != 0 {
+		t.Errorf("Expected no ports left, got %v", podPorts)
+	}
+	if len(osClient.QoSPolicies) != 0 {
+		t.Errorf("Expected QoS policies to be deleted with ports, got %v", osClient.QoSPolicies)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, util.BuildFullPodName(namespace, pod.Name))); !os.IsNotExist(err) {
+		t.Errorf("Expected netns symlink to be removed, got %v", err)
+	}
+}
+
+func TestSetupPodGetPortFailed(t *testing.T) {
+	pod := newPod("pod1", nil)
+	osStack, osClient, _, dir := newOpenStack(t, pod)
+	defer os.RemoveAll(dir)
+
+	// A transient error of getting port should not make a duplicate port.
+	osClient.InjectError("GetPort", fmt.Errorf("connection refused"))
+	if _, _, err := osStack.setupPod(newArgs(pod.Name)); err == nil {
+		t.Fatalf("Expected setup of pod %s to fail", pod.Name)
+	}
+	for _, name := range osClient.GetCalledNames() {
+		if name == "CreatePort" {
+			t.Errorf("Expected no port created for pod %s", pod.Name)
+		}
+	}
+}
+
+func TestSetupStickyPodFailed(t *testing.T) {
+	pod := newPod("web-0", map[string]string{
+		util.NetworksAnnotation:         "net1",
+		util.IngressBandwidthAnnotation: "10M",
+		util.StickyPortAnnotation:       "true",
+	})
+	controller := true
+	pod.OwnerReferences = []metav1.OwnerReference{{Kind: "StatefulSet", Name: "web", Controller: &controller}}
+	osStack, osClient, plugin, dir := newOpenStack(t, pod)
+	defer os.RemoveAll(dir)
+
+	// Ports are retained by the previous run of the pod.
+	if _, _, err := osStack.setupPod(newArgs(pod.Name)); err != nil {
+		t.Fatalf("Setup pod %s failed: %v", pod.Name, err)
+	}
+	if err := osStack.teardownPod(newArgs(pod.Name)); err != nil {
+		t.Fatalf("Teardown pod %s failed: %v", pod.Name, err)
+	}
+	retained := portNames(osClient)
+
+	// The next run fails, the retained ports and QoS policy should be kept.
+	plugin.failIfName = "eth1"
+	if _, _, err := osStack.setupPod(newArgs(pod.Name)); err == nil {
+		t.Fatalf("Expected setup of pod %s to fail", pod.Name)
+	}
+	podPorts := portNames(osClient)
+	for name, port := range retained {
+		kept, ok := podPorts[name]
+		if !ok {
+			t.Errorf("Expected port %s to be kept", name)
+		} else if kept.ID != port.ID || kept.DeviceOwner != openstack.RetainedPortDeviceOwner {
+			t.Errorf("Expected port %s to be kept unbound, got %v", name, kept)
+		}
+	}
+	policyName := util.BuildPortName(namespace, pod.Name)
+	if _, ok := osClient.QoSPolicies[policyName]; !ok {
+		t.Errorf("Expected QoS policy %s of the retained port to be kept", policyName)
+	}
+}
+
+func TestSetupPodQoSPolicy(t *testing.T) {
+	limited := newPod("pod1", map[string]string{util.IngressBandwidthAnnotation: "10M"})
+	unlimited := newPod("pod2", nil)
+	osStack, osClient, _, dir := newOpenStack(t, limited, unlimited)
+	defer os.RemoveAll(dir)
+
+	// No QoS policy is looked up for a new port without limit.
+	if _, _, err := osStack.setupPod(newArgs(unlimited.Name)); err != nil {
+		t.Fatalf("Setup pod %s failed: %v", unlimited.Name, err)
+	}
+	for _, name := range osClient.GetCalledNames() {
+		if name == "EnsurePortQoSPolicy" {
+			t.Errorf("Expected no QoS policy ensured for pod %s", unlimited.Name)
+		}
+	}
+
+	if _, _, err := osStack.setupPod(newArgs(limited.Name)); err != nil {
+		t.Fatalf("Setup pod %s failed: %v", limited.Name, err)
+	}
+	policyName := util.BuildPortName(namespace, limited.Name)
+	if _, ok := osClient.QoSPolicies[policyName]; !ok {
+		t.Fatalf("Expected QoS policy %s to be created", policyName)
+	}
+
+	// The limit is removed while the port is kept, its policy is removed.
+	limited.Annotations = nil
+	if _, err := osStack.KubeClient.CoreV1().Pods(namespace).Update(limited); err != nil {
+		t.Fatalf("Update pod %s failed: %v", limited.Name, err)
+	}
+	if _, _, err := osStack.setupPod(newArgs(limited.Name)); err != nil {
+		t.Fatalf("Setup pod %s again failed: %v", limited.Name, err)
+	}
+	if _, ok := osClient.QoSPolicies[policyName]; ok {
+		t.Errorf("Expected QoS policy %s of the existing port to be removed", policyName)
+	}
+}
+
+func TestTeardownPodQoSPolicy(t *testing.T) {
+	pod := newPod("pod1", map[string]string{util.IngressBandwidthAnnotation: "10M"})
+	osStack, osClient, _, dir := newOpenStack(t, pod)
+	defer os.RemoveAll(dir)
+
+	if _, _, err := osStack.setupPod(newArgs(pod.Name)); err != nil {
+		t.Fatalf("Setup pod %s failed: %v", pod.Name, err)
+	}
+	policyName := util.BuildPortName(namespace, pod.Name)
+	port, ok := portNames(osClient)[policyName]
+	if !ok {
+		t.Fatalf("Expected port %s to be created", policyName)
+	}
+
+	// The port is already deleted, its QoS policy is still removed.
+	if err := osClient.DeletePortByID(port.ID); err != nil {
+		t.Fatalf("Delete port %s failed: %v", port.Name, err)
+	}
+	if err := osStack.teardownPod(newArgs(pod.Name)); err != nil {
+		t.Fatalf("Teardown pod %s failed: %v", pod.Name, err)
+	}
+	if _, ok := osClient.QoSPolicies[policyName]; ok {
+		t.Errorf("Expected QoS policy %s to be removed", policyName)
+	}
+}
+
+func TestSetupPodPortMTU(t *testing.T) {
+	pod := newPod("pod1", map[string]string{util.NetworksAnnotation: "net1"})
+	osStack, osClient, plugin, dir := newOpenStack(t, pod)
+	defer os.RemoveAll(dir)
+
+	if _, _, err := osStack.setupPod(newArgs(pod.Name)); err != nil {
+		t.Fatalf("Setup pod %s failed: %v", pod.Name, err)
+	}
+
+	// MTU reported with the primary port takes precedence over the one of
+	// its network, the additional interface keeps the MTU of its network.
+	primaryPort := portNames(osClient)[util.BuildPortName(namespace, pod.Name)]
+	osClient.PortMTUs[primaryPort.ID] = 1300
+	plugin.interfaces = nil
+	_, mtus, err := osStack.setupPod(newArgs(pod.Name))
+	if err != nil {
+		t.Fatalf("Setup pod %s again failed: %v", pod.Name, err)
+	}
+	for i, mtu := range []int{1300, 1400} {
+		if i >= len(plugin.interfaces) || plugin.interfaces[i].mtu != mtu {
+			t.Errorf("Expected MTU %d of interface %d, got %v", mtu, i, plugin.interfaces)
+		}
+	}
+	for i, mtu := range []int{1300, 1300, 1400, 1400} {
+		if mtus[i] != mtu {
+			t.Errorf("Expected MTU %d of result interface %d, got %d", mtu, i, mtus[i])
+		}
+	}
+}
+
+func TestPrintResult(t *testing.T) {
+	_, ipNet, _ := net.ParseCIDR("10.244.0.2/16")
+	ipNet.IP = net.ParseIP("10.244.0.2")
+	newResult := func() *current.Result {
+		return &current.Result{
+			Interfaces: []*current.Interface{{Name: "qbr1"}, {Name: "eth0", Sandbox: testNetns}},
+			IPs: []*current.IPConfig{{
+				Version:   "4",
+				Interface: current.Int(1),
+				Address:   *ipNet,
+				Gateway:   net.ParseIP("10.244.0.1"),
+			}},
+		}
+	}
+
+	testCases := []struct {
+		testName   string
+		cniVersion string
+		mtus       map[int]int
+		// expectedMTUs are MTUs of interfaces in output, nil if the output
+		// has no interfaces.
+		expectedMTUs []interface{}
+	}{
+		{
+			testName:     "MTUs of interfaces reported",
+			cniVersion:   "0.3.1",
+			mtus:         map[int]int{0: 1450, 1: 1450},
+			expectedMTUs: []interface{}{float64(1450), float64(1450)},
+		},
+		{
+			testName:     "kernel default MTU not reported",
+			cniVersion:   "0.3.1",
+			mtus:         map[int]int{},
+			expectedMTUs: []interface{}{nil, nil},
+		},
+		{
+			testName:   "result without interfaces",
+			cniVersion: "0.2.0",
+			mtus:       map[int]int{0: 1450, 1: 1450},
+		},
+	}
+
+	for tci, tc := range testCases {
+		var buf bytes.Buffer
+		if err := printResult(&buf, newResult(), tc.mtus, tc.cniVersion); err != nil {
+			t.Errorf("Case[%d]: %s expected no error, got %v", tci, tc.testName, err)
+			continue
+		}
+
+		// The output should still be a valid result of the version.
+		result, err := current.NewResult(buf.Bytes())
+		if tc.cniVersion != current.ImplementedSpecVersion {
+			result, err = types020.NewResult(buf.Bytes())
+		}
+		if err != nil || result.Version() != tc.cniVersion {
+			t.Errorf("Case[%d]: %s expected result of version %s, got %s: %v", tci, tc.testName, tc.cniVersion, buf.String(), err)
+		}
+
+		var output struct {
+			Interfaces []map[string]interface{} `json:"interfaces"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &output); err != nil {
+			t.Errorf("Case[%d]: %s expected JSON output, got %v", tci, tc.testName, err)
+			continue
+		}
+		var mtus []interface{}
+		for _, iface := range output.Interfaces {
+			mtus = append(mtus, iface["mtu"])
+		}
+		if !reflect.DeepEqual(mtus, tc.expectedMTUs) {
+			t.Errorf("Case[%d]: %s expected MTUs %v, got %v", tci, tc.testName, tc.expectedMTUs, mtus)
+		}
+	}
+}
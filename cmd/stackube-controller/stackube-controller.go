@@ -22,11 +22,15 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"git.openstack.org/openstack/stackube/pkg/auth-controller/rbacmanager"
 	"git.openstack.org/openstack/stackube/pkg/auth-controller/tenant"
+	"git.openstack.org/openstack/stackube/pkg/floatingip-controller"
+	"git.openstack.org/openstack/stackube/pkg/gc-controller"
 	"git.openstack.org/openstack/stackube/pkg/network-controller"
 	"git.openstack.org/openstack/stackube/pkg/openstack"
+	"git.openstack.org/openstack/stackube/pkg/policy-controller"
 	"git.openstack.org/openstack/stackube/pkg/service-controller"
 	"git.openstack.org/openstack/stackube/pkg/util"
 
@@ -43,10 +47,18 @@ var (
 		"path to kubernetes admin config file")
 	cloudconfig = pflag.String("cloudconfig", "/etc/stackube.conf",
 		"path to stackube config file")
-	userCIDR    = pflag.String("user-cidr", "10.244.0.0/16", "user Pod network CIDR")
-	userGateway = pflag.String("user-gateway", "10.244.0.1", "user Pod network gateway")
-	version     = pflag.Bool("version", false, "Display version")
-	VERSION     = "1.0beta"
+	userCIDR       = pflag.String("user-cidr", "10.244.0.0/16", "user Pod network CIDR")
+	userGateway    = pflag.String("user-gateway", "10.244.0.1", "user Pod network gateway")
+	networkWorkers = pflag.Int("network-workers", 1,
+		"number of workers for syncing networks")
+	portGCPeriod = pflag.Duration("port-gc-period", 5*time.Minute,
+		"period of collecting orphaned pod ports, 0 disables it")
+	portGCGracePeriod = pflag.Duration("port-gc-grace-period", 30*time.Minute,
+		"how long a pod port should be orphaned before it is deleted")
+	portGCDryRun = pflag.Bool("port-gc-dry-run", false,
+		"only report orphaned pod ports which would be deleted")
+	version = pflag.Bool("version", false, "Display version")
+	VERSION = "1.0beta"
 )
 
 func startControllers(kubeClient *kubernetes.Clientset,
@@ -75,6 +87,27 @@ func startControllers(kubeClient *kubernetes.Clientset,
 		return err
 	}
 
+	// Creates a new network policy controller
+	policyController, err := policy.NewPolicyController(kubeClient, osClient)
+	if err != nil {
+		return err
+	}
+
+	// Creates a new floating IP controller
+	floatingIPController, err := floatingip.NewFloatingIPController(kubeClient, osClient, kubeExtClient)
+	if err != nil {
+		return err
+	}
+
+	// Creates a new port GC controller
+	var portGCController *gc.PortGCController
+	if *portGCPeriod > 0 {
+		portGCController, err = gc.NewPortGCController(kubeClient, osClient, *portGCPeriod, *portGCGracePeriod, *portGCDryRun)
+		if err != nil {
+			return err
+		}
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	wg, ctx := errgroup.WithContext(ctx)
 
@@ -83,11 +116,22 @@ func startControllers(kubeClient *kubernetes.Clientset,
 	wg.Go(func() error { return rbacController.Run(ctx.Done()) })
 
 	// start network controller
-	wg.Go(func() error { return networkController.Run(ctx.Done()) })
+	wg.Go(func() error { return networkController.Run(*networkWorkers, ctx.Done()) })
 
 	// start service controller
 	wg.Go(func() error { return serviceController.Run(ctx.Done()) })
 
+	// start network policy controller
+	wg.Go(func() error { return policyController.Run(ctx.Done()) })
+
+	// start floating IP controller
+	wg.Go(func() error { return floatingIPController.Run(ctx.Done()) })
+
+	// start port GC controller
+	if portGCController != nil {
+		wg.Go(func() error { return portGCController.Run(ctx.Done()) })
+	}
+
 	term := make(chan os.Signal)
 	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
 
@@ -35,6 +35,31 @@ sed -i s/_TENANT_NAME_/${TENANT_NAME:-}/g $TMP_CONF
 sed -i s/_REGION_/${REGION:-}/g $TMP_CONF
 sed -i s/_EXT_NET_ID_/${EXT_NET_ID:-}/g $TMP_CONF
 
+# Insert optional DNS parameters, defaults are used for those not set.
+printf "\n[DNS]\n" >> $TMP_CONF
+for i in 'provider:DNS_PROVIDER' 'domain:DNS_DOMAIN' 'replicas:DNS_REPLICAS' \
+	'kube-dns-image:DNS_KUBE_DNS_IMAGE' 'dnsmasq-image:DNS_DNSMASQ_IMAGE' \
+	'sidecar-image:DNS_SIDECAR_IMAGE' 'coredns-image:DNS_COREDNS_IMAGE' \
+	'cpu-limit:DNS_CPU_LIMIT' 'memory-limit:DNS_MEMORY_LIMIT' \
+	'cpu-request:DNS_CPU_REQUEST' 'memory-request:DNS_MEMORY_REQUEST';do
+	key=${i%%:*}
+	env=${i#*:}
+	if [ "${!env:-}" ];then
+		echo "$key = ${!env}" >> $TMP_CONF
+	fi
+done
+# Multiple upstream name servers are separated by comma.
+servers=${DNS_UPSTREAM_NAMESERVERS:-}
+for server in ${servers//,/ };do
+	echo "upstream-nameserver = $server" >> $TMP_CONF
+done
+# Multiple stub domains are separated by semicolon, since their name servers
+# are separated by comma.
+stubs=${DNS_STUB_DOMAINS:-}
+for stub in ${stubs//;/ };do
+	echo "stub-domain = $stub" >> $TMP_CONF
+done
+
 # Move the temporary stackube config into place.
 STACKUBE_CONFIG_PATH='/etc/stackube.conf'
 mv $TMP_CONF $STACKUBE_CONFIG_PATH
@@ -25,8 +25,18 @@ const (
 	NetworkResourcePlural = "networks"
 	// TenantResourcePlural is the plural of tenant resource.
 	TenantResourcePlural = "tenants"
+	// FloatingIPResourcePlural is the plural of floating IP resource.
+	FloatingIPResourcePlural = "floatingips"
 )
 
+// NetworkFinalizer is the finalizer of Network CRD objects, which is removed
+// after the network's resources are deleted.
+const NetworkFinalizer = "stackube.kubernetes.io/neutron-network"
+
+// FloatingIPFinalizer is the finalizer of FloatingIP CRD objects, which is
+// removed after the floating IP is released.
+const FloatingIPFinalizer = "stackube.kubernetes.io/floating-ip"
+
 // These are the valid phases of a network state.
 const (
 	// NetworkInitializing means the network is just accepted by system
@@ -42,6 +52,51 @@ const (
 	NetworkTerminating = "Terminating"
 )
 
+// These are the valid IPv6 address modes of a subnet.
+const (
+	// IPv6SLAAC means addresses are assigned by stateless address autoconfiguration
+	IPv6SLAAC = "slaac"
+	// IPv6DHCPStateful means addresses are assigned by DHCPv6
+	IPv6DHCPStateful = "dhcpv6-stateful"
+	// IPv6DHCPStateless means addresses are assigned by SLAAC, while other
+	// information is provided by DHCPv6
+	IPv6DHCPStateless = "dhcpv6-stateless"
+)
+
+// ConditionStatus is the status of a condition.
+type ConditionStatus string
+
+// These are the valid statuses of a condition.
+const (
+	// ConditionTrue means the resource is in the condition.
+	ConditionTrue ConditionStatus = "True"
+	// ConditionFalse means the resource is not in the condition.
+	ConditionFalse ConditionStatus = "False"
+	// ConditionUnknown means it is unknown whether the resource is in the condition.
+	ConditionUnknown ConditionStatus = "Unknown"
+)
+
+// NetworkConditionType is a valid type of network condition.
+type NetworkConditionType string
+
+// These are the valid types of network condition.
+const (
+	// NetworkNeutronReady means the network, subnets and router are ready in Neutron.
+	NetworkNeutronReady NetworkConditionType = "NeutronReady"
+	// NetworkDNSReady means kube-dns of the network's namespace is ready.
+	NetworkDNSReady NetworkConditionType = "DNSReady"
+)
+
+// TenantConditionType is a valid type of tenant condition.
+type TenantConditionType string
+
+// These are the valid types of tenant condition.
+const (
+	// TenantUserReady means the Keystone user of the tenant is in sync with
+	// the username and password of spec.
+	TenantUserReady TenantConditionType = "UserReady"
+)
+
 // These are the valid phases of a tenant state.
 const (
 	// TenantInitializing means the tenant is just accepted by system
@@ -81,6 +136,93 @@ type NetworkSpec struct {
 	// The network ID in Neutron.
 	// If provided, wouldn't create a network in Neutron.
 	NetworkID string `json:"networkID"`
+	// The IPv6 address mode if CIDR is an IPv6 CIDR.
+	// Valid value: slaac, dhcpv6-stateful, dhcpv6-stateless.
+	IPv6AddressMode string `json:"ipv6AddressMode,omitempty"`
+	// The DNS name servers of the default subnet.
+	DNSNameservers []string `json:"dnsNameservers,omitempty"`
+	// The static host routes of the default subnet.
+	HostRoutes []HostRoute `json:"hostRoutes,omitempty"`
+	// The allocation pools of the default subnet.
+	// If not provided, all IPs of the CIDR except gateway are allocatable.
+	AllocationPools []AllocationPool `json:"allocationPools,omitempty"`
+	// Default marks the network as the default network of its namespace.
+	// Pods without annotation stackube.kubernetes.io/network are placed in it.
+	Default bool `json:"default,omitempty"`
+	// Subnets are additional subnets of the network.
+	// Pods could select one of them by annotation stackube.kubernetes.io/subnet.
+	Subnets []SubnetSpec `json:"subnets,omitempty"`
+	// NoRouter creates an isolated network which is not connected to any
+	// router, so it has neither external access nor ClusterIP services.
+	NoRouter bool `json:"noRouter,omitempty"`
+	// RouterID connects the network to an existing Neutron router instead of
+	// a dedicated one. The router is not managed by stackube.
+	RouterID string `json:"routerID,omitempty"`
+	// DisableSNAT turns off SNAT on the external gateway of the dedicated router.
+	DisableSNAT bool `json:"disableSNAT,omitempty"`
+	// ExternalNetworkID is the external network of the dedicated router.
+	// If not provided, the external network of stackube config is used.
+	ExternalNetworkID string `json:"externalNetworkID,omitempty"`
+	// NetworkType is the provider network type of the network.
+	// Valid value: vlan, flat, vxlan. If not provided, the tenant network
+	// type of Neutron is used.
+	NetworkType string `json:"networkType,omitempty"`
+	// PhysicalNetwork is the physical network of vlan and flat networks,
+	// e.g. physnet1.
+	PhysicalNetwork string `json:"physicalNetwork,omitempty"`
+	// SegmentationID is the VLAN ID of vlan networks or the VNI of vxlan
+	// networks. If not provided, it is allocated by Neutron.
+	SegmentationID int32 `json:"segmentationID,omitempty"`
+	// IPRanges are named IP ranges, which pods could request addresses from
+	// by annotation stackube.kubernetes.io/ip-range. They must be out of
+	// allocation pools, so that their addresses are not taken by other pods.
+	IPRanges []IPRange `json:"ipRanges,omitempty"`
+}
+
+// SubnetSpec is the spec of a subnet.
+type SubnetSpec struct {
+	// The name of the subnet, which is unique in the network.
+	Name string `json:"name"`
+	// The CIDR of the subnet.
+	CIDR string `json:"cidr"`
+	// The gateway IP.
+	Gateway string `json:"gateway,omitempty"`
+	// The allocation pools of the subnet.
+	// If not provided, all IPs of the CIDR except gateway are allocatable.
+	AllocationPools []AllocationPool `json:"allocationPools,omitempty"`
+	// The DNS name servers of the subnet.
+	DNSNameservers []string `json:"dnsNameservers,omitempty"`
+	// The static host routes of the subnet.
+	HostRoutes []HostRoute `json:"hostRoutes,omitempty"`
+	// The IPv6 address mode if CIDR is an IPv6 CIDR.
+	// Valid value: slaac, dhcpv6-stateful, dhcpv6-stateless.
+	IPv6AddressMode string `json:"ipv6AddressMode,omitempty"`
+}
+
+// AllocationPool is an IP range for allocating IPs.
+type AllocationPool struct {
+	// The first IP of the range.
+	Start string `json:"start"`
+	// The last IP of the range.
+	End string `json:"end"`
+}
+
+// IPRange is a named IP range of the network.
+type IPRange struct {
+	// The name of the range, which is unique in the network.
+	Name string `json:"name"`
+	// The first IP of the range.
+	Start string `json:"start"`
+	// The last IP of the range.
+	End string `json:"end"`
+}
+
+// HostRoute is a static route pushed to pods in the subnet.
+type HostRoute struct {
+	// The destination CIDR of the route.
+	Destination string `json:"destination"`
+	// The next hop IP of the route, which must be in the subnet.
+	Nexthop string `json:"nexthop"`
 }
 
 // NetworkStatus is the status of a network.
@@ -89,6 +231,39 @@ type NetworkStatus struct {
 	State string `json:"state,omitempty"`
 	// Message describes why network is in current state.
 	Message string `json:"message,omitempty"`
+	// NetworkID is the ID of the Neutron network resolved for this network.
+	NetworkID string `json:"networkID,omitempty"`
+	// SubnetIDs are the IDs of the Neutron subnets of this network.
+	SubnetIDs []string `json:"subnetIDs,omitempty"`
+	// RouterID is the ID of the Neutron router which the network is connected to.
+	RouterID string `json:"routerID,omitempty"`
+	// NetworkType is the provider network type of the Neutron network.
+	NetworkType string `json:"networkType,omitempty"`
+	// PhysicalNetwork is the physical network of the Neutron network.
+	PhysicalNetwork string `json:"physicalNetwork,omitempty"`
+	// SegmentationID is the segmentation ID of the Neutron network, e.g. VLAN ID or VNI.
+	SegmentationID int32 `json:"segmentationID,omitempty"`
+	// ObservedGeneration is the generation of spec which status is observed for.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// AppliedSpec is the spec last applied to network provider, spec changes
+	// are applied against it.
+	AppliedSpec *NetworkSpec `json:"appliedSpec,omitempty"`
+	// Conditions are the latest observations of network's state.
+	Conditions []NetworkCondition `json:"conditions,omitempty"`
+}
+
+// NetworkCondition describes the state of a network at a certain point.
+type NetworkCondition struct {
+	// Type of the condition.
+	Type NetworkConditionType `json:"type"`
+	// Status of the condition, one of True, False, Unknown.
+	Status ConditionStatus `json:"status"`
+	// LastTransitionTime is the last time the condition transitioned from one status to another.
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Reason is a brief CamelCase reason for the condition's last transition.
+	Reason string `json:"reason,omitempty"`
+	// Message is a human readable message indicating details about the transition.
+	Message string `json:"message,omitempty"`
 }
 
 // NetworkList is a list of networks.
@@ -127,6 +302,35 @@ type TenantSpec struct {
 	// The tenant ID in Keystone.
 	// If provided, wouldn't create a new tenant in Keystone.
 	TenantID string `json:"tenantID"`
+	// SecurityGroup declares the baseline rules of the tenant's default
+	// security group, which is attached to pods not selected by any
+	// NetworkPolicy. All traffic is allowed if it is not set.
+	SecurityGroup *SecurityGroupSpec `json:"securityGroup,omitempty"`
+	// AllowPortSecurityOverride allows pods of the tenant to set allowed
+	// address pairs or to turn off port security of their ports by annotations.
+	AllowPortSecurityOverride bool `json:"allowPortSecurityOverride,omitempty"`
+}
+
+// SecurityGroupSpec declares rules of a security group.
+type SecurityGroupSpec struct {
+	// Ingress rules. All ingress traffic is allowed if it is nil, and denied
+	// if it is empty. It is not omitted when empty to keep the difference.
+	Ingress []SecurityGroupRule `json:"ingress"`
+	// Egress rules. All egress traffic is allowed if it is nil, and denied
+	// if it is empty.
+	Egress []SecurityGroupRule `json:"egress"`
+}
+
+// SecurityGroupRule allows traffic from or to the CIDR on the ports.
+type SecurityGroupRule struct {
+	// CIDR of the remote peers, e.g. 10.0.0.0/8. Any peer if it is empty.
+	CIDR string `json:"cidr,omitempty"`
+	// Protocol of the traffic, e.g. tcp, udp, icmp. Any protocol if it is empty.
+	Protocol string `json:"protocol,omitempty"`
+	// PortRangeMin and PortRangeMax are the port range of tcp or udp traffic.
+	// Any port if they are not set.
+	PortRangeMin int `json:"portRangeMin,omitempty"`
+	PortRangeMax int `json:"portRangeMax,omitempty"`
 }
 
 // TenantStatus is the status of a tenant.
@@ -135,6 +339,28 @@ type TenantStatus struct {
 	State string `json:"state,omitempty"`
 	// Message describes why tenant is in current state.
 	Message string `json:"message,omitempty"`
+	// Conditions are the latest observations of tenant's state.
+	Conditions []TenantCondition `json:"conditions,omitempty"`
+	// AppliedUserName is the username last applied to Keystone, username
+	// changes are applied against it.
+	AppliedUserName string `json:"appliedUserName,omitempty"`
+	// AppliedPasswordHash is the salted hash of the password last applied
+	// to Keystone.
+	AppliedPasswordHash string `json:"appliedPasswordHash,omitempty"`
+}
+
+// TenantCondition describes the state of a tenant at a certain point.
+type TenantCondition struct {
+	// Type of the condition.
+	Type TenantConditionType `json:"type"`
+	// Status of the condition, one of True, False, Unknown.
+	Status ConditionStatus `json:"status"`
+	// LastTransitionTime is the last time the condition transitioned from one status to another.
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Reason is a brief CamelCase reason for the condition's last transition.
+	Reason string `json:"reason,omitempty"`
+	// Message is a human readable message indicating details about the transition.
+	Message string `json:"message,omitempty"`
 }
 
 // TenantList is a list of tenants.
@@ -148,3 +374,72 @@ type TenantList struct {
 	// Items contains a list of tenants.
 	Items []Tenant `json:"items"`
 }
+
+// These are the valid phases of a floating IP state.
+const (
+	// FloatingIPPending means the floating IP is allocated, but the pod has
+	// no port to associate with yet
+	FloatingIPPending = "Pending"
+	// FloatingIPAssociated means the floating IP is associated with the pod's port
+	FloatingIPAssociated = "Associated"
+	// FloatingIPFailed means the floating IP failed to be allocated or associated
+	FloatingIPFailed = "Failed"
+)
+
+// FloatingIP describes a Neutron floating IP associated with a pod.
+// +k8s:deepcopy-gen=true
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type FloatingIP struct {
+	// TypeMeta defines type of the object and its API schema version.
+	metav1.TypeMeta `json:",inline"`
+	// ObjectMeta is metadata that all persisted resources must have.
+	metav1.ObjectMeta `json:"metadata"`
+
+	// Spec defines the behavior of a floating IP.
+	Spec FloatingIPSpec `json:"spec"`
+	// Status describes the floating IP status.
+	Status FloatingIPStatus `json:"status,omitempty"`
+}
+
+// FloatingIPSpec is the spec of a floating IP.
+type FloatingIPSpec struct {
+	// PodName is the name of the pod in the same namespace, which the floating
+	// IP is associated with. The association follows the pod when it is
+	// recreated with the same name.
+	PodName string `json:"podName"`
+	// FloatingIPAddress is the requested address of the floating IP, which is
+	// allocated by Neutron if it is empty.
+	FloatingIPAddress string `json:"floatingIPAddress,omitempty"`
+	// ExternalNetworkID is the Neutron external network which the floating IP
+	// is allocated from. The external network of the pod's network is used if
+	// it is empty, and then the one of stackube config.
+	ExternalNetworkID string `json:"externalNetworkID,omitempty"`
+}
+
+// FloatingIPStatus is the status of a floating IP.
+type FloatingIPStatus struct {
+	// State describes the floating IP state.
+	State string `json:"state,omitempty"`
+	// Message describes why floating IP is in current state.
+	Message string `json:"message,omitempty"`
+	// FloatingIPID is the ID of the Neutron floating IP allocated.
+	FloatingIPID string `json:"floatingIPID,omitempty"`
+	// FloatingIPAddress is the address allocated.
+	FloatingIPAddress string `json:"floatingIPAddress,omitempty"`
+	// PortID is the ID of the pod's port which the floating IP is associated with.
+	PortID string `json:"portID,omitempty"`
+	// FixedIPAddress is the pod IP which the floating IP is associated with.
+	FixedIPAddress string `json:"fixedIPAddress,omitempty"`
+}
+
+// FloatingIPList is a list of floating IPs.
+// +k8s:deepcopy-gen=true
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type FloatingIPList struct {
+	// TypeMeta defines type of the object and its API schema version.
+	metav1.TypeMeta `json:",inline"`
+	// ObjectMeta is metadata that all persisted resources must have.
+	metav1.ListMeta `json:"metadata"`
+	// Items contains a list of floating IPs.
+	Items []FloatingIP `json:"items"`
+}
@@ -145,9 +145,6 @@ func (c *Controller) initSystemReservedTenantNetwork() error {
 		return err
 	}
 
-	// NOTE(harry): we do not support update Network, so although configurable,
-	// user can not update CIDR by changing the configuration, unless manually delete
-	// that system network. We may need to document this.
 	network := &crv1.Network{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      util.SystemNetwork,
@@ -164,6 +161,22 @@ func (c *Controller) initSystemReservedTenantNetwork() error {
 		return err
 	}
 
+	// The system network may be created with a different configuration before,
+	// update it so that network controller could apply the changes.
+	existing, err := c.kubeCRDClient.GetNetwork(network.Namespace, network.Name)
+	if err != nil {
+		return err
+	}
+	if existing.Spec.CIDR != network.Spec.CIDR || existing.Spec.Gateway != network.Spec.Gateway {
+		glog.V(3).Infof("Updating system network from %s/%s to %s/%s", existing.Spec.CIDR, existing.Spec.Gateway,
+			network.Spec.CIDR, network.Spec.Gateway)
+		existing.Spec.CIDR = network.Spec.CIDR
+		existing.Spec.Gateway = network.Spec.Gateway
+		if err := c.kubeCRDClient.UpdateNetwork(existing); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -18,6 +18,8 @@ package tenant
 
 import (
 	"fmt"
+	"reflect"
+	"time"
 
 	crv1 "git.openstack.org/openstack/stackube/pkg/apis/v1"
 	crdClient "git.openstack.org/openstack/stackube/pkg/kubecrd"
@@ -34,6 +36,10 @@ import (
 	"k8s.io/client-go/tools/cache"
 )
 
+// resyncPeriod is the period tenants are resynced, in which the default
+// security groups are converged again.
+const resyncPeriod = 5 * time.Minute
+
 // TenantController manages the life cycle of Tenant.
 type TenantController struct {
 	k8sClient       kubernetes.Interface
@@ -77,7 +83,7 @@ func (c *TenantController) Run(stopCh <-chan struct{}) error {
 	_, tenantInformor := cache.NewInformer(
 		source,
 		&crv1.Tenant{},
-		0,
+		resyncPeriod,
 		cache.ResourceEventHandlerFuncs{
 			AddFunc:    c.onAdd,
 			UpdateFunc: c.onUpdate,
@@ -104,7 +110,50 @@ func (c *TenantController) onAdd(obj interface{}) {
 }
 
 func (c *TenantController) onUpdate(obj1, obj2 interface{}) {
-	glog.Warning("tenant updates is not supported yet.")
+	oldTenant := obj1.(*crv1.Tenant)
+	newTenant := obj2.(*crv1.Tenant)
+
+	resync := oldTenant.ResourceVersion == newTenant.ResourceVersion
+	securityGroupChanged := !reflect.DeepEqual(oldTenant.Spec.SecurityGroup, newTenant.Spec.SecurityGroup)
+	// The user is checked against status rather than the old spec, so that
+	// failed changes are retried on resyncs.
+	userChanged := !userSynced(newTenant)
+	if oldTenant.Spec.TenantID != newTenant.Spec.TenantID {
+		glog.Warningf("Changing tenantID of tenant %s is not supported", newTenant.Name)
+	}
+	if !resync && !securityGroupChanged && !userChanged {
+		return
+	}
+
+	tenantID, err := c.getTenantID(newTenant)
+	if err != nil {
+		glog.Errorf("Failed get tenantID of tenant %s: %v", newTenant.Name, err)
+		return
+	}
+
+	// Converge the default security group on resyncs and its spec changes,
+	// which also repairs the rules changed out of band. Removing the spec
+	// restores the group to allow all traffic.
+	if securityGroupChanged && newTenant.Spec.SecurityGroup == nil {
+		if err := c.resetSecurityGroup(newTenant, tenantID); err != nil {
+			glog.Errorf("Failed reset security group of tenant %s: %v", newTenant.Name, err)
+		}
+	} else if resync || securityGroupChanged {
+		if err := c.syncSecurityGroup(newTenant, tenantID); err != nil {
+			glog.Errorf("Failed sync security group of tenant %s: %v", newTenant.Name, err)
+		}
+	}
+
+	if userChanged {
+		copyObj, err := c.kubeCRDClient.Scheme().Copy(newTenant)
+		if err != nil {
+			glog.Errorf("ERROR creating a deep copy of tenant object: %#v\n", err)
+			return
+		}
+		if err := c.syncUser(copyObj.(*crv1.Tenant), tenantID); err != nil {
+			glog.Errorf("Failed sync user of tenant %s: %v", newTenant.Name, err)
+		}
+	}
 }
 
 func (c *TenantController) onDelete(obj interface{}) {
@@ -17,9 +17,14 @@ limitations under the License.
 package tenant
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
 	crv1 "git.openstack.org/openstack/stackube/pkg/apis/v1"
 	"git.openstack.org/openstack/stackube/pkg/auth-controller/rbacmanager/rbac"
 	"git.openstack.org/openstack/stackube/pkg/openstack"
+	drivertypes "git.openstack.org/openstack/stackube/pkg/openstack/types"
 
 	"github.com/golang/glog"
 	apiv1 "k8s.io/api/core/v1"
@@ -27,6 +32,11 @@ import (
 	apismetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+const (
+	directionIngress = "ingress"
+	directionEgress  = "egress"
+)
+
 func (c *TenantController) syncTenant(tenant *crv1.Tenant) {
 	roleBinding := rbac.GenerateClusterRoleBindingByTenant(tenant.Name)
 	_, err := c.k8sClient.Rbac().ClusterRoleBindings().Create(roleBinding)
@@ -35,28 +45,32 @@ func (c *TenantController) syncTenant(tenant *crv1.Tenant) {
 		return
 	}
 	glog.V(4).Infof("Created ClusterRoleBindings %s-namespace-creater for tenant %s", tenant.Name, tenant.Name)
-	if tenant.Spec.TenantID != "" {
+	tenantID := tenant.Spec.TenantID
+	if tenantID != "" {
 		// Create user with the spec username and password in the given tenant
-		err = c.openstackClient.CreateUser(tenant.Spec.UserName, tenant.Spec.Password, tenant.Spec.TenantID)
-		if err != nil && !openstack.IsAlreadyExists(err) {
+		if err = c.syncUser(tenant, tenantID); err != nil {
 			glog.Errorf("Failed create user %s: %v", tenant.Spec.UserName, err)
 			return
 		}
 	} else {
 		// Create tenant if the tenant not exist in keystone, or get the tenantID by tenantName
-		tenantID, err := c.openstackClient.CreateTenant(tenant.Name)
+		tenantID, err = c.openstackClient.CreateTenant(tenant.Name)
 		if err != nil {
 			glog.Errorf("Failed create tenant %#v: %v", tenant, err)
 			return
 		}
 		// Create user with the spec username and password in the created tenant
-		err = c.openstackClient.CreateUser(tenant.Spec.UserName, tenant.Spec.Password, tenantID)
-		if err != nil {
+		if err = c.syncUser(tenant, tenantID); err != nil {
 			glog.Errorf("Failed create user %s: %v", tenant.Spec.UserName, err)
 			return
 		}
 	}
 
+	// Converge the default security group to the declared rules
+	if err := c.syncSecurityGroup(tenant, tenantID); err != nil {
+		glog.Errorf("Failed sync security group of tenant %s: %v", tenant.Name, err)
+	}
+
 	// Create namespace which name is the same as the tenant's name
 	err = c.createNamespace(tenant.Name)
 	if err != nil {
@@ -66,6 +80,127 @@ func (c *TenantController) syncTenant(tenant *crv1.Tenant) {
 	glog.V(4).Infof("Created namespace %s for tenant %s", tenant.Name, tenant.Name)
 }
 
+// getTenantID gets the Keystone tenant ID of the tenant.
+func (c *TenantController) getTenantID(tenant *crv1.Tenant) (string, error) {
+	if tenant.Spec.TenantID != "" {
+		return tenant.Spec.TenantID, nil
+	}
+	return c.openstackClient.GetTenantIDFromName(tenant.Name)
+}
+
+// passwordHash returns the hash of the spec password salted with the tenant
+// UID, so that the password applied is not kept in status.
+func passwordHash(tenant *crv1.Tenant) string {
+	sum := sha256.Sum256([]byte(string(tenant.UID) + ":" + tenant.Spec.Password))
+	return hex.EncodeToString(sum[:])
+}
+
+// userSynced checks whether the Keystone user of the tenant is in sync with
+// the username and password of spec.
+func userSynced(tenant *crv1.Tenant) bool {
+	if tenant.Status.AppliedUserName != tenant.Spec.UserName ||
+		tenant.Status.AppliedPasswordHash != passwordHash(tenant) {
+		return false
+	}
+	for _, condition := range tenant.Status.Conditions {
+		if condition.Type == crv1.TenantUserReady {
+			return condition.Status == crv1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// syncUser applies the username and password of spec to the Keystone user of
+// the tenant. Changes are applied against the user recorded in status, so that
+// changes failed or made while the controller is down are applied by later
+// syncs. The result is recorded in the UserReady condition.
+func (c *TenantController) syncUser(tenant *crv1.Tenant, tenantID string) error {
+	appliedUserName, userName := tenant.Status.AppliedUserName, tenant.Spec.UserName
+
+	var err error
+	var reason, message string
+	switch appliedUserName {
+	case "":
+		// The user is not applied yet, or applied before status is recorded.
+		err = c.ensureUser(userName, tenant.Spec.Password, tenantID)
+		reason, message = "UserCreated", fmt.Sprintf("User %s is created", userName)
+		if err != nil {
+			reason, message = "UserCreateFailed", fmt.Sprintf("Failed create user %s: %v", userName, err)
+		}
+	case userName:
+		err = c.openstackClient.UpdateUserPassword(userName, tenant.Spec.Password, tenantID)
+		reason, message = "PasswordUpdated", fmt.Sprintf("Password of user %s is updated", userName)
+		if err != nil {
+			reason, message = "PasswordUpdateFailed", fmt.Sprintf("Failed update password of user %s: %v", userName, err)
+		}
+	default:
+		err = c.moveUser(appliedUserName, userName, tenant.Spec.Password, tenantID)
+		reason, message = "UserChanged", fmt.Sprintf("User is changed from %s to %s", appliedUserName, userName)
+		if err != nil {
+			reason, message = "UserChangeFailed", fmt.Sprintf("Failed change user from %s to %s: %v", appliedUserName, userName, err)
+		}
+	}
+
+	status := crv1.ConditionTrue
+	if err != nil {
+		status = crv1.ConditionFalse
+	} else {
+		tenant.Status.AppliedUserName = userName
+		tenant.Status.AppliedPasswordHash = passwordHash(tenant)
+	}
+	setTenantCondition(&tenant.Status, crv1.TenantUserReady, status, reason, message)
+	if updateErr := c.kubeCRDClient.UpdateTenant(tenant); updateErr != nil {
+		glog.Errorf("Update tenant %s condition %s failed: %v", tenant.Name, crv1.TenantUserReady, updateErr)
+	}
+	return err
+}
+
+// ensureUser creates the user if it not exists, and sets its password.
+func (c *TenantController) ensureUser(userName, password, tenantID string) error {
+	if err := c.openstackClient.CreateUser(userName, password, tenantID); err != nil && !openstack.IsAlreadyExists(err) {
+		return err
+	}
+	// The user may be left by a previous failed change, make sure it has the
+	// password of spec.
+	return c.openstackClient.UpdateUserPassword(userName, password, tenantID)
+}
+
+// moveUser moves the tenant from the old user to the new one. The new user is
+// created before the old one is deleted, so that the tenant always has a user.
+func (c *TenantController) moveUser(oldUserName, userName, password, tenantID string) error {
+	if err := c.ensureUser(userName, password, tenantID); err != nil {
+		return err
+	}
+	return c.openstackClient.DeleteUser(oldUserName, tenantID)
+}
+
+// setTenantCondition sets a condition of tenant status. LastTransitionTime
+// is only changed when status of the condition changes.
+func setTenantCondition(tenantStatus *crv1.TenantStatus, conditionType crv1.TenantConditionType,
+	status crv1.ConditionStatus, reason, message string) {
+	condition := crv1.TenantCondition{
+		Type:               conditionType,
+		Status:             status,
+		LastTransitionTime: apismetav1.Now(),
+		Reason:             reason,
+		Message:            message,
+	}
+
+	for i := range tenantStatus.Conditions {
+		existing := &tenantStatus.Conditions[i]
+		if existing.Type != conditionType {
+			continue
+		}
+		if existing.Status == status {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		}
+		*existing = condition
+		return
+	}
+
+	tenantStatus.Conditions = append(tenantStatus.Conditions, condition)
+}
+
 func (c *TenantController) createClusterRoles() error {
 	nsCreater := rbac.GenerateClusterRole()
 	_, err := c.k8sClient.Rbac().ClusterRoles().Create(nsCreater)
@@ -97,3 +232,64 @@ func (c *TenantController) deleteNamespace(namespace string) error {
 	}
 	return nil
 }
+
+// syncSecurityGroup converges the tenant's default security group in Neutron
+// to the rules declared by spec, rules not declared are removed. Without
+// securityGroup in spec, the group is only created if missing, and the rules
+// managed out of band are left untouched.
+func (c *TenantController) syncSecurityGroup(tenant *crv1.Tenant, tenantID string) error {
+	if tenant.Spec.SecurityGroup == nil {
+		_, err := c.openstackClient.EnsureDefaultSecurityGroup(tenantID, nil)
+		return err
+	}
+
+	return c.ensureSecurityGroupRules(tenant, tenantID)
+}
+
+// resetSecurityGroup converges the tenant's default security group to allow
+// all traffic, it is done when securityGroup is removed from spec.
+func (c *TenantController) resetSecurityGroup(tenant *crv1.Tenant, tenantID string) error {
+	copyTenant := *tenant
+	copyTenant.Spec.SecurityGroup = nil
+	return c.ensureSecurityGroupRules(&copyTenant, tenantID)
+}
+
+func (c *TenantController) ensureSecurityGroupRules(tenant *crv1.Tenant, tenantID string) error {
+	rules, err := newDriverSecurityGroupRules(tenant.Spec.SecurityGroup)
+	if err != nil {
+		return fmt.Errorf("invalid securityGroup: %v", err)
+	}
+
+	if _, err := c.openstackClient.EnsureDefaultSecurityGroup(tenantID, rules); err != nil {
+		return err
+	}
+	glog.V(4).Infof("Synced default security group of tenant %s with %d rules", tenant.Name, len(rules))
+	return nil
+}
+
+// newDriverSecurityGroupRules translates security group spec to OpenStack
+// security group rules. Traffic is allowed in the directions without rules.
+func newDriverSecurityGroupRules(spec *crv1.SecurityGroupSpec) ([]*drivertypes.SecurityGroupRule, error) {
+	var ingress, egress []crv1.SecurityGroupRule
+	if spec != nil {
+		ingress, egress = spec.Ingress, spec.Egress
+	}
+
+	// An empty but non-nil slice makes sure all existing rules are removed.
+	rules := []*drivertypes.SecurityGroupRule{}
+	for _, direction := range []struct {
+		name  string
+		rules []crv1.SecurityGroupRule
+	}{
+		{name: directionIngress, rules: ingress},
+		{name: directionEgress, rules: egress},
+	} {
+		translated, err := openstack.NewSecurityGroupRules(direction.name, direction.rules)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, translated...)
+	}
+
+	return rules, nil
+}
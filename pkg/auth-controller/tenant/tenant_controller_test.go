@@ -25,6 +25,7 @@ import (
 	"git.openstack.org/openstack/stackube/pkg/auth-controller/rbacmanager/rbac"
 	crdClient "git.openstack.org/openstack/stackube/pkg/kubecrd"
 	"git.openstack.org/openstack/stackube/pkg/openstack"
+	drivertypes "git.openstack.org/openstack/stackube/pkg/openstack/types"
 	"git.openstack.org/openstack/stackube/pkg/util"
 	apismetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/fake"
@@ -143,7 +144,6 @@ func TestCreateClusterRoles(t *testing.T) {
 
 func TestOnAdd(t *testing.T) {
 	var controller *TenantController
-	var kubeCRDClient *crdClient.FakeCRDClient
 	var osClient *openstack.FakeOSClient
 	var client *fake.Clientset
 	var err error
@@ -160,7 +160,7 @@ func TestOnAdd(t *testing.T) {
 			tenantName: "default",
 			updateFn: func(tenantName string) {
 				// Created a new fake TenantController.
-				controller, kubeCRDClient, osClient, client, err = newTenantController()
+				controller, _, osClient, client, err = newTenantController()
 				if err != nil {
 					t.Fatalf("Failed start a new fake TenantController")
 				}
@@ -182,7 +182,7 @@ func TestOnAdd(t *testing.T) {
 					return fmt.Errorf("the created %s tenant has incorrect parameter: %v", tenantName, tenant)
 				}
 				// test user created
-				user, ok := osClient.Users[tenant.ID]
+				user, ok := osClient.Users[tenantName]
 				if !ok {
 					return fmt.Errorf("expected %s user to be created, got none", tenantName)
 				} else if user.Name != tenantName &&
@@ -220,7 +220,7 @@ func TestOnAdd(t *testing.T) {
 					return fmt.Errorf("the created %s tenant has incorrect parameter: %v", tenantName, tenant)
 				}
 				// test user created
-				user, ok := osClient.Users[tenant.ID]
+				user, ok := osClient.Users[tenantName]
 				if !ok {
 					return fmt.Errorf("expected %s user to be created, got none", tenantName)
 				} else if user.Name != tenantName &&
@@ -254,7 +254,7 @@ func TestOnAdd(t *testing.T) {
 					return err
 				}
 				// test user created
-				user, ok := osClient.Users[tenantID]
+				user, ok := osClient.Users[tenantName]
 				if !ok {
 					return fmt.Errorf("expected %s user to be created, got none", tenantName)
 				} else if user.Name != tenantName &&
@@ -289,7 +289,7 @@ func TestOnAdd(t *testing.T) {
 				}
 				// test user created
 				tenant, _ := osClient.Tenants[tenantName]
-				user, ok := osClient.Users[tenant.ID]
+				user, ok := osClient.Users[tenantName]
 				if !ok {
 					return fmt.Errorf("expected %s user to be created, got none", tenantName)
 				} else if user.Name != tenantName &&
@@ -323,8 +323,7 @@ func TestOnAdd(t *testing.T) {
 					return err
 				}
 				// test no user created
-				tenant, _ := osClient.Tenants[tenantName]
-				user, ok := osClient.Users[tenant.ID]
+				user, ok := osClient.Users[tenantName]
 				if ok {
 					return fmt.Errorf("expected no user to be created, got %v", user)
 				}
@@ -394,7 +393,7 @@ func TestOnDelete(t *testing.T) {
 					return fmt.Errorf("expected %s tenant to be deleted, got %v", tenantName, tenant)
 				}
 				// test user deleted
-				user, ok := osClient.Users[tenantID]
+				user, ok := osClient.Users[tenantName]
 				if ok {
 					return fmt.Errorf("expected %s user to be deleted, got %v", tenantName, user)
 				}
@@ -448,7 +447,7 @@ func TestOnDelete(t *testing.T) {
 					return fmt.Errorf("expected %s tenant remain existed, got none", tenantName)
 				}
 				// test user deleted
-				user, ok := osClient.Users[tenantID]
+				user, ok := osClient.Users[tenantName]
 				if ok {
 					return fmt.Errorf("expected %s user to be deleted, got %v", tenantName, user)
 				}
@@ -510,3 +509,289 @@ func testNamespaceDeleted(t *testing.T, client *fake.Clientset, namespace string
 	}
 	return nil
 }
+
+func defaultSecurityGroup(osClient *openstack.FakeOSClient, tenantID string) *drivertypes.SecurityGroup {
+	for _, sg := range osClient.SecurityGroups {
+		if sg.TenantID == tenantID && sg.Name == "kube-securitygroup-default" {
+			return sg
+		}
+	}
+	return nil
+}
+
+func TestSyncSecurityGroup(t *testing.T) {
+	tenantID := "123"
+	allowAll := []*drivertypes.SecurityGroupRule{
+		{Direction: directionIngress, EtherType: "IPv4"},
+		{Direction: directionIngress, EtherType: "IPv6"},
+		{Direction: directionEgress, EtherType: "IPv4"},
+		{Direction: directionEgress, EtherType: "IPv6"},
+	}
+	sshOnly := []*drivertypes.SecurityGroupRule{
+		{Direction: directionIngress, EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 22, PortRangeMax: 22, RemoteIPPrefix: "10.0.0.0/8"},
+	}
+
+	testCases := []struct {
+		testName      string
+		securityGroup *crv1.SecurityGroupSpec
+		// existing are the rules of the default security group before sync,
+		// the group is missing if nil.
+		existing    []*drivertypes.SecurityGroupRule
+		expectedErr bool
+		expected    []*drivertypes.SecurityGroupRule
+	}{
+		{
+			testName: "no security group declared,group created with all traffic allowed",
+			expected: allowAll,
+		},
+		{
+			testName: "no security group declared,rules managed out of band kept",
+			existing: sshOnly,
+			expected: sshOnly,
+		},
+		{
+			testName: "ingress restricted,egress denied",
+			securityGroup: &crv1.SecurityGroupSpec{
+				Ingress: []crv1.SecurityGroupRule{
+					{CIDR: "10.0.0.0/8", Protocol: "TCP", PortRangeMin: 22},
+					{Protocol: "icmp"},
+				},
+				Egress: []crv1.SecurityGroupRule{},
+			},
+			existing: allowAll,
+			expected: []*drivertypes.SecurityGroupRule{
+				{Direction: directionIngress, EtherType: "IPv4", Protocol: "tcp", PortRangeMin: 22, PortRangeMax: 22, RemoteIPPrefix: "10.0.0.0/8"},
+				{Direction: directionIngress, EtherType: "IPv4", Protocol: "icmp"},
+				{Direction: directionIngress, EtherType: "IPv6", Protocol: "icmp"},
+			},
+		},
+		{
+			testName: "invalid port range,rules kept",
+			securityGroup: &crv1.SecurityGroupSpec{
+				Ingress: []crv1.SecurityGroupRule{
+					{Protocol: "icmp", PortRangeMin: 80, PortRangeMax: 90},
+				},
+			},
+			existing:    sshOnly,
+			expectedErr: true,
+			expected:    sshOnly,
+		},
+	}
+
+	for tci, tc := range testCases {
+		controller, _, osClient, _, err := newTenantController()
+		if err != nil {
+			t.Fatalf("Failed start a new fake TenantController")
+		}
+		if tc.existing != nil {
+			osClient.EnsureDefaultSecurityGroup(tenantID, tc.existing)
+		}
+
+		tenant := newTenant("foo", "foo", password, tenantID)
+		tenant.Spec.SecurityGroup = tc.securityGroup
+		err = controller.syncSecurityGroup(tenant, tenantID)
+		if tc.expectedErr != (err != nil) {
+			t.Errorf("Case[%d]: %s expected error %v, got %v", tci, tc.testName, tc.expectedErr, err)
+		}
+		sg := defaultSecurityGroup(osClient, tenantID)
+		if sg == nil {
+			t.Errorf("Case[%d]: %s expected default security group to be created, got none", tci, tc.testName)
+			continue
+		}
+		if !reflect.DeepEqual(sg.Rules, tc.expected) {
+			t.Errorf("Case[%d]: %s expected rules %v, got %v", tci, tc.testName, tc.expected, sg.Rules)
+		}
+	}
+}
+
+func TestOnUpdateSecurityGroupRemoved(t *testing.T) {
+	tenantID := "123"
+	controller, _, osClient, _, err := newTenantController()
+	if err != nil {
+		t.Fatalf("Failed start a new fake TenantController")
+	}
+
+	oldTenant := newTenant("foo", "foo", password, tenantID)
+	oldTenant.ResourceVersion = "1"
+	oldTenant.Spec.SecurityGroup = &crv1.SecurityGroupSpec{Egress: []crv1.SecurityGroupRule{}}
+	if err := controller.syncSecurityGroup(oldTenant, tenantID); err != nil {
+		t.Fatalf("Failed sync security group: %v", err)
+	}
+
+	tenant := newTenant("foo", "foo", password, tenantID)
+	tenant.ResourceVersion = "2"
+	controller.onUpdate(oldTenant, tenant)
+
+	expected := []*drivertypes.SecurityGroupRule{
+		{Direction: directionIngress, EtherType: "IPv4"},
+		{Direction: directionIngress, EtherType: "IPv6"},
+		{Direction: directionEgress, EtherType: "IPv4"},
+		{Direction: directionEgress, EtherType: "IPv6"},
+	}
+	if sg := defaultSecurityGroup(osClient, tenantID); sg == nil || !reflect.DeepEqual(sg.Rules, expected) {
+		t.Errorf("Expected security group with rules %v, got %v", expected, sg)
+	}
+}
+
+func TestOnUpdateUser(t *testing.T) {
+	tenantID := "123"
+	newPassword := "654321"
+
+	testCases := []struct {
+		testName    string
+		newUserName string
+		newPassword string
+		// updateFn changes the old tenant, which is applied and ready by default.
+		updateFn         func(oldTenant *crv1.Tenant)
+		add              bool
+		injectErrors     map[string]error
+		expectedUserName string
+		expectedPassword string
+		expectedDeleted  string
+		expectedStatus   crv1.ConditionStatus
+		expectedReason   string
+	}{
+		{
+			testName:         "password rotated",
+			newUserName:      "foo",
+			newPassword:      newPassword,
+			expectedUserName: "foo",
+			expectedPassword: newPassword,
+			expectedStatus:   crv1.ConditionTrue,
+			expectedReason:   "PasswordUpdated",
+		},
+		{
+			testName:         "user changed,old user deleted",
+			newUserName:      "bar",
+			newPassword:      newPassword,
+			expectedUserName: "bar",
+			expectedPassword: newPassword,
+			expectedDeleted:  "foo",
+			expectedStatus:   crv1.ConditionTrue,
+			expectedReason:   "UserChanged",
+		},
+		{
+			testName:         "password update failed,password kept",
+			newUserName:      "foo",
+			newPassword:      newPassword,
+			injectErrors:     map[string]error{"UpdateUserPassword": fmt.Errorf("update failed")},
+			expectedUserName: "foo",
+			expectedPassword: password,
+			expectedStatus:   crv1.ConditionFalse,
+			expectedReason:   "PasswordUpdateFailed",
+		},
+		{
+			testName:         "user creation failed,old user kept",
+			newUserName:      "bar",
+			newPassword:      newPassword,
+			injectErrors:     map[string]error{"CreateUser": fmt.Errorf("create failed")},
+			expectedUserName: "foo",
+			expectedPassword: password,
+			expectedStatus:   crv1.ConditionFalse,
+			expectedReason:   "UserChangeFailed",
+		},
+		{
+			testName:    "user change failed before,retried on resync",
+			newUserName: "bar",
+			newPassword: newPassword,
+			updateFn: func(oldTenant *crv1.Tenant) {
+				oldTenant.Spec.UserName, oldTenant.Spec.Password = "bar", newPassword
+				oldTenant.ResourceVersion = "2"
+				setTenantCondition(&oldTenant.Status, crv1.TenantUserReady, crv1.ConditionFalse, "UserChangeFailed", "")
+			},
+			expectedUserName: "bar",
+			expectedPassword: newPassword,
+			expectedDeleted:  "foo",
+			expectedStatus:   crv1.ConditionTrue,
+			expectedReason:   "UserChanged",
+		},
+		{
+			testName:         "user changed while controller down,applied on add",
+			newUserName:      "bar",
+			newPassword:      newPassword,
+			add:              true,
+			expectedUserName: "bar",
+			expectedPassword: newPassword,
+			expectedDeleted:  "foo",
+			expectedStatus:   crv1.ConditionTrue,
+			expectedReason:   "UserChanged",
+		},
+		{
+			testName:    "status not recorded,password applied",
+			newUserName: "foo",
+			newPassword: newPassword,
+			updateFn: func(oldTenant *crv1.Tenant) {
+				oldTenant.Status = crv1.TenantStatus{}
+			},
+			expectedUserName: "foo",
+			expectedPassword: newPassword,
+			expectedStatus:   crv1.ConditionTrue,
+			expectedReason:   "UserCreated",
+		},
+		{
+			testName:    "user in sync on resync,nothing applied",
+			newUserName: "foo",
+			newPassword: password,
+			updateFn: func(oldTenant *crv1.Tenant) {
+				oldTenant.ResourceVersion = "2"
+			},
+			injectErrors:     map[string]error{"UpdateUserPassword": fmt.Errorf("update failed")},
+			expectedUserName: "foo",
+			expectedPassword: password,
+			expectedStatus:   crv1.ConditionTrue,
+			expectedReason:   "UserCreated",
+		},
+	}
+
+	for tci, tc := range testCases {
+		controller, kubeCRDClient, osClient, _, err := newTenantController()
+		if err != nil {
+			t.Fatalf("Failed start a new fake TenantController")
+		}
+		oldTenant := newTenant("foo", "foo", password, tenantID)
+		oldTenant.ResourceVersion = "1"
+		oldTenant.Status.AppliedUserName = "foo"
+		oldTenant.Status.AppliedPasswordHash = passwordHash(oldTenant)
+		setTenantCondition(&oldTenant.Status, crv1.TenantUserReady, crv1.ConditionTrue, "UserCreated", "")
+		if tc.updateFn != nil {
+			tc.updateFn(oldTenant)
+		}
+		kubeCRDClient.SetTenants(oldTenant)
+		osClient.SetTenant("foo", tenantID)
+		osClient.CreateUser("foo", password, tenantID)
+		osClient.InjectErrors(tc.injectErrors)
+
+		tenant := newTenant("foo", tc.newUserName, tc.newPassword, tenantID)
+		tenant.ResourceVersion = "2"
+		tenant.Status = oldTenant.Status
+		if tc.add {
+			controller.onAdd(tenant)
+		} else {
+			controller.onUpdate(oldTenant, tenant)
+		}
+
+		user, ok := osClient.Users[tc.expectedUserName]
+		if !ok {
+			t.Errorf("Case[%d]: %s expected user %s, got none", tci, tc.testName, tc.expectedUserName)
+		} else if osClient.UserPasswords[user.ID] != tc.expectedPassword {
+			t.Errorf("Case[%d]: %s expected user %s with password %s, got %s", tci, tc.testName,
+				tc.expectedUserName, tc.expectedPassword, osClient.UserPasswords[user.ID])
+		}
+		if tc.expectedDeleted != "" {
+			if _, ok := osClient.Users[tc.expectedDeleted]; ok {
+				t.Errorf("Case[%d]: %s expected user %s to be deleted, got %v", tci, tc.testName, tc.expectedDeleted, osClient.Users)
+			}
+		}
+
+		status := kubeCRDClient.Tenants["foo"].Status
+		if status.AppliedUserName != tc.expectedUserName {
+			t.Errorf("Case[%d]: %s expected applied user %s, got %s", tci, tc.testName, tc.expectedUserName, status.AppliedUserName)
+		}
+		conditions := status.Conditions
+		if len(conditions) != 1 || conditions[0].Type != crv1.TenantUserReady ||
+			conditions[0].Status != tc.expectedStatus || conditions[0].Reason != tc.expectedReason {
+			t.Errorf("Case[%d]: %s expected condition %s %s with reason %s, got %v", tci, tc.testName,
+				crv1.TenantUserReady, tc.expectedStatus, tc.expectedReason, conditions)
+		}
+	}
+}
@@ -0,0 +1,458 @@
+/*
+Copyright (c) 2017 OpenStack Foundation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package floatingip
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	apiextensionsclient "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/fields"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	informersV1 "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	crv1 "git.openstack.org/openstack/stackube/pkg/apis/v1"
+	"git.openstack.org/openstack/stackube/pkg/kubecrd"
+	"git.openstack.org/openstack/stackube/pkg/openstack"
+	"git.openstack.org/openstack/stackube/pkg/util"
+)
+
+const (
+	resyncPeriod = 5 * time.Minute
+
+	// How long to wait before retrying the processing of a floating IP change.
+	minRetryDelay = 5 * time.Second
+	maxRetryDelay = 300 * time.Second
+)
+
+// FloatingIPController manages FloatingIP CRD objects. A Neutron floating IP
+// is allocated for each of them, and associated with the port of the pod
+// named by spec. Pods are watched, so that the floating IP is moved to the
+// new port once the pod is recreated with the same name. The floating IP is
+// released when the object is deleted.
+type FloatingIPController struct {
+	kubeCRDClient kubecrd.Interface
+	driver        openstack.Interface
+
+	fipStore      cache.Store
+	fipController cache.Controller
+
+	factory     informers.SharedInformerFactory
+	podInformer informersV1.PodInformer
+
+	// floating IPs that need to be synced
+	queue workqueue.RateLimitingInterface
+}
+
+// NewFloatingIPController creates a new FloatingIPController.
+func NewFloatingIPController(kubeClient kubernetes.Interface, osClient openstack.Interface, kubeExtClient *apiextensionsclient.Clientset) (*FloatingIPController, error) {
+	// initialize CRD if it does not exist
+	_, err := kubecrd.CreateFloatingIPCRD(kubeExtClient)
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return nil, fmt.Errorf("failed to create CRD to kube-apiserver: %v", err)
+	}
+
+	source := cache.NewListWatchFromClient(
+		osClient.GetCRDClient().Client(),
+		crv1.FloatingIPResourcePlural,
+		v1.NamespaceAll,
+		fields.Everything())
+	return newFloatingIPControllerWithSource(kubeClient, osClient, source), nil
+}
+
+// newFloatingIPControllerWithSource creates a new FloatingIPController watching
+// FloatingIP objects from source.
+func newFloatingIPControllerWithSource(kubeClient kubernetes.Interface, osClient openstack.Interface, source cache.ListerWatcher) *FloatingIPController {
+	factory := informers.NewSharedInformerFactory(kubeClient, resyncPeriod)
+	c := &FloatingIPController{
+		kubeCRDClient: osClient.GetCRDClient(),
+		driver:        osClient,
+		factory:       factory,
+		podInformer:   factory.Core().V1().Pods(),
+		queue: workqueue.NewNamedRateLimitingQueue(
+			workqueue.NewItemExponentialFailureRateLimiter(minRetryDelay, maxRetryDelay), "floatingip"),
+	}
+
+	c.fipStore, c.fipController = cache.NewInformer(
+		source,
+		&crv1.FloatingIP{},
+		resyncPeriod,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: c.enqueueFloatingIP,
+			UpdateFunc: func(old, cur interface{}) {
+				oldFIP, ok1 := old.(*crv1.FloatingIP)
+				curFIP, ok2 := cur.(*crv1.FloatingIP)
+				// Status updates by ourselves are also notified here, only
+				// care about spec changes, deletions and resyncs.
+				if ok1 && ok2 && reflect.DeepEqual(oldFIP.Spec, curFIP.Spec) &&
+					(oldFIP.DeletionTimestamp == nil) == (curFIP.DeletionTimestamp == nil) &&
+					oldFIP.ResourceVersion != curFIP.ResourceVersion {
+					return
+				}
+				c.enqueueFloatingIP(cur)
+			},
+			DeleteFunc: c.enqueueFloatingIP,
+		})
+
+	c.podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.onPodChanged,
+		UpdateFunc: func(old, cur interface{}) {
+			oldPod, ok1 := old.(*v1.Pod)
+			curPod, ok2 := cur.(*v1.Pod)
+			// The port of pod is created before it gets IP.
+			if ok1 && ok2 && oldPod.Status.PodIP == curPod.Status.PodIP &&
+				oldPod.UID == curPod.UID && oldPod.ResourceVersion != curPod.ResourceVersion {
+				return
+			}
+			c.onPodChanged(cur)
+		},
+		DeleteFunc: c.onPodChanged,
+	})
+
+	return c
+}
+
+// Run starts the informers and workers, and blocks until stopCh is closed.
+func (c *FloatingIPController) Run(stopCh <-chan struct{}) error {
+	defer utilruntime.HandleCrash()
+	defer c.queue.ShutDown()
+
+	glog.Info("Starting floating IP controller")
+	defer glog.Info("Shutting down floating IP controller")
+
+	go c.fipController.Run(stopCh)
+	go c.factory.Start(stopCh)
+
+	if !cache.WaitForCacheSync(stopCh, c.fipController.HasSynced, c.podInformer.Informer().HasSynced) {
+		return fmt.Errorf("failed to cache floating IPs and pods")
+	}
+
+	go wait.Until(c.worker, time.Second, stopCh)
+
+	<-stopCh
+	return nil
+}
+
+// enqueueFloatingIP adds the key of floating IP to the queue. obj could be an
+// *crv1.FloatingIP, or a DeletionFinalStateUnknown marker item.
+func (c *FloatingIPController) enqueueFloatingIP(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		glog.Errorf("Couldn't get key for object %#v: %v", obj, err)
+		return
+	}
+	c.queue.Add(key)
+}
+
+// onPodChanged enqueues the floating IPs of pod.
+func (c *FloatingIPController) onPodChanged(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		glog.Errorf("Couldn't get key for object %#v: %v", obj, err)
+		return
+	}
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		glog.Errorf("Couldn't split key %q: %v", key, err)
+		return
+	}
+
+	for _, obj := range c.fipStore.List() {
+		fip, ok := obj.(*crv1.FloatingIP)
+		if ok && fip.Namespace == namespace && fip.Spec.PodName == name {
+			c.enqueueFloatingIP(fip)
+		}
+	}
+}
+
+// worker runs a worker thread that just dequeues items, processes them, and marks them done.
+// It enforces that the syncFloatingIP is never invoked concurrently with the same key.
+func (c *FloatingIPController) worker() {
+	for c.processNextWorkItem() {
+	}
+}
+
+// processNextWorkItem processes a key from the queue. Failed keys are retried
+// with per-item exponential backoff. It returns false when the queue is shut down.
+func (c *FloatingIPController) processNextWorkItem() bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	err := c.syncFloatingIP(key.(string))
+	if err == nil {
+		c.queue.Forget(key)
+		return true
+	}
+
+	glog.Errorf("Failed to sync floating IP %q (retried %d times), will retry: %v", key, c.queue.NumRequeues(key), err)
+	c.queue.AddRateLimited(key)
+	return true
+}
+
+// syncFloatingIP ensures the Neutron floating IP of the FloatingIP object, and
+// associates it with the current port of the pod.
+func (c *FloatingIPController) syncFloatingIP(key string) error {
+	startTime := time.Now()
+	defer func() {
+		glog.V(4).Infof("Finished syncing floating IP %q (%v)", key, time.Now().Sub(startTime))
+	}()
+
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+
+	// Get the latest floating IP, so that status is updated with the latest resourceVersion.
+	fip, err := c.kubeCRDClient.GetFloatingIP(namespace, name)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			// The floating IP is released before the finalizer is removed.
+			return nil
+		}
+		return err
+	}
+
+	if fip.DeletionTimestamp != nil {
+		return c.finalizeFloatingIP(fip)
+	}
+
+	status := fip.Status
+	if err := c.ensureFloatingIP(fip, &status); err != nil {
+		status.State = crv1.FloatingIPFailed
+		status.Message = err.Error()
+		if updateErr := c.updateStatus(fip, status); updateErr != nil {
+			glog.Errorf("Update status of floating IP %s failed: %v", key, updateErr)
+		}
+		return err
+	}
+
+	return c.updateStatus(fip, status)
+}
+
+// ensureFloatingIP allocates the floating IP if it is not yet, and associates
+// it with the port of pod. The status is updated along the way.
+func (c *FloatingIPController) ensureFloatingIP(fip *crv1.FloatingIP, status *crv1.FloatingIPStatus) error {
+	if fip.Spec.PodName == "" {
+		return fmt.Errorf("podName is required")
+	}
+	if fip.Spec.FloatingIPAddress != "" && net.ParseIP(fip.Spec.FloatingIPAddress) == nil {
+		return fmt.Errorf("invalid floatingIPAddress %q", fip.Spec.FloatingIPAddress)
+	}
+
+	pod, err := c.podInformer.Lister().Pods(fip.Namespace).Get(fip.Spec.PodName)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	var allocated bool
+	if status.FloatingIPID != "" {
+		existing, err := c.driver.GetFloatingIP(status.FloatingIPID)
+		if err == openstack.ErrNotFound {
+			glog.Warningf("Floating IP %s of %s/%s is gone, allocating again", status.FloatingIPID, fip.Namespace, fip.Name)
+			status.FloatingIPID = ""
+			status.PortID = ""
+			status.FixedIPAddress = ""
+		} else if err != nil {
+			return err
+		} else {
+			if fip.Spec.FloatingIPAddress != "" && fip.Spec.FloatingIPAddress != existing.FloatingIP {
+				return fmt.Errorf("floatingIPAddress can't be changed from %s to %s", existing.FloatingIP, fip.Spec.FloatingIPAddress)
+			}
+			status.FloatingIPAddress = existing.FloatingIP
+			status.PortID = existing.PortID
+			status.FixedIPAddress = existing.FixedIP
+		}
+	}
+
+	if status.FloatingIPID == "" {
+		if err := c.allocateFloatingIP(fip, pod, status); err != nil {
+			return err
+		}
+		allocated = true
+	}
+
+	// Associate with the current port of pod, the port is deleted and created
+	// again along with the pod.
+	portName := util.BuildPortName(fip.Namespace, fip.Spec.PodName)
+	port, err := c.driver.GetPort(portName)
+	if err == openstack.ErrNotFound {
+		if status.PortID != "" && !allocated {
+			glog.V(4).Infof("Port %s is gone, disassociating floating IP %s", portName, status.FloatingIPAddress)
+			if err := c.driver.UpdateFloatingIPAssociation(status.FloatingIPID, "", ""); err != nil {
+				return err
+			}
+		}
+		status.State = crv1.FloatingIPPending
+		status.Message = fmt.Sprintf("Waiting for the port of pod %s", fip.Spec.PodName)
+		status.PortID = ""
+		status.FixedIPAddress = ""
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("get port %s failed: %v", portName, err)
+	}
+
+	var fixedIP string
+	for _, ip := range port.FixedIPs {
+		if parsed := net.ParseIP(ip.IPAddress); parsed != nil && parsed.To4() != nil {
+			fixedIP = ip.IPAddress
+			break
+		}
+	}
+	if fixedIP == "" {
+		return fmt.Errorf("port %s has no IPv4 address", portName)
+	}
+
+	if status.PortID != port.ID || status.FixedIPAddress != fixedIP {
+		glog.V(4).Infof("Associating floating IP %s with %s of port %s", status.FloatingIPAddress, fixedIP, portName)
+		if err := c.driver.UpdateFloatingIPAssociation(status.FloatingIPID, port.ID, fixedIP); err != nil {
+			return err
+		}
+		status.PortID = port.ID
+		status.FixedIPAddress = fixedIP
+	}
+	status.State = crv1.FloatingIPAssociated
+	status.Message = ""
+	return nil
+}
+
+// allocateFloatingIP allocates a floating IP for the tenant of namespace, and
+// records it in the object with the finalizer, so that it is always released.
+func (c *FloatingIPController) allocateFloatingIP(fip *crv1.FloatingIP, pod *v1.Pod, status *crv1.FloatingIPStatus) error {
+	tenantID, err := c.driver.GetTenantIDFromName(fip.Namespace)
+	if err != nil || tenantID == "" {
+		return fmt.Errorf("failed to fetch tenantID for tenantName: %v, error: %v", fip.Namespace, err)
+	}
+
+	extNetID, err := c.externalNetworkID(fip, pod)
+	if err != nil {
+		return err
+	}
+
+	// The address is kept if the floating IP is allocated again.
+	address := fip.Spec.FloatingIPAddress
+	if address == "" {
+		address = status.FloatingIPAddress
+	}
+	allocated, err := c.driver.CreateFloatingIP(tenantID, extNetID, address)
+	if err != nil {
+		return fmt.Errorf("allocate floating IP failed: %v", err)
+	}
+	glog.V(4).Infof("Allocated floating IP %s for %s/%s", allocated.FloatingIP, fip.Namespace, fip.Name)
+
+	// Record the floating IP at once, otherwise it is leaked if following
+	// steps fail.
+	newFIP := fip.DeepCopy()
+	newFIP.Status = crv1.FloatingIPStatus{
+		State:             crv1.FloatingIPPending,
+		FloatingIPID:      allocated.ID,
+		FloatingIPAddress: allocated.FloatingIP,
+	}
+	if !hasFinalizer(newFIP) {
+		newFIP.Finalizers = append(newFIP.Finalizers, crv1.FloatingIPFinalizer)
+	}
+	if err := c.kubeCRDClient.UpdateFloatingIP(newFIP); err != nil {
+		if delErr := c.driver.DeleteFloatingIP(allocated.ID); delErr != nil {
+			glog.Errorf("Delete floating IP %s failed: %v", allocated.ID, delErr)
+		}
+		return fmt.Errorf("record floating IP %s failed: %v", allocated.FloatingIP, err)
+	}
+	*fip = *newFIP
+	*status = newFIP.Status
+	return nil
+}
+
+// externalNetworkID returns the external network which the floating IP is
+// allocated from: the one of spec, or the one of pod's network. Empty means
+// the one of stackube config.
+func (c *FloatingIPController) externalNetworkID(fip *crv1.FloatingIP, pod *v1.Pod) (string, error) {
+	if fip.Spec.ExternalNetworkID != "" {
+		return fip.Spec.ExternalNetworkID, nil
+	}
+
+	var annotations map[string]string
+	if pod != nil {
+		annotations = pod.Annotations
+	}
+	networkName, err := kubecrd.GetPodNetworkName(c.kubeCRDClient, fip.Namespace, annotations)
+	if err != nil {
+		return "", err
+	}
+	network, err := c.kubeCRDClient.GetNetwork(fip.Namespace, networkName)
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return network.Spec.ExternalNetworkID, nil
+}
+
+// finalizeFloatingIP releases the floating IP and removes the finalizer.
+func (c *FloatingIPController) finalizeFloatingIP(fip *crv1.FloatingIP) error {
+	if !hasFinalizer(fip) {
+		return nil
+	}
+
+	if fip.Status.FloatingIPID != "" {
+		glog.V(4).Infof("Releasing floating IP %s of %s/%s", fip.Status.FloatingIPAddress, fip.Namespace, fip.Name)
+		if err := c.driver.DeleteFloatingIP(fip.Status.FloatingIPID); err != nil {
+			return fmt.Errorf("release floating IP %s failed: %v", fip.Status.FloatingIPAddress, err)
+		}
+	}
+
+	newFIP := fip.DeepCopy()
+	var finalizers []string
+	for _, f := range newFIP.Finalizers {
+		if f != crv1.FloatingIPFinalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+	newFIP.Finalizers = finalizers
+	return c.kubeCRDClient.UpdateFloatingIP(newFIP)
+}
+
+// updateStatus updates status of the floating IP if it is changed.
+func (c *FloatingIPController) updateStatus(fip *crv1.FloatingIP, status crv1.FloatingIPStatus) error {
+	if reflect.DeepEqual(fip.Status, status) {
+		return nil
+	}
+
+	newFIP := fip.DeepCopy()
+	newFIP.Status = status
+	return c.kubeCRDClient.UpdateFloatingIP(newFIP)
+}
+
+func hasFinalizer(fip *crv1.FloatingIP) bool {
+	for _, f := range fip.Finalizers {
+		if f == crv1.FloatingIPFinalizer {
+			return true
+		}
+	}
+	return false
+}
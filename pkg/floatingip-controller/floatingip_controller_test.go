@@ -0,0 +1,316 @@
+/*
+Copyright (c) 2017 OpenStack Foundation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package floatingip
+
+import (
+	"fmt"
+	"testing"
+
+	crv1 "git.openstack.org/openstack/stackube/pkg/apis/v1"
+	crdClient "git.openstack.org/openstack/stackube/pkg/kubecrd"
+	"git.openstack.org/openstack/stackube/pkg/openstack"
+	drivertypes "git.openstack.org/openstack/stackube/pkg/openstack/types"
+	"git.openstack.org/openstack/stackube/pkg/util"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	namespace = "test"
+	tenantID  = "123"
+	networkID = "456"
+	podName   = "web"
+	fipName   = "web-fip"
+)
+
+func newFloatingIP(address string) *crv1.FloatingIP {
+	return &crv1.FloatingIP{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fipName,
+			Namespace: namespace,
+		},
+		Spec: crv1.FloatingIPSpec{
+			PodName:           podName,
+			FloatingIPAddress: address,
+		},
+	}
+}
+
+func newPort(id, ip string) ports.Port {
+	return ports.Port{
+		ID:        id,
+		Name:      util.BuildPortName(namespace, podName),
+		NetworkID: networkID,
+		FixedIPs:  []ports.IP{{IPAddress: ip}},
+	}
+}
+
+func newFloatingIPController() (*FloatingIPController, *crdClient.FakeCRDClient, *openstack.FakeOSClient, error) {
+	kubeCRDClient, err := crdClient.NewFake()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	kubeCRDClient.SetTenants(&crv1.Tenant{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      namespace,
+			Namespace: namespace,
+		},
+		Spec: crv1.TenantSpec{
+			TenantID: tenantID,
+		},
+	})
+	osClient := openstack.NewFake(kubeCRDClient)
+
+	source := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return &crv1.FloatingIPList{}, nil
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return watch.NewFake(), nil
+		},
+	}
+	controller := newFloatingIPControllerWithSource(fake.NewSimpleClientset(), osClient, source)
+
+	return controller, kubeCRDClient, osClient, nil
+}
+
+func TestSyncFloatingIP(t *testing.T) {
+	testCases := []struct {
+		name string
+		// address requested by spec
+		address  string
+		updateFn func(c *FloatingIPController, crdClient *crdClient.FakeCRDClient, osClient *openstack.FakeOSClient)
+		// expectedFn checks the floating IP after synced
+		expectedFn func(fip *crv1.FloatingIP, osClient *openstack.FakeOSClient) error
+		expectErr  bool
+	}{
+		{
+			name: "pod without port",
+			expectedFn: func(fip *crv1.FloatingIP, osClient *openstack.FakeOSClient) error {
+				if fip.Status.State != crv1.FloatingIPPending {
+					return fmt.Errorf("expected state %s, got %s", crv1.FloatingIPPending, fip.Status.State)
+				}
+				if fip.Status.FloatingIPAddress == "" || fip.Status.FloatingIPID == "" {
+					return fmt.Errorf("expected floating IP allocated, got %+v", fip.Status)
+				}
+				if !hasFinalizer(fip) {
+					return fmt.Errorf("expected finalizer added")
+				}
+				if allocated := osClient.FloatingIPs[fip.Status.FloatingIPID]; allocated == nil || allocated.TenantID != tenantID {
+					return fmt.Errorf("expected floating IP allocated for tenant %s, got %+v", tenantID, allocated)
+				}
+				return nil
+			},
+		},
+		{
+			name:    "pod with port",
+			address: "172.24.4.100",
+			updateFn: func(c *FloatingIPController, crdClient *crdClient.FakeCRDClient, osClient *openstack.FakeOSClient) {
+				osClient.Ports[networkID] = []ports.Port{newPort("port-1", "10.244.0.2")}
+			},
+			expectedFn: func(fip *crv1.FloatingIP, osClient *openstack.FakeOSClient) error {
+				expected := crv1.FloatingIPStatus{
+					State:             crv1.FloatingIPAssociated,
+					FloatingIPID:      fip.Status.FloatingIPID,
+					FloatingIPAddress: "172.24.4.100",
+					PortID:            "port-1",
+					FixedIPAddress:    "10.244.0.2",
+				}
+				if fip.Status != expected {
+					return fmt.Errorf("expected status %+v, got %+v", expected, fip.Status)
+				}
+				allocated := osClient.FloatingIPs[fip.Status.FloatingIPID]
+				if allocated.PortID != "port-1" || allocated.FixedIP != "10.244.0.2" {
+					return fmt.Errorf("expected floating IP associated with port-1, got %+v", allocated)
+				}
+				return nil
+			},
+		},
+		{
+			name: "pod recreated",
+			updateFn: func(c *FloatingIPController, crdClient *crdClient.FakeCRDClient, osClient *openstack.FakeOSClient) {
+				osClient.SetFloatingIP(&drivertypes.FloatingIP{
+					ID:         "fip-1",
+					FloatingIP: "172.24.4.10",
+					TenantID:   tenantID,
+					PortID:     "port-1",
+					FixedIP:    "10.244.0.2",
+				})
+				fip := crdClient.FloatingIPs[fipName]
+				fip.Finalizers = []string{crv1.FloatingIPFinalizer}
+				fip.Status = crv1.FloatingIPStatus{
+					State:             crv1.FloatingIPAssociated,
+					FloatingIPID:      "fip-1",
+					FloatingIPAddress: "172.24.4.10",
+					PortID:            "port-1",
+					FixedIPAddress:    "10.244.0.2",
+				}
+				osClient.Ports[networkID] = []ports.Port{newPort("port-2", "10.244.0.5")}
+			},
+			expectedFn: func(fip *crv1.FloatingIP, osClient *openstack.FakeOSClient) error {
+				if fip.Status.State != crv1.FloatingIPAssociated || fip.Status.PortID != "port-2" ||
+					fip.Status.FixedIPAddress != "10.244.0.5" || fip.Status.FloatingIPAddress != "172.24.4.10" {
+					return fmt.Errorf("expected 172.24.4.10 associated with port-2, got %+v", fip.Status)
+				}
+				if len(osClient.FloatingIPs) != 1 || osClient.FloatingIPs["fip-1"].PortID != "port-2" {
+					return fmt.Errorf("expected fip-1 moved to port-2, got %+v", osClient.FloatingIPs)
+				}
+				return nil
+			},
+		},
+		{
+			name: "floating IP lost in Neutron",
+			updateFn: func(c *FloatingIPController, crdClient *crdClient.FakeCRDClient, osClient *openstack.FakeOSClient) {
+				fip := crdClient.FloatingIPs[fipName]
+				fip.Finalizers = []string{crv1.FloatingIPFinalizer}
+				fip.Status = crv1.FloatingIPStatus{
+					State:             crv1.FloatingIPPending,
+					FloatingIPID:      "fip-1",
+					FloatingIPAddress: "172.24.4.10",
+				}
+			},
+			expectedFn: func(fip *crv1.FloatingIP, osClient *openstack.FakeOSClient) error {
+				if fip.Status.FloatingIPID == "fip-1" || fip.Status.FloatingIPAddress != "172.24.4.10" {
+					return fmt.Errorf("expected 172.24.4.10 allocated again, got %+v", fip.Status)
+				}
+				return nil
+			},
+		},
+		{
+			name: "allocation failed",
+			updateFn: func(c *FloatingIPController, crdClient *crdClient.FakeCRDClient, osClient *openstack.FakeOSClient) {
+				osClient.InjectError("CreateFloatingIP", fmt.Errorf("no more IP addresses available"))
+			},
+			expectedFn: func(fip *crv1.FloatingIP, osClient *openstack.FakeOSClient) error {
+				if fip.Status.State != crv1.FloatingIPFailed || fip.Status.Message == "" {
+					return fmt.Errorf("expected state %s with message, got %+v", crv1.FloatingIPFailed, fip.Status)
+				}
+				return nil
+			},
+			expectErr: true,
+		},
+		{
+			name: "record failed",
+			updateFn: func(c *FloatingIPController, crdClient *crdClient.FakeCRDClient, osClient *openstack.FakeOSClient) {
+				crdClient.InjectError("UpdateFloatingIP", fmt.Errorf("conflict"))
+			},
+			expectedFn: func(fip *crv1.FloatingIP, osClient *openstack.FakeOSClient) error {
+				if len(osClient.FloatingIPs) != 0 || fip.Status.FloatingIPID != "" {
+					return fmt.Errorf("expected floating IP released, got %+v", osClient.FloatingIPs)
+				}
+				return nil
+			},
+			expectErr: true,
+		},
+		{
+			name:    "invalid address",
+			address: "foo",
+			expectedFn: func(fip *crv1.FloatingIP, osClient *openstack.FakeOSClient) error {
+				if fip.Status.State != crv1.FloatingIPFailed || len(osClient.FloatingIPs) != 0 {
+					return fmt.Errorf("expected state %s without allocation, got %+v", crv1.FloatingIPFailed, fip.Status)
+				}
+				return nil
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		controller, crdClient, osClient, err := newFloatingIPController()
+		if err != nil {
+			t.Fatalf("Case[%s]: new controller failed: %v", tc.name, err)
+		}
+		crdClient.SetFloatingIPs(newFloatingIP(tc.address))
+		if tc.updateFn != nil {
+			tc.updateFn(controller, crdClient, osClient)
+		}
+
+		err = controller.syncFloatingIP(namespace + "/" + fipName)
+		if tc.expectErr != (err != nil) {
+			t.Errorf("Case[%s]: expected error %v, got %v", tc.name, tc.expectErr, err)
+		}
+
+		fip, err := crdClient.GetFloatingIP(namespace, fipName)
+		if err != nil {
+			t.Fatalf("Case[%s]: get floating IP failed: %v", tc.name, err)
+		}
+		if err := tc.expectedFn(fip, osClient); err != nil {
+			t.Errorf("Case[%s]: %v", tc.name, err)
+		}
+	}
+}
+
+func TestFinalizeFloatingIP(t *testing.T) {
+	controller, crdClient, osClient, err := newFloatingIPController()
+	if err != nil {
+		t.Fatalf("new controller failed: %v", err)
+	}
+	osClient.SetFloatingIP(&drivertypes.FloatingIP{
+		ID:         "fip-1",
+		FloatingIP: "172.24.4.10",
+		TenantID:   tenantID,
+	})
+	fip := newFloatingIP("")
+	now := metav1.Now()
+	fip.DeletionTimestamp = &now
+	fip.Finalizers = []string{crv1.FloatingIPFinalizer}
+	fip.Status.FloatingIPID = "fip-1"
+	crdClient.SetFloatingIPs(fip)
+
+	if err := controller.syncFloatingIP(namespace + "/" + fipName); err != nil {
+		t.Fatalf("sync floating IP failed: %v", err)
+	}
+
+	if _, ok := osClient.FloatingIPs["fip-1"]; ok {
+		t.Errorf("expected floating IP released")
+	}
+	if fip, _ := crdClient.GetFloatingIP(namespace, fipName); hasFinalizer(fip) {
+		t.Errorf("expected finalizer removed, got %v", fip.Finalizers)
+	}
+}
+
+func TestOnPodChanged(t *testing.T) {
+	controller, _, _, err := newFloatingIPController()
+	if err != nil {
+		t.Fatalf("new controller failed: %v", err)
+	}
+	other := newFloatingIP("")
+	other.Name = "db-fip"
+	other.Spec.PodName = "db"
+	controller.fipStore.Add(newFloatingIP(""))
+	controller.fipStore.Add(other)
+
+	controller.onPodChanged(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      podName,
+			Namespace: namespace,
+		},
+	})
+
+	if controller.queue.Len() != 1 {
+		t.Fatalf("expected 1 floating IP enqueued, got %d", controller.queue.Len())
+	}
+	if key, _ := controller.queue.Get(); key != namespace+"/"+fipName {
+		t.Errorf("expected %s/%s enqueued, got %v", namespace, fipName, key)
+	}
+}
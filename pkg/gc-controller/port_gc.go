@@ -0,0 +1,230 @@
+/*
+Copyright (c) 2017 OpenStack Foundation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gc
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+
+	"git.openstack.org/openstack/stackube/pkg/openstack"
+	"git.openstack.org/openstack/stackube/pkg/util"
+)
+
+// PortGCController deletes Neutron ports created by kubestack whose pods are
+// gone. Those ports are normally deleted on CNI DEL, but node crashes and
+// runtime bugs may leave them allocated forever. Sticky ports retained for
+// StatefulSet pods are deleted once their replicas are gone too.
+type PortGCController struct {
+	kubeClient kubernetes.Interface
+	osClient   openstack.Interface
+
+	// period of garbage collection.
+	period time.Duration
+	// gracePeriod is how long a port should be orphaned before it is deleted.
+	gracePeriod time.Duration
+	// dryRun only reports the ports which would be deleted.
+	dryRun bool
+
+	// orphans records when orphaned ports are first found, keyed by port ID.
+	orphans map[string]time.Time
+	// now returns current time, it is replaced in tests.
+	now func() time.Time
+}
+
+// NewPortGCController creates a new PortGCController.
+func NewPortGCController(kubeClient kubernetes.Interface, osClient openstack.Interface,
+	period, gracePeriod time.Duration, dryRun bool) (*PortGCController, error) {
+	if period <= 0 {
+		return nil, fmt.Errorf("invalid port GC period %v", period)
+	}
+	if gracePeriod < 0 {
+		return nil, fmt.Errorf("invalid port GC grace period %v", gracePeriod)
+	}
+
+	return &PortGCController{
+		kubeClient:  kubeClient,
+		osClient:    osClient,
+		period:      period,
+		gracePeriod: gracePeriod,
+		dryRun:      dryRun,
+		orphans:     make(map[string]time.Time),
+		now:         time.Now,
+	}, nil
+}
+
+// Run collects orphaned ports periodically until stopCh is closed.
+func (c *PortGCController) Run(stopCh <-chan struct{}) error {
+	defer utilruntime.HandleCrash()
+
+	glog.Infof("Starting port GC controller (period: %v, grace period: %v, dry run: %v)", c.period, c.gracePeriod, c.dryRun)
+	defer glog.Info("Shutting down port GC controller")
+
+	wait.Until(func() {
+		if err := c.gc(); err != nil {
+			glog.Errorf("Collect orphaned ports failed: %v", err)
+		}
+	}, c.period, stopCh)
+	return nil
+}
+
+// gc finds ports bound to cluster nodes without a live pod, and deletes those
+// which have been orphaned for more than the grace period.
+func (c *PortGCController) gc() error {
+	// Ports are listed before pods, so that ports created for new pods are
+	// always matched.
+	podPorts, err := c.listPodPorts()
+	if err != nil {
+		return err
+	}
+
+	pods, err := c.kubeClient.Core().Pods(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("list pods failed: %v", err)
+	}
+	livePorts := sets.NewString()
+	for _, pod := range pods.Items {
+		livePorts.Insert(util.BuildPortName(pod.Namespace, pod.Name))
+	}
+
+	replicaPorts, err := c.listReplicaPorts()
+	if err != nil {
+		return err
+	}
+
+	now := c.now()
+	found := sets.NewString()
+	var errs []error
+	for _, port := range podPorts {
+		if isPortInUse(port.Name, livePorts) {
+			continue
+		}
+		// Retained ports wait for the next run of their StatefulSet replicas.
+		if port.DeviceOwner == openstack.RetainedPortDeviceOwner && isPortInUse(port.Name, replicaPorts) {
+			continue
+		}
+
+		found.Insert(port.ID)
+		firstSeen, ok := c.orphans[port.ID]
+		if !ok {
+			glog.V(3).Infof("Found orphaned port %s (%s) on %s", port.Name, port.ID, port.DeviceOwner)
+			firstSeen = now
+			c.orphans[port.ID] = now
+		}
+		if now.Sub(firstSeen) < c.gracePeriod {
+			continue
+		}
+
+		if c.dryRun {
+			glog.Infof("[dry-run] Would delete orphaned port %s (%s), orphaned since %v", port.Name, port.ID, firstSeen)
+			continue
+		}
+		glog.Infof("Deleting orphaned port %s (%s), orphaned since %v", port.Name, port.ID, firstSeen)
+		if err := c.osClient.DeletePortByID(port.ID); err != nil && err != openstack.ErrNotFound {
+			errs = append(errs, fmt.Errorf("delete port %s failed: %v", port.Name, err))
+			continue
+		}
+		// QoS policies of pod ports are named after the ports.
+		if err := c.osClient.DeleteQoSPolicy(port.Name); err != nil {
+			errs = append(errs, fmt.Errorf("delete QoS policy %s failed: %v", port.Name, err))
+		}
+		found.Delete(port.ID)
+	}
+
+	// Forget ports which are deleted or in use again.
+	for portID := range c.orphans {
+		if !found.Has(portID) {
+			delete(c.orphans, portID)
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// listPodPorts lists ports created by kubestack on all nodes of the cluster,
+// and ports retained for StatefulSet pods.
+func (c *PortGCController) listPodPorts() ([]ports.Port, error) {
+	nodes, err := c.kubeClient.Core().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list nodes failed: %v", err)
+	}
+
+	// Kubestack binds ports to the host with device owner compute:<hostname>,
+	// which may differ from the node name.
+	deviceOwners := sets.NewString(openstack.RetainedPortDeviceOwner)
+	for _, node := range nodes.Items {
+		deviceOwners.Insert(fmt.Sprintf("compute:%s", node.Name))
+		for _, address := range node.Status.Addresses {
+			if address.Type == v1.NodeHostName {
+				deviceOwners.Insert(fmt.Sprintf("compute:%s", address.Address))
+			}
+		}
+	}
+
+	var results []ports.Port
+	for _, deviceOwner := range deviceOwners.List() {
+		portList, err := c.osClient.ListPorts("", deviceOwner)
+		if err != nil {
+			return nil, fmt.Errorf("list ports of %s failed: %v", deviceOwner, err)
+		}
+		for _, port := range portList {
+			if util.IsPodPortName(port.Name) {
+				results = append(results, port)
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// listReplicaPorts lists names of ports for all replicas of StatefulSets, whose
+// pods are named <statefulset>-<ordinal>.
+func (c *PortGCController) listReplicaPorts() (sets.String, error) {
+	statefulSets, err := c.kubeClient.Apps().StatefulSets(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list statefulsets failed: %v", err)
+	}
+
+	replicaPorts := sets.NewString()
+	for _, set := range statefulSets.Items {
+		replicas := int32(1)
+		if set.Spec.Replicas != nil {
+			replicas = *set.Spec.Replicas
+		}
+		for i := int32(0); i < replicas; i++ {
+			replicaPorts.Insert(util.BuildPortName(set.Namespace, fmt.Sprintf("%s-%d", set.Name, i)))
+		}
+	}
+
+	return replicaPorts, nil
+}
+
+// isPortInUse checks whether the port belongs to a live pod. The port is either
+// the primary one of the pod, or one for its additional interfaces, e.g.
+// kube-<namespace>-<pod>_eth1.
+func isPortInUse(portName string, livePorts sets.String) bool {
+	return livePorts.Has(util.GetPrimaryPortName(portName))
+}
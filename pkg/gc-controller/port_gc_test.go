@@ -0,0 +1,233 @@
+/*
+Copyright (c) 2017 OpenStack Foundation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	crdClient "git.openstack.org/openstack/stackube/pkg/kubecrd"
+	"git.openstack.org/openstack/stackube/pkg/openstack"
+	drivertypes "git.openstack.org/openstack/stackube/pkg/openstack/types"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	appsv1beta1 "k8s.io/api/apps/v1beta1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	networkID   = "456"
+	host        = "host1"
+	gracePeriod = 10 * time.Minute
+)
+
+func newNode(name string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+	}
+}
+
+func newPod(namespace, name string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+}
+
+func newPort(id, name string) ports.Port {
+	return ports.Port{
+		ID:          id,
+		Name:        name,
+		NetworkID:   networkID,
+		DeviceOwner: "compute:" + host,
+	}
+}
+
+func newStatefulSet(namespace, name string, replicas int32) *appsv1beta1.StatefulSet {
+	return &appsv1beta1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: appsv1beta1.StatefulSetSpec{
+			Replicas: &replicas,
+		},
+	}
+}
+
+func newRetainedPort(id, name string) ports.Port {
+	port := newPort(id, name)
+	port.DeviceOwner = openstack.RetainedPortDeviceOwner
+	return port
+}
+
+func newPortGCController(dryRun bool) (*PortGCController, *openstack.FakeOSClient, error) {
+	client := fake.NewSimpleClientset(
+		newNode(host),
+		newPod("test", "pod1"),
+		newPod("kube-system", "pod2"),
+		newPod("test", "web-1"),
+		newStatefulSet("test", "web", 2),
+	)
+	kubeCRDClient, err := crdClient.NewFake()
+	if err != nil {
+		return nil, nil, err
+	}
+	osClient := openstack.NewFake(kubeCRDClient)
+	osClient.Ports[networkID] = []ports.Port{
+		newPort("1", "kube-test-pod1"),
+		newPort("2", "kube-test-pod1_eth1"),
+		newPort("3", "kube-default-pod2"),
+		newPort("4", "kube-test-pod3"),
+		newPort("5", "kube-test-pod3_eth1"),
+		newPort("6", "vm-port"),
+		newRetainedPort("7", "kube-test-web-0"),
+		newRetainedPort("8", "kube-test-web-0_eth1"),
+		newRetainedPort("9", "kube-test-web-2"),
+		newRetainedPort("10", "kube-test-db-0"),
+		newPort("11", "kube-test-web-1"),
+		// Primary port of pod pod1-eth2, not an additional port of pod1.
+		newPort("12", "kube-test-pod1-eth2"),
+	}
+
+	controller, err := NewPortGCController(client, osClient, time.Minute, gracePeriod, dryRun)
+	if err != nil {
+		return nil, nil, err
+	}
+	return controller, osClient, nil
+}
+
+func portIDs(osClient *openstack.FakeOSClient) sets.String {
+	ids := sets.NewString()
+	for _, port := range osClient.Ports[networkID] {
+		ids.Insert(port.ID)
+	}
+	return ids
+}
+
+func TestGC(t *testing.T) {
+	now := time.Now()
+
+	testCases := []struct {
+		testName    string
+		dryRun      bool
+		elapsed     []time.Duration
+		expectedIDs []string
+	}{
+		{
+			testName:    "orphaned ports kept in grace period",
+			elapsed:     []time.Duration{0, gracePeriod / 2},
+			expectedIDs: []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12"},
+		},
+		{
+			testName:    "orphaned ports deleted after grace period",
+			elapsed:     []time.Duration{0, gracePeriod},
+			expectedIDs: []string{"1", "2", "3", "6", "7", "8", "11"},
+		},
+		{
+			testName:    "orphaned ports kept in dry run mode",
+			dryRun:      true,
+			elapsed:     []time.Duration{0, gracePeriod},
+			expectedIDs: []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12"},
+		},
+	}
+
+	for tci, tc := range testCases {
+		controller, osClient, err := newPortGCController(tc.dryRun)
+		if err != nil {
+			t.Fatalf("Failed start a new fake PortGCController: %v", err)
+		}
+
+		for _, elapsed := range tc.elapsed {
+			controller.now = func() time.Time { return now.Add(elapsed) }
+			if err := controller.gc(); err != nil {
+				t.Errorf("Case[%d]: %s unexpected error: %v", tci, tc.testName, err)
+			}
+		}
+
+		if ids := portIDs(osClient); !ids.Equal(sets.NewString(tc.expectedIDs...)) {
+			t.Errorf("Case[%d]: %s expected ports %v, got %v", tci, tc.testName, tc.expectedIDs, ids.List())
+		}
+	}
+}
+
+func TestGCDeleteQoSPolicy(t *testing.T) {
+	controller, osClient, err := newPortGCController(false)
+	if err != nil {
+		t.Fatalf("Failed start a new fake PortGCController: %v", err)
+	}
+	for _, name := range []string{"kube-test-pod1", "kube-test-pod3"} {
+		osClient.QoSPolicies[name] = &drivertypes.BandwidthLimit{IngressKbps: 1000}
+	}
+
+	controller.gracePeriod = 0
+	if err := controller.gc(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := osClient.QoSPolicies["kube-test-pod3"]; ok {
+		t.Errorf("Expected QoS policy of orphaned port to be deleted")
+	}
+	if _, ok := osClient.QoSPolicies["kube-test-pod1"]; !ok {
+		t.Errorf("Expected QoS policy of live port to be kept")
+	}
+}
+
+func TestGCNodeHostName(t *testing.T) {
+	controller, osClient, err := newPortGCController(false)
+	if err != nil {
+		t.Fatalf("Failed start a new fake PortGCController: %v", err)
+	}
+	// Ports are bound with the hostname of the node, not its name.
+	node := newNode("node2")
+	node.Status.Addresses = []v1.NodeAddress{{Type: v1.NodeHostName, Address: "host2"}}
+	if _, err := controller.kubeClient.Core().Nodes().Create(node); err != nil {
+		t.Fatalf("Create node %s failed: %v", node.Name, err)
+	}
+	port := newPort("13", "kube-test-pod4")
+	port.DeviceOwner = "compute:host2"
+	osClient.Ports[networkID] = append(osClient.Ports[networkID], port)
+
+	controller.gracePeriod = 0
+	if err := controller.gc(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ids := portIDs(osClient); ids.Has(port.ID) {
+		t.Errorf("Expected orphaned port %s on node %s to be deleted", port.Name, node.Name)
+	}
+}
+
+func TestGCDeleteFailed(t *testing.T) {
+	controller, osClient, err := newPortGCController(false)
+	if err != nil {
+		t.Fatalf("Failed start a new fake PortGCController: %v", err)
+	}
+	osClient.InjectError("DeletePortByID", fmt.Errorf("delete port failed"))
+
+	controller.gracePeriod = 0
+	if err := controller.gc(); err == nil {
+		t.Errorf("Expected error when deleting port failed")
+	}
+	if _, ok := controller.orphans["4"]; !ok {
+		t.Errorf("Expected orphaned port to be retried, got forgotten")
+	}
+}
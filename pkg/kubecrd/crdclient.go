@@ -40,10 +40,18 @@ type Interface interface {
 	UpdateTenant(tenant *crv1.Tenant) error
 	// AddNetwork adds Network CRD object by given object.
 	AddNetwork(network *crv1.Network) error
+	// GetNetwork returns Network CRD object by namespace and networkName.
+	GetNetwork(namespace, networkName string) (*crv1.Network, error)
+	// ListNetworks returns all Network CRD objects in namespace.
+	ListNetworks(namespace string) (*crv1.NetworkList, error)
 	// UpdateNetwork updates Network CRD object by given object.
 	UpdateNetwork(network *crv1.Network) error
 	// DeleteNetwork deletes Network CRD object by networkName.
 	DeleteNetwork(networkName string) error
+	// GetFloatingIP returns FloatingIP CRD object by namespace and name.
+	GetFloatingIP(namespace, name string) (*crv1.FloatingIP, error)
+	// UpdateFloatingIP updates FloatingIP CRD object by given object.
+	UpdateFloatingIP(fip *crv1.FloatingIP) error
 	// Client returns the RESTClient.
 	Client() *rest.RESTClient
 	// Scheme returns runtime scheme.
@@ -92,13 +100,15 @@ func (c *CRDClient) Scheme() *runtime.Scheme {
 
 // UpdateNetwork updates Network CRD object by given object.
 func (c *CRDClient) UpdateNetwork(network *crv1.Network) error {
+	// The updated object is decoded back, so that network could be updated
+	// again with the latest resourceVersion.
 	err := c.client.Put().
 		Name(network.Name).
 		Namespace(network.Namespace).
 		Resource(crv1.NetworkResourcePlural).
 		Body(network).
 		Do().
-		Error()
+		Into(network)
 
 	if err != nil {
 		glog.Errorf("ERROR updating network: %v\n", err)
@@ -169,6 +179,33 @@ func (c *CRDClient) AddNetwork(network *crv1.Network) error {
 	return nil
 }
 
+// GetNetwork returns Network CRD object by namespace and networkName.
+func (c *CRDClient) GetNetwork(namespace, networkName string) (*crv1.Network, error) {
+	network := crv1.Network{}
+	err := c.client.Get().
+		Resource(crv1.NetworkResourcePlural).
+		Namespace(namespace).
+		Name(networkName).
+		Do().Into(&network)
+	if err != nil {
+		return nil, err
+	}
+	return &network, nil
+}
+
+// ListNetworks returns all Network CRD objects in namespace.
+func (c *CRDClient) ListNetworks(namespace string) (*crv1.NetworkList, error) {
+	networks := crv1.NetworkList{}
+	err := c.client.Get().
+		Resource(crv1.NetworkResourcePlural).
+		Namespace(namespace).
+		Do().Into(&networks)
+	if err != nil {
+		return nil, err
+	}
+	return &networks, nil
+}
+
 // DeleteNetwork deletes Network CRD object by networkName.
 // NOTE: the automatically created network for tenant use namespace as name.
 func (c *CRDClient) DeleteNetwork(networkName string) error {
@@ -182,3 +219,37 @@ func (c *CRDClient) DeleteNetwork(networkName string) error {
 	}
 	return nil
 }
+
+// GetFloatingIP returns FloatingIP CRD object by namespace and name.
+func (c *CRDClient) GetFloatingIP(namespace, name string) (*crv1.FloatingIP, error) {
+	fip := crv1.FloatingIP{}
+	err := c.client.Get().
+		Resource(crv1.FloatingIPResourcePlural).
+		Namespace(namespace).
+		Name(name).
+		Do().Into(&fip)
+	if err != nil {
+		return nil, err
+	}
+	return &fip, nil
+}
+
+// UpdateFloatingIP updates FloatingIP CRD object by given object.
+func (c *CRDClient) UpdateFloatingIP(fip *crv1.FloatingIP) error {
+	// The updated object is decoded back, so that fip could be updated
+	// again with the latest resourceVersion.
+	err := c.client.Put().
+		Name(fip.Name).
+		Namespace(fip.Namespace).
+		Resource(crv1.FloatingIPResourcePlural).
+		Body(fip).
+		Do().
+		Into(fip)
+
+	if err != nil {
+		glog.Errorf("ERROR updating floating IP: %v\n", err)
+		return err
+	}
+	glog.V(3).Infof("UPDATED floating IP: %#v\n", fip)
+	return nil
+}
@@ -21,6 +21,7 @@ import (
 	"sync"
 
 	crv1 "git.openstack.org/openstack/stackube/pkg/apis/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/rest"
 )
@@ -37,11 +38,12 @@ type CalledDetail struct {
 // can be run for testing without requiring a real kubernetes setup.
 type FakeCRDClient struct {
 	sync.Mutex
-	called   []CalledDetail
-	errors   map[string]error
-	Tenants  map[string]*crv1.Tenant
-	Networks map[string]*crv1.Network
-	scheme   *runtime.Scheme
+	called      []CalledDetail
+	errors      map[string]error
+	Tenants     map[string]*crv1.Tenant
+	Networks    map[string]*crv1.Network
+	FloatingIPs map[string]*crv1.FloatingIP
+	scheme      *runtime.Scheme
 }
 
 var _ = Interface(&FakeCRDClient{})
@@ -54,10 +56,11 @@ func NewFake() (*FakeCRDClient, error) {
 	}
 
 	return &FakeCRDClient{
-		errors:   make(map[string]error),
-		Tenants:  make(map[string]*crv1.Tenant),
-		Networks: make(map[string]*crv1.Network),
-		scheme:   scheme,
+		errors:      make(map[string]error),
+		Tenants:     make(map[string]*crv1.Tenant),
+		Networks:    make(map[string]*crv1.Network),
+		FloatingIPs: make(map[string]*crv1.FloatingIP),
+		scheme:      scheme,
 	}, nil
 }
 
@@ -135,6 +138,15 @@ func (f *FakeCRDClient) SetNetworks(networks ...*crv1.Network) {
 	}
 }
 
+// SetFloatingIPs injects fake floating IP.
+func (f *FakeCRDClient) SetFloatingIPs(fips ...*crv1.FloatingIP) {
+	f.Lock()
+	defer f.Unlock()
+	for _, fip := range fips {
+		f.FloatingIPs[fip.Name] = fip
+	}
+}
+
 // Client is a test implementation of Interface.Client.
 func (f *FakeCRDClient) Client() *rest.RESTClient {
 	return nil
@@ -227,6 +239,42 @@ func (f *FakeCRDClient) UpdateTenant(tenant *crv1.Tenant) error {
 	return nil
 }
 
+// GetNetwork is a test implementation of Interface.GetNetwork.
+func (f *FakeCRDClient) GetNetwork(namespace, networkName string) (*crv1.Network, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("GetNetwork", networkName)
+	if err := f.getError("GetNetwork"); err != nil {
+		return nil, err
+	}
+
+	network, ok := f.Networks[networkName]
+	if !ok || network.Namespace != namespace {
+		return nil, apierrors.NewNotFound(crv1.SchemeGroupVersion.WithResource(crv1.NetworkResourcePlural).GroupResource(), networkName)
+	}
+
+	return network, nil
+}
+
+// ListNetworks is a test implementation of Interface.ListNetworks.
+func (f *FakeCRDClient) ListNetworks(namespace string) (*crv1.NetworkList, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("ListNetworks", namespace)
+	if err := f.getError("ListNetworks"); err != nil {
+		return nil, err
+	}
+
+	networks := &crv1.NetworkList{}
+	for _, network := range f.Networks {
+		if network.Namespace == namespace {
+			networks.Items = append(networks.Items, *network)
+		}
+	}
+
+	return networks, nil
+}
+
 // UpdateNetwork is a test implementation of Interface.UpdateNetwork.
 func (f *FakeCRDClient) UpdateNetwork(network *crv1.Network) error {
 	f.Lock()
@@ -257,3 +305,37 @@ func (f *FakeCRDClient) DeleteNetwork(networkName string) error {
 
 	return nil
 }
+
+// GetFloatingIP is a test implementation of Interface.GetFloatingIP.
+func (f *FakeCRDClient) GetFloatingIP(namespace, name string) (*crv1.FloatingIP, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("GetFloatingIP", name)
+	if err := f.getError("GetFloatingIP"); err != nil {
+		return nil, err
+	}
+
+	fip, ok := f.FloatingIPs[name]
+	if !ok || fip.Namespace != namespace {
+		return nil, apierrors.NewNotFound(crv1.SchemeGroupVersion.WithResource(crv1.FloatingIPResourcePlural).GroupResource(), name)
+	}
+
+	return fip.DeepCopy(), nil
+}
+
+// UpdateFloatingIP is a test implementation of Interface.UpdateFloatingIP.
+func (f *FakeCRDClient) UpdateFloatingIP(fip *crv1.FloatingIP) error {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("UpdateFloatingIP", fip)
+	if err := f.getError("UpdateFloatingIP"); err != nil {
+		return err
+	}
+
+	if _, ok := f.FloatingIPs[fip.Name]; !ok {
+		return fmt.Errorf("FloatingIP %s not exist", fip.Name)
+	}
+
+	f.FloatingIPs[fip.Name] = fip.DeepCopy()
+	return nil
+}
@@ -18,11 +18,13 @@ package kubecrd
 
 import (
 	"reflect"
+	"sort"
 	"time"
 
 	crv1 "git.openstack.org/openstack/stackube/pkg/apis/v1"
 	"git.openstack.org/openstack/stackube/pkg/util"
 
+	"github.com/golang/glog"
 	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
 	apiextensionsclient "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -78,3 +80,39 @@ func WaitForNetworkInstanceProcessed(networkClient *rest.RESTClient, name, names
 		return false, err
 	})
 }
+
+// GetDefaultNetworkName returns name of the default Network CRD object of namespace.
+// The network named after namespace is the default one if none is marked as default.
+func GetDefaultNetworkName(client Interface, namespace string) (string, error) {
+	networks, err := client.ListNetworks(namespace)
+	if err != nil {
+		return "", err
+	}
+
+	var defaults []string
+	for _, network := range networks.Items {
+		if network.Spec.Default {
+			defaults = append(defaults, network.Name)
+		}
+	}
+	if len(defaults) == 0 {
+		return namespace, nil
+	}
+
+	sort.Strings(defaults)
+	if len(defaults) > 1 {
+		glog.Warningf("Multiple default networks %v found in namespace %s, using %s", defaults, namespace, defaults[0])
+	}
+	return defaults[0], nil
+}
+
+// GetPodNetworkName returns name of the Network CRD object which a pod with the
+// annotations is placed in. The network selected by annotation
+// stackube.kubernetes.io/network is preferred, otherwise default network is used.
+func GetPodNetworkName(client Interface, namespace string, annotations map[string]string) (string, error) {
+	if name, ok := annotations[util.NetworkAnnotation]; ok && name != "" {
+		return name, nil
+	}
+
+	return GetDefaultNetworkName(client, namespace)
+}
@@ -70,3 +70,62 @@ func TestCreateNetworkCRD(t *testing.T) {
 	assert.Equal(t, "v1", networkCRD.Spec.Version)
 	assert.Equal(t, apiextensionsv1beta1.NamespaceScoped, networkCRD.Spec.Scope)
 }
+
+func newTestNetwork(namespace, name string, isDefault bool) *crv1.Network {
+	return &crv1.Network{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: crv1.NetworkSpec{
+			Default: isDefault,
+		},
+	}
+}
+
+func TestGetPodNetworkName(t *testing.T) {
+	testCases := []struct {
+		networks    []*crv1.Network
+		annotations map[string]string
+		expected    string
+	}{
+		{
+			// Case[0]: no networks, use the network named after namespace.
+			expected: "test",
+		},
+		{
+			// Case[1]: use the default network.
+			networks: []*crv1.Network{
+				newTestNetwork("test", "foo", false),
+				newTestNetwork("test", "bar", true),
+			},
+			expected: "bar",
+		},
+		{
+			// Case[2]: default network of other namespace is ignored.
+			networks: []*crv1.Network{
+				newTestNetwork("other", "bar", true),
+			},
+			expected: "test",
+		},
+		{
+			// Case[3]: use the network selected by annotation.
+			networks: []*crv1.Network{
+				newTestNetwork("test", "foo", false),
+				newTestNetwork("test", "bar", true),
+			},
+			annotations: map[string]string{"stackube.kubernetes.io/network": "foo"},
+			expected:    "foo",
+		},
+	}
+
+	for tci, tc := range testCases {
+		client, err := NewFake()
+		assert.NoError(t, err)
+		client.SetNetworks(tc.networks...)
+
+		name, err := GetPodNetworkName(client, "test", tc.annotations)
+		assert.NoError(t, err, "Case[%d]", tci)
+		assert.Equal(t, tc.expected, name, "Case[%d]", tci)
+	}
+}
@@ -18,6 +18,7 @@ package openvswitch
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"git.openstack.org/openstack/stackube/pkg/kubestack/plugins"
@@ -31,6 +32,10 @@ const (
 	pluginName = "ovs"
 )
 
+// RunCommand runs the command and returns its output lines, it is replaced
+// in tests of the plugin and its callers.
+var RunCommand = util.RunCommand
+
 type OVSPlugin struct {
 	IntegrationBridge string
 }
@@ -70,136 +75,181 @@ func (p *OVSPlugin) buildVethName(portID string) (string, string) {
 	return ("qvb" + portID)[:14], ("qvo" + portID)[:14]
 }
 
-func (p *OVSPlugin) SetupSandboxInterface(podName, podInfraContainerID string, port *ports.Port, ipcidr, gateway, ifName, netns string) (*current.Interface, error) {
+func (p *OVSPlugin) SetupSandboxInterface(podName, podInfraContainerID string, port *ports.Port, ips []plugins.IPConfig, mtu int, ifName, netns string) (*current.Interface, error) {
 	vibName, vifName := p.buildSandboxInterfaceName(port.ID)
-	ret, err := util.RunCommand("ip", "link", "add", vibName, "type", "veth", "peer", "name", vifName)
+	ret, err := RunCommand("ip", "link", "add", vibName, "type", "veth", "peer", "name", vifName)
 	if err != nil {
 		glog.Warningf("SetupInterface failed, ret:%s, error:%v", strings.Join(ret, "\n"), err)
 		p.DestroyInterface(podName, podInfraContainerID, port)
 		return nil, err
 	}
 
-	bridge := p.buildBridgeName(port.ID)
-	ret, err = util.RunCommand("brctl", "addif", bridge, vibName)
+	// Both ends are set before vif is moved into netns.
+	err = p.setMTU(mtu, vibName, vifName)
 	if err != nil {
-		glog.Warningf("SetupInterface failed, ret:%s, error:%v", strings.Join(ret, "\n"), err)
 		p.DestroyInterface(podName, podInfraContainerID, port)
 		return nil, err
 	}
 
-	ret, err = util.RunCommand("ip", "link", "set", "dev", vifName, "address", port.MACAddress)
+	bridge := p.buildBridgeName(port.ID)
+	ret, err = RunCommand("brctl", "addif", bridge, vibName)
 	if err != nil {
 		glog.Warningf("SetupInterface failed, ret:%s, error:%v", strings.Join(ret, "\n"), err)
 		p.DestroyInterface(podName, podInfraContainerID, port)
 		return nil, err
 	}
 
-	ret, err = util.RunCommand("ip", "link", "set", vifName, "netns", netns)
+	ret, err = RunCommand("ip", "link", "set", "dev", vifName, "address", port.MACAddress)
 	if err != nil {
 		glog.Warningf("SetupInterface failed, ret:%s, error:%v", strings.Join(ret, "\n"), err)
 		p.DestroyInterface(podName, podInfraContainerID, port)
 		return nil, err
 	}
 
-	ret, err = util.RunCommand("ip", "netns", "exec", netns, "ip", "link", "set", vifName, "down")
+	ret, err = RunCommand("ip", "link", "set", vifName, "netns", netns)
 	if err != nil {
 		glog.Warningf("SetupInterface failed, ret:%s, error:%v", strings.Join(ret, "\n"), err)
 		p.DestroyInterface(podName, podInfraContainerID, port)
 		return nil, err
 	}
 
-	ret, err = util.RunCommand("ip", "netns", "exec", netns, "ip", "link", "set", vifName, "name", ifName)
+	ret, err = RunCommand("ip", "netns", "exec", netns, "ip", "link", "set", vifName, "down")
 	if err != nil {
 		glog.Warningf("SetupInterface failed, ret:%s, error:%v", strings.Join(ret, "\n"), err)
 		p.DestroyInterface(podName, podInfraContainerID, port)
 		return nil, err
 	}
 
-	ret, err = util.RunCommand("ip", "netns", "exec", netns, "ip", "link", "set", ifName, "up")
+	ret, err = RunCommand("ip", "netns", "exec", netns, "ip", "link", "set", vifName, "name", ifName)
 	if err != nil {
 		glog.Warningf("SetupInterface failed, ret:%s, error:%v", strings.Join(ret, "\n"), err)
 		p.DestroyInterface(podName, podInfraContainerID, port)
 		return nil, err
 	}
 
-	ret, err = util.RunCommand("ip", "netns", "exec", netns, "ip", "addr", "add", "dev", ifName, ipcidr)
+	ret, err = RunCommand("ip", "netns", "exec", netns, "ip", "link", "set", ifName, "up")
 	if err != nil {
 		glog.Warningf("SetupInterface failed, ret:%s, error:%v", strings.Join(ret, "\n"), err)
 		p.DestroyInterface(podName, podInfraContainerID, port)
 		return nil, err
 	}
 
-	ret, err = util.RunCommand("ip", "netns", "exec", netns, "ip", "route", "add", "default", "via", gateway)
-	if err != nil {
-		glog.Warningf("SetupInterface failed, ret:%s, error:%v", strings.Join(ret, "\n"), err)
-		p.DestroyInterface(podName, podInfraContainerID, port)
-		return nil, err
+	for _, ip := range ips {
+		args := []string{"netns", "exec", netns, "ip", "addr", "add", "dev", ifName, ip.Address}
+		if ip.IPv6 {
+			// Skip duplicate address detection since the address is allocated by Neutron.
+			args = append(args, "nodad")
+		}
+		ret, err = RunCommand("ip", args...)
+		if err != nil {
+			glog.Warningf("SetupInterface failed, ret:%s, error:%v", strings.Join(ret, "\n"), err)
+			p.DestroyInterface(podName, podInfraContainerID, port)
+			return nil, err
+		}
 	}
 
-	ret, err = util.RunCommand("ip", "link", "set", "dev", vibName, "up")
+	// Add default route for each IP family.
+	defaultRoutes := make(map[bool]bool)
+	for _, ip := range ips {
+		if ip.Gateway == "" || defaultRoutes[ip.IPv6] {
+			continue
+		}
+		family := "-4"
+		if ip.IPv6 {
+			family = "-6"
+		}
+		ret, err = RunCommand("ip", "netns", "exec", netns, "ip", family, "route", "add", "default", "via", ip.Gateway)
+		if err != nil {
+			glog.Warningf("SetupInterface failed, ret:%s, error:%v", strings.Join(ret, "\n"), err)
+			p.DestroyInterface(podName, podInfraContainerID, port)
+			return nil, err
+		}
+		defaultRoutes[ip.IPv6] = true
+	}
+
+	// Add static routes of subnets.
+	for _, ip := range ips {
+		for _, route := range ip.Routes {
+			ret, err = RunCommand("ip", "netns", "exec", netns, "ip", "route", "add", route.Destination, "via", route.Nexthop)
+			if err != nil {
+				glog.Warningf("SetupInterface failed, ret:%s, error:%v", strings.Join(ret, "\n"), err)
+				p.DestroyInterface(podName, podInfraContainerID, port)
+				return nil, err
+			}
+		}
+	}
+
+	ret, err = RunCommand("ip", "link", "set", "dev", vibName, "up")
 	if err != nil {
 		glog.Warningf("SetupInterface failed, ret:%s, error:%v", strings.Join(ret, "\n"), err)
 		p.DestroyInterface(podName, podInfraContainerID, port)
 		return nil, err
 	}
 
-	_, err = util.RunCommand("rm", "-f", fmt.Sprintf("/var/run/netns/%s", netns))
-	if err != nil {
-		glog.V(5).Infof("Warning: remove netns symlink failed: %v", err)
-	}
-
 	return &current.Interface{
 		Name: p.buildTapName(port.ID),
 		Mac:  port.MACAddress,
 	}, nil
 }
 
-func (p *OVSPlugin) SetupOVSInterface(podName, podInfraContainerID string, port *ports.Port) (*current.Interface, error) {
+func (p *OVSPlugin) SetupOVSInterface(podName, podInfraContainerID string, port *ports.Port, mtu int) (*current.Interface, error) {
 	qvb, qvo := p.buildVethName(port.ID)
-	ret, err := util.RunCommand("ip", "link", "add", qvb, "type", "veth", "peer", "name", qvo)
+	ret, err := RunCommand("ip", "link", "add", qvb, "type", "veth", "peer", "name", qvo)
 	if err != nil {
 		glog.Warningf("SetupInterface failed, ret:%s, error:%v", strings.Join(ret, "\n"), err)
 		p.DestroyInterface(podName, podInfraContainerID, port)
 		return nil, err
 	}
 
+	err = p.setMTU(mtu, qvb, qvo)
+	if err != nil {
+		p.DestroyInterface(podName, podInfraContainerID, port)
+		return nil, err
+	}
+
 	bridge := p.buildBridgeName(port.ID)
-	ret, err = util.RunCommand("brctl", "addbr", bridge)
+	ret, err = RunCommand("brctl", "addbr", bridge)
 	if err != nil {
 		glog.Warningf("SetupInterface failed, ret:%s, error:%v", strings.Join(ret, "\n"), err)
 		p.DestroyInterface(podName, podInfraContainerID, port)
 		return nil, err
 	}
 
-	ret, err = util.RunCommand("ip", "link", "set", qvb, "up")
+	ret, err = RunCommand("ip", "link", "set", qvb, "up")
 	if err != nil {
 		glog.Warningf("SetupInterface failed, ret:%s, error:%v", strings.Join(ret, "\n"), err)
 		p.DestroyInterface(podName, podInfraContainerID, port)
 		return nil, err
 	}
 
-	ret, err = util.RunCommand("ip", "link", "set", qvo, "up")
+	ret, err = RunCommand("ip", "link", "set", qvo, "up")
 	if err != nil {
 		glog.Warningf("SetupInterface failed, ret:%s, error:%v", strings.Join(ret, "\n"), err)
 		p.DestroyInterface(podName, podInfraContainerID, port)
 		return nil, err
 	}
 
-	ret, err = util.RunCommand("ip", "link", "set", bridge, "up")
+	ret, err = RunCommand("ip", "link", "set", bridge, "up")
 	if err != nil {
 		glog.Warningf("SetupInterface failed, ret:%s, error:%v", strings.Join(ret, "\n"), err)
 		p.DestroyInterface(podName, podInfraContainerID, port)
 		return nil, err
 	}
 
-	ret, err = util.RunCommand("brctl", "addif", bridge, qvb)
+	ret, err = RunCommand("brctl", "addif", bridge, qvb)
 	if err != nil {
 		glog.Warningf("SetupInterface failed, ret:%s, error:%v", strings.Join(ret, "\n"), err)
 		p.DestroyInterface(podName, podInfraContainerID, port)
 		return nil, err
 	}
 
-	ret, err = util.RunCommand("ovs-vsctl", "-vconsole:off", "--", "--if-exists", "del-port",
+	err = p.setMTU(mtu, bridge)
+	if err != nil {
+		p.DestroyInterface(podName, podInfraContainerID, port)
+		return nil, err
+	}
+
+	ret, err = RunCommand("ovs-vsctl", "-vconsole:off", "--", "--if-exists", "del-port",
 		qvo, "--", "add-port", p.IntegrationBridge, qvo, "--", "set", "Interface", qvo,
 		fmt.Sprintf("external_ids:attached-mac=%s", port.MACAddress),
 		fmt.Sprintf("external_ids:iface-id=%s", port.ID),
@@ -212,7 +262,7 @@ func (p *OVSPlugin) SetupOVSInterface(podName, podInfraContainerID string, port
 	}
 
 	// Get bridge mac
-	ret, err = util.RunCommand("ip", "link", "show", bridge)
+	ret, err = RunCommand("ip", "link", "show", bridge)
 	if err != nil {
 		glog.Warningf("SetupInterface failed, ret:%s, error:%v", strings.Join(ret, "\n"), err)
 		p.DestroyInterface(podName, podInfraContainerID, port)
@@ -225,14 +275,14 @@ func (p *OVSPlugin) SetupOVSInterface(podName, podInfraContainerID string, port
 	}, nil
 }
 
-func (p *OVSPlugin) SetupInterface(podName, podInfraContainerID string, port *ports.Port, ipcidr, gateway, ifName, netns string) (*current.Interface, *current.Interface, error) {
-	brInterface, err := p.SetupOVSInterface(podName, podInfraContainerID, port)
+func (p *OVSPlugin) SetupInterface(podName, podInfraContainerID string, port *ports.Port, ips []plugins.IPConfig, mtu int, ifName, netns string) (*current.Interface, *current.Interface, error) {
+	brInterface, err := p.SetupOVSInterface(podName, podInfraContainerID, port, mtu)
 	if err != nil {
 		glog.Errorf("SetupOVSInterface failed: %v", err)
 		return nil, nil, err
 	}
 
-	conInterface, err := p.SetupSandboxInterface(podName, podInfraContainerID, port, ipcidr, gateway, ifName, netns)
+	conInterface, err := p.SetupSandboxInterface(podName, podInfraContainerID, port, ips, mtu, ifName, netns)
 	if err != nil {
 		glog.Errorf("SetupSandboxInterface failed: %v", err)
 		return nil, nil, err
@@ -242,31 +292,49 @@ func (p *OVSPlugin) SetupInterface(podName, podInfraContainerID string, port *po
 	return brInterface, conInterface, nil
 }
 
+// setMTU sets MTU of the devices, they are kept with the kernel default if
+// mtu is zero.
+func (p *OVSPlugin) setMTU(mtu int, devices ...string) error {
+	if mtu <= 0 {
+		return nil
+	}
+
+	for _, device := range devices {
+		ret, err := RunCommand("ip", "link", "set", "dev", device, "mtu", strconv.Itoa(mtu))
+		if err != nil {
+			glog.Warningf("Set MTU of %s to %d failed, ret:%s, error:%v", device, mtu, strings.Join(ret, "\n"), err)
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (p *OVSPlugin) destroyOVSInterface(podName, portID string) error {
 	_, qvo := p.buildVethName(portID)
 	bridge := p.buildBridgeName(portID)
 
-	output, err := util.RunCommand("ovs-vsctl", "-vconsole:off", "--if-exists", "del-port", qvo)
+	output, err := RunCommand("ovs-vsctl", "-vconsole:off", "--if-exists", "del-port", qvo)
 	if err != nil {
 		glog.Warningf("Warning: ovs del-port %s failed: %v, %v", qvo, output, err)
 	}
 
-	output, err = util.RunCommand("ip", "link", "set", "dev", qvo, "down")
+	output, err = RunCommand("ip", "link", "set", "dev", qvo, "down")
 	if err != nil {
 		glog.Warningf("Warning: set dev %s down failed: %v, %v", qvo, output, err)
 	}
 
-	output, err = util.RunCommand("ip", "link", "delete", "dev", qvo)
+	output, err = RunCommand("ip", "link", "delete", "dev", qvo)
 	if err != nil {
 		glog.Warningf("Warning: delete dev %s failed: %v, %v", qvo, output, err)
 	}
 
-	output, err = util.RunCommand("ip", "link", "set", "dev", bridge, "down")
+	output, err = RunCommand("ip", "link", "set", "dev", bridge, "down")
 	if err != nil {
 		glog.Warningf("Warning: set bridge %s down failed: %v, %v", bridge, output, err)
 	}
 
-	output, err = util.RunCommand("brctl", "delbr", bridge)
+	output, err = RunCommand("brctl", "delbr", bridge)
 	if err != nil {
 		glog.Warningf("Warning: delete bridge %s failed: %v, %v", bridge, output, err)
 	}
@@ -276,7 +344,7 @@ func (p *OVSPlugin) destroyOVSInterface(podName, portID string) error {
 
 func (p *OVSPlugin) destroySandboxInterface(podName, podInfraContainerID, portID string) error {
 	vibName, _ := p.buildSandboxInterfaceName(portID)
-	_, err := util.RunCommand("ip", "link", "delete", vibName)
+	_, err := RunCommand("ip", "link", "delete", vibName)
 	if err != nil {
 		glog.V(5).Infof("Warning: DestroyInterface failed: %v", err)
 	}
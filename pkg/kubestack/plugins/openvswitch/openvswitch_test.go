@@ -0,0 +1,149 @@
+/*
+Copyright (c) 2017 OpenStack Foundation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openvswitch
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+)
+
+// fakeRunner records commands run by the plugin instead of running them.
+type fakeRunner struct {
+	commands []string
+	// failCommand is the command failed to run.
+	failCommand string
+}
+
+func (r *fakeRunner) run(cmd string, args ...string) ([]string, error) {
+	command := strings.Join(append([]string{cmd}, args...), " ")
+	r.commands = append(r.commands, command)
+	if command == r.failCommand {
+		return nil, fmt.Errorf("%s failed", command)
+	}
+	if len(args) > 1 && args[0] == "link" && args[1] == "show" {
+		return []string{"3: bridge: <BROADCAST,MULTICAST,UP,LOWER_UP>", "    link/ether 00:11:22:33:44:55 brd ff:ff:ff:ff:ff:ff"}, nil
+	}
+	return nil, nil
+}
+
+// newFakeRunner replaces RunCommand with a fakeRunner, the returned func
+// restores it.
+func newFakeRunner(failCommand string) (*fakeRunner, func()) {
+	runner := &fakeRunner{failCommand: failCommand}
+	origin := RunCommand
+	RunCommand = runner.run
+	return runner, func() { RunCommand = origin }
+}
+
+// mtuCommands returns the commands setting MTU.
+func mtuCommands(commands []string) []string {
+	var results []string
+	for _, command := range commands {
+		if strings.Contains(command, " mtu ") {
+			results = append(results, command)
+		}
+	}
+	return results
+}
+
+func TestSetMTU(t *testing.T) {
+	testCases := []struct {
+		testName    string
+		mtu         int
+		failCommand string
+		expectedErr bool
+		expected    []string
+	}{
+		{
+			testName: "MTU set on all devices",
+			mtu:      1450,
+			expected: []string{"ip link set dev qvb mtu 1450", "ip link set dev qvo mtu 1450"},
+		},
+		{
+			testName: "zero MTU,kernel default kept",
+		},
+		{
+			testName:    "set MTU failed,later devices skipped",
+			mtu:         1450,
+			failCommand: "ip link set dev qvb mtu 1450",
+			expectedErr: true,
+			expected:    []string{"ip link set dev qvb mtu 1450"},
+		},
+	}
+
+	p := NewOVSPlugin()
+	for tci, tc := range testCases {
+		runner, restore := newFakeRunner(tc.failCommand)
+		err := p.setMTU(tc.mtu, "qvb", "qvo")
+		restore()
+		if tc.expectedErr != (err != nil) {
+			t.Errorf("Case[%d]: %s expected error %v, got %v", tci, tc.testName, tc.expectedErr, err)
+		}
+		if !reflect.DeepEqual(runner.commands, tc.expected) {
+			t.Errorf("Case[%d]: %s expected commands %v, got %v", tci, tc.testName, tc.expected, runner.commands)
+		}
+	}
+}
+
+func TestSetupInterfaceMTU(t *testing.T) {
+	port := &ports.Port{ID: "0123456789abcdef", MACAddress: "fa:16:3e:00:00:01"}
+	p := NewOVSPlugin()
+	qvb, qvo := p.buildVethName(port.ID)
+	vib, vif := p.buildSandboxInterfaceName(port.ID)
+	bridge := p.buildBridgeName(port.ID)
+
+	testCases := []struct {
+		testName string
+		mtu      int
+		expected []string
+	}{
+		{
+			testName: "MTU set on veths and bridge",
+			mtu:      1400,
+			expected: []string{
+				fmt.Sprintf("ip link set dev %s mtu 1400", qvb),
+				fmt.Sprintf("ip link set dev %s mtu 1400", qvo),
+				fmt.Sprintf("ip link set dev %s mtu 1400", bridge),
+				fmt.Sprintf("ip link set dev %s mtu 1400", vib),
+				fmt.Sprintf("ip link set dev %s mtu 1400", vif),
+			},
+		},
+		{
+			testName: "zero MTU,kernel default kept",
+		},
+	}
+
+	for tci, tc := range testCases {
+		runner, restore := newFakeRunner("")
+		brInterface, conInterface, err := p.SetupInterface("pod1", "container1", port, nil, tc.mtu, "eth0", "netns1")
+		restore()
+		if err != nil {
+			t.Errorf("Case[%d]: %s expected no error, got %v", tci, tc.testName, err)
+			continue
+		}
+		if brInterface.Name != bridge || brInterface.Mac != "00:11:22:33:44:55" || conInterface.Mac != port.MACAddress {
+			t.Errorf("Case[%d]: %s got unexpected interfaces %v and %v", tci, tc.testName, brInterface, conInterface)
+		}
+		if commands := mtuCommands(runner.commands); !reflect.DeepEqual(commands, tc.expected) {
+			t.Errorf("Case[%d]: %s expected MTU commands %v, got %v", tci, tc.testName, tc.expected, commands)
+		}
+	}
+}
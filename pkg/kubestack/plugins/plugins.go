@@ -25,8 +25,32 @@ import (
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
 )
 
+// IPConfig describes an IP address of pod's interface.
+type IPConfig struct {
+	// Address is the IP address with prefix length, e.g. 10.244.0.2/16.
+	Address string
+	// Gateway is the gateway of the address. Default route of the IP family
+	// is added via the first gateway.
+	Gateway string
+	// IPv6 is true if it is an IPv6 address.
+	IPv6 bool
+	// Routes are static routes of the address's subnet, which are added
+	// after the default route.
+	Routes []Route
+}
+
+// Route describes a static route of pod's interface.
+type Route struct {
+	// Destination is the destination CIDR, e.g. 192.168.0.0/24.
+	Destination string
+	// Nexthop is the IP of next hop.
+	Nexthop string
+}
+
 type PluginInterface interface {
-	SetupInterface(podName, podInfraContainerID string, port *ports.Port, ipcidr, gateway, ifName, netns string) (*current.Interface, *current.Interface, error)
+	// SetupInterface plugs the port into netns as ifName. Devices are created
+	// with mtu, the kernel default is used if it is zero.
+	SetupInterface(podName, podInfraContainerID string, port *ports.Port, ips []IPConfig, mtu int, ifName, netns string) (*current.Interface, *current.Interface, error)
 	DestroyInterface(podName, podInfraContainerID string, port *ports.Port) error
 	Init(integrationBridge string) error
 }
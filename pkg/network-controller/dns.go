@@ -0,0 +1,425 @@
+/*
+Copyright (c) 2017 OpenStack Foundation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	apiv1 "k8s.io/api/core/v1"
+	v1beta1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	apismetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kuberuntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"git.openstack.org/openstack/stackube/pkg/openstack"
+)
+
+const (
+	dnsProviderKubeDNS = "kube-dns"
+	dnsProviderCoreDNS = "coredns"
+
+	// kubeDNSName is the name of DNS deployment and service of each namespace.
+	kubeDNSName = "kube-dns"
+
+	defaultDNSReplicas  = 1
+	defaultCoreDNSImage = "coredns/coredns:1.0.6"
+
+	// kubeDNSConfigMap is read by kubedns and dnsmasq containers of kube-dns.
+	kubeDNSConfigMap = "kube-dns"
+	// coreDNSConfigMap holds the Corefile of CoreDNS.
+	coreDNSConfigMap = "coredns"
+
+	// kube-dns accepts at most 3 upstream name servers.
+	maxUpstreamNameservers = 3
+)
+
+// dnsServerContainers are the containers serving DNS queries, which get the
+// configured resources.
+var dnsServerContainers = map[string]bool{
+	"kubedns": true,
+	"dnsmasq": true,
+	"coredns": true,
+}
+
+// dnsConfig is the validated and defaulted config of the DNS deployed in
+// each namespace.
+type dnsConfig struct {
+	Provider     string
+	Domain       string
+	Replicas     int32
+	KubeDNSImage string
+	DNSMasqImage string
+	SidecarImage string
+	CoreDNSImage string
+	// Limits and Requests override the defaults of DNS server containers.
+	Limits   apiv1.ResourceList
+	Requests apiv1.ResourceList
+
+	UpstreamNameservers []string
+	// StubDomains maps private domains to their name servers.
+	StubDomains map[string][]string
+}
+
+// dnsTemplateArgs are the arguments of DNS manifests.
+type dnsTemplateArgs struct {
+	Namespace      string
+	DNSDomain      string
+	Replicas       int32
+	KubeDNSImage   string
+	DNSMasqImage   string
+	SidecarImage   string
+	CoreDNSImage   string
+	KubernetesHost string
+	KubernetesPort string
+
+	UpstreamNameservers []string
+	StubDomains         map[string][]string
+}
+
+// newDNSConfig validates DNS options and fills defaults for the options not set.
+func newDNSConfig(opts openstack.DNSOpts) (*dnsConfig, error) {
+	cfg := &dnsConfig{
+		Provider:     opts.Provider,
+		Domain:       strings.TrimSuffix(opts.Domain, "."),
+		Replicas:     int32(opts.Replicas),
+		KubeDNSImage: opts.KubeDNSImage,
+		DNSMasqImage: opts.DNSMasqImage,
+		SidecarImage: opts.SidecarImage,
+		CoreDNSImage: opts.CoreDNSImage,
+		Limits:       apiv1.ResourceList{},
+		Requests:     apiv1.ResourceList{},
+		StubDomains:  make(map[string][]string),
+	}
+
+	switch cfg.Provider {
+	case "":
+		cfg.Provider = dnsProviderKubeDNS
+	case dnsProviderKubeDNS, dnsProviderCoreDNS:
+	default:
+		return nil, fmt.Errorf("unsupported DNS provider %q, should be %s or %s", cfg.Provider, dnsProviderKubeDNS, dnsProviderCoreDNS)
+	}
+	if cfg.Domain == "" {
+		cfg.Domain = defaultDNSDomain
+	}
+	if cfg.Replicas < 0 {
+		return nil, fmt.Errorf("invalid DNS replicas %d", cfg.Replicas)
+	} else if cfg.Replicas == 0 {
+		cfg.Replicas = defaultDNSReplicas
+	}
+	if cfg.KubeDNSImage == "" {
+		cfg.KubeDNSImage = defaultKubeDNSImage
+	}
+	if cfg.DNSMasqImage == "" {
+		cfg.DNSMasqImage = defaultDNSMasqImage
+	}
+	if cfg.SidecarImage == "" {
+		cfg.SidecarImage = defaultSideCarImage
+	}
+	if cfg.CoreDNSImage == "" {
+		cfg.CoreDNSImage = defaultCoreDNSImage
+	}
+
+	for _, r := range []struct {
+		list  apiv1.ResourceList
+		name  apiv1.ResourceName
+		value string
+	}{
+		{cfg.Limits, apiv1.ResourceCPU, opts.CPULimit},
+		{cfg.Limits, apiv1.ResourceMemory, opts.MemoryLimit},
+		{cfg.Requests, apiv1.ResourceCPU, opts.CPURequest},
+		{cfg.Requests, apiv1.ResourceMemory, opts.MemoryRequest},
+	} {
+		if r.value == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(r.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DNS %s resource %q: %v", r.name, r.value, err)
+		}
+		r.list[r.name] = quantity
+	}
+
+	if len(opts.UpstreamNameservers) > maxUpstreamNameservers {
+		return nil, fmt.Errorf("at most %d upstream name servers are allowed, got %v", maxUpstreamNameservers, opts.UpstreamNameservers)
+	}
+	for _, server := range opts.UpstreamNameservers {
+		if err := validateNameserver(server); err != nil {
+			return nil, err
+		}
+		cfg.UpstreamNameservers = append(cfg.UpstreamNameservers, server)
+	}
+
+	for _, stub := range opts.StubDomains {
+		parts := strings.SplitN(stub, "=", 2)
+		domain := strings.TrimSuffix(strings.TrimSpace(parts[0]), ".")
+		if len(parts) != 2 || domain == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid stub domain %q, should be domain=ip[:port][,ip[:port]...]", stub)
+		}
+		for _, server := range strings.Split(parts[1], ",") {
+			server = strings.TrimSpace(server)
+			if err := validateNameserver(server); err != nil {
+				return nil, fmt.Errorf("invalid stub domain %q: %v", stub, err)
+			}
+			cfg.StubDomains[domain] = append(cfg.StubDomains[domain], server)
+		}
+	}
+
+	return cfg, nil
+}
+
+// validateNameserver checks the name server is in format of ip[:port].
+func validateNameserver(server string) error {
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		// No port.
+		host = server
+	} else if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return fmt.Errorf("invalid port of name server %q", server)
+	}
+	if net.ParseIP(host) == nil {
+		return fmt.Errorf("name server %q should be an IP address", server)
+	}
+	return nil
+}
+
+// templateArgs returns the arguments of DNS manifests in namespace.
+func (d *dnsConfig) templateArgs(namespace string) dnsTemplateArgs {
+	args := dnsTemplateArgs{
+		Namespace:           namespace,
+		DNSDomain:           d.Domain,
+		Replicas:            d.Replicas,
+		KubeDNSImage:        d.KubeDNSImage,
+		DNSMasqImage:        d.DNSMasqImage,
+		SidecarImage:        d.SidecarImage,
+		CoreDNSImage:        d.CoreDNSImage,
+		UpstreamNameservers: d.UpstreamNameservers,
+		StubDomains:         d.StubDomains,
+	}
+	if host := os.Getenv("KUBERNETES_SERVICE_HOST"); host != "" {
+		args.KubernetesHost = host
+	}
+	if port := os.Getenv("KUBERNETES_SERVICE_PORT"); port != "" {
+		args.KubernetesPort = port
+	}
+	return args
+}
+
+// newDNSDeployment renders the DNS deployment of the provider in namespace.
+// The deployment is always named kube-dns, so that the kube-dns service selects
+// it whichever provider is used.
+func (d *dnsConfig) newDNSDeployment(namespace string) (*v1beta1.Deployment, error) {
+	manifest := kubeDNSDeployment
+	if d.Provider == dnsProviderCoreDNS {
+		manifest = coreDNSDeployment
+	}
+	deploymentBytes, err := parseTemplate(manifest, d.templateArgs(namespace))
+	if err != nil {
+		return nil, err
+	}
+	deployment := &v1beta1.Deployment{}
+	if err = kuberuntime.DecodeInto(scheme.Codecs.UniversalDecoder(), deploymentBytes, deployment); err != nil {
+		return nil, fmt.Errorf("unable to decode %s deployment %v", d.Provider, err)
+	}
+
+	containers := deployment.Spec.Template.Spec.Containers
+	for i := range containers {
+		if !dnsServerContainers[containers[i].Name] {
+			continue
+		}
+		for name, quantity := range d.Limits {
+			if containers[i].Resources.Limits == nil {
+				containers[i].Resources.Limits = apiv1.ResourceList{}
+			}
+			containers[i].Resources.Limits[name] = quantity
+		}
+		for name, quantity := range d.Requests {
+			if containers[i].Resources.Requests == nil {
+				containers[i].Resources.Requests = apiv1.ResourceList{}
+			}
+			containers[i].Resources.Requests[name] = quantity
+		}
+	}
+
+	return deployment, nil
+}
+
+// newDNSConfigMap builds the config map of the provider in namespace, which
+// holds upstream name servers and stub domains.
+func (d *dnsConfig) newDNSConfigMap(namespace string) (*apiv1.ConfigMap, error) {
+	configMap := &apiv1.ConfigMap{
+		ObjectMeta: apismetav1.ObjectMeta{
+			Namespace: namespace,
+			Labels:    map[string]string{"k8s-app": "kube-dns"},
+		},
+		Data: make(map[string]string),
+	}
+
+	if d.Provider == dnsProviderCoreDNS {
+		corefile, err := parseTemplate(coreDNSCorefile, d.templateArgs(namespace))
+		if err != nil {
+			return nil, err
+		}
+		configMap.Name = coreDNSConfigMap
+		configMap.Data["Corefile"] = string(corefile)
+		return configMap, nil
+	}
+
+	configMap.Name = kubeDNSConfigMap
+	if len(d.UpstreamNameservers) > 0 {
+		upstream, err := json.Marshal(d.UpstreamNameservers)
+		if err != nil {
+			return nil, err
+		}
+		configMap.Data["upstreamNameservers"] = string(upstream)
+	}
+	if len(d.StubDomains) > 0 {
+		stubDomains, err := json.Marshal(d.StubDomains)
+		if err != nil {
+			return nil, err
+		}
+		configMap.Data["stubDomains"] = string(stubDomains)
+	}
+	return configMap, nil
+}
+
+// newKubeDNSService renders the kube-dns service in namespace.
+func newKubeDNSService(namespace string) (*apiv1.Service, error) {
+	tempArgs := struct{ Namespace string }{
+		Namespace: namespace,
+	}
+	dnsServiceBytes, err := parseTemplate(kubeDNSService, tempArgs)
+	if err != nil {
+		return nil, err
+	}
+	dnsService := &apiv1.Service{}
+	if err = kuberuntime.DecodeInto(scheme.Codecs.UniversalDecoder(), dnsServiceBytes, dnsService); err != nil {
+		return nil, fmt.Errorf("unable to decode kube-dns service %v", err)
+	}
+	return dnsService, nil
+}
+
+// dnsDeploymentChanged checks whether the deployment is changed from the
+// rendered one. Only fields set by the manifests are compared, since others
+// are defaulted by apiserver.
+func dnsDeploymentChanged(desired, actual *v1beta1.Deployment) bool {
+	if actual.Spec.Replicas == nil || *actual.Spec.Replicas != *desired.Spec.Replicas {
+		return true
+	}
+	if !reflect.DeepEqual(actual.Spec.Selector, desired.Spec.Selector) ||
+		!reflect.DeepEqual(actual.Spec.Template.Labels, desired.Spec.Template.Labels) {
+		return true
+	}
+
+	desiredPod, actualPod := desired.Spec.Template.Spec, actual.Spec.Template.Spec
+	if len(actualPod.Containers) != len(desiredPod.Containers) || len(actualPod.Volumes) != len(desiredPod.Volumes) {
+		return true
+	}
+	for i := range desiredPod.Containers {
+		if containerChanged(&desiredPod.Containers[i], &actualPod.Containers[i]) {
+			return true
+		}
+	}
+	for i := range desiredPod.Volumes {
+		dv, av := desiredPod.Volumes[i], actualPod.Volumes[i]
+		if av.Name != dv.Name || (av.ConfigMap == nil) != (dv.ConfigMap == nil) ||
+			av.ConfigMap != nil && av.ConfigMap.Name != dv.ConfigMap.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// containerChanged checks whether the container is changed from the rendered one.
+func containerChanged(desired, actual *apiv1.Container) bool {
+	if actual.Name != desired.Name || actual.Image != desired.Image ||
+		!reflect.DeepEqual(actual.Command, desired.Command) || !reflect.DeepEqual(actual.Args, desired.Args) {
+		return true
+	}
+
+	if len(actual.Env) != len(desired.Env) {
+		return true
+	}
+	for i := range desired.Env {
+		de, ae := desired.Env[i], actual.Env[i]
+		if ae.Name != de.Name || ae.Value != de.Value || (ae.ValueFrom == nil) != (de.ValueFrom == nil) {
+			return true
+		}
+		if de.ValueFrom != nil && de.ValueFrom.FieldRef != nil &&
+			(ae.ValueFrom.FieldRef == nil || ae.ValueFrom.FieldRef.FieldPath != de.ValueFrom.FieldRef.FieldPath) {
+			return true
+		}
+	}
+
+	if len(actual.Ports) != len(desired.Ports) {
+		return true
+	}
+	for i := range desired.Ports {
+		if actual.Ports[i].ContainerPort != desired.Ports[i].ContainerPort ||
+			actual.Ports[i].Protocol != desired.Ports[i].Protocol {
+			return true
+		}
+	}
+
+	return resourceListChanged(desired.Resources.Limits, actual.Resources.Limits) ||
+		resourceListChanged(effectiveRequests(desired.Resources), effectiveRequests(actual.Resources))
+}
+
+// effectiveRequests returns the resource requests, those not set default to limits.
+func effectiveRequests(resources apiv1.ResourceRequirements) apiv1.ResourceList {
+	requests := apiv1.ResourceList{}
+	for name, quantity := range resources.Limits {
+		requests[name] = quantity
+	}
+	for name, quantity := range resources.Requests {
+		requests[name] = quantity
+	}
+	return requests
+}
+
+func resourceListChanged(desired, actual apiv1.ResourceList) bool {
+	if len(actual) != len(desired) {
+		return true
+	}
+	for name, quantity := range desired {
+		actualQuantity, ok := actual[name]
+		if !ok || actualQuantity.Cmp(quantity) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// dnsServiceChanged checks whether the service is changed from the rendered one.
+func dnsServiceChanged(desired, actual *apiv1.Service) bool {
+	if !reflect.DeepEqual(actual.Spec.Selector, desired.Spec.Selector) || len(actual.Spec.Ports) != len(desired.Spec.Ports) {
+		return true
+	}
+	for i := range desired.Spec.Ports {
+		dp, ap := desired.Spec.Ports[i], actual.Spec.Ports[i]
+		if ap.Name != dp.Name || ap.Port != dp.Port || ap.Protocol != dp.Protocol || ap.TargetPort != dp.TargetPort {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,409 @@
+/*
+Copyright (c) 2017 OpenStack Foundation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package network
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	crv1 "git.openstack.org/openstack/stackube/pkg/apis/v1"
+	"git.openstack.org/openstack/stackube/pkg/openstack"
+
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	apismetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	core "k8s.io/client-go/testing"
+)
+
+func TestNewDNSConfig(t *testing.T) {
+	testCases := []struct {
+		testName    string
+		opts        openstack.DNSOpts
+		expectedErr bool
+	}{
+		{testName: "defaults"},
+		{testName: "unsupported provider", opts: openstack.DNSOpts{Provider: "bind"}, expectedErr: true},
+		{testName: "negative replicas", opts: openstack.DNSOpts{Replicas: -1}, expectedErr: true},
+		{testName: "invalid resource", opts: openstack.DNSOpts{CPULimit: "1 core"}, expectedErr: true},
+		{testName: "upstream with port", opts: openstack.DNSOpts{UpstreamNameservers: []string{"8.8.8.8", "10.0.0.10:5353"}}},
+		{testName: "upstream not IP", opts: openstack.DNSOpts{UpstreamNameservers: []string{"dns.example.com"}}, expectedErr: true},
+		{testName: "too many upstreams", opts: openstack.DNSOpts{UpstreamNameservers: []string{"1.1.1.1", "1.0.0.1", "8.8.8.8", "8.8.4.4"}}, expectedErr: true},
+		{testName: "stub domain", opts: openstack.DNSOpts{StubDomains: []string{"acme.local=10.0.0.10,10.0.0.11:5353"}}},
+		{testName: "stub domain without servers", opts: openstack.DNSOpts{StubDomains: []string{"acme.local="}}, expectedErr: true},
+		{testName: "stub domain with invalid port", opts: openstack.DNSOpts{StubDomains: []string{"acme.local=10.0.0.10:0"}}, expectedErr: true},
+	}
+
+	for tci, tc := range testCases {
+		_, err := newDNSConfig(tc.opts)
+		if tc.expectedErr != (err != nil) {
+			t.Errorf("Case[%d]: %s expected error %v, got %v", tci, tc.testName, tc.expectedErr, err)
+		}
+	}
+
+	cfg, err := newDNSConfig(openstack.DNSOpts{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.Provider != dnsProviderKubeDNS || cfg.Domain != defaultDNSDomain || cfg.Replicas != defaultDNSReplicas {
+		t.Errorf("Expected default DNS config, got %+v", cfg)
+	}
+}
+
+func TestCreateConfiguredKubeDNS(t *testing.T) {
+	testNamespace := "foo"
+	controller, _, _, client, err := newNetworkController()
+	if err != nil {
+		t.Fatalf("Failed start a new fake NetworkController")
+	}
+	controller.dns, err = newDNSConfig(openstack.DNSOpts{
+		Domain:              "example.org.",
+		Replicas:            2,
+		KubeDNSImage:        "registry.local/kube-dns:1.14.4",
+		DNSMasqImage:        "registry.local/dnsmasq-nanny:1.14.4",
+		SidecarImage:        "registry.local/sidecar:1.14.4",
+		MemoryLimit:         "256Mi",
+		UpstreamNameservers: []string{"10.0.0.2"},
+		StubDomains:         []string{"acme.local=10.0.0.10"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := controller.createDNSConfigMap(testNamespace); err != nil {
+		t.Fatalf("Create kube-dns config map in namespace %v error: %v", testNamespace, err)
+	}
+	configMap, err := client.Core().ConfigMaps(testNamespace).Get(kubeDNSConfigMap, apismetav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed get kube-dns config map in namespace %s: %v", testNamespace, err)
+	}
+	expectedData := map[string]string{
+		"upstreamNameservers": `["10.0.0.2"]`,
+		"stubDomains":         `{"acme.local":["10.0.0.10"]}`,
+	}
+	if !reflect.DeepEqual(configMap.Data, expectedData) {
+		t.Errorf("Expected kube-dns config map data %v, got %v", expectedData, configMap.Data)
+	}
+
+	if err := controller.createKubeDNSDeployment(testNamespace); err != nil {
+		t.Fatalf("Create kube-dns deployment in namespace %v error: %v", testNamespace, err)
+	}
+	deployment, err := client.ExtensionsV1beta1().Deployments(testNamespace).Get("kube-dns", apismetav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed get kube-dns deployment in namespace %s: %v", testNamespace, err)
+	}
+	if *deployment.Spec.Replicas != 2 {
+		t.Errorf("Expected 2 replicas of kube-dns, got %d", *deployment.Spec.Replicas)
+	}
+	images := map[string]string{
+		"kubedns": "registry.local/kube-dns:1.14.4",
+		"dnsmasq": "registry.local/dnsmasq-nanny:1.14.4",
+		"sidecar": "registry.local/sidecar:1.14.4",
+	}
+	for _, container := range deployment.Spec.Template.Spec.Containers {
+		if container.Image != images[container.Name] {
+			t.Errorf("Expected image of %s to be %s, got %s", container.Name, images[container.Name], container.Image)
+		}
+		memory := container.Resources.Limits[apiv1.ResourceMemory]
+		if dnsServerContainers[container.Name] && memory.Cmp(resource.MustParse("256Mi")) != 0 {
+			t.Errorf("Expected memory limit of %s to be 256Mi, got %s", container.Name, memory.String())
+		}
+		if container.Name == "kubedns" && container.Args[0] != "--domain=example.org." {
+			t.Errorf("Expected kubedns to serve domain example.org, got %v", container.Args)
+		}
+	}
+}
+
+func TestCreateCoreDNS(t *testing.T) {
+	testNamespace := "foo"
+	controller, _, _, client, err := newNetworkController()
+	if err != nil {
+		t.Fatalf("Failed start a new fake NetworkController")
+	}
+	controller.dns, err = newDNSConfig(openstack.DNSOpts{
+		Provider:     dnsProviderCoreDNS,
+		CoreDNSImage: "registry.local/coredns:1.0.6",
+		CPULimit:     "200m",
+		StubDomains:  []string{"acme.local=10.0.0.10,10.0.0.11:5353"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := controller.createDNSConfigMap(testNamespace); err != nil {
+		t.Fatalf("Create coredns config map in namespace %v error: %v", testNamespace, err)
+	}
+	configMap, err := client.Core().ConfigMaps(testNamespace).Get(coreDNSConfigMap, apismetav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed get coredns config map in namespace %s: %v", testNamespace, err)
+	}
+	corefile := configMap.Data["Corefile"]
+	for _, expected := range []string{
+		"kubernetes cluster.local in-addr.arpa ip6.arpa {",
+		"namespaces foo",
+		"proxy . /etc/resolv.conf",
+		"acme.local:53 {",
+		"proxy . 10.0.0.10 10.0.0.11:5353",
+	} {
+		if !strings.Contains(corefile, expected) {
+			t.Errorf("Expected Corefile to contain %q, got %s", expected, corefile)
+		}
+	}
+
+	if err := controller.createKubeDNSDeployment(testNamespace); err != nil {
+		t.Fatalf("Create coredns deployment in namespace %v error: %v", testNamespace, err)
+	}
+	deployment, err := client.ExtensionsV1beta1().Deployments(testNamespace).Get("kube-dns", apismetav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed get coredns deployment in namespace %s: %v", testNamespace, err)
+	}
+	containers := deployment.Spec.Template.Spec.Containers
+	if len(containers) != 1 || containers[0].Name != "coredns" || containers[0].Image != "registry.local/coredns:1.0.6" {
+		t.Fatalf("Expected a single coredns container, got %v", containers)
+	}
+	cpu := containers[0].Resources.Limits[apiv1.ResourceCPU]
+	if cpu.Cmp(resource.MustParse("200m")) != 0 {
+		t.Errorf("Expected cpu limit of coredns to be 200m, got %s", cpu.String())
+	}
+	if deployment.Spec.Template.Labels["k8s-app"] != "kube-dns" {
+		t.Errorf("Expected coredns to be selected by kube-dns service, got labels %v", deployment.Spec.Template.Labels)
+	}
+}
+
+func TestSyncDNS(t *testing.T) {
+	testNamespace := "foo"
+	controller, kubeCRDClient, _, client, err := newNetworkController()
+	if err != nil {
+		t.Fatalf("Failed start a new fake NetworkController")
+	}
+	network := newNetwork(testNamespace, "")
+	kubeCRDClient.SetNetworks(network)
+	controller.cache.set(testNamespace+"/"+testNamespace, network)
+
+	deployments := client.ExtensionsV1beta1().Deployments(testNamespace)
+	services := client.Core().Services(testNamespace)
+
+	testCases := []struct {
+		testName   string
+		updateFn   func() error
+		expectedFn func() error
+	}{
+		{
+			testName: "kube-dns created,not ready",
+			updateFn: func() error { return nil },
+			expectedFn: func() error {
+				if _, err := deployments.Get(kubeDNSName, apismetav1.GetOptions{}); err != nil {
+					return fmt.Errorf("expected kube-dns deployment created, got %v", err)
+				}
+				if _, err := services.Get(kubeDNSName, apismetav1.GetOptions{}); err != nil {
+					return fmt.Errorf("expected kube-dns service created, got %v", err)
+				}
+				return testDNSReadyCondition(kubeCRDClient.Networks[testNamespace], crv1.ConditionFalse, reasonKubeDNSNotReady)
+			},
+		},
+		{
+			testName: "kube-dns available",
+			updateFn: func() error {
+				deployment, err := deployments.Get(kubeDNSName, apismetav1.GetOptions{})
+				if err != nil {
+					return err
+				}
+				deployment.Status.AvailableReplicas = 1
+				_, err = deployments.Update(deployment)
+				return err
+			},
+			expectedFn: func() error {
+				return testDNSReadyCondition(kubeCRDClient.Networks[testNamespace], crv1.ConditionTrue, reasonKubeDNSReady)
+			},
+		},
+		{
+			testName: "kube-dns deployment edited,repaired",
+			updateFn: func() error {
+				deployment, err := deployments.Get(kubeDNSName, apismetav1.GetOptions{})
+				if err != nil {
+					return err
+				}
+				deployment.Spec.Template.Spec.Containers[0].Image = "unknown/kube-dns:latest"
+				_, err = deployments.Update(deployment)
+				return err
+			},
+			expectedFn: func() error {
+				deployment, err := deployments.Get(kubeDNSName, apismetav1.GetOptions{})
+				if err != nil {
+					return err
+				}
+				if image := deployment.Spec.Template.Spec.Containers[0].Image; image != defaultKubeDNSImage {
+					return fmt.Errorf("expected image of kube-dns repaired to %s, got %s", defaultKubeDNSImage, image)
+				}
+				return nil
+			},
+		},
+		{
+			testName: "kube-dns service deleted,recreated",
+			updateFn: func() error {
+				return services.Delete(kubeDNSName, apismetav1.NewDeleteOptions(0))
+			},
+			expectedFn: func() error {
+				if _, err := services.Get(kubeDNSName, apismetav1.GetOptions{}); err != nil {
+					return fmt.Errorf("expected kube-dns service recreated, got %v", err)
+				}
+				return nil
+			},
+		},
+		{
+			testName: "network terminating,kube-dns not recreated",
+			updateFn: func() error {
+				now := apismetav1.Now()
+				kubeCRDClient.Networks[testNamespace].DeletionTimestamp = &now
+				return deployments.Delete(kubeDNSName, apismetav1.NewDeleteOptions(0))
+			},
+			expectedFn: func() error {
+				if _, err := deployments.Get(kubeDNSName, apismetav1.GetOptions{}); !apierrors.IsNotFound(err) {
+					return fmt.Errorf("expected kube-dns deployment not recreated, got %v", err)
+				}
+				return nil
+			},
+		},
+	}
+
+	for tci, tc := range testCases {
+		if err := tc.updateFn(); err != nil {
+			t.Fatalf("Case[%d]: %s update failed: %v", tci, tc.testName, err)
+		}
+		if err := controller.syncDNS(testNamespace); err != nil {
+			t.Errorf("Case[%d]: %s unexpected error: %v", tci, tc.testName, err)
+		}
+		if err := tc.expectedFn(); err != nil {
+			t.Errorf("Case[%d]: %s %v", tci, tc.testName, err)
+		}
+	}
+}
+
+func TestRetryKubeDNS(t *testing.T) {
+	testNamespace := "foo"
+	controller, kubeCRDClient, osClient, client, err := newNetworkController()
+	if err != nil {
+		t.Fatalf("Failed start a new fake NetworkController")
+	}
+	kubeCRDClient.SetTenants(newTenant(testNamespace, tenantID))
+	kubeCRDClient.SetNetworks(newNetwork(testNamespace, ""))
+	osClient.SetTenant(testNamespace, tenantID)
+
+	createFailed := true
+	client.PrependReactor("create", "deployments", func(action core.Action) (bool, runtime.Object, error) {
+		return createFailed, nil, fmt.Errorf("create failed")
+	})
+
+	key := testNamespace + "/" + testNamespace
+	if err := controller.syncNetwork(key); err == nil {
+		t.Fatalf("Expected sync of network %s to fail", key)
+	}
+	network := kubeCRDClient.Networks[testNamespace]
+	if network.Status.AppliedSpec == nil {
+		t.Fatalf("Expected network %s to be applied, got status %v", key, network.Status)
+	}
+	if err := testDNSReadyCondition(network, crv1.ConditionFalse, reasonKubeDNSFailed); err != nil {
+		t.Errorf("Network %s %v", key, err)
+	}
+
+	// The network is applied already, kube-dns is retried on the next sync.
+	createFailed = false
+	if err := controller.syncNetwork(key); err != nil {
+		t.Fatalf("Sync network %s failed: %v", key, err)
+	}
+	if _, err := client.ExtensionsV1beta1().Deployments(testNamespace).Get(kubeDNSName, apismetav1.GetOptions{}); err != nil {
+		t.Errorf("Expected kube-dns deployment created, got %v", err)
+	}
+	if err := testDNSReadyCondition(kubeCRDClient.Networks[testNamespace], crv1.ConditionFalse, reasonKubeDNSNotReady); err != nil {
+		t.Errorf("Network %s %v", key, err)
+	}
+}
+
+func TestResyncKubeDNS(t *testing.T) {
+	testNamespace := "foo"
+	controller, kubeCRDClient, _, client, err := newNetworkController()
+	if err != nil {
+		t.Fatalf("Failed start a new fake NetworkController")
+	}
+	network := newNetwork(testNamespace, "")
+	network.ResourceVersion = "1"
+	kubeCRDClient.SetNetworks(network)
+	controller.cache.set(testNamespace+"/"+testNamespace, network)
+
+	// kube-dns is never created, so only the resync of network could repair it.
+	controller.onUpdate(network, network)
+	if controller.dnsQueue.Len() != 1 {
+		t.Fatalf("Expected namespace %s to be queued, got %d items", testNamespace, controller.dnsQueue.Len())
+	}
+	controller.processNextDNSWorkItem()
+	if _, err := client.ExtensionsV1beta1().Deployments(testNamespace).Get(kubeDNSName, apismetav1.GetOptions{}); err != nil {
+		t.Errorf("Expected kube-dns deployment created, got %v", err)
+	}
+}
+
+func testDNSReadyCondition(network *crv1.Network, status crv1.ConditionStatus, reason string) error {
+	condition := getNetworkCondition(&network.Status, crv1.NetworkDNSReady)
+	if condition == nil || condition.Status != status || condition.Reason != reason {
+		return fmt.Errorf("expected condition %s %s with reason %s, got %v", crv1.NetworkDNSReady, status, reason, condition)
+	}
+	return nil
+}
+
+func TestDNSDeploymentChanged(t *testing.T) {
+	cfg, err := newDNSConfig(openstack.DNSOpts{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	desired, err := cfg.newDNSDeployment("foo")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Fields defaulted by apiserver are not changes.
+	defaulted, _ := cfg.newDNSDeployment("foo")
+	for i := range defaulted.Spec.Template.Spec.Containers {
+		container := &defaulted.Spec.Template.Spec.Containers[i]
+		container.TerminationMessagePath = "/dev/termination-log"
+		if container.Resources.Requests == nil {
+			container.Resources.Requests = container.Resources.Limits
+		}
+		for j := range container.Env {
+			if container.Env[j].ValueFrom != nil {
+				container.Env[j].ValueFrom.FieldRef.APIVersion = "v1"
+			}
+		}
+	}
+	if dnsDeploymentChanged(desired, defaulted) {
+		t.Errorf("Expected defaulted kube-dns deployment not changed")
+	}
+
+	scaled, _ := cfg.newDNSDeployment("foo")
+	replicas := int32(0)
+	scaled.Spec.Replicas = &replicas
+	if !dnsDeploymentChanged(desired, scaled) {
+		t.Errorf("Expected scaled kube-dns deployment changed")
+	}
+
+	limited, _ := cfg.newDNSDeployment("foo")
+	limited.Spec.Template.Spec.Containers[0].Resources.Limits[apiv1.ResourceMemory] = resource.MustParse("1Mi")
+	if !dnsDeploymentChanged(desired, limited) {
+		t.Errorf("Expected kube-dns deployment with resources changed")
+	}
+}
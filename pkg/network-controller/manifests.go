@@ -26,7 +26,7 @@ metadata:
   name: kube-dns
   namespace: {{ .Namespace }}
 spec:
-  replicas: 1
+  replicas: {{ .Replicas }}
   selector:
     matchLabels:
       k8s-app: kube-dns
@@ -157,6 +157,122 @@ spec:
         name: kube-dns-config
 `
 
+	coreDNSDeployment = `
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  labels:
+    k8s-app: kube-dns
+  name: kube-dns
+  namespace: {{ .Namespace }}
+spec:
+  replicas: {{ .Replicas }}
+  selector:
+    matchLabels:
+      k8s-app: kube-dns
+  strategy:
+    rollingUpdate:
+      maxSurge: 10%
+      maxUnavailable: 0
+    type: RollingUpdate
+  template:
+    metadata:
+      annotations:
+        scheduler.alpha.kubernetes.io/critical-pod: ""
+      labels:
+        k8s-app: kube-dns
+    spec:
+      affinity:
+        nodeAffinity:
+          requiredDuringSchedulingIgnoredDuringExecution:
+            nodeSelectorTerms:
+            - matchExpressions:
+              - key: beta.kubernetes.io/arch
+                operator: In
+                values:
+                - amd64
+      containers:
+      - args:
+        - -conf
+        - /etc/coredns/Corefile
+        env:
+        - name: KUBERNETES_SERVICE_HOST
+          value: "{{ .KubernetesHost }}"
+        - name: KUBERNETES_SERVICE_PORT
+          value: "{{ .KubernetesPort }}"
+        image: {{ .CoreDNSImage }}
+        imagePullPolicy: IfNotPresent
+        livenessProbe:
+          failureThreshold: 5
+          httpGet:
+            path: /health
+            port: 8080
+            scheme: HTTP
+          initialDelaySeconds: 60
+          successThreshold: 1
+          timeoutSeconds: 5
+        name: coredns
+        ports:
+        - containerPort: 53
+          name: dns
+          protocol: UDP
+        - containerPort: 53
+          name: dns-tcp
+          protocol: TCP
+        - containerPort: 9153
+          name: metrics
+          protocol: TCP
+        resources:
+          limits:
+            memory: 170Mi
+          requests:
+            cpu: 100m
+            memory: 70Mi
+        volumeMounts:
+        - mountPath: /etc/coredns
+          name: config-volume
+      dnsPolicy: Default
+      restartPolicy: Always
+      terminationGracePeriodSeconds: 30
+      tolerations:
+      - effect: NoSchedule
+        key: node-role.kubernetes.io/master
+      - key: CriticalAddonsOnly
+        operator: Exists
+      volumes:
+      - configMap:
+          defaultMode: 420
+          items:
+          - key: Corefile
+            path: Corefile
+          name: coredns
+        name: config-volume
+`
+
+	// coreDNSCorefile serves the namespace's records, and forwards other
+	// queries to stub domains' name servers or upstream name servers, which
+	// defaults to those of the node.
+	coreDNSCorefile = `.:53 {
+    errors
+    health
+    kubernetes {{ .DNSDomain }} in-addr.arpa ip6.arpa {
+        namespaces {{ .Namespace }}
+        pods insecure
+        fallthrough in-addr.arpa ip6.arpa
+    }
+    prometheus :9153
+    proxy .{{ range .UpstreamNameservers }} {{ . }}{{ else }} /etc/resolv.conf{{ end }}
+    cache 30
+}
+{{- range $domain, $servers := .StubDomains }}
+{{ $domain }}:53 {
+    errors
+    cache 30
+    proxy .{{ range $servers }} {{ . }}{{ end }}
+}
+{{- end }}
+`
+
 	kubeDNSService = `
 apiVersion: v1
 kind: Service
@@ -18,27 +18,28 @@ package network
 
 import (
 	"fmt"
-	"os"
+	"reflect"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
 	apiv1 "k8s.io/api/core/v1"
-	v1beta1 "k8s.io/api/extensions/v1beta1"
 	apiextensionsclient "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	apismetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
-	kuberuntime "k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/kubernetes/scheme"
+	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 
 	crv1 "git.openstack.org/openstack/stackube/pkg/apis/v1"
 	"git.openstack.org/openstack/stackube/pkg/kubecrd"
 	"git.openstack.org/openstack/stackube/pkg/openstack"
-	"git.openstack.org/openstack/stackube/pkg/util"
 )
 
 const (
@@ -46,6 +47,15 @@ const (
 	defaultKubeDNSImage = "stackube/k8s-dns-kube-dns-amd64:1.14.4"
 	defaultDNSMasqImage = "stackube/k8s-dns-dnsmasq-nanny-amd64:1.14.4"
 	defaultSideCarImage = "stackube/k8s-dns-sidecar-amd64:1.14.4"
+
+	// networkResyncPeriod is the period for resyncing networks, so that
+	// networks failed to be synced are retried, and synced networks are
+	// reconciled with network provider.
+	networkResyncPeriod = 5 * time.Minute
+
+	// How long to wait before retrying the processing of a network change.
+	minRetryDelay = 5 * time.Second
+	maxRetryDelay = 300 * time.Second
 )
 
 // NetworkController manages the life cycle of Network.
@@ -54,15 +64,90 @@ type NetworkController struct {
 	kubeCRDClient   kubecrd.Interface
 	driver          openstack.Interface
 	networkInformer cache.Controller
+	// dns is the config of DNS deployed in each namespace
+	dns *dnsConfig
+	// recorder records events of networks, e.g. drifts found by reconciling
+	recorder record.EventRecorder
+
+	// kube-dns deployments and services, which are repaired once changed
+	kubeInformerFactory informers.SharedInformerFactory
+	kubeDNSSynced       []cache.InformerSynced
+
+	// networks that need to be synced
+	queue workqueue.RateLimitingInterface
+	// namespaces whose kube-dns need to be synced
+	dnsQueue workqueue.RateLimitingInterface
+	// networks which have been synced to network provider
+	cache *networkCache
+}
+
+// networkCache caches the last synced networks, keyed by namespace/name, so
+// that kube-dns is only managed for synced networks, and resources of deleted
+// networks could be cleaned up. Spec changes are applied against the applied
+// spec in network status instead, which survives restarts.
+type networkCache struct {
+	mu         sync.Mutex
+	networkMap map[string]*crv1.Network
+}
+
+func newNetworkCache() *networkCache {
+	return &networkCache{networkMap: make(map[string]*crv1.Network)}
+}
+
+func (c *networkCache) get(key string) (*crv1.Network, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	network, ok := c.networkMap[key]
+	return network, ok
+}
+
+func (c *networkCache) set(key string, network *crv1.Network) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.networkMap[key] = network
 }
 
-// Run the network controller.
-func (c *NetworkController) Run(stopCh <-chan struct{}) error {
+func (c *networkCache) delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.networkMap, key)
+}
+
+// namespaceKeys returns keys of the networks in namespace.
+func (c *networkCache) namespaceKeys(namespace string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var keys []string
+	for key, network := range c.networkMap {
+		if network.Namespace == namespace {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Run the network controller with the given number of workers.
+func (c *NetworkController) Run(workers int, stopCh <-chan struct{}) error {
 	defer utilruntime.HandleCrash()
+	defer c.queue.ShutDown()
+	defer c.dnsQueue.ShutDown()
 
 	go c.networkInformer.Run(stopCh)
-	<-stopCh
+	go c.kubeInformerFactory.Start(stopCh)
+
+	if !cache.WaitForCacheSync(stopCh, c.networkInformer.HasSynced) {
+		return fmt.Errorf("failed to cache networks")
+	}
+	if !cache.WaitForCacheSync(stopCh, c.kubeDNSSynced...) {
+		return fmt.Errorf("failed to cache kube-dns deployments and services")
+	}
+
+	for i := 0; i < workers; i++ {
+		go wait.Until(c.worker, time.Second, stopCh)
+		go wait.Until(c.dnsWorker, time.Second, stopCh)
+	}
 
+	<-stopCh
 	return nil
 }
 
@@ -74,6 +159,16 @@ func NewNetworkController(kubeClient kubernetes.Interface, osClient openstack.In
 		return nil, fmt.Errorf("failed to create CRD to kube-apiserver: %v", err)
 	}
 
+	dns, err := newDNSConfig(osClient.GetDNSOpts())
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNS config: %v", err)
+	}
+
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(glog.Infof)
+	eventBroadcaster.StartRecordingToSink(&v1core.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	recorder := eventBroadcaster.NewRecorder(osClient.GetCRDClient().Scheme(), apiv1.EventSource{Component: eventSourceComponent})
+
 	source := cache.NewListWatchFromClient(
 		osClient.GetCRDClient().Client(),
 		crv1.NetworkResourcePlural,
@@ -83,11 +178,18 @@ func NewNetworkController(kubeClient kubernetes.Interface, osClient openstack.In
 		k8sclient:     kubeClient,
 		kubeCRDClient: osClient.GetCRDClient(),
 		driver:        osClient,
+		dns:           dns,
+		recorder:      recorder,
+		queue: workqueue.NewNamedRateLimitingQueue(
+			workqueue.NewItemExponentialFailureRateLimiter(minRetryDelay, maxRetryDelay), "network"),
+		dnsQueue: workqueue.NewNamedRateLimitingQueue(
+			workqueue.NewItemExponentialFailureRateLimiter(minRetryDelay, maxRetryDelay), "kube-dns"),
+		cache: newNetworkCache(),
 	}
 	_, networkInformer := cache.NewInformer(
 		source,
 		&crv1.Network{},
-		0,
+		networkResyncPeriod,
 		cache.ResourceEventHandlerFuncs{
 			AddFunc:    networkController.onAdd,
 			UpdateFunc: networkController.onUpdate,
@@ -95,111 +197,395 @@ func NewNetworkController(kubeClient kubernetes.Interface, osClient openstack.In
 		})
 	networkController.networkInformer = networkInformer
 
+	kubeInformerFactory := informers.NewSharedInformerFactory(kubeClient, networkResyncPeriod)
+	dnsHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc: networkController.enqueueKubeDNS,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			networkController.enqueueKubeDNS(newObj)
+		},
+		DeleteFunc: networkController.enqueueKubeDNS,
+	}
+	deploymentInformer := kubeInformerFactory.Extensions().V1beta1().Deployments().Informer()
+	deploymentInformer.AddEventHandler(dnsHandler)
+	serviceInformer := kubeInformerFactory.Core().V1().Services().Informer()
+	serviceInformer.AddEventHandler(dnsHandler)
+	networkController.kubeInformerFactory = kubeInformerFactory
+	networkController.kubeDNSSynced = []cache.InformerSynced{deploymentInformer.HasSynced, serviceInformer.HasSynced}
+
 	return networkController, nil
 }
 
-func (c *NetworkController) onAdd(obj interface{}) {
-	network := obj.(*crv1.Network)
-	// glog.Infof("[NETWORK CONTROLLER] OnAdd %\n", network.ObjectMeta.SelfLink)
-	glog.Infof("[NETWORK CONTROLLER] OnAdd %#v\n", network)
-
-	// NEVER modify objects from the store. It's a read-only, local cache.
-	// You can use networkScheme.Copy() to make a deep copy of original object and modify this copy
-	// Or create a copy manually for better performance
-	copyObj, err := c.kubeCRDClient.Scheme().Copy(network)
+// enqueueNetwork adds the key of network to the queue. obj could be an
+// *crv1.Network, or a DeletionFinalStateUnknown marker item.
+func (c *NetworkController) enqueueNetwork(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
 	if err != nil {
-		glog.Errorf("ERROR creating a deep copy of network object: %v\n", err)
+		glog.Errorf("Couldn't get key for object %#v: %v", obj, err)
 		return
 	}
+	c.queue.Add(key)
+}
 
-	networkCopy := copyObj.(*crv1.Network)
-
-	// This will:
-	// 1. Create Network in Neutron
-	// 2. Update Network CRD object status to Active or Failed
-	err = c.addNetworkToDriver(networkCopy)
-	if err != nil {
-		glog.Errorf("Add network to driver failed: %v", err)
+func (c *NetworkController) onAdd(obj interface{}) {
+	network, ok := obj.(*crv1.Network)
+	if !ok {
+		glog.Warningf("Receiving an unkown object: %v", obj)
 		return
 	}
 
-	// create kube-dns in this namespace.
-	namespace := networkCopy.Namespace
-	if err := c.createKubeDNSDeployment(namespace); err != nil {
-		glog.Errorf("Create kube-dns deployment failed: %v", err)
+	glog.V(4).Infof("NetworkController: network %s added", network.Name)
+	c.enqueueNetwork(network)
+	c.dnsQueue.Add(network.Namespace)
+}
+
+func (c *NetworkController) onUpdate(oldObj, newObj interface{}) {
+	oldNetwork, ok1 := oldObj.(*crv1.Network)
+	newNetwork, ok2 := newObj.(*crv1.Network)
+	if !ok1 || !ok2 {
+		glog.Warningf("Receiving an unkown object: %v", newObj)
 		return
 	}
 
-	if err := c.createKubeDNSService(namespace); err != nil {
-		glog.Errorf("Create kube-dns service failed: %v", err)
+	// Status updates by ourselves are also notified here, only care about spec
+	// changes, deletions and resyncs.
+	if reflect.DeepEqual(oldNetwork.Spec, newNetwork.Spec) &&
+		(oldNetwork.DeletionTimestamp == nil) == (newNetwork.DeletionTimestamp == nil) &&
+		oldNetwork.ResourceVersion != newNetwork.ResourceVersion {
 		return
 	}
-}
 
-func (c *NetworkController) onUpdate(oldObj, newObj interface{}) {
-	// NOTE(harry) not supported yet
+	glog.V(4).Infof("NetworkController: network %s updated", newNetwork.Name)
+	c.enqueueNetwork(newNetwork)
+	// kube-dns is also repaired on resyncs, in case it is never created and
+	// so no deployment or service event comes.
+	c.dnsQueue.Add(newNetwork.Namespace)
 }
 
 func (c *NetworkController) onDelete(obj interface{}) {
 	net, ok := obj.(*crv1.Network)
 	if !ok {
-		glog.Warningf("Receiving an unkown object: %v", obj)
-		return
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			glog.Warningf("Receiving an unkown object: %v", obj)
+			return
+		}
+		net, ok = tombstone.Obj.(*crv1.Network)
+		if !ok {
+			glog.Warningf("Tombstone contained an unkown object: %v", tombstone.Obj)
+			return
+		}
 	}
 
 	glog.V(4).Infof("NetworkController: network %s deleted", net.Name)
 
-	// Delete kube-dns deployment.
-	if err := c.deleteDeployment(net.Namespace, "kube-dns"); err != nil {
-		glog.Warningf("error on deleting kube-dns deployment: %v", err)
+	// Keep the final state of network, so that its resources could be cleaned up.
+	key, err := cache.MetaNamespaceKeyFunc(net)
+	if err != nil {
+		glog.Errorf("Couldn't get key for object %#v: %v", net, err)
+		return
 	}
-	// Delete kube-dns services for non-system namespaces.
-	if !util.IsSystemNamespace(net.Namespace) {
-		if err := c.k8sclient.Core().Services(net.Namespace).Delete("kube-dns", apismetav1.NewDeleteOptions(0)); err != nil {
-			glog.Warningf("error on deleting kube-dns service: %v", err)
-		}
+	c.cache.set(key, net)
+	c.queue.Add(key)
+}
+
+// enqueueKubeDNS adds the namespace of kube-dns deployment or service to the
+// dnsQueue. obj could be an object of any kind, or a DeletionFinalStateUnknown
+// marker item, objects not named kube-dns are omitted.
+func (c *NetworkController) enqueueKubeDNS(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		glog.Errorf("Couldn't get key for object %#v: %v", obj, err)
+		return
+	}
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		glog.Errorf("Couldn't split key %q: %v", key, err)
+		return
+	}
+	if name == kubeDNSName {
+		c.dnsQueue.Add(namespace)
+	}
+}
+
+// worker runs a worker thread that just dequeues items, processes them, and marks them done.
+// It enforces that the syncNetwork is never invoked concurrently with the same key.
+func (c *NetworkController) worker() {
+	for c.processNextWorkItem() {
+	}
+}
+
+// processNextWorkItem processes a key from the queue. Failed keys are retried
+// with per-item exponential backoff. It returns false when the queue is shut down.
+func (c *NetworkController) processNextWorkItem() bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	err := c.syncNetwork(key.(string))
+	if err == nil {
+		c.queue.Forget(key)
+		return true
+	}
+	if _, ok := err.(*invalidSpecError); ok {
+		// Retrying won't help, the network is synced again once its spec is changed.
+		glog.Errorf("Failed to sync network %q, will not retry: %v", key, err)
+		c.queue.Forget(key)
+		return true
+	}
+
+	glog.Errorf("Failed to sync network %q (retried %d times), will retry: %v", key, c.queue.NumRequeues(key), err)
+	c.queue.AddRateLimited(key)
+	return true
+}
+
+// dnsWorker runs a worker thread that syncs kube-dns of namespaces in dnsQueue.
+func (c *NetworkController) dnsWorker() {
+	for c.processNextDNSWorkItem() {
+	}
+}
+
+// processNextDNSWorkItem processes a namespace from the dnsQueue. Failed
+// namespaces are retried with per-item exponential backoff. It returns false
+// when the queue is shut down.
+func (c *NetworkController) processNextDNSWorkItem() bool {
+	key, quit := c.dnsQueue.Get()
+	if quit {
+		return false
 	}
+	defer c.dnsQueue.Done(key)
 
-	// Delete neutron network created by stackube.
-	if net.Spec.NetworkID == "" {
-		networkName := util.BuildNetworkName(net.GetNamespace(), net.GetName())
-		err := c.driver.DeleteNetwork(networkName)
+	err := c.syncDNS(key.(string))
+	if err == nil {
+		c.dnsQueue.Forget(key)
+		return true
+	}
+
+	glog.Errorf("Failed to sync kube-dns of namespace %q (retried %d times), will retry: %v", key, c.dnsQueue.NumRequeues(key), err)
+	c.dnsQueue.AddRateLimited(key)
+	return true
+}
+
+// syncDNS repairs kube-dns of the namespace, and updates the DNSReady condition
+// of networks in the namespace. kube-dns is only managed for networks which
+// have been synced and are not being deleted, so that it is not recreated
+// while networks are deleted.
+func (c *NetworkController) syncDNS(namespace string) error {
+	var networks []*crv1.Network
+	for _, key := range c.cache.namespaceKeys(namespace) {
+		_, name, err := cache.SplitMetaNamespaceKey(key)
 		if err != nil {
-			glog.Errorf("NetworkController: delete network %s failed in networkprovider: %v", networkName, err)
-		} else {
-			glog.V(4).Infof("NetworkController: network %s deleted in networkprovider", networkName)
+			return err
+		}
+		network, err := c.kubeCRDClient.GetNetwork(namespace, name)
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return err
 		}
+		if network.DeletionTimestamp == nil {
+			networks = append(networks, network)
+		}
+	}
+	if len(networks) == 0 {
+		glog.V(5).Infof("No network in namespace %s, skip syncing kube-dns", namespace)
+		return nil
 	}
+
+	status, reason, message, err := c.syncKubeDNS(namespace)
+	for _, network := range networks {
+		c.updateDNSReadyCondition(network, status, reason, message)
+	}
+	return err
 }
 
-func (c *NetworkController) createKubeDNSDeployment(namespace string) error {
-	tempArgs := struct{ Namespace, DNSDomain, KubeDNSImage, DNSMasqImage, SidecarImage, KubernetesHost, KubernetesPort string }{
-		Namespace:    namespace,
-		DNSDomain:    defaultDNSDomain,
-		KubeDNSImage: defaultKubeDNSImage,
-		DNSMasqImage: defaultDNSMasqImage,
-		SidecarImage: defaultSideCarImage,
+// syncNetwork syncs the network with the given key to network provider:
+// 1. Create network in Neutron and kube-dns for new networks
+// 2. Update subnets of the network in Neutron if spec is changed from the applied one
+// 3. Reconcile the network with Neutron if spec is not changed
+// 4. Delete resources of terminating or deleted networks
+// Network CRD object status is updated to Active, Failed or Terminating.
+func (c *NetworkController) syncNetwork(key string) error {
+	startTime := time.Now()
+	defer func() {
+		glog.V(4).Infof("Finished syncing network %q (%v)", key, time.Now().Sub(startTime))
+	}()
+
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+
+	// Get the latest network, so that status is updated with the latest resourceVersion.
+	network, err := c.kubeCRDClient.GetNetwork(namespace, name)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+
+		// Resources are normally deleted before the finalizer is removed, this
+		// cleans up those left by networks without finalizer.
+		cachedNetwork, ok := c.cache.get(key)
+		if !ok {
+			return nil
+		}
+		if err := c.deleteNetworkResources(cachedNetwork); err != nil {
+			return err
+		}
+		c.cache.delete(key)
+		return nil
 	}
-	if host := os.Getenv("KUBERNETES_SERVICE_HOST"); host != "" {
-		tempArgs.KubernetesHost = host
+
+	if network.DeletionTimestamp != nil {
+		if err := c.finalizeNetwork(network); err != nil {
+			return err
+		}
+		c.cache.delete(key)
+		return nil
 	}
-	if port := os.Getenv("KUBERNETES_SERVICE_PORT"); port != "" {
-		tempArgs.KubernetesPort = port
+
+	appliedSpec := network.Status.AppliedSpec
+	if appliedSpec == nil {
+		if err := c.addNetworkToDriver(network); err != nil {
+			return err
+		}
+		c.cache.set(key, network)
+		return c.ensureKubeDNS(network)
 	}
-	dnsDeploymentBytes, err := parseTemplate(kubeDNSDeployment, tempArgs)
-	kubeDNSDeploy := &v1beta1.Deployment{}
-	if err = kuberuntime.DecodeInto(scheme.Codecs.UniversalDecoder(), dnsDeploymentBytes, kubeDNSDeploy); err != nil {
-		return fmt.Errorf("unable to decode kube-dns deployment %v", err)
+
+	c.cache.set(key, network)
+	if reflect.DeepEqual(*appliedSpec, network.Spec) {
+		// Nothing changed, which is usually a resync. Check whether the
+		// network drifts from network provider.
+		if err := c.reconcileNetwork(network); err != nil {
+			return err
+		}
+		// kube-dns failed to be created along with the network is retried,
+		// since the network is not added again.
+		if kubeDNSFailed(network) {
+			return c.ensureKubeDNS(network)
+		}
+		return nil
 	}
-	_, err = c.k8sclient.ExtensionsV1beta1().Deployments(namespace).Create(kubeDNSDeploy)
+	glog.V(4).Infof("NetworkController: network %s updated from %#v to %#v", network.Name, *appliedSpec, network.Spec)
+	appliedNetwork := network.DeepCopy()
+	appliedNetwork.Spec = *appliedSpec
+	return c.updateNetworkInDriver(appliedNetwork, network)
+}
+
+// ensureKubeDNS creates or repairs kube-dns in the network's namespace, and
+// updates the DNSReady condition of the network with its readiness.
+func (c *NetworkController) ensureKubeDNS(network *crv1.Network) error {
+	status, reason, message, err := c.syncKubeDNS(network.Namespace)
+	c.updateDNSReadyCondition(network, status, reason, message)
+	return err
+}
+
+// kubeDNSFailed checks whether kube-dns of the network is not created or
+// failed to be created.
+func kubeDNSFailed(network *crv1.Network) bool {
+	condition := getNetworkCondition(&network.Status, crv1.NetworkDNSReady)
+	return condition == nil || condition.Reason == reasonKubeDNSFailed
+}
+
+// syncKubeDNS repairs the config map, deployment and service of kube-dns in
+// namespace to match the rendered manifests, and returns the DNSReady
+// condition observed.
+func (c *NetworkController) syncKubeDNS(namespace string) (crv1.ConditionStatus, string, string, error) {
+	if err := c.createDNSConfigMap(namespace); err != nil {
+		glog.Errorf("Create %s config map failed: %v", c.dns.Provider, err)
+		return crv1.ConditionFalse, reasonKubeDNSFailed, fmt.Sprintf("create %s config map failed: %v", c.dns.Provider, err), err
+	}
+
+	if err := c.createKubeDNSDeployment(namespace); err != nil {
+		glog.Errorf("Create kube-dns deployment failed: %v", err)
+		return crv1.ConditionFalse, reasonKubeDNSFailed, fmt.Sprintf("create kube-dns deployment failed: %v", err), err
+	}
+
+	if err := c.createKubeDNSService(namespace); err != nil {
+		glog.Errorf("Create kube-dns service failed: %v", err)
+		return crv1.ConditionFalse, reasonKubeDNSFailed, fmt.Sprintf("create kube-dns service failed: %v", err), err
+	}
+
+	deployment, err := c.k8sclient.ExtensionsV1beta1().Deployments(namespace).Get(kubeDNSName, apismetav1.GetOptions{})
 	if err != nil {
-		if !apierrors.IsAlreadyExists(err) {
+		return crv1.ConditionFalse, reasonKubeDNSFailed, fmt.Sprintf("get kube-dns deployment failed: %v", err), err
+	}
+	var replicas int32 = 1
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	message := fmt.Sprintf("%d of %d kube-dns replicas available", deployment.Status.AvailableReplicas, replicas)
+	if deployment.Status.AvailableReplicas == 0 {
+		return crv1.ConditionFalse, reasonKubeDNSNotReady, message, nil
+	}
+	return crv1.ConditionTrue, reasonKubeDNSReady, message, nil
+}
+
+// updateDNSReadyCondition updates the DNSReady condition of the network if it
+// is changed, so that resyncs don't update networks needlessly.
+func (c *NetworkController) updateDNSReadyCondition(network *crv1.Network, status crv1.ConditionStatus, reason, message string) {
+	condition := getNetworkCondition(&network.Status, crv1.NetworkDNSReady)
+	if condition != nil && condition.Status == status && condition.Reason == reason && condition.Message == message {
+		return
+	}
+	c.updateNetworkCondition(network, crv1.NetworkDNSReady, status, reason, message)
+}
+
+// createKubeDNSDeployment creates the kube-dns deployment of the configured
+// DNS provider in namespace, or updates it if it is changed from the rendered one.
+func (c *NetworkController) createKubeDNSDeployment(namespace string) error {
+	kubeDNSDeploy, err := c.dns.newDNSDeployment(namespace)
+	if err != nil {
+		return err
+	}
+	existing, err := c.k8sclient.ExtensionsV1beta1().Deployments(namespace).Get(kubeDNSDeploy.Name, apismetav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if _, err = c.k8sclient.ExtensionsV1beta1().Deployments(namespace).Create(kubeDNSDeploy); err != nil {
 			return fmt.Errorf("unable to create a new kube-dns deployment: %v", err)
 		}
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("unable to get the kube-dns deployment: %v", err)
+	}
 
-		if _, err = c.k8sclient.ExtensionsV1beta1().Deployments(namespace).Update(kubeDNSDeploy); err != nil {
-			return fmt.Errorf("unable to update the kube-dns deployment: %v", err)
+	if !dnsDeploymentChanged(kubeDNSDeploy, existing) {
+		return nil
+	}
+	glog.V(3).Infof("Repairing kube-dns deployment in namespace %s", namespace)
+	kubeDNSDeploy.ResourceVersion = existing.ResourceVersion
+	if _, err = c.k8sclient.ExtensionsV1beta1().Deployments(namespace).Update(kubeDNSDeploy); err != nil {
+		return fmt.Errorf("unable to update the kube-dns deployment: %v", err)
+	}
+
+	return nil
+}
+
+// createDNSConfigMap creates the config map of the configured DNS provider in
+// namespace, or updates it if it is changed from the rendered one.
+func (c *NetworkController) createDNSConfigMap(namespace string) error {
+	configMap, err := c.dns.newDNSConfigMap(namespace)
+	if err != nil {
+		return err
+	}
+	existing, err := c.k8sclient.Core().ConfigMaps(namespace).Get(configMap.Name, apismetav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if _, err = c.k8sclient.Core().ConfigMaps(namespace).Create(configMap); err != nil {
+			return fmt.Errorf("unable to create a new %s config map: %v", configMap.Name, err)
 		}
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("unable to get the %s config map: %v", configMap.Name, err)
+	}
+
+	if len(existing.Data) == 0 && len(configMap.Data) == 0 || reflect.DeepEqual(existing.Data, configMap.Data) {
+		return nil
+	}
+	glog.V(3).Infof("Repairing %s config map in namespace %s", configMap.Name, namespace)
+	configMap.ResourceVersion = existing.ResourceVersion
+	if _, err = c.k8sclient.Core().ConfigMaps(namespace).Update(configMap); err != nil {
+		return fmt.Errorf("unable to update the %s config map: %v", configMap.Name, err)
 	}
 
 	return nil
@@ -229,24 +615,31 @@ func (c *NetworkController) deleteDeployment(namespace, name string) error {
 	return nil
 }
 
+// createKubeDNSService creates the kube-dns service in namespace, or updates it
+// if it is changed from the rendered one. The cluster IP is kept on updates.
 func (c *NetworkController) createKubeDNSService(namespace string) error {
-	tempArgs := struct{ Namespace string }{
-		Namespace: namespace,
-	}
-	dnsServiceBytes, err := parseTemplate(kubeDNSService, tempArgs)
-	dnsService := &apiv1.Service{}
-	if err = kuberuntime.DecodeInto(scheme.Codecs.UniversalDecoder(), dnsServiceBytes, dnsService); err != nil {
-		return fmt.Errorf("unable to decode kube-dns service %v", err)
-	}
-	_, err = c.k8sclient.Core().Services(namespace).Create(dnsService)
+	dnsService, err := newKubeDNSService(namespace)
 	if err != nil {
-		if !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	existing, err := c.k8sclient.Core().Services(namespace).Get(dnsService.Name, apismetav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if _, err = c.k8sclient.Core().Services(namespace).Create(dnsService); err != nil {
 			return fmt.Errorf("unable to create a new kube-dns service: %v", err)
 		}
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("unable to get the kube-dns service: %v", err)
+	}
 
-		if _, err = c.k8sclient.Core().Services(namespace).Update(dnsService); err != nil {
-			return fmt.Errorf("unable to update the kube-dns service: %v", err)
-		}
+	if !dnsServiceChanged(dnsService, existing) {
+		return nil
+	}
+	glog.V(3).Infof("Repairing kube-dns service in namespace %s", namespace)
+	dnsService.ResourceVersion = existing.ResourceVersion
+	dnsService.Spec.ClusterIP = existing.Spec.ClusterIP
+	if _, err = c.k8sclient.Core().Services(namespace).Update(dnsService); err != nil {
+		return fmt.Errorf("unable to update the kube-dns service: %v", err)
 	}
 
 	return nil
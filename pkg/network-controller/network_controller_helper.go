@@ -20,63 +20,86 @@ import (
 	"bytes"
 	"fmt"
 	"html/template"
-	"time"
+	"net"
+	"reflect"
+	"strings"
 
 	crv1 "git.openstack.org/openstack/stackube/pkg/apis/v1"
+	"git.openstack.org/openstack/stackube/pkg/openstack"
 	drivertypes "git.openstack.org/openstack/stackube/pkg/openstack/types"
 	"git.openstack.org/openstack/stackube/pkg/util"
 
 	"github.com/golang/glog"
-	"k8s.io/apimachinery/pkg/util/wait"
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 const (
 	networkPrefix = "network"
-	subnetSuffix  = "subnet"
+
+	// eventSourceComponent is the component of events recorded by the controller.
+	eventSourceComponent = "stackube-controller"
+)
+
+// These are the reasons of network conditions.
+const (
+	reasonNetworkReady    = "NetworkReady"
+	reasonTenantNotFound  = "TenantNotFound"
+	reasonNetworkNotFound = "NetworkNotFound"
+	reasonInvalidSpec     = "InvalidSpec"
+	reasonCreateFailed    = "CreateFailed"
+	reasonUpdateFailed    = "UpdateFailed"
+	reasonKubeDNSReady    = "KubeDNSReady"
+	reasonKubeDNSNotReady = "KubeDNSNotReady"
+	reasonKubeDNSFailed   = "KubeDNSFailed"
+	reasonDeleting        = "Deleting"
+	reasonDeleteFailed    = "DeleteFailed"
+	reasonNetworkDrift    = "NetworkDrift"
+)
+
+// These are the reasons of events recorded for drifts found by reconciling.
+const (
+	eventReasonDrift         = "NetworkDrift"
+	eventReasonDriftRepaired = "NetworkDriftRepaired"
+)
+
+// Provider network types supported by Network CRD.
+const (
+	networkTypeVLAN  = "vlan"
+	networkTypeFlat  = "flat"
+	networkTypeVXLAN = "vxlan"
+
+	maxVLANID = 4094
+	maxVNI    = 1<<24 - 1
 )
 
+// invalidSpecError is returned when the network spec could never be applied to
+// network provider, such networks are not retried until the spec is changed.
+type invalidSpecError struct {
+	error
+}
+
 func (c *NetworkController) addNetworkToDriver(kubeNetwork *crv1.Network) error {
+	// The finalizer is saved along with status, so that resources are deleted
+	// before the Network CRD object is removed.
+	addFinalizer(kubeNetwork)
+
 	// The tenant name is the same with namespace, let's get tenantID by tenantName
 	tenantName := kubeNetwork.GetNamespace()
+	// Fetching tenantID may fail or get nothing if tenant is still being processed
+	// by cloud provider, the network will be retried later then.
 	tenantID, err := c.driver.GetTenantIDFromName(tenantName)
-
-	// Retry for a while if fetch tenantID failed or tenantID not found,
-	// this is normally caused by cloud provider processing
-	if err != nil || tenantID == "" {
-		err = wait.Poll(2*time.Second, 10*time.Second, func() (bool, error) {
-			tenantID, err = c.driver.GetTenantIDFromName(kubeNetwork.GetNamespace())
-			if err != nil {
-				glog.Errorf("failed to fetch tenantID for tenantName: %v, error: %v retrying\n", tenantName, err)
-				return false, err
-			}
-
-			if tenantID == "" {
-				glog.V(5).Infof("tenantID is empty for tenantName: %v, retrying\n", tenantName)
-				return false, err
-			}
-			return true, nil
-		})
-	}
 	if err != nil || tenantID == "" {
-		return fmt.Errorf("failed to fetch tenantID for tenantName: %v, error: %v abort! \n", tenantName, err)
+		err = fmt.Errorf("failed to fetch tenantID for tenantName: %v, error: %v", tenantName, err)
+		c.updateNetworkStatus(kubeNetwork, crv1.NetworkPending, reasonTenantNotFound, err.Error())
+		return err
 	}
 
 	networkName := util.BuildNetworkName(tenantName, kubeNetwork.GetName())
 
 	// Translate Kubernetes network to OpenStack network
-	driverNetwork := &drivertypes.Network{
-		Name:     networkName,
-		TenantID: tenantID,
-		Subnets: []*drivertypes.Subnet{
-			{
-				// network: subnet = 1:1
-				Name:     networkName + "-" + subnetSuffix,
-				Cidr:     kubeNetwork.Spec.CIDR,
-				Gateway:  kubeNetwork.Spec.Gateway,
-				Tenantid: tenantID,
-			},
-		},
-	}
+	driverNetwork := newDriverNetwork(kubeNetwork, tenantID)
 
 	glog.V(4).Infof("[NetworkController]: adding network %s", driverNetwork.Name)
 
@@ -87,24 +110,28 @@ func (c *NetworkController) addNetworkToDriver(kubeNetwork *crv1.Network) error
 		return err
 	}
 	if !check {
-		kubeNetwork.Status.State = crv1.NetworkFailed
-		c.kubeCRDClient.UpdateNetwork(kubeNetwork)
-		return fmt.Errorf("tenantID %s doesn't exist in network provider", driverNetwork.TenantID)
+		err = fmt.Errorf("tenantID %s doesn't exist in network provider", driverNetwork.TenantID)
+		c.updateNetworkStatus(kubeNetwork, crv1.NetworkFailed, reasonTenantNotFound, err.Error())
+		return err
 	}
 
 	// Check if provider network id exist
 	if kubeNetwork.Spec.NetworkID != "" {
 		_, err := c.driver.GetNetworkByID(kubeNetwork.Spec.NetworkID)
 		if err != nil {
-			kubeNetwork.Status.State = crv1.NetworkFailed
-			c.kubeCRDClient.UpdateNetwork(kubeNetwork)
-			return fmt.Errorf("network %s doesn't exit in network provider", kubeNetwork.Spec.NetworkID)
+			err = fmt.Errorf("network %s doesn't exit in network provider", kubeNetwork.Spec.NetworkID)
+			c.updateNetworkStatus(kubeNetwork, crv1.NetworkFailed, reasonNetworkNotFound, err.Error())
+			return err
 		}
 	} else {
+		if err := validateNetworkSpec(&kubeNetwork.Spec); err != nil {
+			c.updateNetworkStatus(kubeNetwork, crv1.NetworkFailed, reasonInvalidSpec, err.Error())
+			return &invalidSpecError{err}
+		}
 		if len(driverNetwork.Subnets) == 0 {
-			kubeNetwork.Status.State = crv1.NetworkFailed
-			c.kubeCRDClient.UpdateNetwork(kubeNetwork)
-			return fmt.Errorf("subnets of %s is null", driverNetwork.Name)
+			err = fmt.Errorf("subnets of %s is null", driverNetwork.Name)
+			c.updateNetworkStatus(kubeNetwork, crv1.NetworkFailed, reasonInvalidSpec, err.Error())
+			return &invalidSpecError{err}
 		}
 		// Check if provider network has already created
 		_, err := c.driver.GetNetworkByName(networkName)
@@ -114,22 +141,664 @@ func (c *NetworkController) addNetworkToDriver(kubeNetwork *crv1.Network) error
 			// Create a new network by network provider
 			err := c.driver.CreateNetwork(driverNetwork)
 			if err != nil {
-				kubeNetwork.Status.State = crv1.NetworkFailed
-				c.kubeCRDClient.UpdateNetwork(kubeNetwork)
-				return fmt.Errorf("create network %s failed: %v", driverNetwork.Name, err)
+				err = fmt.Errorf("create network %s failed: %v", driverNetwork.Name, err)
+				c.updateNetworkStatus(kubeNetwork, crv1.NetworkFailed, reasonCreateFailed, err.Error())
+				return err
 			}
 		} else {
-			kubeNetwork.Status.State = crv1.NetworkFailed
-			c.kubeCRDClient.UpdateNetwork(kubeNetwork)
-			return fmt.Errorf("get network failed: %v", err)
+			err = fmt.Errorf("get network failed: %v", err)
+			c.updateNetworkStatus(kubeNetwork, crv1.NetworkFailed, reasonCreateFailed, err.Error())
+			return err
+		}
+	}
+
+	c.recordNetworkIDs(kubeNetwork)
+	kubeNetwork.Status.AppliedSpec = kubeNetwork.Spec.DeepCopy()
+	c.updateNetworkStatus(kubeNetwork, crv1.NetworkActive, reasonNetworkReady,
+		fmt.Sprintf("network %s is ready in network provider", networkName))
+	return nil
+}
+
+// updateNetworkInDriver applies the spec changes from oldNetwork, whose spec is
+// the applied one, to kubeNetwork in network provider.
+func (c *NetworkController) updateNetworkInDriver(oldNetwork, kubeNetwork *crv1.Network) error {
+	if err := validateNetworkUpdate(oldNetwork, kubeNetwork); err != nil {
+		c.updateNetworkStatus(kubeNetwork, crv1.NetworkFailed, reasonInvalidSpec, err.Error())
+		return &invalidSpecError{err}
+	}
+
+	tenantID, err := c.driver.GetTenantIDFromName(kubeNetwork.GetNamespace())
+	if err != nil || tenantID == "" {
+		err = fmt.Errorf("failed to fetch tenantID for tenantName: %v, error: %v", kubeNetwork.GetNamespace(), err)
+		c.updateNetworkStatus(kubeNetwork, crv1.NetworkFailed, reasonTenantNotFound, err.Error())
+		return err
+	}
+
+	driverNetwork := newDriverNetwork(kubeNetwork, tenantID)
+	if kubeNetwork.Spec.NetworkID != "" {
+		// Only IP ranges are changed, which are used by kubestack only.
+		kubeNetwork.Status.AppliedSpec = kubeNetwork.Spec.DeepCopy()
+		c.updateNetworkStatus(kubeNetwork, crv1.NetworkActive, reasonNetworkReady,
+			fmt.Sprintf("IP ranges of network %s are updated", kubeNetwork.Spec.NetworkID))
+		return nil
+	}
+	glog.V(4).Infof("[NetworkController]: updating network %s", driverNetwork.Name)
+
+	if err := c.driver.UpdateNetwork(driverNetwork); err != nil {
+		err = fmt.Errorf("update network %s failed: %v", driverNetwork.Name, err)
+		c.updateNetworkStatus(kubeNetwork, crv1.NetworkFailed, reasonUpdateFailed, err.Error())
+		return err
+	}
+
+	c.recordNetworkIDs(kubeNetwork)
+	kubeNetwork.Status.AppliedSpec = kubeNetwork.Spec.DeepCopy()
+	c.updateNetworkStatus(kubeNetwork, crv1.NetworkActive, reasonNetworkReady,
+		fmt.Sprintf("network %s is updated in network provider", driverNetwork.Name))
+	return nil
+}
+
+// reconcileNetwork checks the synced network against network provider. Drifts
+// are repaired if possible and recorded as events, the network is marked
+// Failed if any of them could not be repaired.
+func (c *NetworkController) reconcileNetwork(kubeNetwork *crv1.Network) error {
+	var drifts []*drivertypes.Drift
+	if kubeNetwork.Spec.NetworkID != "" {
+		// Networks not managed by stackube could only be checked for existence.
+		if _, err := c.driver.GetNetworkByID(kubeNetwork.Spec.NetworkID); err != nil {
+			if err != openstack.ErrNotFound {
+				return err
+			}
+			drifts = append(drifts, &drivertypes.Drift{
+				Resource: drivertypes.DriftResourceNetwork,
+				Message:  fmt.Sprintf("network %s not found", kubeNetwork.Spec.NetworkID),
+			})
+		}
+	} else {
+		tenantID, err := c.driver.GetTenantIDFromName(kubeNetwork.GetNamespace())
+		if err != nil || tenantID == "" {
+			return fmt.Errorf("failed to fetch tenantID for tenantName: %v, error: %v", kubeNetwork.GetNamespace(), err)
+		}
+
+		drifts, err = c.driver.ReconcileNetwork(newDriverNetwork(kubeNetwork, tenantID))
+		if err != nil {
+			glog.Errorf("[NetworkController]: reconcile network %s failed: %v", kubeNetwork.Name, err)
+			return err
+		}
+	}
+
+	var unrepaired []string
+	for _, drift := range drifts {
+		glog.Warningf("[NetworkController]: network %s/%s drifts (repaired: %v): %s",
+			kubeNetwork.Namespace, kubeNetwork.Name, drift.Repaired, drift.Message)
+		if drift.Repaired {
+			c.recorder.Eventf(networkReference(kubeNetwork), apiv1.EventTypeNormal, eventReasonDriftRepaired,
+				"%s drift repaired: %s", drift.Resource, drift.Message)
+			continue
+		}
+		c.recorder.Eventf(networkReference(kubeNetwork), apiv1.EventTypeWarning, eventReasonDrift,
+			"%s drift: %s", drift.Resource, drift.Message)
+		unrepaired = append(unrepaired, drift.Message)
+	}
+
+	if len(unrepaired) > 0 {
+		c.updateNetworkStatus(kubeNetwork, crv1.NetworkFailed, reasonNetworkDrift,
+			fmt.Sprintf("network drifts from network provider: %s", strings.Join(unrepaired, "; ")))
+		return nil
+	}
+	if len(drifts) > 0 || kubeNetwork.Status.State != crv1.NetworkActive {
+		c.recordNetworkIDs(kubeNetwork)
+		c.updateNetworkStatus(kubeNetwork, crv1.NetworkActive, reasonNetworkReady,
+			fmt.Sprintf("network %s is in sync with network provider",
+				util.BuildNetworkName(kubeNetwork.GetNamespace(), kubeNetwork.GetName())))
+	}
+	return nil
+}
+
+// networkReference returns the reference of Network CRD object for its events.
+// Networks decoded by the CRD client have no type meta, which the event
+// recorder can't make a reference from.
+func networkReference(kubeNetwork *crv1.Network) *apiv1.ObjectReference {
+	return &apiv1.ObjectReference{
+		Kind:            "Network",
+		APIVersion:      crv1.SchemeGroupVersion.String(),
+		Namespace:       kubeNetwork.Namespace,
+		Name:            kubeNetwork.Name,
+		UID:             kubeNetwork.UID,
+		ResourceVersion: kubeNetwork.ResourceVersion,
+	}
+}
+
+// validateNetworkUpdate checks whether the changes from oldNetwork to kubeNetwork
+// could be applied to network provider.
+func validateNetworkUpdate(oldNetwork, kubeNetwork *crv1.Network) error {
+	if oldNetwork.Spec.NetworkID != kubeNetwork.Spec.NetworkID {
+		return fmt.Errorf("networkID can not be changed from %q to %q", oldNetwork.Spec.NetworkID, kubeNetwork.Spec.NetworkID)
+	}
+	if kubeNetwork.Spec.NetworkID != "" && !onlyIPRangesChanged(oldNetwork, kubeNetwork) {
+		return fmt.Errorf("network %s is not managed by stackube and can not be updated", kubeNetwork.Spec.NetworkID)
+	}
+	if oldNetwork.Spec.NoRouter != kubeNetwork.Spec.NoRouter || oldNetwork.Spec.RouterID != kubeNetwork.Spec.RouterID {
+		return fmt.Errorf("router of network can not be changed")
+	}
+	if oldNetwork.Spec.NetworkType != kubeNetwork.Spec.NetworkType ||
+		oldNetwork.Spec.PhysicalNetwork != kubeNetwork.Spec.PhysicalNetwork ||
+		oldNetwork.Spec.SegmentationID != kubeNetwork.Spec.SegmentationID {
+		return fmt.Errorf("provider attributes of network can not be changed")
+	}
+
+	return validateNetworkSpec(&kubeNetwork.Spec)
+}
+
+// onlyIPRangesChanged checks whether IP ranges are the only changes from
+// oldNetwork to kubeNetwork, they are not applied to network provider.
+func onlyIPRangesChanged(oldNetwork, kubeNetwork *crv1.Network) bool {
+	oldSpec, newSpec := oldNetwork.Spec.DeepCopy(), kubeNetwork.Spec.DeepCopy()
+	oldSpec.IPRanges, newSpec.IPRanges = nil, nil
+	return reflect.DeepEqual(oldSpec, newSpec)
+}
+
+// validateNetworkSpec checks whether router options, provider attributes and
+// subnets of the network spec are valid.
+func validateNetworkSpec(spec *crv1.NetworkSpec) error {
+	if spec.NoRouter || spec.RouterID != "" {
+		switch {
+		case spec.NoRouter && spec.RouterID != "":
+			return fmt.Errorf("noRouter and routerID can not be both set")
+		case spec.DisableSNAT || spec.ExternalNetworkID != "":
+			return fmt.Errorf("disableSNAT and externalNetworkID are only valid for dedicated router")
+		}
+	}
+
+	if err := validateNetworkProvider(spec.NetworkType, spec.PhysicalNetwork, spec.SegmentationID); err != nil {
+		return err
+	}
+
+	if spec.CIDR != "" {
+		if err := validateSubnet(spec.CIDR, spec.Gateway, spec.IPv6AddressMode, spec.AllocationPools); err != nil {
+			return err
+		}
+		if err := validateSubnetOptions(spec.CIDR, spec.DNSNameservers, spec.HostRoutes); err != nil {
+			return err
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, sub := range spec.Subnets {
+		if sub.Name == "" {
+			return fmt.Errorf("name of subnet %q is empty", sub.CIDR)
+		}
+		if names[sub.Name] {
+			return fmt.Errorf("duplicate subnet name %q", sub.Name)
+		}
+		names[sub.Name] = true
+
+		if err := validateSubnet(sub.CIDR, sub.Gateway, sub.IPv6AddressMode, sub.AllocationPools); err != nil {
+			return fmt.Errorf("invalid subnet %q: %v", sub.Name, err)
+		}
+		if err := validateSubnetOptions(sub.CIDR, sub.DNSNameservers, sub.HostRoutes); err != nil {
+			return fmt.Errorf("invalid subnet %q: %v", sub.Name, err)
+		}
+	}
+
+	return validateIPRanges(spec)
+}
+
+// validateIPRanges checks whether IP ranges of the network spec are valid,
+// each of them should be in the CIDR of the network or one of its subnets,
+// and out of the allocation pools of the subnet. Otherwise Neutron may
+// allocate their addresses to other pods. CIDRs and allocation pools should
+// have been validated.
+func validateIPRanges(spec *crv1.NetworkSpec) error {
+	type subnetPools struct {
+		ipNet *net.IPNet
+		pools []crv1.AllocationPool
+	}
+	var subnets []subnetPools
+	if spec.CIDR != "" {
+		_, ipNet, _ := net.ParseCIDR(spec.CIDR)
+		subnets = append(subnets, subnetPools{ipNet, spec.AllocationPools})
+	}
+	for _, sub := range spec.Subnets {
+		_, ipNet, _ := net.ParseCIDR(sub.CIDR)
+		subnets = append(subnets, subnetPools{ipNet, sub.AllocationPools})
+	}
+
+	names := make(map[string]bool)
+	for _, r := range spec.IPRanges {
+		if r.Name == "" {
+			return fmt.Errorf("name of IP range %s-%s is empty", r.Start, r.End)
+		}
+		if names[r.Name] {
+			return fmt.Errorf("duplicate IP range name %q", r.Name)
+		}
+		names[r.Name] = true
+
+		start, end := net.ParseIP(r.Start), net.ParseIP(r.End)
+		if start == nil || end == nil || (start.To4() == nil) != (end.To4() == nil) ||
+			bytes.Compare(start.To16(), end.To16()) > 0 {
+			return fmt.Errorf("invalid IP range %q: %s-%s", r.Name, r.Start, r.End)
+		}
+		// Ranges of networks not managed by stackube are not checked.
+		if len(subnets) == 0 {
+			continue
+		}
+		var subnet *subnetPools
+		for i := range subnets {
+			if subnets[i].ipNet.Contains(start) && subnets[i].ipNet.Contains(end) {
+				subnet = &subnets[i]
+				break
+			}
+		}
+		if subnet == nil {
+			return fmt.Errorf("IP range %q is not in CIDR of the network or its subnets", r.Name)
+		}
+		if len(subnet.pools) == 0 {
+			return fmt.Errorf("IP range %q overlaps allocation pools of CIDR %q, which cover the whole CIDR if not provided", r.Name, subnet.ipNet)
+		}
+		for _, pool := range subnet.pools {
+			poolStart, poolEnd := net.ParseIP(pool.Start), net.ParseIP(pool.End)
+			if bytes.Compare(start.To16(), poolEnd.To16()) <= 0 && bytes.Compare(poolStart.To16(), end.To16()) <= 0 {
+				return fmt.Errorf("IP range %q overlaps allocation pool %s-%s", r.Name, pool.Start, pool.End)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateNetworkProvider checks whether provider attributes are valid for the
+// network type.
+func validateNetworkProvider(networkType, physicalNetwork string, segmentationID int32) error {
+	switch networkType {
+	case "":
+		if physicalNetwork != "" || segmentationID != 0 {
+			return fmt.Errorf("physicalNetwork and segmentationID require networkType")
+		}
+	case networkTypeFlat:
+		if physicalNetwork == "" {
+			return fmt.Errorf("physicalNetwork is required by %s network", networkType)
+		}
+		if segmentationID != 0 {
+			return fmt.Errorf("segmentationID is not allowed by %s network", networkType)
+		}
+	case networkTypeVLAN:
+		if physicalNetwork == "" {
+			return fmt.Errorf("physicalNetwork is required by %s network", networkType)
+		}
+		if segmentationID < 0 || segmentationID > maxVLANID {
+			return fmt.Errorf("invalid VLAN ID %d, must be 0 (allocated by Neutron) or 1-%d", segmentationID, maxVLANID)
+		}
+	case networkTypeVXLAN:
+		if physicalNetwork != "" {
+			return fmt.Errorf("physicalNetwork is not allowed by %s network", networkType)
+		}
+		if segmentationID < 0 || segmentationID > maxVNI {
+			return fmt.Errorf("invalid VNI %d, must be 0 (allocated by Neutron) or 1-%d", segmentationID, maxVNI)
+		}
+	default:
+		return fmt.Errorf("unsupported networkType %q, valid value: %s, %s, %s",
+			networkType, networkTypeVLAN, networkTypeFlat, networkTypeVXLAN)
+	}
+
+	return nil
+}
+
+func validateSubnet(cidr, gateway, ipv6AddressMode string, pools []crv1.AllocationPool) error {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fmt.Errorf("invalid CIDR %q: %v", cidr, err)
+	}
+	switch ipv6AddressMode {
+	case "":
+	case crv1.IPv6SLAAC, crv1.IPv6DHCPStateless, crv1.IPv6DHCPStateful:
+		if ipNet.IP.To4() != nil {
+			return fmt.Errorf("ipv6AddressMode %q is not allowed for IPv4 CIDR %q", ipv6AddressMode, cidr)
+		}
+		// SLAAC only works with 64 bits prefix.
+		if ones, _ := ipNet.Mask.Size(); ipv6AddressMode != crv1.IPv6DHCPStateful && ones != 64 {
+			return fmt.Errorf("ipv6AddressMode %q requires a /64 CIDR, got %q", ipv6AddressMode, cidr)
+		}
+	default:
+		return fmt.Errorf("invalid ipv6AddressMode %q", ipv6AddressMode)
+	}
+	if gateway != "" {
+		ip := net.ParseIP(gateway)
+		if ip == nil || !ipNet.Contains(ip) {
+			return fmt.Errorf("gateway %q is not in CIDR %q", gateway, cidr)
+		}
+	}
+	for _, pool := range pools {
+		start, end := net.ParseIP(pool.Start), net.ParseIP(pool.End)
+		if start == nil || end == nil || !ipNet.Contains(start) || !ipNet.Contains(end) {
+			return fmt.Errorf("allocation pool %s-%s is not in CIDR %q", pool.Start, pool.End, cidr)
+		}
+	}
+
+	return nil
+}
+
+// validateSubnetOptions validates DNS name servers and host routes of the subnet.
+// CIDR should have been validated by validateSubnet.
+func validateSubnetOptions(cidr string, nameservers []string, routes []crv1.HostRoute) error {
+	for _, server := range nameservers {
+		if net.ParseIP(server) == nil {
+			return fmt.Errorf("invalid DNS name server %q", server)
+		}
+	}
+
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fmt.Errorf("invalid CIDR %q: %v", cidr, err)
+	}
+	for _, route := range routes {
+		_, dst, err := net.ParseCIDR(route.Destination)
+		if err != nil {
+			return fmt.Errorf("invalid destination %q of host route: %v", route.Destination, err)
+		}
+		if (dst.IP.To4() == nil) != (ipNet.IP.To4() == nil) {
+			return fmt.Errorf("destination %q of host route is not in the same IP family with CIDR %q", route.Destination, cidr)
+		}
+		nexthop := net.ParseIP(route.Nexthop)
+		if nexthop == nil || !ipNet.Contains(nexthop) {
+			return fmt.Errorf("nexthop %q of host route is not in CIDR %q", route.Nexthop, cidr)
+		}
+	}
+
+	return nil
+}
+
+// finalizeNetwork deletes resources of the terminating network, and removes the
+// finalizer once they are all gone, so that the Network CRD object could be
+// removed. Progress is reported in status, and failures are retried by caller.
+func (c *NetworkController) finalizeNetwork(kubeNetwork *crv1.Network) error {
+	if !hasFinalizer(kubeNetwork) {
+		return nil
+	}
+
+	c.updateNetworkStatus(kubeNetwork, crv1.NetworkTerminating, reasonDeleting, "deleting network resources")
+	if err := c.deleteNetworkResources(kubeNetwork); err != nil {
+		glog.Warningf("[NetworkController]: delete resources of network %s failed: %v", kubeNetwork.Name, err)
+		c.updateNetworkStatus(kubeNetwork, crv1.NetworkTerminating, reasonDeleteFailed,
+			fmt.Sprintf("delete network resources failed, will retry: %v", err))
+		return err
+	}
+
+	removeFinalizer(kubeNetwork)
+	if err := c.kubeCRDClient.UpdateNetwork(kubeNetwork); err != nil {
+		glog.Errorf("[NetworkController]: remove finalizer of network %s failed: %v", kubeNetwork.Name, err)
+		return err
+	}
+
+	return nil
+}
+
+// deleteNetworkResources deletes the Neutron network created by stackube, and
+// kube-dns of the namespace if it is the last network there. It is idempotent,
+// resources already gone are omitted.
+func (c *NetworkController) deleteNetworkResources(kubeNetwork *crv1.Network) error {
+	remaining, err := c.hasRemainingNetworks(kubeNetwork)
+	if err != nil {
+		return fmt.Errorf("list networks in namespace %s failed: %v", kubeNetwork.Namespace, err)
+	}
+	if remaining {
+		glog.V(4).Infof("[NetworkController]: keep kube-dns in namespace %s for remaining networks", kubeNetwork.Namespace)
+	} else if err := c.deleteKubeDNS(kubeNetwork.Namespace); err != nil {
+		return err
+	}
+
+	// Delete neutron network created by stackube.
+	if kubeNetwork.Spec.NetworkID == "" {
+		networkName := util.BuildNetworkName(kubeNetwork.GetNamespace(), kubeNetwork.GetName())
+		err := c.driver.DeleteNetwork(networkName)
+		if err != nil && err != openstack.ErrNotFound {
+			return fmt.Errorf("delete network %s failed in network provider: %v", networkName, err)
+		}
+		glog.V(4).Infof("[NetworkController]: network %s deleted in network provider", networkName)
+	}
+
+	return nil
+}
+
+// hasRemainingNetworks checks whether there are other networks not being
+// deleted in the namespace of kubeNetwork.
+func (c *NetworkController) hasRemainingNetworks(kubeNetwork *crv1.Network) (bool, error) {
+	networks, err := c.kubeCRDClient.ListNetworks(kubeNetwork.Namespace)
+	if err != nil {
+		return false, err
+	}
+	for _, network := range networks.Items {
+		if network.Name != kubeNetwork.Name && network.DeletionTimestamp == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// deleteKubeDNS deletes kube-dns deployment of namespace, and its service and
+// config maps for non-system namespaces.
+func (c *NetworkController) deleteKubeDNS(namespace string) error {
+	if err := c.deleteDeployment(namespace, kubeDNSName); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("delete kube-dns deployment failed: %v", err)
+	}
+	if !util.IsSystemNamespace(namespace) {
+		err := c.k8sclient.Core().Services(namespace).Delete(kubeDNSName, metav1.NewDeleteOptions(0))
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("delete kube-dns service failed: %v", err)
+		}
+		for _, name := range []string{kubeDNSConfigMap, coreDNSConfigMap} {
+			err := c.k8sclient.Core().ConfigMaps(namespace).Delete(name, metav1.NewDeleteOptions(0))
+			if err != nil && !apierrors.IsNotFound(err) {
+				return fmt.Errorf("delete %s config map failed: %v", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// hasFinalizer checks whether Network CRD object has stackube's finalizer.
+func hasFinalizer(kubeNetwork *crv1.Network) bool {
+	for _, finalizer := range kubeNetwork.Finalizers {
+		if finalizer == crv1.NetworkFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// addFinalizer adds stackube's finalizer to Network CRD object.
+func addFinalizer(kubeNetwork *crv1.Network) {
+	if !hasFinalizer(kubeNetwork) {
+		kubeNetwork.Finalizers = append(kubeNetwork.Finalizers, crv1.NetworkFinalizer)
+	}
+}
+
+// removeFinalizer removes stackube's finalizer from Network CRD object.
+func removeFinalizer(kubeNetwork *crv1.Network) {
+	var finalizers []string
+	for _, finalizer := range kubeNetwork.Finalizers {
+		if finalizer != crv1.NetworkFinalizer {
+			finalizers = append(finalizers, finalizer)
+		}
+	}
+	kubeNetwork.Finalizers = finalizers
+}
+
+// updateNetworkStatus updates Network CRD object's state, and the NeutronReady
+// condition with reason and message.
+func (c *NetworkController) updateNetworkStatus(kubeNetwork *crv1.Network, state, reason, message string) {
+	kubeNetwork.Status.State = state
+	kubeNetwork.Status.Message = message
+	kubeNetwork.Status.ObservedGeneration = kubeNetwork.Generation
+
+	conditionStatus := crv1.ConditionFalse
+	if state == crv1.NetworkActive {
+		conditionStatus = crv1.ConditionTrue
+	}
+	setNetworkCondition(&kubeNetwork.Status, crv1.NetworkNeutronReady, conditionStatus, reason, message)
+
+	if err := c.kubeCRDClient.UpdateNetwork(kubeNetwork); err != nil {
+		glog.Errorf("Update network %s status failed: %v", kubeNetwork.Name, err)
+	}
+}
+
+// updateNetworkCondition updates a condition of Network CRD object.
+func (c *NetworkController) updateNetworkCondition(kubeNetwork *crv1.Network, conditionType crv1.NetworkConditionType,
+	status crv1.ConditionStatus, reason, message string) {
+	setNetworkCondition(&kubeNetwork.Status, conditionType, status, reason, message)
+	if err := c.kubeCRDClient.UpdateNetwork(kubeNetwork); err != nil {
+		glog.Errorf("Update network %s condition %s failed: %v", kubeNetwork.Name, conditionType, err)
+	}
+}
+
+// setNetworkCondition sets a condition of network status. LastTransitionTime
+// is only changed when status of the condition changes.
+func setNetworkCondition(networkStatus *crv1.NetworkStatus, conditionType crv1.NetworkConditionType,
+	status crv1.ConditionStatus, reason, message string) {
+	condition := crv1.NetworkCondition{
+		Type:               conditionType,
+		Status:             status,
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            message,
+	}
+
+	for i := range networkStatus.Conditions {
+		existing := &networkStatus.Conditions[i]
+		if existing.Type != conditionType {
+			continue
+		}
+		if existing.Status == status {
+			condition.LastTransitionTime = existing.LastTransitionTime
 		}
+		*existing = condition
+		return
 	}
 
-	kubeNetwork.Status.State = crv1.NetworkActive
-	c.kubeCRDClient.UpdateNetwork(kubeNetwork)
+	networkStatus.Conditions = append(networkStatus.Conditions, condition)
+}
+
+// getNetworkCondition gets a condition of network status, nil is returned if not found.
+func getNetworkCondition(networkStatus *crv1.NetworkStatus, conditionType crv1.NetworkConditionType) *crv1.NetworkCondition {
+	for i := range networkStatus.Conditions {
+		if networkStatus.Conditions[i].Type == conditionType {
+			return &networkStatus.Conditions[i]
+		}
+	}
 	return nil
 }
 
+// recordNetworkIDs records IDs of the resolved network, its subnets and router
+// in Network CRD object's status, so that users could find them in Neutron.
+func (c *NetworkController) recordNetworkIDs(kubeNetwork *crv1.Network) {
+	network, err := openstack.GetNetworkByCRD(c.driver, kubeNetwork)
+	if err != nil {
+		glog.Warningf("Get network of %s/%s failed: %v", kubeNetwork.Namespace, kubeNetwork.Name, err)
+		return
+	}
+
+	kubeNetwork.Status.NetworkID = network.Uid
+	kubeNetwork.Status.NetworkType = network.NetworkType
+	kubeNetwork.Status.PhysicalNetwork = network.PhysicalNetwork
+	kubeNetwork.Status.SegmentationID = network.SegmentID
+	kubeNetwork.Status.SubnetIDs = nil
+	for _, subnet := range network.Subnets {
+		kubeNetwork.Status.SubnetIDs = append(kubeNetwork.Status.SubnetIDs, subnet.Uid)
+	}
+
+	// The router is found by its interfaces on the network.
+	kubeNetwork.Status.RouterID = ""
+	ports, err := c.driver.ListPorts(network.Uid, "network:router_interface")
+	if err != nil {
+		glog.Warningf("Get router interfaces of network %s failed: %v", network.Name, err)
+	} else if len(ports) > 0 {
+		kubeNetwork.Status.RouterID = ports[0].DeviceID
+	}
+}
+
+// newDriverNetwork translates Kubernetes network to OpenStack network.
+func newDriverNetwork(kubeNetwork *crv1.Network, tenantID string) *drivertypes.Network {
+	networkName := util.BuildNetworkName(kubeNetwork.GetNamespace(), kubeNetwork.GetName())
+	network := &drivertypes.Network{
+		Name:        networkName,
+		TenantID:    tenantID,
+		NoRouter:    kubeNetwork.Spec.NoRouter,
+		RouterID:    kubeNetwork.Spec.RouterID,
+		DisableSNAT: kubeNetwork.Spec.DisableSNAT,
+		ExtNetID:    kubeNetwork.Spec.ExternalNetworkID,
+
+		NetworkType:     kubeNetwork.Spec.NetworkType,
+		PhysicalNetwork: kubeNetwork.Spec.PhysicalNetwork,
+		SegmentID:       kubeNetwork.Spec.SegmentationID,
+	}
+
+	// CIDR and gateway of the spec make up the default subnet.
+	if kubeNetwork.Spec.CIDR != "" {
+		network.Subnets = append(network.Subnets, &drivertypes.Subnet{
+			Name:            util.BuildSubnetName(networkName, ""),
+			Cidr:            kubeNetwork.Spec.CIDR,
+			Gateway:         kubeNetwork.Spec.Gateway,
+			Tenantid:        tenantID,
+			Dnsservers:      kubeNetwork.Spec.DNSNameservers,
+			Routes:          newDriverRoutes(kubeNetwork.Spec.HostRoutes),
+			IPVersion:       ipVersion(kubeNetwork.Spec.CIDR),
+			IPv6AddressMode: kubeNetwork.Spec.IPv6AddressMode,
+			AllocationPools: newDriverAllocationPools(kubeNetwork.Spec.AllocationPools),
+		})
+	}
+
+	for _, sub := range kubeNetwork.Spec.Subnets {
+		subnet := &drivertypes.Subnet{
+			Name:            util.BuildSubnetName(networkName, sub.Name),
+			Cidr:            sub.CIDR,
+			Gateway:         sub.Gateway,
+			Tenantid:        tenantID,
+			Dnsservers:      sub.DNSNameservers,
+			Routes:          newDriverRoutes(sub.HostRoutes),
+			IPVersion:       ipVersion(sub.CIDR),
+			IPv6AddressMode: sub.IPv6AddressMode,
+			AllocationPools: newDriverAllocationPools(sub.AllocationPools),
+		}
+		network.Subnets = append(network.Subnets, subnet)
+	}
+
+	return network
+}
+
+// newDriverAllocationPools translates allocation pools of Kubernetes network
+// to OpenStack allocation pools.
+func newDriverAllocationPools(pools []crv1.AllocationPool) []*drivertypes.AllocationPool {
+	var results []*drivertypes.AllocationPool
+	for _, pool := range pools {
+		results = append(results, &drivertypes.AllocationPool{
+			Start: pool.Start,
+			End:   pool.End,
+		})
+	}
+	return results
+}
+
+// newDriverRoutes translates host routes of Kubernetes network to OpenStack routes.
+func newDriverRoutes(hostRoutes []crv1.HostRoute) []*drivertypes.Route {
+	var routes []*drivertypes.Route
+	for _, route := range hostRoutes {
+		routes = append(routes, &drivertypes.Route{
+			DestinationCIDR: route.Destination,
+			Nexthop:         route.Nexthop,
+		})
+	}
+	return routes
+}
+
+// ipVersion returns IP version of the CIDR.
+func ipVersion(cidr string) int {
+	ip, _, err := net.ParseCIDR(cidr)
+	if err == nil && ip.To4() == nil {
+		return 6
+	}
+	return 4
+}
+
 func parseTemplate(strtmpl string, obj interface{}) ([]byte, error) {
 	var buf bytes.Buffer
 	tmpl, err := template.New("template").Parse(strtmpl)
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 
 	crv1 "git.openstack.org/openstack/stackube/pkg/apis/v1"
@@ -34,6 +35,8 @@ import (
 	kuberuntime "k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 )
 
 const (
@@ -97,15 +100,34 @@ func newNetworkController() (*NetworkController, *crdClient.FakeCRDClient, *open
 
 	client := fake.NewSimpleClientset()
 
+	dns, err := newDNSConfig(osClient.GetDNSOpts())
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
 	c := &NetworkController{
 		k8sclient:     client,
 		kubeCRDClient: kubeCRDClient,
 		driver:        osClient,
+		dns:           dns,
+		recorder:      record.NewFakeRecorder(100),
+		queue: workqueue.NewRateLimitingQueue(
+			workqueue.NewItemExponentialFailureRateLimiter(minRetryDelay, maxRetryDelay)),
+		dnsQueue: workqueue.NewRateLimitingQueue(
+			workqueue.NewItemExponentialFailureRateLimiter(minRetryDelay, maxRetryDelay)),
+		cache: newNetworkCache(),
 	}
 
 	return c, kubeCRDClient, osClient, client, nil
 }
 
+// processQueue syncs all networks in the queue of NetworkController.
+func processQueue(c *NetworkController) {
+	for c.queue.Len() > 0 {
+		c.processNextWorkItem()
+	}
+}
+
 func TestCreateKubeDNSDeployment(t *testing.T) {
 	testNamespace := "foo"
 	// Created a new fake NetworkController.
@@ -124,9 +146,10 @@ func TestCreateKubeDNSDeployment(t *testing.T) {
 		t.Fatalf("Failed get kube-dns deployment in namespace %s: %v", testNamespace, err)
 	}
 	// Generates the kube-dns deployment template
-	tempArgs := struct{ Namespace, DNSDomain, KubeDNSImage, DNSMasqImage, SidecarImage, KubernetesHost, KubernetesPort string }{
+	tempArgs := dnsTemplateArgs{
 		Namespace:    testNamespace,
 		DNSDomain:    defaultDNSDomain,
+		Replicas:     defaultDNSReplicas,
 		KubeDNSImage: defaultKubeDNSImage,
 		DNSMasqImage: defaultDNSMasqImage,
 		SidecarImage: defaultSideCarImage,
@@ -237,6 +260,7 @@ func TestOnAdd(t *testing.T) {
 				osClient.SetTenant(util.BuildNetworkName(networkName, networkName), tenantID)
 				// Add network
 				controller.onAdd(network)
+				processQueue(controller)
 
 			},
 			expectedFn: func(networkName string) error {
@@ -250,8 +274,20 @@ func TestOnAdd(t *testing.T) {
 				}
 				// test network status
 				net := kubeCRDClient.Networks[networkName]
-				if net.Status.State != crv1.NetworkActive {
-					return fmt.Errorf("expected %s network status Active,got %v", networkName, net.Status.State)
+				if net.Status.State != crv1.NetworkActive || net.Status.Message == "" {
+					return fmt.Errorf("expected %s network status Active with message,got %v", networkName, net.Status)
+				}
+				if !hasFinalizer(net) {
+					return fmt.Errorf("expected finalizer added to %s network,got %v", networkName, net.Finalizers)
+				}
+				condition := getNetworkCondition(&net.Status, crv1.NetworkNeutronReady)
+				if condition == nil || condition.Status != crv1.ConditionTrue || condition.Reason == "" {
+					return fmt.Errorf("expected %s network condition %s True,got %v", networkName, crv1.NetworkNeutronReady, condition)
+				}
+				// kube-dns replicas never become available with fake clientset.
+				condition = getNetworkCondition(&net.Status, crv1.NetworkDNSReady)
+				if condition == nil || condition.Status != crv1.ConditionFalse || condition.Reason != reasonKubeDNSNotReady {
+					return fmt.Errorf("expected %s network condition %s False,got %v", networkName, crv1.NetworkDNSReady, condition)
 				}
 
 				// test kube-dns deployment created
@@ -290,6 +326,7 @@ func TestOnAdd(t *testing.T) {
 				osClient.SetNetwork(net)
 				// Add network
 				controller.onAdd(network)
+				processQueue(controller)
 
 			},
 			expectedFn: func(networkName string) error {
@@ -335,6 +372,7 @@ func TestOnAdd(t *testing.T) {
 				osClient.SetNetwork(net)
 				// Add network
 				controller.onAdd(network)
+				processQueue(controller)
 
 			},
 			expectedFn: func(networkName string) error {
@@ -343,6 +381,9 @@ func TestOnAdd(t *testing.T) {
 				if net.Status.State != crv1.NetworkActive {
 					return fmt.Errorf("expected %s network status Active,got %v", networkName, net.Status.State)
 				}
+				if net.Status.NetworkID != networkID {
+					return fmt.Errorf("expected %s network status networkID %s,got %v", networkName, networkID, net.Status.NetworkID)
+				}
 
 				// test kube-dns deployment created
 				err = testKubeDNSDeploymentCreated(t, client, networkName)
@@ -375,6 +416,7 @@ func TestOnAdd(t *testing.T) {
 				kubeCRDClient.SetNetworks(network)
 				// Add network
 				controller.onAdd(network)
+				processQueue(controller)
 
 			},
 			expectedFn: func(networkName string) error {
@@ -417,6 +459,7 @@ func TestOnAdd(t *testing.T) {
 				osClient.SetTenant(util.BuildNetworkName(networkName, networkName), tenantID)
 				// Add network
 				controller.onAdd(network)
+				processQueue(controller)
 
 			},
 			expectedFn: func(networkName string) error {
@@ -461,6 +504,7 @@ func TestOnAdd(t *testing.T) {
 				osClient.InjectError("CreateNetwork", fmt.Errorf("Failed create network"))
 				// Add network
 				controller.onAdd(network)
+				processQueue(controller)
 
 			},
 			expectedFn: func(networkName string) error {
@@ -505,6 +549,7 @@ func TestOnAdd(t *testing.T) {
 				osClient.InjectError("GetNetworkByName", fmt.Errorf("Failed get network by name"))
 				// Add network
 				controller.onAdd(network)
+				processQueue(controller)
 
 			},
 			expectedFn: func(networkName string) error {
@@ -527,6 +572,536 @@ func TestOnAdd(t *testing.T) {
 				return nil
 			},
 		},
+		{
+			testName:    "Add foo8 Network,status active,isolated network without router",
+			networkName: "foo8",
+			updateFn: func(networkName string) {
+
+				// Created a new fake NetworkController
+				controller, kubeCRDClient, osClient, client, err = newNetworkController()
+				if err != nil {
+					t.Fatalf("Failed start a new fake NetworkController")
+				}
+				// CRD injects fake tenant
+				tenant := newTenant(networkName, tenantID)
+				kubeCRDClient.SetTenants(tenant)
+				// CRD injects fake network without router
+				network := newNetwork(networkName, "")
+				network.Spec.NoRouter = true
+				kubeCRDClient.SetNetworks(network)
+				// openstack injects fake tenant
+				osClient.SetTenant(util.BuildNetworkName(networkName, networkName), tenantID)
+				// Add network
+				controller.onAdd(network)
+				processQueue(controller)
+
+			},
+			expectedFn: func(networkName string) error {
+				// test network created without router
+				if _, ok := osClient.Networks[util.BuildNetworkName(networkName, networkName)]; !ok {
+					return fmt.Errorf("expected %s network to be created, got none", networkName)
+				}
+				if router, ok := osClient.Routers[util.BuildNetworkName(networkName, networkName)]; ok {
+					return fmt.Errorf("expected no router to be created, got %v", router)
+				}
+				// test network status
+				net := kubeCRDClient.Networks[networkName]
+				if net.Status.State != crv1.NetworkActive {
+					return fmt.Errorf("expected %s network status Active,got %v", networkName, net.Status.State)
+				}
+				return nil
+			},
+		},
+		{
+			testName:    "Add foo9 Network,status failed,conflict router options",
+			networkName: "foo9",
+			updateFn: func(networkName string) {
+
+				// Created a new fake NetworkController
+				controller, kubeCRDClient, osClient, client, err = newNetworkController()
+				if err != nil {
+					t.Fatalf("Failed start a new fake NetworkController")
+				}
+				// CRD injects fake tenant
+				tenant := newTenant(networkName, tenantID)
+				kubeCRDClient.SetTenants(tenant)
+				// CRD injects fake network with an existing router and SNAT disabled
+				network := newNetwork(networkName, "")
+				network.Spec.RouterID = "789"
+				network.Spec.DisableSNAT = true
+				kubeCRDClient.SetNetworks(network)
+				// openstack injects fake tenant
+				osClient.SetTenant(util.BuildNetworkName(networkName, networkName), tenantID)
+				// Add network
+				controller.onAdd(network)
+				processQueue(controller)
+
+			},
+			expectedFn: func(networkName string) error {
+				// test network not created
+				if network, ok := osClient.Networks[util.BuildNetworkName(networkName, networkName)]; ok {
+					return fmt.Errorf("expected %s network not to be created, got %v", networkName, network)
+				}
+				// test network status
+				net := kubeCRDClient.Networks[networkName]
+				if net.Status.State != crv1.NetworkFailed {
+					return fmt.Errorf("expected %s network status Failed,got %v", networkName, net.Status.State)
+				}
+				// invalid spec is not retried
+				if requeues := controller.queue.NumRequeues(networkName + "/" + networkName); requeues != 0 {
+					return fmt.Errorf("expected invalid %s network not be requeued, got %d requeues", networkName, requeues)
+				}
+				return nil
+			},
+		},
+		{
+			testName:    "Add foo10 Network,status active,VLAN provider network",
+			networkName: "foo10",
+			updateFn: func(networkName string) {
+
+				// Created a new fake NetworkController
+				controller, kubeCRDClient, osClient, client, err = newNetworkController()
+				if err != nil {
+					t.Fatalf("Failed start a new fake NetworkController")
+				}
+				// CRD injects fake tenant
+				tenant := newTenant(networkName, tenantID)
+				kubeCRDClient.SetTenants(tenant)
+				// CRD injects fake VLAN network
+				network := newNetwork(networkName, "")
+				network.Spec.NetworkType = "vlan"
+				network.Spec.PhysicalNetwork = "physnet1"
+				network.Spec.SegmentationID = 100
+				kubeCRDClient.SetNetworks(network)
+				// openstack injects fake tenant
+				osClient.SetTenant(util.BuildNetworkName(networkName, networkName), tenantID)
+				// Add network
+				controller.onAdd(network)
+				processQueue(controller)
+
+			},
+			expectedFn: func(networkName string) error {
+				// test network status with the effective segment
+				net := kubeCRDClient.Networks[networkName]
+				if net.Status.State != crv1.NetworkActive {
+					return fmt.Errorf("expected %s network status Active,got %v", networkName, net.Status.State)
+				}
+				if net.Status.NetworkType != "vlan" || net.Status.PhysicalNetwork != "physnet1" || net.Status.SegmentationID != 100 {
+					return fmt.Errorf("expected %s network segment vlan/physnet1/100,got %v", networkName, net.Status)
+				}
+				return nil
+			},
+		},
+	}
+
+	for tci, tc := range testCases {
+		tc.updateFn(tc.networkName)
+		err := tc.expectedFn(tc.networkName)
+		if err != nil {
+			t.Errorf("Case[%d]: %s %v", tci, tc.testName, err)
+		}
+	}
+}
+
+func TestSetNetworkCondition(t *testing.T) {
+	status := &crv1.NetworkStatus{}
+
+	setNetworkCondition(status, crv1.NetworkNeutronReady, crv1.ConditionFalse, reasonCreateFailed, "failed")
+	condition := getNetworkCondition(status, crv1.NetworkNeutronReady)
+	if condition == nil || condition.Status != crv1.ConditionFalse || condition.Reason != reasonCreateFailed {
+		t.Fatalf("expected condition NeutronReady False, got %v", condition)
+	}
+	lastTransitionTime := condition.LastTransitionTime
+
+	// LastTransitionTime is kept if status is not changed.
+	setNetworkCondition(status, crv1.NetworkNeutronReady, crv1.ConditionFalse, reasonUpdateFailed, "failed again")
+	condition = getNetworkCondition(status, crv1.NetworkNeutronReady)
+	if len(status.Conditions) != 1 || condition.Reason != reasonUpdateFailed || condition.LastTransitionTime != lastTransitionTime {
+		t.Errorf("expected condition NeutronReady updated without transition, got %v", status.Conditions)
+	}
+
+	setNetworkCondition(status, crv1.NetworkDNSReady, crv1.ConditionTrue, reasonKubeDNSReady, "")
+	if len(status.Conditions) != 2 {
+		t.Errorf("expected 2 conditions, got %v", status.Conditions)
+	}
+}
+
+func TestOnUpdate(t *testing.T) {
+	var controller *NetworkController
+	var kubeCRDClient *crdClient.FakeCRDClient
+	var osClient *openstack.FakeOSClient
+
+	// prepare creates a new fake NetworkController with an active network.
+	prepare := func(networkName string) *crv1.Network {
+		var err error
+		controller, kubeCRDClient, osClient, _, err = newNetworkController()
+		if err != nil {
+			t.Fatalf("Failed start a new fake NetworkController")
+		}
+		// CRD injects fake tenant
+		kubeCRDClient.SetTenants(newTenant(networkName, tenantID))
+		// CRD injects fake network
+		network := newNetwork(networkName, "")
+		network.Status.State = crv1.NetworkActive
+		network.Status.AppliedSpec = network.Spec.DeepCopy()
+		kubeCRDClient.SetNetworks(network)
+		// openstack injects fake network
+		net := osNetwork(util.BuildNetworkName(networkName, networkName), tenantID, networkID)
+		net.Subnets = newDriverNetwork(network, tenantID).Subnets
+		osClient.SetNetwork(net)
+		// network has been synced by controller
+		controller.cache.set(networkName+"/"+networkName, network)
+		return network
+	}
+
+	// update notifies controller the network update, and syncs it.
+	update := func(oldNetwork, newNetwork *crv1.Network) {
+		// Spec updates keep the status.
+		newNetwork.Status.AppliedSpec = oldNetwork.Status.AppliedSpec
+		kubeCRDClient.SetNetworks(newNetwork)
+		controller.onUpdate(oldNetwork, newNetwork)
+		processQueue(controller)
+	}
+
+	testCases := []struct {
+		testName    string
+		networkName string
+		updateFn    func(networkName string)
+		expectedFn  func(networkName string) error
+	}{
+		{
+			testName:    "Update foo1 Network gateway,status active",
+			networkName: "foo1",
+			updateFn: func(networkName string) {
+				oldNetwork := prepare(networkName)
+				newNetwork := newNetwork(networkName, "")
+				newNetwork.Spec.Gateway = "10.244.0.254"
+				update(oldNetwork, newNetwork)
+			},
+			expectedFn: func(networkName string) error {
+				network := osClient.Networks[util.BuildNetworkName(networkName, networkName)]
+				if network.Subnets[0].Gateway != "10.244.0.254" {
+					return fmt.Errorf("expected gateway of %s network to be updated, got %v", networkName, network.Subnets[0].Gateway)
+				}
+				net := kubeCRDClient.Networks[networkName]
+				if net.Status.State != crv1.NetworkActive {
+					return fmt.Errorf("expected %s network status Active,got %v", networkName, net.Status.State)
+				}
+				return nil
+			},
+		},
+		{
+			testName:    "Update foo2 Network CIDR,status failed,subnet still has ports",
+			networkName: "foo2",
+			updateFn: func(networkName string) {
+				oldNetwork := prepare(networkName)
+				// openstack injects fake port
+				osClient.SetPort(networkID, "compute:host1", "pod1")
+				newNetwork := newNetwork(networkName, "")
+				newNetwork.Spec.CIDR = "10.244.0.0/24"
+				update(oldNetwork, newNetwork)
+			},
+			expectedFn: func(networkName string) error {
+				network := osClient.Networks[util.BuildNetworkName(networkName, networkName)]
+				if network.Subnets[0].Cidr != userCIDR {
+					return fmt.Errorf("expected CIDR of %s network not be updated, got %v", networkName, network.Subnets[0].Cidr)
+				}
+				net := kubeCRDClient.Networks[networkName]
+				if net.Status.State != crv1.NetworkFailed || net.Status.Message == "" {
+					return fmt.Errorf("expected %s network status Failed with message,got %v", networkName, net.Status)
+				}
+				return nil
+			},
+		},
+		{
+			testName:    "Update foo3 Network networkID,status failed",
+			networkName: "foo3",
+			updateFn: func(networkName string) {
+				oldNetwork := prepare(networkName)
+				newNetwork := newNetwork(networkName, networkID)
+				update(oldNetwork, newNetwork)
+			},
+			expectedFn: func(networkName string) error {
+				for _, name := range osClient.GetCalledNames() {
+					if name == "UpdateNetwork" {
+						return fmt.Errorf("expected %s network not be updated in openstack", networkName)
+					}
+				}
+				net := kubeCRDClient.Networks[networkName]
+				if net.Status.State != crv1.NetworkFailed {
+					return fmt.Errorf("expected %s network status Failed,got %v", networkName, net.Status.State)
+				}
+				return nil
+			},
+		},
+		{
+			testName:    "Update foo5 Network with a new subnet,status active",
+			networkName: "foo5",
+			updateFn: func(networkName string) {
+				oldNetwork := prepare(networkName)
+				newNetwork := newNetwork(networkName, "")
+				newNetwork.Spec.Subnets = []crv1.SubnetSpec{
+					{
+						Name:    "db",
+						CIDR:    "10.245.0.0/24",
+						Gateway: "10.245.0.1",
+						AllocationPools: []crv1.AllocationPool{
+							{Start: "10.245.0.10", End: "10.245.0.100"},
+						},
+						DNSNameservers: []string{"8.8.8.8"},
+					},
+				}
+				update(oldNetwork, newNetwork)
+			},
+			expectedFn: func(networkName string) error {
+				networkName = util.BuildNetworkName(networkName, networkName)
+				network := osClient.Networks[networkName]
+				if len(network.Subnets) != 2 {
+					return fmt.Errorf("expected 2 subnets of %s network, got %v", networkName, len(network.Subnets))
+				}
+				subnet := network.Subnets[1]
+				if subnet.Name != util.BuildSubnetName(networkName, "db") || subnet.Cidr != "10.245.0.0/24" ||
+					len(subnet.AllocationPools) != 1 || len(subnet.Dnsservers) != 1 {
+					return fmt.Errorf("the created subnet of %s network has incorrect parameters: %v", networkName, subnet)
+				}
+				return nil
+			},
+		},
+		{
+			testName:    "Update foo6 Network with an invalid subnet,status failed",
+			networkName: "foo6",
+			updateFn: func(networkName string) {
+				oldNetwork := prepare(networkName)
+				newNetwork := newNetwork(networkName, "")
+				newNetwork.Spec.Subnets = []crv1.SubnetSpec{
+					{
+						Name:    "db",
+						CIDR:    "10.245.0.0/24",
+						Gateway: "10.246.0.1",
+					},
+				}
+				update(oldNetwork, newNetwork)
+			},
+			expectedFn: func(networkName string) error {
+				network := osClient.Networks[util.BuildNetworkName(networkName, networkName)]
+				if len(network.Subnets) != 1 {
+					return fmt.Errorf("expected subnets of %s network not be updated, got %v", networkName, len(network.Subnets))
+				}
+				net := kubeCRDClient.Networks[networkName]
+				if net.Status.State != crv1.NetworkFailed {
+					return fmt.Errorf("expected %s network status Failed,got %v", networkName, net.Status.State)
+				}
+				if requeues := controller.queue.NumRequeues(networkName + "/" + networkName); requeues != 0 {
+					return fmt.Errorf("expected invalid %s network not be requeued, got %d requeues", networkName, requeues)
+				}
+				return nil
+			},
+		},
+		{
+			testName:    "Update foo7 Network with host routes and DNS name servers,status active",
+			networkName: "foo7",
+			updateFn: func(networkName string) {
+				oldNetwork := prepare(networkName)
+				newNetwork := newNetwork(networkName, "")
+				newNetwork.Spec.DNSNameservers = []string{"8.8.8.8"}
+				newNetwork.Spec.HostRoutes = []crv1.HostRoute{
+					{Destination: "192.168.0.0/16", Nexthop: "10.244.0.2"},
+				}
+				update(oldNetwork, newNetwork)
+			},
+			expectedFn: func(networkName string) error {
+				network := osClient.Networks[util.BuildNetworkName(networkName, networkName)]
+				subnet := network.Subnets[0]
+				if len(subnet.Dnsservers) != 1 || len(subnet.Routes) != 1 ||
+					subnet.Routes[0].DestinationCIDR != "192.168.0.0/16" || subnet.Routes[0].Nexthop != "10.244.0.2" {
+					return fmt.Errorf("expected subnet of %s network to be updated, got %v", networkName, subnet)
+				}
+				return nil
+			},
+		},
+		{
+			testName:    "Update foo8 Network with nexthop out of CIDR,status failed",
+			networkName: "foo8",
+			updateFn: func(networkName string) {
+				oldNetwork := prepare(networkName)
+				newNetwork := newNetwork(networkName, "")
+				newNetwork.Spec.HostRoutes = []crv1.HostRoute{
+					{Destination: "192.168.0.0/16", Nexthop: "10.245.0.2"},
+				}
+				update(oldNetwork, newNetwork)
+			},
+			expectedFn: func(networkName string) error {
+				net := kubeCRDClient.Networks[networkName]
+				if net.Status.State != crv1.NetworkFailed {
+					return fmt.Errorf("expected %s network status Failed,got %v", networkName, net.Status.State)
+				}
+				return nil
+			},
+		},
+		{
+			testName:    "Update foo9 Network terminating,resources deleted and finalizer removed",
+			networkName: "foo9",
+			updateFn: func(networkName string) {
+				oldNetwork := prepare(networkName)
+				oldNetwork.Finalizers = []string{crv1.NetworkFinalizer}
+				newNetwork := newNetwork(networkName, "")
+				newNetwork.Finalizers = []string{crv1.NetworkFinalizer}
+				now := apismetav1.Now()
+				newNetwork.DeletionTimestamp = &now
+				update(oldNetwork, newNetwork)
+			},
+			expectedFn: func(networkName string) error {
+				if _, ok := osClient.Networks[util.BuildNetworkName(networkName, networkName)]; ok {
+					return fmt.Errorf("expected %s network to be deleted, got not deleted", networkName)
+				}
+				net := kubeCRDClient.Networks[networkName]
+				if hasFinalizer(net) {
+					return fmt.Errorf("expected finalizer of %s network to be removed, got %v", networkName, net.Finalizers)
+				}
+				return nil
+			},
+		},
+		{
+			testName:    "Update foo10 Network terminating,delete network failed and finalizer kept",
+			networkName: "foo10",
+			updateFn: func(networkName string) {
+				oldNetwork := prepare(networkName)
+				oldNetwork.Finalizers = []string{crv1.NetworkFinalizer}
+				newNetwork := newNetwork(networkName, "")
+				newNetwork.Finalizers = []string{crv1.NetworkFinalizer}
+				now := apismetav1.Now()
+				newNetwork.DeletionTimestamp = &now
+				osClient.InjectError("DeleteNetwork", fmt.Errorf("delete network failed"))
+				update(oldNetwork, newNetwork)
+			},
+			expectedFn: func(networkName string) error {
+				net := kubeCRDClient.Networks[networkName]
+				if !hasFinalizer(net) {
+					return fmt.Errorf("expected finalizer of %s network to be kept, got removed", networkName)
+				}
+				if net.Status.State != crv1.NetworkTerminating || net.Status.Message == "" {
+					return fmt.Errorf("expected %s network status Terminating with message,got %v", networkName, net.Status)
+				}
+				return nil
+			},
+		},
+		{
+			testName:    "Resync foo11 Network,drift repaired and recorded",
+			networkName: "foo11",
+			updateFn: func(networkName string) {
+				network := prepare(networkName)
+				osClient.SetDrifts(util.BuildNetworkName(networkName, networkName), []*drivertypes.Drift{
+					{Resource: drivertypes.DriftResourceRouter, Message: "router not found", Repaired: true},
+				})
+				update(network, network)
+			},
+			expectedFn: func(networkName string) error {
+				net := kubeCRDClient.Networks[networkName]
+				if net.Status.State != crv1.NetworkActive {
+					return fmt.Errorf("expected %s network status Active,got %v", networkName, net.Status.State)
+				}
+				return testNetworkEventRecorded(controller, networkName, apiv1.EventTypeNormal, eventReasonDriftRepaired)
+			},
+		},
+		{
+			testName:    "Resync foo12 Network,drift not repaired and status failed",
+			networkName: "foo12",
+			updateFn: func(networkName string) {
+				network := prepare(networkName)
+				osClient.SetDrifts(util.BuildNetworkName(networkName, networkName), []*drivertypes.Drift{
+					{Resource: drivertypes.DriftResourceSubnet, Message: "CIDR of subnet foo12 is 10.0.0.0/24 instead of 10.244.0.0/16"},
+				})
+				update(network, network)
+			},
+			expectedFn: func(networkName string) error {
+				net := kubeCRDClient.Networks[networkName]
+				if net.Status.State != crv1.NetworkFailed || !strings.Contains(net.Status.Message, "10.0.0.0/24") {
+					return fmt.Errorf("expected %s network status Failed with drift message,got %v", networkName, net.Status)
+				}
+				return testNetworkEventRecorded(controller, networkName, apiv1.EventTypeWarning, eventReasonDrift)
+			},
+		},
+		{
+			testName:    "Update foo13 Network gateway while controller is down,status active",
+			networkName: "foo13",
+			updateFn: func(networkName string) {
+				oldNetwork := prepare(networkName)
+				// Controller is restarted, the network is added after spec is changed.
+				controller.cache = newNetworkCache()
+				newNetwork := newNetwork(networkName, "")
+				newNetwork.Spec.Gateway = "10.244.0.254"
+				newNetwork.Status = *oldNetwork.Status.DeepCopy()
+				kubeCRDClient.SetNetworks(newNetwork)
+				controller.onAdd(newNetwork)
+				processQueue(controller)
+			},
+			expectedFn: func(networkName string) error {
+				network := osClient.Networks[util.BuildNetworkName(networkName, networkName)]
+				if network.Subnets[0].Gateway != "10.244.0.254" {
+					return fmt.Errorf("expected gateway of %s network to be updated, got %v", networkName, network.Subnets[0].Gateway)
+				}
+				net := kubeCRDClient.Networks[networkName]
+				if net.Status.State != crv1.NetworkActive || net.Status.AppliedSpec == nil ||
+					net.Status.AppliedSpec.Gateway != "10.244.0.254" {
+					return fmt.Errorf("expected %s network status Active with applied spec,got %v", networkName, net.Status)
+				}
+				return nil
+			},
+		},
+		{
+			testName:    "Resync foo14 Network twice,drift recorded on each resync",
+			networkName: "foo14",
+			updateFn: func(networkName string) {
+				network := prepare(networkName)
+				osClient.SetDrifts(util.BuildNetworkName(networkName, networkName), []*drivertypes.Drift{
+					{Resource: drivertypes.DriftResourceSubnet, Message: "subnet foo14 not found"},
+				})
+				update(network, network)
+				update(network, network)
+			},
+			expectedFn: func(networkName string) error {
+				// Events of the same drift are aggregated by the event broadcaster.
+				if events := recordedEvents(controller); len(events) != 2 {
+					return fmt.Errorf("expected 2 drift events of %s network, got %v", networkName, events)
+				}
+				return nil
+			},
+		},
+		{
+			testName:    "Add foo15 Network after controller restarted,drift reconciled",
+			networkName: "foo15",
+			updateFn: func(networkName string) {
+				network := prepare(networkName)
+				// Controller is restarted, synced networks are added again.
+				controller.cache = newNetworkCache()
+				osClient.SetDrifts(util.BuildNetworkName(networkName, networkName), []*drivertypes.Drift{
+					{Resource: drivertypes.DriftResourceRouter, Message: "router not found", Repaired: true},
+				})
+				controller.onAdd(network)
+				processQueue(controller)
+			},
+			expectedFn: func(networkName string) error {
+				return testNetworkEventRecorded(controller, networkName, apiv1.EventTypeNormal, eventReasonDriftRepaired)
+			},
+		},
+		{
+			testName:    "Update foo4 Network status only,nothing changed",
+			networkName: "foo4",
+			updateFn: func(networkName string) {
+				oldNetwork := prepare(networkName)
+				newNetwork := newNetwork(networkName, "")
+				newNetwork.Status.State = crv1.NetworkFailed
+				update(oldNetwork, newNetwork)
+			},
+			expectedFn: func(networkName string) error {
+				for _, name := range osClient.GetCalledNames() {
+					if name == "UpdateNetwork" {
+						return fmt.Errorf("expected %s network not be updated in openstack", networkName)
+					}
+				}
+				return nil
+			},
+		},
 	}
 
 	for tci, tc := range testCases {
@@ -572,6 +1147,7 @@ func TestOnDelete(t *testing.T) {
 				network := newNetwork(networkName, "")
 				// Delete network
 				controller.onDelete(network)
+				processQueue(controller)
 
 			},
 			expectedFn: func(networkName string) error {
@@ -617,6 +1193,7 @@ func TestOnDelete(t *testing.T) {
 				network := newNetwork(networkName, "")
 				// Delete network
 				controller.onDelete(network)
+				processQueue(controller)
 
 			},
 			expectedFn: func(networkName string) error {
@@ -660,6 +1237,7 @@ func TestOnDelete(t *testing.T) {
 				network := newNetwork(networkName, networkID)
 				// Delete network
 				controller.onDelete(network)
+				processQueue(controller)
 
 			},
 			expectedFn: func(networkName string) error {
@@ -682,6 +1260,52 @@ func TestOnDelete(t *testing.T) {
 				return nil
 			},
 		},
+		{
+			testName:    "Delete foo4 Network with another network left in namespace,kube-dns kept",
+			networkName: "foo4",
+			updateFn: func(networkName string) {
+
+				// Created a new fake NetworkController
+				controller, kubeCRDClient, osClient, client, err = newNetworkController()
+				if err != nil {
+					t.Fatalf("Failed start a new fake NetworkController")
+				}
+				// Create kube-dns deployment
+				controller.createKubeDNSDeployment(networkName)
+				// Create kube-dns svc
+				controller.createKubeDNSService(networkName)
+				// openstack injects fake network
+				net := osNetwork(util.BuildNetworkName(networkName, networkName), "", "")
+				osClient.SetNetwork(net)
+				// Another network is left in the namespace
+				other := newNetwork("bar", "")
+				other.Namespace = networkName
+				kubeCRDClient.SetNetworks(other)
+
+				network := newNetwork(networkName, "")
+				// Delete network
+				controller.onDelete(network)
+				processQueue(controller)
+
+			},
+			expectedFn: func(networkName string) error {
+				// test network deleted
+				network, ok := osClient.Networks[util.BuildNetworkName(networkName, networkName)]
+				if ok {
+					return fmt.Errorf("expected %s network to be deleted, got %v", networkName, network)
+				}
+
+				// test kube-dns deployment kept
+				if _, err := client.ExtensionsV1beta1().Deployments(networkName).Get(kubeDNSName, apismetav1.GetOptions{}); err != nil {
+					return fmt.Errorf("expected kube-dns deployment to be kept, got %v", err)
+				}
+				// test kube-dns service kept
+				if _, err := client.Core().Services(networkName).Get(kubeDNSName, apismetav1.GetOptions{}); err != nil {
+					return fmt.Errorf("expected kube-dns service to be kept, got %v", err)
+				}
+				return nil
+			},
+		},
 	}
 
 	for tci, tc := range testCases {
@@ -693,15 +1317,40 @@ func TestOnDelete(t *testing.T) {
 	}
 }
 
+// recordedEvents drains events recorded by the fake recorder of NetworkController.
+func recordedEvents(c *NetworkController) []string {
+	var events []string
+	recorder := c.recorder.(*record.FakeRecorder)
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func testNetworkEventRecorded(c *NetworkController, networkName, eventType, reason string) error {
+	events := recordedEvents(c)
+	for _, event := range events {
+		if strings.HasPrefix(event, eventType+" "+reason+" ") {
+			return nil
+		}
+	}
+	return fmt.Errorf("expected %s event %s of %s network, got %v", eventType, reason, networkName, events)
+}
+
 func testKubeDNSDeploymentCreated(t *testing.T, client *fake.Clientset, namespace string) error {
 	kubeDNSDeploy, err := client.ExtensionsV1beta1().Deployments(namespace).Get("kube-dns", apismetav1.GetOptions{})
 	if err != nil {
 		t.Fatalf("Failed get kube-dns deployment in namespace %s: %v", namespace, err)
 	}
 	// Generates the kube-dns deployment template
-	tempArgs := struct{ Namespace, DNSDomain, KubeDNSImage, DNSMasqImage, SidecarImage, KubernetesHost, KubernetesPort string }{
+	tempArgs := dnsTemplateArgs{
 		Namespace:    namespace,
 		DNSDomain:    defaultDNSDomain,
+		Replicas:     defaultDNSReplicas,
 		KubeDNSImage: defaultKubeDNSImage,
 		DNSMasqImage: defaultDNSMasqImage,
 		SidecarImage: defaultSideCarImage,
@@ -761,3 +1410,139 @@ func testKubeDNSServiceDeletedOrNoCreated(t *testing.T, client *fake.Clientset,
 	}
 	return nil
 }
+
+func TestValidateNetworkProvider(t *testing.T) {
+	testCases := []struct {
+		testName        string
+		networkType     string
+		physicalNetwork string
+		segmentationID  int32
+		expectedErr     bool
+	}{
+		{testName: "tenant network"},
+		{testName: "segment without type", physicalNetwork: "physnet1", expectedErr: true},
+		{testName: "flat network", networkType: "flat", physicalNetwork: "physnet1"},
+		{testName: "flat network with VLAN ID", networkType: "flat", physicalNetwork: "physnet1", segmentationID: 100, expectedErr: true},
+		{testName: "VLAN network", networkType: "vlan", physicalNetwork: "physnet1", segmentationID: 100},
+		{testName: "VLAN network allocated by Neutron", networkType: "vlan", physicalNetwork: "physnet1"},
+		{testName: "VLAN network without physical network", networkType: "vlan", segmentationID: 100, expectedErr: true},
+		{testName: "VLAN ID out of range", networkType: "vlan", physicalNetwork: "physnet1", segmentationID: 4095, expectedErr: true},
+		{testName: "VXLAN network", networkType: "vxlan", segmentationID: 10000},
+		{testName: "VXLAN network with physical network", networkType: "vxlan", physicalNetwork: "physnet1", expectedErr: true},
+		{testName: "unsupported type", networkType: "gre", expectedErr: true},
+	}
+
+	for tci, tc := range testCases {
+		err := validateNetworkProvider(tc.networkType, tc.physicalNetwork, tc.segmentationID)
+		if tc.expectedErr != (err != nil) {
+			t.Errorf("Case[%d]: %s expected error %v, got %v", tci, tc.testName, tc.expectedErr, err)
+		}
+	}
+}
+
+func TestValidateIPRanges(t *testing.T) {
+	testCases := []struct {
+		testName        string
+		networkID       string
+		cidr            string
+		allocationPools []crv1.AllocationPool
+		subnets         []crv1.SubnetSpec
+		ipRanges        []crv1.IPRange
+		expectedErr     bool
+	}{
+		{
+			testName:        "range in network CIDR",
+			cidr:            "10.244.0.0/16",
+			allocationPools: []crv1.AllocationPool{{Start: "10.244.0.2", End: "10.244.199.255"}},
+			ipRanges:        []crv1.IPRange{{Name: "legacy", Start: "10.244.200.1", End: "10.244.200.100"}},
+		},
+		{
+			testName: "range in subnet CIDR",
+			cidr:     "10.244.0.0/16",
+			subnets: []crv1.SubnetSpec{{
+				Name:            "db",
+				CIDR:            "10.245.0.0/24",
+				AllocationPools: []crv1.AllocationPool{{Start: "10.245.0.2", End: "10.245.0.199"}},
+			}},
+			ipRanges: []crv1.IPRange{{Name: "legacy", Start: "10.245.0.200", End: "10.245.0.250"}},
+		},
+		{
+			testName:    "range in network CIDR without allocation pools",
+			cidr:        "10.244.0.0/16",
+			ipRanges:    []crv1.IPRange{{Name: "legacy", Start: "10.244.200.1", End: "10.244.200.100"}},
+			expectedErr: true,
+		},
+		{
+			testName: "range overlaps allocation pool of subnet",
+			cidr:     "10.244.0.0/16",
+			subnets: []crv1.SubnetSpec{{
+				Name:            "db",
+				CIDR:            "10.245.0.0/24",
+				AllocationPools: []crv1.AllocationPool{{Start: "10.245.0.2", End: "10.245.0.199"}},
+			}},
+			ipRanges:    []crv1.IPRange{{Name: "legacy", Start: "10.245.0.150", End: "10.245.0.250"}},
+			expectedErr: true,
+		},
+		{
+			testName:  "range of network not managed by stackube",
+			networkID: "456",
+			ipRanges:  []crv1.IPRange{{Name: "legacy", Start: "192.168.0.10", End: "192.168.0.20"}},
+		},
+		{
+			testName:    "range across CIDRs",
+			cidr:        "10.244.0.0/16",
+			subnets:     []crv1.SubnetSpec{{Name: "db", CIDR: "10.245.0.0/24"}},
+			ipRanges:    []crv1.IPRange{{Name: "legacy", Start: "10.244.255.200", End: "10.245.0.10"}},
+			expectedErr: true,
+		},
+		{
+			testName:    "range without name",
+			cidr:        "10.244.0.0/16",
+			ipRanges:    []crv1.IPRange{{Start: "10.244.200.1", End: "10.244.200.100"}},
+			expectedErr: true,
+		},
+		{
+			testName: "duplicate range name",
+			cidr:     "10.244.0.0/16",
+			ipRanges: []crv1.IPRange{
+				{Name: "legacy", Start: "10.244.200.1", End: "10.244.200.100"},
+				{Name: "legacy", Start: "10.244.201.1", End: "10.244.201.100"},
+			},
+			expectedErr: true,
+		},
+		{
+			testName:    "reversed range",
+			cidr:        "10.244.0.0/16",
+			ipRanges:    []crv1.IPRange{{Name: "legacy", Start: "10.244.200.100", End: "10.244.200.1"}},
+			expectedErr: true,
+		},
+		{
+			testName:    "invalid IP",
+			cidr:        "10.244.0.0/16",
+			ipRanges:    []crv1.IPRange{{Name: "legacy", Start: "10.244.200.1", End: "foo"}},
+			expectedErr: true,
+		},
+	}
+
+	for tci, tc := range testCases {
+		spec := &crv1.NetworkSpec{
+			NetworkID:       tc.networkID,
+			CIDR:            tc.cidr,
+			AllocationPools: tc.allocationPools,
+			Subnets:         tc.subnets,
+			IPRanges:        tc.ipRanges,
+		}
+		err := validateNetworkSpec(spec)
+		if tc.expectedErr != (err != nil) {
+			t.Errorf("Case[%d]: %s expected error %v, got %v", tci, tc.testName, tc.expectedErr, err)
+		}
+
+		// IP ranges of networks not managed by stackube could also be updated.
+		if tc.networkID != "" && !tc.expectedErr {
+			oldNetwork := &crv1.Network{Spec: crv1.NetworkSpec{NetworkID: tc.networkID}}
+			if err := validateNetworkUpdate(oldNetwork, &crv1.Network{Spec: *spec}); err != nil {
+				t.Errorf("Case[%d]: %s expected IP ranges could be updated, got %v", tci, tc.testName, err)
+			}
+		}
+	}
+}
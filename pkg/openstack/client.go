@@ -19,6 +19,7 @@ package openstack
 import (
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 
 	crv1 "git.openstack.org/openstack/stackube/pkg/apis/v1"
@@ -34,14 +35,14 @@ import (
 	"github.com/gophercloud/gophercloud/openstack/identity/v2/users"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/routers"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/portsbinding"
-	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/security/groups"
-	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/security/rules"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/networks"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/subnets"
 	"github.com/gophercloud/gophercloud/pagination"
 
 	gcfg "gopkg.in/gcfg.v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 )
 
 const (
@@ -54,13 +55,22 @@ const (
 	// Service affinities
 	ServiceAffinityNone     = "None"
 	ServiceAffinityClientIP = "ClientIP"
+
+	// RetainedPortDeviceOwner is the device owner of sticky ports which are
+	// unbound from their hosts and kept for the next run of their pods.
+	RetainedPortDeviceOwner = "stackube:retained"
 )
 
 var (
 	adminStateUp = true
 
-	ErrNotFound        = errors.New("NotFound")
-	ErrMultipleResults = errors.New("MultipleResults")
+	// ErrNotFound and ErrMultipleResults are the same errors as util ones,
+	// so that they could be compared directly by callers of either package.
+	ErrNotFound        = util.ErrNotFound
+	ErrMultipleResults = util.ErrMultipleResults
+	// ErrIPAddressInUse is returned by CreatePort if the requested IP address
+	// is already allocated to another port.
+	ErrIPAddressInUse = errors.New("IPAddressInUse")
 )
 
 // Interface should be implemented by a openstack client.
@@ -77,28 +87,72 @@ type Interface interface {
 	CreateUser(username, password, tenantID string) error
 	// DeleteAllUsersOnTenant deletes all users on the tenant.
 	DeleteAllUsersOnTenant(tenantName string) error
+	// UpdateUserPassword updates password of the user in the tenant.
+	UpdateUserPassword(username, password, tenantID string) error
+	// DeleteUser deletes the user in the tenant.
+	DeleteUser(username, tenantID string) error
 	// CreateNetwork creates network.
 	CreateNetwork(network *drivertypes.Network) error
+	// UpdateNetwork updates network's subnets.
+	UpdateNetwork(network *drivertypes.Network) error
 	// GetNetworkByID gets network by networkID.
 	GetNetworkByID(networkID string) (*drivertypes.Network, error)
 	// GetNetworkByName gets network by networkName.
 	GetNetworkByName(networkName string) (*drivertypes.Network, error)
 	// DeleteNetwork deletes network by networkName.
 	DeleteNetwork(networkName string) error
+	// ReconcileNetwork compares network in Neutron with the desired one, repairs
+	// drifts which could be fixed, and returns all drifts found.
+	ReconcileNetwork(network *drivertypes.Network) ([]*drivertypes.Drift, error)
 	// GetProviderSubnet gets provider subnet by id
 	GetProviderSubnet(osSubnetID string) (*drivertypes.Subnet, error)
-	// CreatePort creates port by neworkID, tenantID and portName.
-	CreatePort(networkID, tenantID, portName string) (*portsbinding.Port, error)
+	// CreatePort creates port by neworkID, tenantID and portName with optional
+	// attributes in opts, which may be nil.
+	CreatePort(networkID, tenantID, portName string, opts *PortOptions) (*Port, error)
 	// GetPort gets port by portName.
-	GetPort(name string) (*ports.Port, error)
-	// ListPorts lists ports by networkID and deviceOwner.
+	GetPort(name string) (*Port, error)
+	// ListPorts lists ports by networkID and deviceOwner. Ports of all networks
+	// are listed if networkID is empty, and of all owners if deviceOwner is empty.
 	ListPorts(networkID, deviceOwner string) ([]ports.Port, error)
+	// ListTenantPorts lists ports of the tenant.
+	ListTenantPorts(tenantID string) ([]ports.Port, error)
 	// DeletePortByName deletes port by portName.
 	DeletePortByName(portName string) error
 	// DeletePortByID deletes port by portID.
 	DeletePortByID(portID string) error
 	// UpdatePortsBinding updates port binding.
 	UpdatePortsBinding(portID, deviceOwner string) error
+	// UnbindPort unbinds the port from its host and marks it as retained.
+	UnbindPort(portID string) error
+	// GetPortSecurityEnabled checks whether port security of the port is enabled.
+	GetPortSecurityEnabled(portID string) (bool, error)
+	// UpdatePortSecurity updates allowed address pairs and port security of the port.
+	UpdatePortSecurity(portID, tenantID string, opts *PortOptions) error
+	// EnsurePortQoSPolicy ensures the QoS policy named name with the bandwidth
+	// limit, and attaches it to the port. The policy is deleted if limit is nil.
+	EnsurePortQoSPolicy(portID, tenantID, name string, limit *drivertypes.BandwidthLimit) error
+	// DeleteQoSPolicy deletes the QoS policy by its name.
+	DeleteQoSPolicy(name string) error
+	// EnsureDefaultSecurityGroup ensures the default security group of the tenant
+	// with rules. Existing rules are kept if rules is nil.
+	EnsureDefaultSecurityGroup(tenantID string, rules []*drivertypes.SecurityGroupRule) (string, error)
+	// EnsureSecurityGroup ensures the security group with exactly the desired rules.
+	EnsureSecurityGroup(sg *drivertypes.SecurityGroup) (string, error)
+	// ListSecurityGroups lists security groups of the tenant.
+	ListSecurityGroups(tenantID string) ([]*drivertypes.SecurityGroup, error)
+	// DeleteSecurityGroup deletes security group by groupID.
+	DeleteSecurityGroup(groupID string) error
+	// UpdatePortSecurityGroups replaces security groups of the port.
+	UpdatePortSecurityGroups(portID string, groupIDs []string) error
+	// CreateFloatingIP allocates a floating IP for the tenant from the external network.
+	CreateFloatingIP(tenantID, extNetID, address string) (*drivertypes.FloatingIP, error)
+	// GetFloatingIP gets floating IP by its ID.
+	GetFloatingIP(id string) (*drivertypes.FloatingIP, error)
+	// UpdateFloatingIPAssociation associates the floating IP with the port, or
+	// disassociates it if portID is empty.
+	UpdateFloatingIPAssociation(id, portID, fixedIP string) error
+	// DeleteFloatingIP releases the floating IP by its ID.
+	DeleteFloatingIP(id string) error
 	// LoadBalancerExist returns whether a load balancer has already been exist.
 	LoadBalancerExist(name string) (bool, error)
 	// EnsureLoadBalancer ensures a load balancer is created.
@@ -111,6 +165,8 @@ type Interface interface {
 	GetPluginName() string
 	// GetIntegrationBridge returns the integration bridge name.
 	GetIntegrationBridge() string
+	// GetDNSOpts returns the options of DNS deployed in each namespace.
+	GetDNSOpts() DNSOpts
 }
 
 // Client implements the openstack client Interface.
@@ -122,14 +178,49 @@ type Client struct {
 	ExtNetID          string
 	PluginName        string
 	IntegrationBridge string
+	DNSOpts           DNSOpts
 	CRDClient         crdClient.Interface
 }
 
+// Port is a Neutron port with its MTU, which is extracted from the same
+// response. MTU is zero if Neutron doesn't report MTU of ports.
+type Port struct {
+	ports.Port
+	MTU int `json:"mtu"`
+}
+
 type PluginOpts struct {
 	PluginName        string `gcfg:"plugin-name"`
 	IntegrationBridge string `gcfg:"integration-bridge"`
 }
 
+// DNSOpts configures the DNS deployed in each namespace. Defaults are used
+// for the options not set.
+type DNSOpts struct {
+	// Provider is the DNS server, either kube-dns or coredns.
+	Provider string `gcfg:"provider"`
+	Domain   string `gcfg:"domain"`
+	Replicas int    `gcfg:"replicas"`
+
+	KubeDNSImage string `gcfg:"kube-dns-image"`
+	DNSMasqImage string `gcfg:"dnsmasq-image"`
+	SidecarImage string `gcfg:"sidecar-image"`
+	CoreDNSImage string `gcfg:"coredns-image"`
+
+	// Resources of the DNS server containers, e.g. 100m or 128Mi.
+	CPULimit      string `gcfg:"cpu-limit"`
+	MemoryLimit   string `gcfg:"memory-limit"`
+	CPURequest    string `gcfg:"cpu-request"`
+	MemoryRequest string `gcfg:"memory-request"`
+
+	// UpstreamNameservers are the name servers for domains out of cluster,
+	// in format of ip[:port].
+	UpstreamNameservers []string `gcfg:"upstream-nameserver"`
+	// StubDomains are private domains with their own name servers, in
+	// format of domain=ip[:port][,ip[:port]...].
+	StubDomains []string `gcfg:"stub-domain"`
+}
+
 // Config used to configure the openstack client.
 type Config struct {
 	Global struct {
@@ -141,6 +232,7 @@ type Config struct {
 		ExtNetID   string `gcfg:"ext-net-id"`
 	}
 	Plugin PluginOpts
+	DNS    DNSOpts
 }
 
 func toAuthOptions(cfg Config) gophercloud.AuthOptions {
@@ -183,7 +275,7 @@ func NewClient(config string, kubeConfig string) (Interface, error) {
 		Region: cfg.Global.Region,
 	})
 	if err != nil {
-		glog.Warning("Failed to find neutron endpoint: %v", err)
+		glog.Warningf("Failed to find neutron endpoint: %v", err)
 		return nil, err
 	}
 
@@ -205,6 +297,7 @@ func NewClient(config string, kubeConfig string) (Interface, error) {
 		ExtNetID:          cfg.Global.ExtNetID,
 		PluginName:        cfg.Plugin.PluginName,
 		IntegrationBridge: cfg.Plugin.IntegrationBridge,
+		DNSOpts:           cfg.DNS,
 		CRDClient:         kubeCRDClient,
 	}
 	return client, nil
@@ -238,6 +331,11 @@ func (os *Client) GetIntegrationBridge() string {
 	return os.IntegrationBridge
 }
 
+// GetDNSOpts returns the options of DNS deployed in each namespace.
+func (os *Client) GetDNSOpts() DNSOpts {
+	return os.DNSOpts
+}
+
 // GetTenantIDFromName gets tenantID by tenantName.
 func (os *Client) GetTenantIDFromName(tenantName string) (string, error) {
 	if util.IsSystemNamespace(tenantName) {
@@ -363,6 +461,71 @@ func (os *Client) DeleteAllUsersOnTenant(tenantName string) error {
 	})
 }
 
+// UpdateUserPassword updates password of the user in the tenant.
+func (os *Client) UpdateUserPassword(username, password, tenantID string) error {
+	user, err := os.getUserOnTenant(username, tenantID)
+	if err != nil {
+		glog.Errorf("Get user %s failed: %v", username, err)
+		return err
+	}
+
+	opts := users.UpdateOpts{
+		Password: password,
+	}
+	_, err = users.Update(os.Identity, user.ID, opts).Extract()
+	if err != nil {
+		glog.Errorf("Failed to update password of user %s: %v", username, err)
+		return err
+	}
+	glog.V(4).Infof("Password of user %s updated", username)
+	return nil
+}
+
+// DeleteUser deletes the user in the tenant.
+func (os *Client) DeleteUser(username, tenantID string) error {
+	user, err := os.getUserOnTenant(username, tenantID)
+	if err == ErrNotFound {
+		return nil
+	} else if err != nil {
+		glog.Errorf("Get user %s failed: %v", username, err)
+		return err
+	}
+
+	res := users.Delete(os.Identity, user.ID)
+	if res.Err != nil && !isNotFound(res.Err) {
+		glog.Errorf("Delete openstack user %s error: %v", username, res.Err)
+		return res.Err
+	}
+	glog.V(4).Infof("User %s deleted", username)
+	return nil
+}
+
+// getUserOnTenant gets the user by its name in the tenant.
+func (os *Client) getUserOnTenant(username, tenantID string) (*users.User, error) {
+	var user *users.User
+	err := users.ListUsers(os.Identity, tenantID).EachPage(func(page pagination.Page) (bool, error) {
+		usersList, err := users.ExtractUsers(page)
+		if err != nil {
+			return false, err
+		}
+		for i := range usersList {
+			if usersList[i].Name == username || usersList[i].Username == username {
+				user = &usersList[i]
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrNotFound
+	}
+
+	return user, nil
+}
+
 // IsAlreadyExists determines if the err is an error which indicates that a specified resource already exists.
 func IsAlreadyExists(err error) bool {
 	return reasonForError(err) == StatusCodeAlreadyExists
@@ -376,30 +539,58 @@ func reasonForError(err error) int {
 	return 0
 }
 
+// isConflict checks whether err is a 409 response, e.g. the requested IP
+// address is already allocated.
+func isConflict(err error) bool {
+	switch e := err.(type) {
+	case gophercloud.ErrUnexpectedResponseCode:
+		return e.Actual == http.StatusConflict
+	case *gophercloud.ErrUnexpectedResponseCode:
+		return e.Actual == http.StatusConflict
+	}
+
+	return false
+}
+
 // GetOpenStackNetworkByTenantID gets tenant's network by tenantID(tenant and network are one to one mapping in stackube)
 func (os *Client) GetOpenStackNetworkByTenantID(tenantID string) (*networks.Network, error) {
 	opts := networks.ListOpts{TenantID: tenantID}
-	return os.getOpenStackNetwork(&opts)
+	osNetwork, err := os.getOpenStackNetwork(&opts)
+	if err != nil {
+		return nil, err
+	}
+	return &osNetwork.Network, nil
 }
 
 // Get openstack network by id
-func (os *Client) getOpenStackNetworkByID(id string) (*networks.Network, error) {
+func (os *Client) getOpenStackNetworkByID(id string) (*openStackNetwork, error) {
 	opts := networks.ListOpts{ID: id}
 	return os.getOpenStackNetwork(&opts)
 }
 
 // Get openstack network by name
-func (os *Client) getOpenStackNetworkByName(name string) (*networks.Network, error) {
+func (os *Client) getOpenStackNetworkByName(name string) (*openStackNetwork, error) {
 	opts := networks.ListOpts{Name: name}
 	return os.getOpenStackNetwork(&opts)
 }
 
+// openStackNetwork is a Neutron network with its provider attributes and MTU,
+// which are extracted from the same response.
+type openStackNetwork struct {
+	networks.Network
+	networkProvider
+}
+
 // Get openstack network
-func (os *Client) getOpenStackNetwork(opts *networks.ListOpts) (*networks.Network, error) {
-	var osNetwork *networks.Network
+func (os *Client) getOpenStackNetwork(opts *networks.ListOpts) (*openStackNetwork, error) {
+	var osNetwork *openStackNetwork
 	pager := networks.List(os.Network, *opts)
 	err := pager.EachPage(func(page pagination.Page) (bool, error) {
-		networkList, e := networks.ExtractNetworks(page)
+		var s struct {
+			Networks []openStackNetwork `json:"networks"`
+		}
+		e := page.(networks.NetworkPage).ExtractInto(&s)
+		networkList := s.Networks
 		if len(networkList) > 1 {
 			return false, ErrMultipleResults
 		}
@@ -426,6 +617,11 @@ func (os *Client) GetProviderSubnet(osSubnetID string) (*drivertypes.Subnet, err
 		return nil, err
 	}
 
+	return toProviderSubnet(s), nil
+}
+
+// toProviderSubnet converts an OpenStack subnet to provider subnet.
+func toProviderSubnet(s *subnets.Subnet) *drivertypes.Subnet {
 	var routes []*drivertypes.Route
 	for _, r := range s.HostRoutes {
 		route := drivertypes.Route{
@@ -435,16 +631,24 @@ func (os *Client) GetProviderSubnet(osSubnetID string) (*drivertypes.Subnet, err
 		routes = append(routes, &route)
 	}
 
-	providerSubnet := drivertypes.Subnet{
-		Uid:        s.ID,
-		Cidr:       s.CIDR,
-		Gateway:    s.GatewayIP,
-		Name:       s.Name,
-		Dnsservers: s.DNSNameservers,
-		Routes:     routes,
+	var pools []*drivertypes.AllocationPool
+	for _, p := range s.AllocationPools {
+		pools = append(pools, &drivertypes.AllocationPool{
+			Start: p.Start,
+			End:   p.End,
+		})
 	}
 
-	return &providerSubnet, nil
+	return &drivertypes.Subnet{
+		Uid:             s.ID,
+		Cidr:            s.CIDR,
+		Gateway:         s.GatewayIP,
+		Name:            s.Name,
+		Dnsservers:      s.DNSNameservers,
+		Routes:          routes,
+		AllocationPools: pools,
+		IPVersion:       s.IPVersion,
+	}
 }
 
 // GetNetworkByID gets network by networkID
@@ -469,8 +673,33 @@ func (os *Client) GetNetworkByName(networkName string) (*drivertypes.Network, er
 	return os.OSNetworktoProviderNetwork(osNetwork)
 }
 
-// OSNetworktoProviderNetwork transfers networks.Network to drivertypes.Network.
-func (os *Client) OSNetworktoProviderNetwork(osNetwork *networks.Network) (*drivertypes.Network, error) {
+// GetNetworkByCRD gets the network of Network CRD object. The adopted network
+// (Spec.NetworkID) is used if it is set, otherwise the network created by stackube.
+func GetNetworkByCRD(client Interface, network *crv1.Network) (*drivertypes.Network, error) {
+	if network.Spec.NetworkID != "" {
+		return client.GetNetworkByID(network.Spec.NetworkID)
+	}
+
+	return client.GetNetworkByName(util.BuildNetworkName(network.GetNamespace(), network.GetName()))
+}
+
+// GetNetworkByCRDName gets the network of Network CRD object namespace/name.
+// Network is got by naming convention if the CRD object doesn't exist, e.g.
+// the shared network of system namespaces.
+func GetNetworkByCRDName(client Interface, namespace, name string) (*drivertypes.Network, error) {
+	network, err := client.GetCRDClient().GetNetwork(namespace, name)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+		return client.GetNetworkByName(util.BuildNetworkName(namespace, name))
+	}
+
+	return GetNetworkByCRD(client, network)
+}
+
+// OSNetworktoProviderNetwork transfers openStackNetwork to drivertypes.Network.
+func (os *Client) OSNetworktoProviderNetwork(osNetwork *openStackNetwork) (*drivertypes.Network, error) {
 	var providerNetwork drivertypes.Network
 	var providerSubnets []*drivertypes.Subnet
 	providerNetwork.Name = osNetwork.Name
@@ -487,10 +716,52 @@ func (os *Client) OSNetworktoProviderNetwork(osNetwork *networks.Network) (*driv
 	}
 
 	providerNetwork.Subnets = providerSubnets
+	providerNetwork.NetworkType = osNetwork.NetworkType
+	providerNetwork.PhysicalNetwork = osNetwork.PhysicalNetwork
+	providerNetwork.SegmentID = osNetwork.SegmentationID
+	providerNetwork.MTU = osNetwork.MTU
 
 	return &providerNetwork, nil
 }
 
+// networkProvider is the provider attributes and MTU of a network, which
+// are not supported by networks.Network.
+type networkProvider struct {
+	NetworkType     string `json:"provider:network_type"`
+	PhysicalNetwork string `json:"provider:physical_network"`
+	SegmentationID  int32  `json:"provider:segmentation_id"`
+	MTU             int    `json:"mtu"`
+}
+
+// networkCreateOpts creates a network with provider attributes, which are
+// not supported by networks.CreateOpts.
+type networkCreateOpts struct {
+	networks.CreateOpts
+	NetworkType     string
+	PhysicalNetwork string
+	SegmentationID  int32
+}
+
+// ToNetworkCreateMap builds a create body based on networkCreateOpts.
+func (opts networkCreateOpts) ToNetworkCreateMap() (map[string]interface{}, error) {
+	b, err := opts.CreateOpts.ToNetworkCreateMap()
+	if err != nil {
+		return nil, err
+	}
+
+	network := b["network"].(map[string]interface{})
+	if opts.NetworkType != "" {
+		network["provider:network_type"] = opts.NetworkType
+	}
+	if opts.PhysicalNetwork != "" {
+		network["provider:physical_network"] = opts.PhysicalNetwork
+	}
+	if opts.SegmentationID != 0 {
+		network["provider:segmentation_id"] = opts.SegmentationID
+	}
+	return b, nil
+}
+
 // ToProviderStatus transfers networks.Network's status to drivertypes.Network's status.
 func (os *Client) ToProviderStatus(status string) string {
 	switch status {
@@ -512,10 +783,15 @@ func (os *Client) CreateNetwork(network *drivertypes.Network) error {
 	}
 
 	// create network
-	opts := networks.CreateOpts{
-		Name:         network.Name,
-		AdminStateUp: &adminStateUp,
-		TenantID:     network.TenantID,
+	opts := networkCreateOpts{
+		CreateOpts: networks.CreateOpts{
+			Name:         network.Name,
+			AdminStateUp: &adminStateUp,
+			TenantID:     network.TenantID,
+		},
+		NetworkType:     network.NetworkType,
+		PhysicalNetwork: network.PhysicalNetwork,
+		SegmentationID:  network.SegmentID,
 	}
 	osNet, err := networks.Create(os.Network, opts).Extract()
 	if err != nil {
@@ -523,21 +799,19 @@ func (os *Client) CreateNetwork(network *drivertypes.Network) error {
 		return err
 	}
 
-	// create router
-	routerOpts := routers.CreateOpts{
-		// use network name as router name for convenience
-		Name:        network.Name,
-		TenantID:    network.TenantID,
-		GatewayInfo: &routers.GatewayInfo{NetworkID: os.ExtNetID},
-	}
-	osRouter, err := routers.Create(os.Network, routerOpts).Extract()
-	if err != nil {
-		glog.Errorf("Create openstack router %s failed: %v", network.Name, err)
-		delErr := os.DeleteNetwork(network.Name)
-		if delErr != nil {
-			glog.Errorf("Delete openstack network %s failed: %v", network.Name, delErr)
+	// create router, unless the network is isolated or connected to an existing router
+	routerID := network.RouterID
+	if routerID == "" && !network.NoRouter {
+		osRouter, err := os.createRouter(network)
+		if err != nil {
+			glog.Errorf("Create openstack router %s failed: %v", network.Name, err)
+			delErr := os.DeleteNetwork(network.Name)
+			if delErr != nil {
+				glog.Errorf("Delete openstack network %s failed: %v", network.Name, delErr)
+			}
+			return err
 		}
-		return err
+		routerID = osRouter.ID
 	}
 
 	// create subnets and connect them to router
@@ -546,16 +820,7 @@ func (os *Client) CreateNetwork(network *drivertypes.Network) error {
 	network.Uid = osNet.ID
 	for _, sub := range network.Subnets {
 		// create subnet
-		subnetOpts := subnets.CreateOpts{
-			NetworkID:      networkID,
-			CIDR:           sub.Cidr,
-			Name:           sub.Name,
-			IPVersion:      gophercloud.IPv4,
-			TenantID:       network.TenantID,
-			GatewayIP:      &sub.Gateway,
-			DNSNameservers: sub.Dnsservers,
-		}
-		s, err := subnets.Create(os.Network, subnetOpts).Extract()
+		s, err := os.createSubnet(networkID, network.TenantID, sub)
 		if err != nil {
 			glog.Errorf("Create openstack subnet %s failed: %v", sub.Name, err)
 			delErr := os.DeleteNetwork(network.Name)
@@ -565,11 +830,15 @@ func (os *Client) CreateNetwork(network *drivertypes.Network) error {
 			return err
 		}
 
+		if routerID == "" {
+			continue
+		}
+
 		// add subnet to router
 		opts := routers.AddInterfaceOpts{
 			SubnetID: s.ID,
 		}
-		_, err = routers.AddInterface(os.Network, osRouter.ID, opts).Extract()
+		_, err = routers.AddInterface(os.Network, routerID, opts).Extract()
 		if err != nil {
 			glog.Errorf("Create openstack subnet %s failed: %v", sub.Name, err)
 			delErr := os.DeleteNetwork(network.Name)
@@ -583,12 +852,310 @@ func (os *Client) CreateNetwork(network *drivertypes.Network) error {
 	return nil
 }
 
-// UpdateNetwork updates network.
+func (os *Client) createSubnet(networkID, tenantID string, sub *drivertypes.Subnet) (*subnets.Subnet, error) {
+	createOpts := subnets.CreateOpts{
+		NetworkID:       networkID,
+		CIDR:            sub.Cidr,
+		Name:            sub.Name,
+		IPVersion:       gophercloud.IPv4,
+		TenantID:        tenantID,
+		DNSNameservers:  sub.Dnsservers,
+		HostRoutes:      toHostRoutes(sub.Routes),
+		AllocationPools: toAllocationPools(sub.AllocationPools),
+	}
+	if sub.Gateway != "" {
+		createOpts.GatewayIP = &sub.Gateway
+	}
+	if sub.IPVersion == int(gophercloud.IPv6) {
+		createOpts.IPVersion = gophercloud.IPv6
+	}
+
+	opts := subnetCreateOpts{
+		CreateOpts:      createOpts,
+		IPv6AddressMode: sub.IPv6AddressMode,
+	}
+	return subnets.Create(os.Network, opts).Extract()
+}
+
+func toAllocationPools(pools []*drivertypes.AllocationPool) []subnets.AllocationPool {
+	var results []subnets.AllocationPool
+	for _, p := range pools {
+		results = append(results, subnets.AllocationPool{
+			Start: p.Start,
+			End:   p.End,
+		})
+	}
+
+	return results
+}
+
+func toHostRoutes(routes []*drivertypes.Route) []subnets.HostRoute {
+	results := make([]subnets.HostRoute, 0, len(routes))
+	for _, r := range routes {
+		results = append(results, subnets.HostRoute{
+			DestinationCIDR: r.DestinationCIDR,
+			NextHop:         r.Nexthop,
+		})
+	}
+
+	return results
+}
+
+// subnetCreateOpts is the same as subnets.CreateOpts, except that IPv6 address
+// mode is supported.
+type subnetCreateOpts struct {
+	subnets.CreateOpts
+	IPv6AddressMode string
+}
+
+// ToSubnetCreateMap builds a create body based on subnetCreateOpts.
+func (opts subnetCreateOpts) ToSubnetCreateMap() (map[string]interface{}, error) {
+	b, err := opts.CreateOpts.ToSubnetCreateMap()
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.IPv6AddressMode != "" {
+		// Router advertisements are sent in the same mode as address assignment.
+		subnet := b["subnet"].(map[string]interface{})
+		subnet["ipv6_address_mode"] = opts.IPv6AddressMode
+		subnet["ipv6_ra_mode"] = opts.IPv6AddressMode
+	}
+
+	return b, nil
+}
+
+// subnetUpdateOpts is the same as subnets.UpdateOpts, except that dns_nameservers
+// and host_routes are always sent, so that they could also be cleared.
+type subnetUpdateOpts struct {
+	GatewayIP       *string                  `json:"gateway_ip,omitempty"`
+	AllocationPools []subnets.AllocationPool `json:"allocation_pools,omitempty"`
+	DNSNameservers  []string                 `json:"dns_nameservers"`
+	HostRoutes      []subnets.HostRoute      `json:"host_routes"`
+}
+
+// ToSubnetUpdateMap builds an update body based on subnetUpdateOpts.
+func (opts subnetUpdateOpts) ToSubnetUpdateMap() (map[string]interface{}, error) {
+	return gophercloud.BuildRequestBody(opts, "subnet")
+}
+
+// UpdateNetwork updates network's subnets in place. Gateway, DNS servers, host routes
+// and allocation pools are updated directly, while subnets with a different CIDR are
+// recreated, which is only allowed when there are no ports using them. New subnets
+// are created and subnets not in network any more are deleted.
 func (os *Client) UpdateNetwork(network *drivertypes.Network) error {
-	// TODO: update network subnets
+	osNetwork, err := os.getOpenStackNetworkByName(network.Name)
+	if err != nil {
+		glog.Errorf("Get openstack network %s failed: %v", network.Name, err)
+		return err
+	}
+
+	router, err := os.getNetworkRouter(network)
+	if err != nil {
+		glog.Errorf("Get openstack router of network %s error: %v", network.Name, err)
+		return err
+	}
+	if network.RouterID != "" && router == nil {
+		return fmt.Errorf("router %s not found", network.RouterID)
+	}
+
+	// Converge the external gateway of dedicated router.
+	if router != nil && network.RouterID == "" {
+		drift, err := os.checkRouterGateway(network, router.ID)
+		if err != nil {
+			return err
+		}
+		if drift != "" {
+			glog.V(4).Infof("Updating router of network %s: %s", network.Name, drift)
+			if err := os.updateRouterGateway(network, router.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	osSubnets := make(map[string]*subnets.Subnet)
+	for _, subnetID := range osNetwork.Subnets {
+		s, err := subnets.Get(os.Network, subnetID).Extract()
+		if err != nil {
+			glog.Errorf("Get openstack subnet %s failed: %v", subnetID, err)
+			return err
+		}
+		osSubnets[s.Name] = s
+	}
+
+	for _, sub := range network.Subnets {
+		s, ok := osSubnets[sub.Name]
+		if !ok {
+			err = os.addSubnet(osNetwork.ID, network.TenantID, router, sub)
+		} else if s.CIDR != sub.Cidr {
+			err = os.recreateSubnet(osNetwork.ID, network.TenantID, router, s, sub)
+		} else {
+			err = os.updateSubnet(s.ID, sub)
+		}
+		if err != nil {
+			return err
+		}
+		delete(osSubnets, sub.Name)
+	}
+
+	// Remove subnets which are not wanted any more.
+	for _, s := range osSubnets {
+		err = os.removeSubnet(osNetwork.ID, router, s)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addSubnet creates a new subnet and attaches it to router.
+func (os *Client) addSubnet(networkID, tenantID string, router *routers.Router, sub *drivertypes.Subnet) error {
+	s, err := os.createSubnet(networkID, tenantID, sub)
+	if err != nil {
+		glog.Errorf("Create openstack subnet %s failed: %v", sub.Name, err)
+		return err
+	}
+
+	if router != nil {
+		opts := routers.AddInterfaceOpts{SubnetID: s.ID}
+		_, err = routers.AddInterface(os.Network, router.ID, opts).Extract()
+		if err != nil {
+			glog.Errorf("Add subnet %s to router %s failed: %v", s.ID, router.ID, err)
+			// Don't leave a subnet without router behind.
+			if delErr := subnets.Delete(os.Network, s.ID).ExtractErr(); delErr != nil {
+				glog.Errorf("Delete openstack subnet %s error: %v", s.ID, delErr)
+			}
+			return err
+		}
+	}
+
+	glog.V(4).Infof("Subnet %s created with CIDR %s", sub.Name, sub.Cidr)
+	return nil
+}
+
+// removeSubnet detaches subnet from router and deletes it, which is only allowed
+// when there are no ports using it.
+func (os *Client) removeSubnet(networkID string, router *routers.Router, s *subnets.Subnet) error {
+	inUse, err := os.countSubnetPorts(networkID, s.ID)
+	if err != nil {
+		return err
+	}
+	if inUse > 0 {
+		return fmt.Errorf("can not delete subnet %s: %d ports are still using it", s.Name, inUse)
+	}
+
+	if router != nil {
+		opts := routers.RemoveInterfaceOpts{SubnetID: s.ID}
+		_, err := routers.RemoveInterface(os.Network, router.ID, opts).Extract()
+		if err != nil {
+			glog.Errorf("Remove subnet %s from router %s failed: %v", s.ID, router.ID, err)
+			return err
+		}
+	}
+
+	err = subnets.Delete(os.Network, s.ID).ExtractErr()
+	if err != nil {
+		glog.Errorf("Delete openstack subnet %s error: %v", s.ID, err)
+		return err
+	}
+
+	glog.V(4).Infof("Subnet %s deleted", s.Name)
 	return nil
 }
 
+func (os *Client) updateSubnet(subnetID string, sub *drivertypes.Subnet) error {
+	opts := subnetUpdateOpts{
+		AllocationPools: toAllocationPools(sub.AllocationPools),
+		DNSNameservers:  append([]string{}, sub.Dnsservers...),
+		HostRoutes:      toHostRoutes(sub.Routes),
+	}
+	if sub.Gateway != "" {
+		opts.GatewayIP = &sub.Gateway
+	}
+	_, err := subnets.Update(os.Network, subnetID, opts).Extract()
+	if err != nil {
+		glog.Errorf("Update openstack subnet %s failed: %v", sub.Name, err)
+		return err
+	}
+
+	glog.V(4).Infof("Subnet %s updated", sub.Name)
+	return nil
+}
+
+// recreateSubnet replaces subnet old with a new one, since CIDR of a subnet
+// can't be changed in Neutron.
+func (os *Client) recreateSubnet(networkID, tenantID string, router *routers.Router, old *subnets.Subnet, sub *drivertypes.Subnet) error {
+	inUse, err := os.countSubnetPorts(networkID, old.ID)
+	if err != nil {
+		return err
+	}
+	if inUse > 0 {
+		return fmt.Errorf("can not change CIDR of subnet %s from %s to %s: %d ports are still using it",
+			sub.Name, old.CIDR, sub.Cidr, inUse)
+	}
+
+	// The new CIDR may overlap the old one, which is not allowed in the same
+	// network, so the old subnet is removed first and restored if the new one
+	// can't be created.
+	previous := toProviderSubnet(old)
+	var s struct {
+		Subnet struct {
+			IPv6AddressMode string `json:"ipv6_address_mode"`
+		} `json:"subnet"`
+	}
+	if err := subnets.Get(os.Network, old.ID).ExtractInto(&s); err != nil {
+		glog.Errorf("Get openstack subnet %s failed: %v", old.ID, err)
+		return err
+	}
+	previous.IPv6AddressMode = s.Subnet.IPv6AddressMode
+
+	if err := os.removeSubnet(networkID, router, old); err != nil {
+		return err
+	}
+
+	if err := os.addSubnet(networkID, tenantID, router, sub); err != nil {
+		if restoreErr := os.addSubnet(networkID, tenantID, router, previous); restoreErr != nil {
+			glog.Errorf("Restore subnet %s with CIDR %s failed: %v", previous.Name, previous.Cidr, restoreErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// countSubnetPorts returns the number of ports allocated from the subnet. Router
+// interfaces and DHCP ports are not counted since they are not used by pods.
+func (os *Client) countSubnetPorts(networkID, subnetID string) (int, error) {
+	var count int
+	opts := ports.ListOpts{NetworkID: networkID}
+	pager := ports.List(os.Network, opts)
+	err := pager.EachPage(func(page pagination.Page) (bool, error) {
+		portList, err := ports.ExtractPorts(page)
+		if err != nil {
+			glog.Errorf("Get openstack ports error: %v", err)
+			return false, err
+		}
+
+		for _, port := range portList {
+			if port.DeviceOwner == "network:router_interface" || port.DeviceOwner == "network:dhcp" {
+				continue
+			}
+
+			for _, ip := range port.FixedIPs {
+				if ip.SubnetID == subnetID {
+					count++
+					break
+				}
+			}
+		}
+
+		return true, nil
+	})
+
+	return count, err
+}
+
 func (os *Client) getRouterByName(name string) (*routers.Router, error) {
 	var result *routers.Router
 
@@ -647,44 +1214,59 @@ func (os *Client) DeleteNetwork(networkName string) error {
 			glog.Errorf("Delete ports error: %v", err)
 		}
 
-		router, err := os.getRouterByName(networkName)
+		// Try to delete all resources even if some of them failed, so that
+		// as few as possible are left for the next retry.
+		var errs []error
+
+		// Detach subnets from all routers the network is connected to, which
+		// may be the dedicated router or an existing router given by user.
+		interfaces, err := os.ListPorts(osNetwork.ID, "network:router_interface")
 		if err != nil {
-			glog.Errorf("Get openstack router %s error: %v", networkName, err)
+			glog.Errorf("Get router interfaces of network %s error: %v", networkName, err)
 			return err
 		}
-
-		// delete all subnets
-		for _, subnet := range osNetwork.Subnets {
-			if router != nil {
-				opts := routers.RemoveInterfaceOpts{SubnetID: subnet}
-				_, err := routers.RemoveInterface(os.Network, router.ID, opts).Extract()
-				if err != nil {
-					glog.Errorf("Get openstack router %s error: %v", networkName, err)
-					return err
+		for _, port := range interfaces {
+			for _, ip := range port.FixedIPs {
+				opts := routers.RemoveInterfaceOpts{SubnetID: ip.SubnetID}
+				_, err := routers.RemoveInterface(os.Network, port.DeviceID, opts).Extract()
+				if err != nil && !isNotFound(err) {
+					glog.Errorf("Remove subnet %s from openstack router %s error: %v", ip.SubnetID, port.DeviceID, err)
+					errs = append(errs, err)
 				}
 			}
+		}
 
+		// delete all subnets
+		for _, subnet := range osNetwork.Subnets {
 			err = subnets.Delete(os.Network, subnet).ExtractErr()
-			if err != nil {
+			if err != nil && !isNotFound(err) {
 				glog.Errorf("Delete openstack subnet %s error: %v", subnet, err)
-				return err
+				errs = append(errs, err)
 			}
 		}
 
-		// delete router
-		if router != nil {
+		// Delete the dedicated router, routers not created by stackube are kept.
+		router, err := os.getRouterByName(networkName)
+		if err != nil {
+			glog.Errorf("Get openstack router %s error: %v", networkName, err)
+			errs = append(errs, err)
+		} else if router != nil {
 			err = routers.Delete(os.Network, router.ID).ExtractErr()
-			if err != nil {
+			if err != nil && !isNotFound(err) {
 				glog.Errorf("Delete openstack router %s error: %v", router.ID, err)
-				return err
+				errs = append(errs, err)
 			}
 		}
 
 		// delete network
 		err = networks.Delete(os.Network, osNetwork.ID).ExtractErr()
-		if err != nil {
+		if err != nil && !isNotFound(err) {
 			glog.Errorf("Delete openstack network %s error: %v", osNetwork.ID, err)
-			return err
+			errs = append(errs, err)
+		}
+
+		if len(errs) > 0 {
+			return utilerrors.NewAggregate(errs)
 		}
 	}
 
@@ -721,17 +1303,20 @@ func (os *Client) CheckTenantByID(tenantID string) (bool, error) {
 }
 
 // GetPort gets port by portName.
-func (os *Client) GetPort(name string) (*ports.Port, error) {
+func (os *Client) GetPort(name string) (*Port, error) {
 	opts := ports.ListOpts{Name: name}
 	pager := ports.List(os.Network, opts)
 
-	var port *ports.Port
+	var port *Port
 	err := pager.EachPage(func(page pagination.Page) (bool, error) {
-		portList, err := ports.ExtractPorts(page)
-		if err != nil {
+		var s struct {
+			Ports []Port `json:"ports"`
+		}
+		if err := page.(ports.PortPage).ExtractInto(&s); err != nil {
 			glog.Errorf("Get openstack ports error: %v", err)
 			return false, err
 		}
+		portList := s.Ports
 
 		if len(portList) > 1 {
 			return false, ErrMultipleResults
@@ -743,7 +1328,7 @@ func (os *Client) GetPort(name string) (*ports.Port, error) {
 
 		port = &portList[0]
 
-		return true, err
+		return true, nil
 	})
 
 	return port, err
@@ -758,126 +1343,72 @@ func getHostName() string {
 	return host
 }
 
-func (os *Client) ensureSecurityGroup(tenantID string) (string, error) {
-	var securitygroup *groups.SecGroup
-
-	opts := groups.ListOpts{
-		TenantID: tenantID,
-		Name:     securitygroupName,
-	}
-	pager := groups.List(os.Network, opts)
-	err := pager.EachPage(func(page pagination.Page) (bool, error) {
-		sg, err := groups.ExtractGroups(page)
-		if err != nil {
-			glog.Errorf("Get openstack securitygroups error: %v", err)
-			return false, err
-		}
-
-		if len(sg) > 0 {
-			securitygroup = &sg[0]
-		}
-
-		return true, err
-	})
-	if err != nil {
-		return "", err
-	}
-
-	// If securitygroup doesn't exist, create a new one
-	if securitygroup == nil {
-		securitygroup, err = groups.Create(os.Network, groups.CreateOpts{
-			Name:     securitygroupName,
-			TenantID: tenantID,
-		}).Extract()
-
-		if err != nil {
-			return "", err
-		}
+// CreatePort creates port by neworkID, tenantID and portName.
+// The IP is allocated from opts.SubnetID if it is not empty, and
+// opts.IPAddress is requested if it is not empty.
+func (os *Client) CreatePort(networkID, tenantID, portName string, opts *PortOptions) (*Port, error) {
+	if opts == nil {
+		opts = &PortOptions{}
 	}
 
-	var secGroupsRules int
-	listopts := rules.ListOpts{
-		TenantID:   tenantID,
-		Direction:  string(rules.DirIngress),
-		SecGroupID: securitygroup.ID,
+	createOpts := ports.CreateOpts{
+		NetworkID:           networkID,
+		Name:                portName,
+		AdminStateUp:        &adminStateUp,
+		TenantID:            tenantID,
+		DeviceID:            uuid.Generate().String(),
+		DeviceOwner:         fmt.Sprintf("compute:%s", getHostName()),
+		AllowedAddressPairs: opts.AllowedAddressPairs,
 	}
-	rulesPager := rules.List(os.Network, listopts)
-	err = rulesPager.EachPage(func(page pagination.Page) (bool, error) {
-		r, err := rules.ExtractRules(page)
+	if !opts.PortSecurityDisabled {
+		securitygroup, err := os.EnsureDefaultSecurityGroup(tenantID, nil)
 		if err != nil {
-			glog.Errorf("Get openstack securitygroup rules error: %v", err)
-			return false, err
-		}
-
-		secGroupsRules = len(r)
-
-		return true, err
-	})
-	if err != nil {
-		return "", err
-	}
-
-	// create new rules
-	if secGroupsRules == 0 {
-		// create egress rule
-		_, err = rules.Create(os.Network, rules.CreateOpts{
-			TenantID:   tenantID,
-			SecGroupID: securitygroup.ID,
-			Direction:  rules.DirEgress,
-			EtherType:  rules.EtherType4,
-		}).Extract()
-
-		// create ingress rule
-		_, err := rules.Create(os.Network, rules.CreateOpts{
-			TenantID:   tenantID,
-			SecGroupID: securitygroup.ID,
-			Direction:  rules.DirIngress,
-			EtherType:  rules.EtherType4,
-		}).Extract()
-		if err != nil {
-			return "", err
+			glog.Errorf("EnsureSecurityGroup failed: %v", err)
+			return nil, err
 		}
+		createOpts.SecurityGroups = []string{securitygroup}
 	}
-
-	return securitygroup.ID, nil
-}
-
-// CreatePort creates port by neworkID, tenantID and portName.
-func (os *Client) CreatePort(networkID, tenantID, portName string) (*portsbinding.Port, error) {
-	securitygroup, err := os.ensureSecurityGroup(tenantID)
-	if err != nil {
-		glog.Errorf("EnsureSecurityGroup failed: %v", err)
-		return nil, err
+	if opts.SubnetID != "" {
+		createOpts.FixedIPs = []ports.IP{{SubnetID: opts.SubnetID, IPAddress: opts.IPAddress}}
 	}
 
-	opts := portsbinding.CreateOpts{
+	bindingOpts := portsbinding.CreateOpts{
 		HostID: getHostName(),
-		CreateOptsBuilder: ports.CreateOpts{
-			NetworkID:      networkID,
-			Name:           portName,
-			AdminStateUp:   &adminStateUp,
-			TenantID:       tenantID,
-			DeviceID:       uuid.Generate().String(),
-			DeviceOwner:    fmt.Sprintf("compute:%s", getHostName()),
-			SecurityGroups: []string{securitygroup},
+		CreateOptsBuilder: portSecurityCreateOpts{
+			CreateOptsBuilder:    createOpts,
+			PortSecurityDisabled: opts.PortSecurityDisabled,
 		},
 	}
 
-	port, err := portsbinding.Create(os.Network, opts).Extract()
+	var s struct {
+		Port Port `json:"port"`
+	}
+	err := portsbinding.Create(os.Network, bindingOpts).ExtractInto(&s)
 	if err != nil {
 		glog.Errorf("Create port %s failed: %v", portName, err)
+		if opts.IPAddress != "" && isConflict(err) {
+			return nil, ErrIPAddressInUse
+		}
 		return nil, err
 	}
-	return port, nil
+	return &s.Port, nil
 }
 
 // ListPorts lists ports by networkID and deviceOwner.
 func (os *Client) ListPorts(networkID, deviceOwner string) ([]ports.Port, error) {
-	var results []ports.Port
-	opts := ports.ListOpts{
+	return os.listPorts(ports.ListOpts{
 		NetworkID:   networkID,
 		DeviceOwner: deviceOwner,
-	}
+	})
+}
+
+// ListTenantPorts lists ports of the tenant.
+func (os *Client) ListTenantPorts(tenantID string) ([]ports.Port, error) {
+	return os.listPorts(ports.ListOpts{TenantID: tenantID})
+}
+
+func (os *Client) listPorts(opts ports.ListOpts) ([]ports.Port, error) {
+	var results []ports.Port
 	pager := ports.List(os.Network, opts)
 	err := pager.EachPage(func(page pagination.Page) (bool, error) {
 		portList, err := ports.ExtractPorts(page)
@@ -936,12 +1467,41 @@ func (os *Client) DeletePortByID(portID string) error {
 // UpdatePortsBinding updates port binding.
 func (os *Client) UpdatePortsBinding(portID, deviceOwner string) error {
 	// Update hostname in order to make sure it is correct
-	updateOpts := portsbinding.UpdateOpts{
-		HostID: getHostName(),
-		UpdateOptsBuilder: ports.UpdateOpts{
-			DeviceOwner: deviceOwner,
-		},
+	updateOpts := portBindingUpdateOpts{
+		HostID:      getHostName(),
+		DeviceOwner: deviceOwner,
 	}
 	_, err := portsbinding.Update(os.Network, portID, updateOpts).Extract()
 	return err
 }
+
+// UnbindPort unbinds the port from its host and marks it as retained.
+func (os *Client) UnbindPort(portID string) error {
+	updateOpts := portBindingUpdateOpts{
+		DeviceOwner: RetainedPortDeviceOwner,
+	}
+	_, err := portsbinding.Update(os.Network, portID, updateOpts).Extract()
+	if err != nil {
+		glog.Errorf("Unbind port %s failed: %v", portID, err)
+		return err
+	}
+
+	return nil
+}
+
+// portBindingUpdateOpts updates binding host and device owner of a port only,
+// ports.UpdateOpts would also reset security groups and address pairs of the port.
+type portBindingUpdateOpts struct {
+	HostID      string
+	DeviceOwner string
+}
+
+// ToPortUpdateMap builds an update body based on portBindingUpdateOpts.
+func (opts portBindingUpdateOpts) ToPortUpdateMap() (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"port": map[string]interface{}{
+			"binding:host_id": opts.HostID,
+			"device_owner":    opts.DeviceOwner,
+		},
+	}, nil
+}
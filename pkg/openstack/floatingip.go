@@ -0,0 +1,118 @@
+/*
+Copyright (c) 2017 OpenStack Foundation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	drivertypes "git.openstack.org/openstack/stackube/pkg/openstack/types"
+
+	"github.com/golang/glog"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/floatingips"
+)
+
+// CreateFloatingIP allocates a floating IP for the tenant from the external
+// network extNetID, or the global one if it is empty. The address is chosen
+// by Neutron if address is empty.
+func (os *Client) CreateFloatingIP(tenantID, extNetID, address string) (*drivertypes.FloatingIP, error) {
+	if extNetID == "" {
+		extNetID = os.ExtNetID
+	}
+	fip, err := floatingips.Create(os.Network, floatingips.CreateOpts{
+		FloatingNetworkID: extNetID,
+		FloatingIP:        address,
+		TenantID:          tenantID,
+	}).Extract()
+	if err != nil {
+		glog.Errorf("Create floating IP on external network %s failed: %v", extNetID, err)
+		return nil, err
+	}
+
+	return toDriverFloatingIP(fip), nil
+}
+
+// GetFloatingIP gets floating IP by its ID.
+func (os *Client) GetFloatingIP(id string) (*drivertypes.FloatingIP, error) {
+	fip, err := floatingips.Get(os.Network, id).Extract()
+	if err != nil {
+		if isNotFound(err) {
+			return nil, ErrNotFound
+		}
+		glog.Errorf("Get floating IP %s failed: %v", id, err)
+		return nil, err
+	}
+
+	return toDriverFloatingIP(fip), nil
+}
+
+// UpdateFloatingIPAssociation associates the floating IP with fixedIP of the
+// port, or disassociates it if portID is empty. Neutron picks the fixed IP if
+// fixedIP is empty.
+func (os *Client) UpdateFloatingIPAssociation(id, portID, fixedIP string) error {
+	_, err := floatingips.Update(os.Network, id, floatingIPAssociationOpts{
+		PortID:  portID,
+		FixedIP: fixedIP,
+	}).Extract()
+	if err != nil {
+		glog.Errorf("Associate floating IP %s with port %q failed: %v", id, portID, err)
+		return err
+	}
+
+	return nil
+}
+
+// DeleteFloatingIP releases the floating IP by its ID.
+func (os *Client) DeleteFloatingIP(id string) error {
+	err := floatingips.Delete(os.Network, id).ExtractErr()
+	if err != nil && !isNotFound(err) {
+		glog.Errorf("Delete floating IP %s failed: %v", id, err)
+		return err
+	}
+
+	return nil
+}
+
+// toDriverFloatingIP translates Neutron floating IP to driver's.
+func toDriverFloatingIP(fip *floatingips.FloatingIP) *drivertypes.FloatingIP {
+	return &drivertypes.FloatingIP{
+		ID:                fip.ID,
+		FloatingIP:        fip.FloatingIP,
+		FloatingNetworkID: fip.FloatingNetworkID,
+		PortID:            fip.PortID,
+		FixedIP:           fip.FixedIP,
+		TenantID:          fip.TenantID,
+	}
+}
+
+// floatingIPAssociationOpts updates the association of a floating IP,
+// floatingips.UpdateOpts can't choose the fixed IP of the port.
+type floatingIPAssociationOpts struct {
+	PortID  string
+	FixedIP string
+}
+
+// ToFloatingIPUpdateMap builds an update body based on floatingIPAssociationOpts.
+func (opts floatingIPAssociationOpts) ToFloatingIPUpdateMap() (map[string]interface{}, error) {
+	fip := map[string]interface{}{
+		"port_id": nil,
+	}
+	if opts.PortID != "" {
+		fip["port_id"] = opts.PortID
+		if opts.FixedIP != "" {
+			fip["fixed_ip_address"] = opts.FixedIP
+		}
+	}
+	return map[string]interface{}{"floatingip": fip}, nil
+}
@@ -774,7 +774,8 @@ func isNotFound(err error) bool {
 		return true
 	}
 
-	if _, ok := err.(*gophercloud.ErrDefault404); ok {
+	switch err.(type) {
+	case gophercloud.ErrDefault404, *gophercloud.ErrDefault404:
 		return true
 	}
 
@@ -20,6 +20,7 @@ import (
 	"crypto/sha1"
 	"fmt"
 	"io"
+	"net"
 	"sync"
 
 	crdClient "git.openstack.org/openstack/stackube/pkg/kubecrd"
@@ -28,7 +29,6 @@ import (
 	"github.com/gophercloud/gophercloud/openstack/identity/v2/tenants"
 	"github.com/gophercloud/gophercloud/openstack/identity/v2/users"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/routers"
-	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/portsbinding"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
 	"github.com/gophercloud/gophercloud/openstack/networking/v2/subnets"
 )
@@ -49,14 +49,22 @@ type FakeOSClient struct {
 	errors            map[string]error
 	Tenants           map[string]*tenants.Tenant
 	Users             map[string]*users.User
+	UserPasswords     map[string]string
 	Networks          map[string]*drivertypes.Network
 	Subnets           map[string]*subnets.Subnet
 	Routers           map[string]*routers.Router
 	Ports             map[string][]ports.Port
 	LoadBalancers     map[string]*LoadBalancer
+	Drifts            map[string][]*drivertypes.Drift
+	SecurityGroups    map[string]*drivertypes.SecurityGroup
+	FloatingIPs       map[string]*drivertypes.FloatingIP
+	NoPortSecurity    map[string]bool
+	QoSPolicies       map[string]*drivertypes.BandwidthLimit
+	PortMTUs          map[string]int
 	CRDClient         crdClient.Interface
 	PluginName        string
 	IntegrationBridge string
+	DNSOpts           DNSOpts
 }
 
 var _ = Interface(&FakeOSClient{})
@@ -67,11 +75,18 @@ func NewFake(crdClient crdClient.Interface) *FakeOSClient {
 		errors:            make(map[string]error),
 		Tenants:           make(map[string]*tenants.Tenant),
 		Users:             make(map[string]*users.User),
+		UserPasswords:     make(map[string]string),
 		Networks:          make(map[string]*drivertypes.Network),
 		Subnets:           make(map[string]*subnets.Subnet),
 		Routers:           make(map[string]*routers.Router),
 		Ports:             make(map[string][]ports.Port),
 		LoadBalancers:     make(map[string]*LoadBalancer),
+		Drifts:            make(map[string][]*drivertypes.Drift),
+		SecurityGroups:    make(map[string]*drivertypes.SecurityGroup),
+		FloatingIPs:       make(map[string]*drivertypes.FloatingIP),
+		NoPortSecurity:    make(map[string]bool),
+		QoSPolicies:       make(map[string]*drivertypes.BandwidthLimit),
+		PortMTUs:          make(map[string]int),
 		CRDClient:         crdClient,
 		PluginName:        "ovs",
 		IntegrationBridge: "bi-int",
@@ -145,7 +160,7 @@ func (f *FakeOSClient) SetTenant(tenantName, tenantID string) {
 	f.Tenants[tenantName] = tenant
 }
 
-// SetUser injects fake user.
+// SetUser injects fake user, users are keyed by user name.
 func (f *FakeOSClient) SetUser(userName, userID, tenantID string) {
 	f.Lock()
 	defer f.Unlock()
@@ -154,7 +169,7 @@ func (f *FakeOSClient) SetUser(userName, userID, tenantID string) {
 		ID:       userID,
 		TenantID: tenantID,
 	}
-	f.Users[tenantID] = user
+	f.Users[userName] = user
 }
 
 // SetNetwork injects fake network.
@@ -184,6 +199,14 @@ func (f *FakeOSClient) SetPort(networkID, deviceOwner, deviceID string) {
 	f.Ports[networkID] = netPorts
 }
 
+// SetDrifts injects fake drifts of network, they are returned by ReconcileNetwork.
+func (f *FakeOSClient) SetDrifts(networkName string, drifts []*drivertypes.Drift) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.Drifts[networkName] = drifts
+}
+
 // SetLoadbalancer injects fake loadbalancer.
 func (f *FakeOSClient) SetLoadbalancer(lb *LoadBalancer) {
 	f.Lock()
@@ -192,6 +215,14 @@ func (f *FakeOSClient) SetLoadbalancer(lb *LoadBalancer) {
 	f.LoadBalancers[lb.Name] = lb
 }
 
+// SetFloatingIP injects fake floating IP.
+func (f *FakeOSClient) SetFloatingIP(fip *drivertypes.FloatingIP) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.FloatingIPs[fip.ID] = fip
+}
+
 func tenantIDHash(tenantName string) string {
 	return idHash(tenantName)
 }
@@ -315,12 +346,18 @@ func (f *FakeOSClient) CreateUser(username, password, tenantID string) error {
 		return err
 	}
 
+	// Existing user is kept as is, same as Keystone.
+	if _, ok := f.Users[username]; ok {
+		return nil
+	}
+
 	user := &users.User{
 		Name:     username,
 		TenantID: tenantID,
 		ID:       userIDHash(username, tenantID),
 	}
-	f.Users[tenantID] = user
+	f.Users[username] = user
+	f.UserPasswords[user.ID] = password
 	return nil
 }
 
@@ -335,11 +372,51 @@ func (f *FakeOSClient) DeleteAllUsersOnTenant(tenantName string) error {
 
 	tenant := f.Tenants[tenantName]
 
-	delete(f.Users, tenant.ID)
+	for name, user := range f.Users {
+		if user.TenantID == tenant.ID {
+			delete(f.Users, name)
+			delete(f.UserPasswords, user.ID)
+		}
+	}
+	return nil
+}
+
+// UpdateUserPassword is a test implementation of Interface.UpdateUserPassword.
+func (f *FakeOSClient) UpdateUserPassword(username, password, tenantID string) error {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("UpdateUserPassword", username, password, tenantID)
+	if err := f.getError("UpdateUserPassword"); err != nil {
+		return err
+	}
+
+	user, ok := f.Users[username]
+	if !ok || user.TenantID != tenantID {
+		return ErrNotFound
+	}
+
+	f.UserPasswords[user.ID] = password
 	return nil
 }
 
-func (f *FakeOSClient) createNetwork(networkName, tenantID string) error {
+// DeleteUser is a test implementation of Interface.DeleteUser.
+func (f *FakeOSClient) DeleteUser(username, tenantID string) error {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("DeleteUser", username, tenantID)
+	if err := f.getError("DeleteUser"); err != nil {
+		return err
+	}
+
+	user, ok := f.Users[username]
+	if ok && user.TenantID == tenantID {
+		delete(f.Users, username)
+		delete(f.UserPasswords, user.ID)
+	}
+	return nil
+}
+
+func (f *FakeOSClient) createNetwork(networkName, tenantID, networkType, physicalNetwork string, segmentID int32) error {
 	f.Lock()
 	defer f.Unlock()
 	f.appendCalled("createNetwork", networkName, tenantID)
@@ -352,9 +429,12 @@ func (f *FakeOSClient) createNetwork(networkName, tenantID string) error {
 	}
 
 	network := &drivertypes.Network{
-		Name:     networkName,
-		Uid:      networkIDHash(networkName),
-		TenantID: tenantID,
+		Name:            networkName,
+		Uid:             networkIDHash(networkName),
+		TenantID:        tenantID,
+		NetworkType:     networkType,
+		PhysicalNetwork: physicalNetwork,
+		SegmentID:       segmentID,
 	}
 	f.Networks[networkName] = network
 	return nil
@@ -451,15 +531,18 @@ func (f *FakeOSClient) CreateNetwork(network *drivertypes.Network) error {
 	}
 
 	// create network
-	err := f.createNetwork(network.Name, network.TenantID)
+	err := f.createNetwork(network.Name, network.TenantID, network.NetworkType, network.PhysicalNetwork, network.SegmentID)
 	if err != nil {
 		return err
 	}
 	// create router, and use network name as router name for convenience.
-	err = f.createRouter(network.Name, network.TenantID)
-	if err != nil {
-		f.deleteNetwork(network.Name)
-		return err
+	// Isolated networks and networks connected to existing routers have none.
+	if !network.NoRouter && network.RouterID == "" {
+		err = f.createRouter(network.Name, network.TenantID)
+		if err != nil {
+			f.deleteNetwork(network.Name)
+			return err
+		}
 	}
 	// create subnets and connect them to router
 	err = f.createSubnet(network.Subnets[0].Name, network.Uid, network.TenantID)
@@ -471,6 +554,39 @@ func (f *FakeOSClient) CreateNetwork(network *drivertypes.Network) error {
 	return nil
 }
 
+// UpdateNetwork is a test implementation of Interface.UpdateNetwork.
+func (f *FakeOSClient) UpdateNetwork(network *drivertypes.Network) error {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("UpdateNetwork", network)
+	if err := f.getError("UpdateNetwork"); err != nil {
+		return err
+	}
+
+	net, ok := f.Networks[network.Name]
+	if !ok {
+		return ErrNotFound
+	}
+
+	for _, sub := range network.Subnets {
+		for _, old := range net.Subnets {
+			if old.Name != sub.Name || old.Cidr == sub.Cidr {
+				continue
+			}
+
+			for _, port := range f.Ports[net.Uid] {
+				if port.DeviceOwner != "network:router_interface" {
+					return fmt.Errorf("can not change CIDR of subnet %s from %s to %s: ports are still using it",
+						sub.Name, old.Cidr, sub.Cidr)
+				}
+			}
+		}
+	}
+
+	net.Subnets = network.Subnets
+	return nil
+}
+
 // GetNetworkByID is a test implementation of Interface.GetNetworkByID.
 func (f *FakeOSClient) GetNetworkByID(networkID string) (*drivertypes.Network, error) {
 	for _, network := range f.Networks {
@@ -511,19 +627,130 @@ func (f *FakeOSClient) DeleteNetwork(networkName string) error {
 	return nil
 }
 
+// ReconcileNetwork is a test implementation of Interface.ReconcileNetwork.
+func (f *FakeOSClient) ReconcileNetwork(network *drivertypes.Network) ([]*drivertypes.Drift, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("ReconcileNetwork", network)
+	if err := f.getError("ReconcileNetwork"); err != nil {
+		return nil, err
+	}
+
+	return f.Drifts[network.Name], nil
+}
+
 // GetProviderSubnet is a test implementation of Interface.GetProviderSubnet.
 func (f *FakeOSClient) GetProviderSubnet(osSubnetID string) (*drivertypes.Subnet, error) {
-	return nil, fmt.Errorf("Not implemented")
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("GetProviderSubnet", osSubnetID)
+	if err := f.getError("GetProviderSubnet"); err != nil {
+		return nil, err
+	}
+
+	for _, network := range f.Networks {
+		for _, subnet := range network.Subnets {
+			if subnet.Uid == osSubnetID {
+				return subnet, nil
+			}
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// CreatePort is a test implementation of Interface.CreatePort. The IP address
+// is allocated from the first subnet of the network unless requested by opts.
+func (f *FakeOSClient) CreatePort(networkID, tenantID, portName string, opts *PortOptions) (*Port, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("CreatePort", networkID, tenantID, portName, opts)
+	if err := f.getError("CreatePort"); err != nil {
+		return nil, err
+	}
+
+	var subnet *drivertypes.Subnet
+	for _, network := range f.Networks {
+		if network.Uid != networkID {
+			continue
+		}
+		for _, s := range network.Subnets {
+			if (subnet == nil && opts.SubnetID == "") || s.Uid == opts.SubnetID {
+				subnet = s
+			}
+		}
+	}
+	if subnet == nil {
+		return nil, ErrNotFound
+	}
+
+	used := make(map[string]bool)
+	for _, port := range f.Ports[networkID] {
+		for _, fixedIP := range port.FixedIPs {
+			used[fixedIP.IPAddress] = true
+		}
+	}
+	ipAddress := opts.IPAddress
+	if ipAddress == "" {
+		ip, ipNet, err := net.ParseCIDR(subnet.Cidr)
+		if err != nil {
+			return nil, err
+		}
+		// Skip the network address and the gateway.
+		for ip = nextFakeIP(nextFakeIP(ip.Mask(ipNet.Mask))); ipNet.Contains(ip); ip = nextFakeIP(ip) {
+			if !used[ip.String()] {
+				ipAddress = ip.String()
+				break
+			}
+		}
+	} else if used[ipAddress] {
+		return nil, ErrIPAddressInUse
+	}
+
+	port := ports.Port{
+		ID:                  idHash(networkID, portName),
+		Name:                portName,
+		NetworkID:           networkID,
+		TenantID:            tenantID,
+		FixedIPs:            []ports.IP{{SubnetID: subnet.Uid, IPAddress: ipAddress}},
+		AllowedAddressPairs: opts.AllowedAddressPairs,
+	}
+	f.Ports[networkID] = append(f.Ports[networkID], port)
+	if opts.PortSecurityDisabled {
+		f.NoPortSecurity[port.ID] = true
+	}
+	return &Port{Port: port, MTU: f.PortMTUs[port.ID]}, nil
 }
 
-// CreatePort is a test implementation of Interface.CreatePort.
-func (f *FakeOSClient) CreatePort(networkID, tenantID, portName string) (*portsbinding.Port, error) {
-	return nil, fmt.Errorf("Not implemented")
+// nextFakeIP returns the IP address next to ip.
+func nextFakeIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
 }
 
 // GetPort is a test implementation of Interface.GetPort.
-func (f *FakeOSClient) GetPort(name string) (*ports.Port, error) {
-	return nil, fmt.Errorf("Not implemented")
+func (f *FakeOSClient) GetPort(name string) (*Port, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("GetPort", name)
+	if err := f.getError("GetPort"); err != nil {
+		return nil, err
+	}
+
+	for _, portList := range f.Ports {
+		for i := range portList {
+			if portList[i].Name == name {
+				return &Port{Port: portList[i], MTU: f.PortMTUs[portList[i].ID]}, nil
+			}
+		}
+	}
+	return nil, ErrNotFound
 }
 
 // ListPorts is a test implementation of Interface.ListPorts.
@@ -536,14 +763,34 @@ func (f *FakeOSClient) ListPorts(networkID, deviceOwner string) ([]ports.Port, e
 	}
 
 	var results []ports.Port
-	portList, ok := f.Ports[networkID]
-	if !ok {
-		return results, nil
+	for netID, portList := range f.Ports {
+		if networkID != "" && netID != networkID {
+			continue
+		}
+		for _, port := range portList {
+			if deviceOwner == "" || port.DeviceOwner == deviceOwner {
+				results = append(results, port)
+			}
+		}
+	}
+	return results, nil
+}
+
+// ListTenantPorts is a test implementation of Interface.ListTenantPorts.
+func (f *FakeOSClient) ListTenantPorts(tenantID string) ([]ports.Port, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("ListTenantPorts", tenantID)
+	if err := f.getError("ListTenantPorts"); err != nil {
+		return nil, err
 	}
 
-	for _, port := range portList {
-		if port.DeviceOwner == deviceOwner {
-			results = append(results, port)
+	var results []ports.Port
+	for _, portList := range f.Ports {
+		for _, port := range portList {
+			if port.TenantID == tenantID {
+				results = append(results, port)
+			}
 		}
 	}
 	return results, nil
@@ -556,12 +803,290 @@ func (f *FakeOSClient) DeletePortByName(portName string) error {
 
 // DeletePortByID is a test implementation of Interface.DeletePortByID.
 func (f *FakeOSClient) DeletePortByID(portID string) error {
-	return fmt.Errorf("Not implemented")
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("DeletePortByID", portID)
+	if err := f.getError("DeletePortByID"); err != nil {
+		return err
+	}
+
+	for networkID, portList := range f.Ports {
+		for i := range portList {
+			if portList[i].ID == portID {
+				f.Ports[networkID] = append(portList[:i], portList[i+1:]...)
+				return nil
+			}
+		}
+	}
+	return ErrNotFound
 }
 
 // UpdatePortsBinding is a test implementation of Interface.UpdatePortsBinding.
 func (f *FakeOSClient) UpdatePortsBinding(portID, deviceOwner string) error {
-	return fmt.Errorf("Not implemented")
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("UpdatePortsBinding", portID, deviceOwner)
+	if err := f.getError("UpdatePortsBinding"); err != nil {
+		return err
+	}
+
+	return f.updatePortDeviceOwner(portID, deviceOwner)
+}
+
+// UnbindPort is a test implementation of Interface.UnbindPort.
+func (f *FakeOSClient) UnbindPort(portID string) error {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("UnbindPort", portID)
+	if err := f.getError("UnbindPort"); err != nil {
+		return err
+	}
+
+	return f.updatePortDeviceOwner(portID, RetainedPortDeviceOwner)
+}
+
+// GetPortSecurityEnabled is a test implementation of Interface.GetPortSecurityEnabled.
+func (f *FakeOSClient) GetPortSecurityEnabled(portID string) (bool, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("GetPortSecurityEnabled", portID)
+	if err := f.getError("GetPortSecurityEnabled"); err != nil {
+		return false, err
+	}
+
+	return !f.NoPortSecurity[portID], nil
+}
+
+// UpdatePortSecurity is a test implementation of Interface.UpdatePortSecurity.
+func (f *FakeOSClient) UpdatePortSecurity(portID, tenantID string, opts *PortOptions) error {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("UpdatePortSecurity", portID, tenantID, opts)
+	if err := f.getError("UpdatePortSecurity"); err != nil {
+		return err
+	}
+
+	for _, portList := range f.Ports {
+		for i := range portList {
+			if portList[i].ID == portID {
+				portList[i].AllowedAddressPairs = opts.AllowedAddressPairs
+				if opts.PortSecurityDisabled {
+					portList[i].SecurityGroups = nil
+					f.NoPortSecurity[portID] = true
+				} else {
+					delete(f.NoPortSecurity, portID)
+				}
+				return nil
+			}
+		}
+	}
+	return ErrNotFound
+}
+
+// EnsurePortQoSPolicy is a test implementation of Interface.EnsurePortQoSPolicy.
+func (f *FakeOSClient) EnsurePortQoSPolicy(portID, tenantID, name string, limit *drivertypes.BandwidthLimit) error {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("EnsurePortQoSPolicy", portID, tenantID, name, limit)
+	if err := f.getError("EnsurePortQoSPolicy"); err != nil {
+		return err
+	}
+
+	if limit == nil {
+		delete(f.QoSPolicies, name)
+		return nil
+	}
+	f.QoSPolicies[name] = limit
+	return nil
+}
+
+// DeleteQoSPolicy is a test implementation of Interface.DeleteQoSPolicy.
+func (f *FakeOSClient) DeleteQoSPolicy(name string) error {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("DeleteQoSPolicy", name)
+	if err := f.getError("DeleteQoSPolicy"); err != nil {
+		return err
+	}
+
+	delete(f.QoSPolicies, name)
+	return nil
+}
+
+func (f *FakeOSClient) updatePortDeviceOwner(portID, deviceOwner string) error {
+	for _, portList := range f.Ports {
+		for i := range portList {
+			if portList[i].ID == portID {
+				portList[i].DeviceOwner = deviceOwner
+				return nil
+			}
+		}
+	}
+	return ErrNotFound
+}
+
+// EnsureDefaultSecurityGroup is a test implementation of Interface.EnsureDefaultSecurityGroup.
+func (f *FakeOSClient) EnsureDefaultSecurityGroup(tenantID string, rules []*drivertypes.SecurityGroupRule) (string, error) {
+	if rules == nil {
+		f.Lock()
+		sg, ok := f.SecurityGroups[idHash(tenantID, securitygroupName)]
+		f.Unlock()
+		if ok {
+			return sg.Uid, nil
+		}
+		rules = append(AllowAllRules("ingress"), AllowAllRules("egress")...)
+	}
+
+	return f.EnsureSecurityGroup(&drivertypes.SecurityGroup{
+		Name:     securitygroupName,
+		TenantID: tenantID,
+		Rules:    rules,
+	})
+}
+
+// EnsureSecurityGroup is a test implementation of Interface.EnsureSecurityGroup.
+func (f *FakeOSClient) EnsureSecurityGroup(sg *drivertypes.SecurityGroup) (string, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("EnsureSecurityGroup", sg)
+	if err := f.getError("EnsureSecurityGroup"); err != nil {
+		return "", err
+	}
+
+	group := *sg
+	group.Uid = idHash(sg.TenantID, sg.Name)
+	f.SecurityGroups[group.Uid] = &group
+	return group.Uid, nil
+}
+
+// ListSecurityGroups is a test implementation of Interface.ListSecurityGroups.
+func (f *FakeOSClient) ListSecurityGroups(tenantID string) ([]*drivertypes.SecurityGroup, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("ListSecurityGroups", tenantID)
+	if err := f.getError("ListSecurityGroups"); err != nil {
+		return nil, err
+	}
+
+	var results []*drivertypes.SecurityGroup
+	for _, sg := range f.SecurityGroups {
+		if sg.TenantID == tenantID {
+			results = append(results, sg)
+		}
+	}
+	return results, nil
+}
+
+// DeleteSecurityGroup is a test implementation of Interface.DeleteSecurityGroup.
+func (f *FakeOSClient) DeleteSecurityGroup(groupID string) error {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("DeleteSecurityGroup", groupID)
+	if err := f.getError("DeleteSecurityGroup"); err != nil {
+		return err
+	}
+
+	delete(f.SecurityGroups, groupID)
+	return nil
+}
+
+// UpdatePortSecurityGroups is a test implementation of Interface.UpdatePortSecurityGroups.
+func (f *FakeOSClient) UpdatePortSecurityGroups(portID string, groupIDs []string) error {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("UpdatePortSecurityGroups", portID, groupIDs)
+	if err := f.getError("UpdatePortSecurityGroups"); err != nil {
+		return err
+	}
+
+	for _, portList := range f.Ports {
+		for i := range portList {
+			if portList[i].ID == portID {
+				portList[i].SecurityGroups = groupIDs
+				return nil
+			}
+		}
+	}
+	return ErrNotFound
+}
+
+// CreateFloatingIP is a test implementation of Interface.CreateFloatingIP.
+func (f *FakeOSClient) CreateFloatingIP(tenantID, extNetID, address string) (*drivertypes.FloatingIP, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("CreateFloatingIP", tenantID, extNetID, address)
+	if err := f.getError("CreateFloatingIP"); err != nil {
+		return nil, err
+	}
+
+	if address == "" {
+		address = fmt.Sprintf("172.24.4.%d", len(f.FloatingIPs)+10)
+	}
+	for _, fip := range f.FloatingIPs {
+		if fip.FloatingIP == address {
+			return nil, fmt.Errorf("floating IP %s is already allocated", address)
+		}
+	}
+	fip := &drivertypes.FloatingIP{
+		ID:                idHash(tenantID, address),
+		FloatingIP:        address,
+		FloatingNetworkID: extNetID,
+		TenantID:          tenantID,
+	}
+	f.FloatingIPs[fip.ID] = fip
+	copied := *fip
+	return &copied, nil
+}
+
+// GetFloatingIP is a test implementation of Interface.GetFloatingIP.
+func (f *FakeOSClient) GetFloatingIP(id string) (*drivertypes.FloatingIP, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("GetFloatingIP", id)
+	if err := f.getError("GetFloatingIP"); err != nil {
+		return nil, err
+	}
+
+	fip, ok := f.FloatingIPs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *fip
+	return &copied, nil
+}
+
+// UpdateFloatingIPAssociation is a test implementation of Interface.UpdateFloatingIPAssociation.
+func (f *FakeOSClient) UpdateFloatingIPAssociation(id, portID, fixedIP string) error {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("UpdateFloatingIPAssociation", id, portID, fixedIP)
+	if err := f.getError("UpdateFloatingIPAssociation"); err != nil {
+		return err
+	}
+
+	fip, ok := f.FloatingIPs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	fip.PortID = portID
+	fip.FixedIP = fixedIP
+	if portID == "" {
+		fip.FixedIP = ""
+	}
+	return nil
+}
+
+// DeleteFloatingIP is a test implementation of Interface.DeleteFloatingIP.
+func (f *FakeOSClient) DeleteFloatingIP(id string) error {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("DeleteFloatingIP", id)
+	if err := f.getError("DeleteFloatingIP"); err != nil {
+		return err
+	}
+
+	delete(f.FloatingIPs, id)
+	return nil
 }
 
 // LoadBalancerExist is a test implementation of Interface.LoadBalancerExist.
@@ -624,3 +1149,8 @@ func (f *FakeOSClient) GetPluginName() string {
 func (f *FakeOSClient) GetIntegrationBridge() string {
 	return f.IntegrationBridge
 }
+
+// GetDNSOpts is a test implementation of Interface.GetDNSOpts.
+func (f *FakeOSClient) GetDNSOpts() DNSOpts {
+	return f.DNSOpts
+}
@@ -0,0 +1,133 @@
+/*
+Copyright (c) 2017 OpenStack Foundation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"github.com/golang/glog"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+)
+
+// PortOptions are optional attributes of ports created for pods.
+type PortOptions struct {
+	// SubnetID is the subnet to allocate the IP address from.
+	SubnetID string
+	// IPAddress is the requested IP address in SubnetID.
+	IPAddress string
+	// AllowedAddressPairs are additional addresses the port may send from,
+	// e.g. virtual IPs of keepalived.
+	AllowedAddressPairs []ports.AddressPair
+	// PortSecurityDisabled turns off anti-spoofing and security groups of the port.
+	PortSecurityDisabled bool
+}
+
+// GetPortSecurityEnabled checks whether port security of the port is enabled.
+func (os *Client) GetPortSecurityEnabled(portID string) (bool, error) {
+	var s struct {
+		Port struct {
+			PortSecurityEnabled *bool `json:"port_security_enabled"`
+		} `json:"port"`
+	}
+	if err := ports.Get(os.Network, portID).ExtractInto(&s); err != nil {
+		glog.Errorf("Get port %s failed: %v", portID, err)
+		return false, err
+	}
+
+	// Port security is always enabled without the port-security extension.
+	return s.Port.PortSecurityEnabled == nil || *s.Port.PortSecurityEnabled, nil
+}
+
+// UpdatePortSecurity updates allowed address pairs and port security of the
+// port. Ports without port security have no security groups, so the default
+// security group of the tenant is attached again if port security is enabled.
+func (os *Client) UpdatePortSecurity(portID, tenantID string, opts *PortOptions) error {
+	updateOpts := portSecurityUpdateOpts{
+		PortSecurityEnabled: !opts.PortSecurityDisabled,
+		AllowedAddressPairs: opts.AllowedAddressPairs,
+	}
+	if opts.PortSecurityDisabled {
+		updateOpts.SecurityGroups = []string{}
+	} else {
+		port, err := ports.Get(os.Network, portID).Extract()
+		if err != nil {
+			glog.Errorf("Get port %s failed: %v", portID, err)
+			return err
+		}
+		if len(port.SecurityGroups) == 0 {
+			securitygroup, err := os.EnsureDefaultSecurityGroup(tenantID, nil)
+			if err != nil {
+				glog.Errorf("EnsureSecurityGroup failed: %v", err)
+				return err
+			}
+			updateOpts.SecurityGroups = []string{securitygroup}
+		}
+	}
+
+	_, err := ports.Update(os.Network, portID, updateOpts).Extract()
+	if err != nil {
+		glog.Errorf("Update port security of port %s failed: %v", portID, err)
+		return err
+	}
+
+	return nil
+}
+
+// portSecurityCreateOpts adds port security to ports.CreateOptsBuilder, which
+// is not supported by ports.CreateOpts.
+type portSecurityCreateOpts struct {
+	ports.CreateOptsBuilder
+	PortSecurityDisabled bool
+}
+
+// ToPortCreateMap builds a create body based on portSecurityCreateOpts.
+func (opts portSecurityCreateOpts) ToPortCreateMap() (map[string]interface{}, error) {
+	b, err := opts.CreateOptsBuilder.ToPortCreateMap()
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.PortSecurityDisabled {
+		port := b["port"].(map[string]interface{})
+		port["port_security_enabled"] = false
+		// Ports without port security can't have security groups.
+		port["security_groups"] = []string{}
+	}
+	return b, nil
+}
+
+// portSecurityUpdateOpts updates allowed address pairs and port security of
+// a port only, security groups are kept if SecurityGroups is nil.
+type portSecurityUpdateOpts struct {
+	PortSecurityEnabled bool
+	AllowedAddressPairs []ports.AddressPair
+	SecurityGroups      []string
+}
+
+// ToPortUpdateMap builds an update body based on portSecurityUpdateOpts.
+func (opts portSecurityUpdateOpts) ToPortUpdateMap() (map[string]interface{}, error) {
+	addressPairs := opts.AllowedAddressPairs
+	if addressPairs == nil {
+		addressPairs = []ports.AddressPair{}
+	}
+	port := map[string]interface{}{
+		"port_security_enabled": opts.PortSecurityEnabled,
+		"allowed_address_pairs": addressPairs,
+	}
+	if opts.SecurityGroups != nil {
+		port["security_groups"] = opts.SecurityGroups
+	}
+	return map[string]interface{}{"port": port}, nil
+}
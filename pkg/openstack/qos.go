@@ -0,0 +1,232 @@
+/*
+Copyright (c) 2017 OpenStack Foundation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"net/url"
+
+	drivertypes "git.openstack.org/openstack/stackube/pkg/openstack/types"
+
+	"github.com/golang/glog"
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+)
+
+const (
+	qosRuleTypeBandwidthLimit = "bandwidth_limit"
+	qosDirectionIngress       = "ingress"
+	qosDirectionEgress        = "egress"
+)
+
+// qosPolicy is a Neutron QoS policy, which is not supported by gophercloud yet.
+type qosPolicy struct {
+	ID    string    `json:"id"`
+	Name  string    `json:"name"`
+	Rules []qosRule `json:"rules"`
+}
+
+// qosRule is a rule of Neutron QoS policy.
+type qosRule struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	MaxKbps   int64  `json:"max_kbps"`
+	Direction string `json:"direction"`
+}
+
+// EnsurePortQoSPolicy ensures the QoS policy named name with the bandwidth
+// limit, and attaches it to the port. The policy is detached and deleted if
+// limit is nil.
+func (os *Client) EnsurePortQoSPolicy(portID, tenantID, name string, limit *drivertypes.BandwidthLimit) error {
+	policy, err := os.getQoSPolicy(name)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+
+	if limit == nil {
+		if policy == nil {
+			return nil
+		}
+		if err := os.updatePortQoSPolicy(portID, ""); err != nil {
+			return err
+		}
+		return os.DeleteQoSPolicy(name)
+	}
+
+	if policy == nil {
+		policy, err = os.createQoSPolicy(tenantID, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	existing := make(map[string]qosRule)
+	for _, rule := range policy.Rules {
+		if rule.Type != qosRuleTypeBandwidthLimit {
+			continue
+		}
+		// Rules are egress ones if Neutron doesn't support directions.
+		if rule.Direction == "" {
+			rule.Direction = qosDirectionEgress
+		}
+		existing[rule.Direction] = rule
+	}
+	desired := map[string]int64{
+		qosDirectionIngress: limit.IngressKbps,
+		qosDirectionEgress:  limit.EgressKbps,
+	}
+	for direction, maxKbps := range desired {
+		if err := os.ensureBandwidthLimitRule(policy.ID, direction, maxKbps, existing); err != nil {
+			return err
+		}
+	}
+
+	return os.updatePortQoSPolicy(portID, policy.ID)
+}
+
+// DeleteQoSPolicy deletes the QoS policy by its name.
+func (os *Client) DeleteQoSPolicy(name string) error {
+	policy, err := os.getQoSPolicy(name)
+	if err == ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	_, err = os.Network.Delete(os.Network.ServiceURL("qos", "policies", policy.ID), nil)
+	if err != nil && !isNotFound(err) {
+		glog.Errorf("Delete QoS policy %s failed: %v", name, err)
+		return err
+	}
+
+	return nil
+}
+
+func (os *Client) getQoSPolicy(name string) (*qosPolicy, error) {
+	var s struct {
+		Policies []qosPolicy `json:"policies"`
+	}
+	query := url.Values{"name": []string{name}}
+	_, err := os.Network.Get(os.Network.ServiceURL("qos", "policies")+"?"+query.Encode(), &s, nil)
+	if isNotFound(err) {
+		// The QoS extension is not enabled in Neutron.
+		return nil, ErrNotFound
+	} else if err != nil {
+		glog.Errorf("Get QoS policy %s failed: %v", name, err)
+		return nil, err
+	}
+
+	switch len(s.Policies) {
+	case 0:
+		return nil, ErrNotFound
+	case 1:
+		return &s.Policies[0], nil
+	default:
+		return nil, ErrMultipleResults
+	}
+}
+
+func (os *Client) createQoSPolicy(tenantID, name string) (*qosPolicy, error) {
+	var s struct {
+		Policy qosPolicy `json:"policy"`
+	}
+	body := map[string]interface{}{
+		"policy": map[string]interface{}{
+			"name":      name,
+			"tenant_id": tenantID,
+		},
+	}
+	_, err := os.Network.Post(os.Network.ServiceURL("qos", "policies"), body, &s, &gophercloud.RequestOpts{
+		OkCodes: []int{201},
+	})
+	if err != nil {
+		glog.Errorf("Create QoS policy %s failed: %v", name, err)
+		return nil, err
+	}
+
+	return &s.Policy, nil
+}
+
+// ensureBandwidthLimitRule ensures the bandwidth limit rule of the direction,
+// the rule is deleted if maxKbps is zero.
+func (os *Client) ensureBandwidthLimitRule(policyID, direction string, maxKbps int64, existing map[string]qosRule) error {
+	rulesURL := os.Network.ServiceURL("qos", "policies", policyID, "bandwidth_limit_rules")
+	rule, ok := existing[direction]
+	switch {
+	case ok && maxKbps == 0:
+		_, err := os.Network.Delete(rulesURL+"/"+rule.ID, nil)
+		if err != nil && !isNotFound(err) {
+			glog.Errorf("Delete %s bandwidth limit rule of QoS policy %s failed: %v", direction, policyID, err)
+			return err
+		}
+	case ok && rule.MaxKbps != maxKbps:
+		body := map[string]interface{}{
+			"bandwidth_limit_rule": map[string]interface{}{"max_kbps": maxKbps},
+		}
+		_, err := os.Network.Put(rulesURL+"/"+rule.ID, body, nil, &gophercloud.RequestOpts{
+			OkCodes: []int{200},
+		})
+		if err != nil {
+			glog.Errorf("Update %s bandwidth limit rule of QoS policy %s failed: %v", direction, policyID, err)
+			return err
+		}
+	case !ok && maxKbps > 0:
+		rule := map[string]interface{}{"max_kbps": maxKbps}
+		if direction != qosDirectionEgress {
+			// Neutron without directions only accepts egress rules.
+			rule["direction"] = direction
+		}
+		body := map[string]interface{}{"bandwidth_limit_rule": rule}
+		_, err := os.Network.Post(rulesURL, body, nil, &gophercloud.RequestOpts{
+			OkCodes: []int{201},
+		})
+		if err != nil {
+			glog.Errorf("Create %s bandwidth limit rule of QoS policy %s failed: %v", direction, policyID, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// updatePortQoSPolicy attaches the QoS policy to the port, or detaches the
+// current one if policyID is empty.
+func (os *Client) updatePortQoSPolicy(portID, policyID string) error {
+	_, err := ports.Update(os.Network, portID, portQoSPolicyUpdateOpts{QoSPolicyID: policyID}).Extract()
+	if err != nil {
+		glog.Errorf("Update QoS policy of port %s failed: %v", portID, err)
+		return err
+	}
+
+	return nil
+}
+
+// portQoSPolicyUpdateOpts updates the QoS policy of a port only, which is not
+// supported by ports.UpdateOpts.
+type portQoSPolicyUpdateOpts struct {
+	QoSPolicyID string
+}
+
+// ToPortUpdateMap builds an update body based on portQoSPolicyUpdateOpts.
+func (opts portQoSPolicyUpdateOpts) ToPortUpdateMap() (map[string]interface{}, error) {
+	port := map[string]interface{}{
+		"qos_policy_id": nil,
+	}
+	if opts.QoSPolicyID != "" {
+		port["qos_policy_id"] = opts.QoSPolicyID
+	}
+	return map[string]interface{}{"port": port}, nil
+}
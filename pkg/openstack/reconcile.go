@@ -0,0 +1,240 @@
+/*
+Copyright (c) 2017 OpenStack Foundation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+
+	drivertypes "git.openstack.org/openstack/stackube/pkg/openstack/types"
+
+	"github.com/golang/glog"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/routers"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/subnets"
+)
+
+// ReconcileNetwork compares the network in Neutron with the desired one, and
+// repairs the drift which could be fixed: missing network, router, external
+// gateway, subnets and router interfaces. All drifts found are returned, and
+// those not repaired are marked by Repaired=false.
+func (os *Client) ReconcileNetwork(network *drivertypes.Network) ([]*drivertypes.Drift, error) {
+	var drifts []*drivertypes.Drift
+
+	// Check network.
+	osNetwork, err := os.getOpenStackNetworkByName(network.Name)
+	if err == ErrNotFound {
+		drift := &drivertypes.Drift{
+			Resource: drivertypes.DriftResourceNetwork,
+			Message:  fmt.Sprintf("network %s not found", network.Name),
+		}
+		drifts = append(drifts, drift)
+		if err := os.CreateNetwork(network); err != nil {
+			glog.Errorf("Recreate network %s failed: %v", network.Name, err)
+			drift.Message = fmt.Sprintf("%s, recreate failed: %v", drift.Message, err)
+			return drifts, nil
+		}
+		drift.Repaired = true
+		return drifts, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Check provider attributes, which could not be changed in place.
+	if network.NetworkType != "" {
+		if message := checkNetworkProvider(network, &osNetwork.networkProvider); message != "" {
+			drifts = append(drifts, &drivertypes.Drift{
+				Resource: drivertypes.DriftResourceNetwork,
+				Message:  message,
+			})
+		}
+	}
+
+	// Check router and its external gateway. Isolated networks have no router,
+	// and existing routers given by user could not be recreated.
+	router, err := os.getNetworkRouter(network)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case network.NoRouter:
+	case network.RouterID != "" && router == nil:
+		drifts = append(drifts, &drivertypes.Drift{
+			Resource: drivertypes.DriftResourceRouter,
+			Message:  fmt.Sprintf("router %s not found", network.RouterID),
+		})
+	case router == nil:
+		drift := &drivertypes.Drift{
+			Resource: drivertypes.DriftResourceRouter,
+			Message:  fmt.Sprintf("router %s not found", network.Name),
+		}
+		drifts = append(drifts, drift)
+		router, err = os.createRouter(network)
+		if err != nil {
+			glog.Errorf("Recreate router %s failed: %v", network.Name, err)
+			drift.Message = fmt.Sprintf("%s, recreate failed: %v", drift.Message, err)
+			return drifts, nil
+		}
+		drift.Repaired = true
+	case network.RouterID == "":
+		message, err := os.checkRouterGateway(network, router.ID)
+		if err != nil {
+			return nil, err
+		}
+		if message != "" {
+			drift := &drivertypes.Drift{
+				Resource: drivertypes.DriftResourceRouter,
+				Message:  message,
+			}
+			drifts = append(drifts, drift)
+			if err := os.updateRouterGateway(network, router.ID); err != nil {
+				drift.Message = fmt.Sprintf("%s, update failed: %v", drift.Message, err)
+			} else {
+				drift.Repaired = true
+			}
+		}
+	}
+
+	// Check subnets, they are repaired by updating the network.
+	subnetDrifts, err := os.checkSubnets(osNetwork.Subnets, network.Subnets)
+	if err != nil {
+		return nil, err
+	}
+	if len(subnetDrifts) > 0 {
+		drifts = append(drifts, subnetDrifts...)
+		err := os.UpdateNetwork(network)
+		if err != nil {
+			glog.Errorf("Repair subnets of network %s failed: %v", network.Name, err)
+		}
+		for _, drift := range subnetDrifts {
+			if err != nil {
+				drift.Message = fmt.Sprintf("%s, repair failed: %v", drift.Message, err)
+			} else {
+				drift.Repaired = true
+			}
+		}
+		if err != nil {
+			return drifts, nil
+		}
+
+		// Subnets may be recreated, get them again.
+		osNetwork, err = os.getOpenStackNetworkByID(osNetwork.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if router == nil {
+		return drifts, nil
+	}
+
+	// Check router interfaces.
+	interfaces, err := os.ListPorts(osNetwork.ID, "network:router_interface")
+	if err != nil {
+		return nil, err
+	}
+	connected := make(map[string]bool)
+	for _, port := range interfaces {
+		if port.DeviceID != router.ID {
+			continue
+		}
+		for _, ip := range port.FixedIPs {
+			connected[ip.SubnetID] = true
+		}
+	}
+	for _, subnetID := range osNetwork.Subnets {
+		if connected[subnetID] {
+			continue
+		}
+		drift := &drivertypes.Drift{
+			Resource: drivertypes.DriftResourceRouterInterface,
+			Message:  fmt.Sprintf("subnet %s is not connected to router %s", subnetID, network.Name),
+		}
+		drifts = append(drifts, drift)
+		opts := routers.AddInterfaceOpts{SubnetID: subnetID}
+		if _, err := routers.AddInterface(os.Network, router.ID, opts).Extract(); err != nil {
+			glog.Errorf("Add subnet %s to router %s failed: %v", subnetID, router.ID, err)
+			drift.Message = fmt.Sprintf("%s, connect failed: %v", drift.Message, err)
+			continue
+		}
+		drift.Repaired = true
+	}
+
+	return drifts, nil
+}
+
+// checkNetworkProvider compares provider attributes of the network in Neutron
+// with the desired ones, and returns the difference, which is empty if they
+// are the same. Attributes allocated by Neutron are not compared.
+func checkNetworkProvider(network *drivertypes.Network, provider *networkProvider) string {
+	switch {
+	case provider.NetworkType != network.NetworkType:
+		return fmt.Sprintf("type of network %s is %q instead of %q",
+			network.Name, provider.NetworkType, network.NetworkType)
+	case network.PhysicalNetwork != "" && provider.PhysicalNetwork != network.PhysicalNetwork:
+		return fmt.Sprintf("physical network of network %s is %q instead of %q",
+			network.Name, provider.PhysicalNetwork, network.PhysicalNetwork)
+	case network.SegmentID != 0 && provider.SegmentationID != network.SegmentID:
+		return fmt.Sprintf("segmentation ID of network %s is %d instead of %d",
+			network.Name, provider.SegmentationID, network.SegmentID)
+	}
+	return ""
+}
+
+// checkSubnets compares subnets in Neutron with the desired ones by name.
+func (os *Client) checkSubnets(subnetIDs []string, desired []*drivertypes.Subnet) ([]*drivertypes.Drift, error) {
+	var drifts []*drivertypes.Drift
+
+	osSubnets := make(map[string]*subnets.Subnet)
+	for _, subnetID := range subnetIDs {
+		s, err := subnets.Get(os.Network, subnetID).Extract()
+		if err != nil {
+			glog.Errorf("Get openstack subnet %s failed: %v", subnetID, err)
+			return nil, err
+		}
+		osSubnets[s.Name] = s
+	}
+
+	for _, sub := range desired {
+		s, ok := osSubnets[sub.Name]
+		delete(osSubnets, sub.Name)
+		switch {
+		case !ok:
+			drifts = append(drifts, &drivertypes.Drift{
+				Resource: drivertypes.DriftResourceSubnet,
+				Message:  fmt.Sprintf("subnet %s not found", sub.Name),
+			})
+		case s.CIDR != sub.Cidr:
+			drifts = append(drifts, &drivertypes.Drift{
+				Resource: drivertypes.DriftResourceSubnet,
+				Message:  fmt.Sprintf("CIDR of subnet %s is %s instead of %s", sub.Name, s.CIDR, sub.Cidr),
+			})
+		case sub.Gateway != "" && s.GatewayIP != sub.Gateway:
+			drifts = append(drifts, &drivertypes.Drift{
+				Resource: drivertypes.DriftResourceSubnet,
+				Message:  fmt.Sprintf("gateway of subnet %s is %q instead of %q", sub.Name, s.GatewayIP, sub.Gateway),
+			})
+		}
+	}
+
+	for name := range osSubnets {
+		drifts = append(drifts, &drivertypes.Drift{
+			Resource: drivertypes.DriftResourceSubnet,
+			Message:  fmt.Sprintf("subnet %s is not wanted", name),
+		})
+	}
+
+	return drifts, nil
+}
@@ -0,0 +1,311 @@
+/*
+Copyright (c) 2017 OpenStack Foundation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	drivertypes "git.openstack.org/openstack/stackube/pkg/openstack/types"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+const (
+	testNetworkName = "kube-test-test"
+	testNetworkID   = "net-1"
+	testSubnetID    = "subnet-1"
+	testRouterID    = "router-1"
+	testExtNetID    = "ext-net"
+)
+
+// fakeNeutron is a Neutron API server serving the resources checked by
+// ReconcileNetwork. Requests not served fail with 500.
+type fakeNeutron struct {
+	networks []map[string]interface{}
+	subnets  []map[string]interface{}
+	routers  []map[string]interface{}
+	ports    []map[string]interface{}
+	// requests are the write requests served, e.g. "POST /v2.0/routers".
+	requests []string
+}
+
+func newFakeNeutron() *fakeNeutron {
+	return &fakeNeutron{
+		networks: []map[string]interface{}{{
+			"id":                    testNetworkID,
+			"name":                  testNetworkName,
+			"subnets":               []string{testSubnetID},
+			"provider:network_type": "vxlan",
+		}},
+		subnets: []map[string]interface{}{{
+			"id":         testSubnetID,
+			"name":       testNetworkName + "-subnet",
+			"network_id": testNetworkID,
+			"cidr":       "10.244.0.0/16",
+			"gateway_ip": "10.244.0.1",
+		}},
+		routers: []map[string]interface{}{{
+			"id":                    testRouterID,
+			"name":                  testNetworkName,
+			"external_gateway_info": map[string]interface{}{"network_id": testExtNetID},
+		}},
+		ports: []map[string]interface{}{{
+			"id":           "port-1",
+			"network_id":   testNetworkID,
+			"device_owner": "network:router_interface",
+			"device_id":    testRouterID,
+			"fixed_ips":    []map[string]interface{}{{"subnet_id": testSubnetID, "ip_address": "10.244.0.1"}},
+		}},
+	}
+}
+
+// filter returns the resources matching all the query parameters.
+func filter(resources []map[string]interface{}, r *http.Request) []map[string]interface{} {
+	results := []map[string]interface{}{}
+	for _, resource := range resources {
+		matched := true
+		for key, values := range r.URL.Query() {
+			if fmt.Sprint(resource[key]) != values[0] {
+				matched = false
+			}
+		}
+		if matched {
+			results = append(results, resource)
+		}
+	}
+	return results
+}
+
+// find returns the resource with id, nil if not found.
+func find(resources []map[string]interface{}, id string) map[string]interface{} {
+	for _, resource := range resources {
+		if resource["id"] == id {
+			return resource
+		}
+	}
+	return nil
+}
+
+func (f *fakeNeutron) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v2.0/")
+	parts := strings.Split(path, "/")
+	if r.Method != http.MethodGet {
+		f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	}
+
+	var body map[string]interface{}
+	if r.Body != nil {
+		json.NewDecoder(r.Body).Decode(&body)
+	}
+
+	reply := func(code int, result interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(result)
+	}
+	get := func(kind string, resources []map[string]interface{}) {
+		if resource := find(resources, parts[1]); resource != nil {
+			reply(http.StatusOK, map[string]interface{}{kind: resource})
+			return
+		}
+		reply(http.StatusNotFound, map[string]interface{}{})
+	}
+
+	switch {
+	case r.Method == http.MethodGet && path == "networks":
+		reply(http.StatusOK, map[string]interface{}{"networks": filter(f.networks, r)})
+	case r.Method == http.MethodGet && parts[0] == "subnets" && len(parts) == 2:
+		get("subnet", f.subnets)
+	case r.Method == http.MethodGet && path == "routers":
+		reply(http.StatusOK, map[string]interface{}{"routers": filter(f.routers, r)})
+	case r.Method == http.MethodGet && parts[0] == "routers" && len(parts) == 2:
+		get("router", f.routers)
+	case r.Method == http.MethodGet && path == "ports":
+		reply(http.StatusOK, map[string]interface{}{"ports": filter(f.ports, r)})
+	case r.Method == http.MethodPost && path == "routers":
+		router := body["router"].(map[string]interface{})
+		router["id"] = fmt.Sprintf("router-%d", len(f.routers)+1)
+		f.routers = append(f.routers, router)
+		reply(http.StatusCreated, map[string]interface{}{"router": router})
+	case r.Method == http.MethodPut && parts[0] == "routers" && len(parts) == 2:
+		router := find(f.routers, parts[1])
+		for key, value := range body["router"].(map[string]interface{}) {
+			router[key] = value
+		}
+		reply(http.StatusOK, map[string]interface{}{"router": router})
+	case r.Method == http.MethodPut && parts[0] == "routers" && len(parts) == 3 && parts[2] == "add_router_interface":
+		subnet := find(f.subnets, body["subnet_id"].(string))
+		port := map[string]interface{}{
+			"id":           fmt.Sprintf("port-%d", len(f.ports)+1),
+			"network_id":   subnet["network_id"],
+			"device_owner": "network:router_interface",
+			"device_id":    parts[1],
+			"fixed_ips":    []map[string]interface{}{{"subnet_id": subnet["id"], "ip_address": subnet["gateway_ip"]}},
+		}
+		f.ports = append(f.ports, port)
+		reply(http.StatusOK, map[string]interface{}{"id": parts[1], "subnet_id": subnet["id"], "port_id": port["id"]})
+	default:
+		reply(http.StatusInternalServerError, map[string]interface{}{})
+	}
+}
+
+// newReconcileClient creates a Client talking to the fake Neutron server.
+func newReconcileClient(neutron *fakeNeutron) (*Client, *httptest.Server) {
+	server := httptest.NewServer(neutron)
+	client := &Client{
+		Network: &gophercloud.ServiceClient{
+			ProviderClient: &gophercloud.ProviderClient{},
+			Endpoint:       server.URL + "/",
+			ResourceBase:   server.URL + "/v2.0/",
+		},
+		ExtNetID: testExtNetID,
+	}
+	return client, server
+}
+
+func newReconcileNetwork() *drivertypes.Network {
+	return &drivertypes.Network{
+		Name:     testNetworkName,
+		TenantID: "123",
+		Subnets: []*drivertypes.Subnet{{
+			Name:    testNetworkName + "-subnet",
+			Cidr:    "10.244.0.0/16",
+			Gateway: "10.244.0.1",
+		}},
+	}
+}
+
+// driftSummary describes a drift by its resource and whether it is repaired.
+type driftSummary struct {
+	resource string
+	repaired bool
+}
+
+func TestReconcileNetwork(t *testing.T) {
+	testCases := []struct {
+		testName         string
+		updateFn         func(neutron *fakeNeutron, network *drivertypes.Network)
+		expectedDrifts   []driftSummary
+		expectedRequests []string
+	}{
+		{
+			testName: "network in sync",
+			updateFn: func(neutron *fakeNeutron, network *drivertypes.Network) {},
+		},
+		{
+			testName: "network not found,recreate failed",
+			updateFn: func(neutron *fakeNeutron, network *drivertypes.Network) {
+				neutron.networks = nil
+			},
+			expectedDrifts:   []driftSummary{{drivertypes.DriftResourceNetwork, false}},
+			expectedRequests: []string{"POST /v2.0/networks"},
+		},
+		{
+			testName: "network type changed,not repaired",
+			updateFn: func(neutron *fakeNeutron, network *drivertypes.Network) {
+				network.NetworkType = "vlan"
+				network.PhysicalNetwork = "physnet1"
+			},
+			expectedDrifts: []driftSummary{{drivertypes.DriftResourceNetwork, false}},
+		},
+		{
+			testName: "router deleted,router and interface recreated",
+			updateFn: func(neutron *fakeNeutron, network *drivertypes.Network) {
+				neutron.routers = nil
+				neutron.ports = nil
+			},
+			expectedDrifts: []driftSummary{
+				{drivertypes.DriftResourceRouter, true},
+				{drivertypes.DriftResourceRouterInterface, true},
+			},
+			expectedRequests: []string{"POST /v2.0/routers", "PUT /v2.0/routers/router-1/add_router_interface"},
+		},
+		{
+			testName: "external gateway changed,repaired",
+			updateFn: func(neutron *fakeNeutron, network *drivertypes.Network) {
+				neutron.routers[0]["external_gateway_info"] = map[string]interface{}{"network_id": "other-net"}
+			},
+			expectedDrifts:   []driftSummary{{drivertypes.DriftResourceRouter, true}},
+			expectedRequests: []string{"PUT /v2.0/routers/" + testRouterID},
+		},
+		{
+			testName: "existing router not found,not repaired",
+			updateFn: func(neutron *fakeNeutron, network *drivertypes.Network) {
+				network.RouterID = "router-2"
+			},
+			expectedDrifts: []driftSummary{{drivertypes.DriftResourceRouter, false}},
+		},
+		{
+			testName: "router interface removed,reconnected",
+			updateFn: func(neutron *fakeNeutron, network *drivertypes.Network) {
+				neutron.ports = nil
+			},
+			expectedDrifts:   []driftSummary{{drivertypes.DriftResourceRouterInterface, true}},
+			expectedRequests: []string{"PUT /v2.0/routers/" + testRouterID + "/add_router_interface"},
+		},
+		{
+			testName: "isolated network,router not checked",
+			updateFn: func(neutron *fakeNeutron, network *drivertypes.Network) {
+				neutron.routers = nil
+				neutron.ports = nil
+				network.NoRouter = true
+			},
+		},
+		{
+			testName: "subnet CIDR changed,repair failed",
+			updateFn: func(neutron *fakeNeutron, network *drivertypes.Network) {
+				neutron.subnets[0]["cidr"] = "10.0.0.0/24"
+			},
+			expectedDrifts: []driftSummary{{drivertypes.DriftResourceSubnet, false}},
+		},
+	}
+
+	for tci, tc := range testCases {
+		neutron := newFakeNeutron()
+		network := newReconcileNetwork()
+		tc.updateFn(neutron, network)
+		client, server := newReconcileClient(neutron)
+
+		drifts, err := client.ReconcileNetwork(network)
+		server.Close()
+		if err != nil {
+			t.Errorf("Case[%d]: %s expected no error, got %v", tci, tc.testName, err)
+			continue
+		}
+
+		var summaries []driftSummary
+		for _, drift := range drifts {
+			summaries = append(summaries, driftSummary{drift.Resource, drift.Repaired})
+		}
+		if fmt.Sprint(summaries) != fmt.Sprint(tc.expectedDrifts) {
+			t.Errorf("Case[%d]: %s expected drifts %v, got %v", tci, tc.testName, tc.expectedDrifts, drifts)
+		}
+		for _, request := range tc.expectedRequests {
+			if !strings.Contains(strings.Join(neutron.requests, ","), request) {
+				t.Errorf("Case[%d]: %s expected request %q, got %v", tci, tc.testName, request, neutron.requests)
+			}
+		}
+		if tc.expectedRequests == nil && len(drifts) == 0 && len(neutron.requests) != 0 {
+			t.Errorf("Case[%d]: %s expected no changes, got %v", tci, tc.testName, neutron.requests)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright (c) 2017 OpenStack Foundation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+
+	drivertypes "git.openstack.org/openstack/stackube/pkg/openstack/types"
+
+	"github.com/golang/glog"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/layer3/routers"
+)
+
+// getNetworkRouter gets the router which the network should be connected to:
+// nil for isolated networks, the existing router given by RouterID, or the
+// dedicated router named after the network. Nil is returned if the router is
+// not found.
+func (os *Client) getNetworkRouter(network *drivertypes.Network) (*routers.Router, error) {
+	if network.NoRouter {
+		return nil, nil
+	}
+
+	if network.RouterID != "" {
+		router, err := routers.Get(os.Network, network.RouterID).Extract()
+		if err != nil {
+			if isNotFound(err) {
+				return nil, nil
+			}
+			glog.Errorf("Get openstack router %s error: %v", network.RouterID, err)
+			return nil, err
+		}
+		return router, nil
+	}
+
+	return os.getRouterByName(network.Name)
+}
+
+// createRouter creates the dedicated router of the network.
+func (os *Client) createRouter(network *drivertypes.Network) (*routers.Router, error) {
+	opts := routerCreateOpts{
+		// use network name as router name for convenience
+		Name:        network.Name,
+		TenantID:    network.TenantID,
+		GatewayInfo: os.externalGateway(network),
+	}
+	return routers.Create(os.Network, opts).Extract()
+}
+
+// externalGateway returns the desired external gateway of the dedicated router
+// of the network. SNAT is left to Neutron's default unless it is disabled.
+func (os *Client) externalGateway(network *drivertypes.Network) gatewayInfo {
+	gateway := gatewayInfo{NetworkID: os.ExtNetID}
+	if network.ExtNetID != "" {
+		gateway.NetworkID = network.ExtNetID
+	}
+	if network.DisableSNAT {
+		enableSNAT := false
+		gateway.EnableSNAT = &enableSNAT
+	}
+	return gateway
+}
+
+// checkRouterGateway compares the external gateway of the dedicated router with
+// the desired one, and returns the difference, which is empty if they are the same.
+func (os *Client) checkRouterGateway(network *drivertypes.Network, routerID string) (string, error) {
+	var result struct {
+		Router struct {
+			GatewayInfo *gatewayInfo `json:"external_gateway_info"`
+		} `json:"router"`
+	}
+	if err := routers.Get(os.Network, routerID).ExtractInto(&result); err != nil {
+		glog.Errorf("Get openstack router %s error: %v", routerID, err)
+		return "", err
+	}
+
+	actual := result.Router.GatewayInfo
+	if actual == nil {
+		actual = &gatewayInfo{}
+	}
+	desired := os.externalGateway(network)
+	if actual.NetworkID != desired.NetworkID {
+		return fmt.Sprintf("external gateway of router %s is %q instead of %q",
+			network.Name, actual.NetworkID, desired.NetworkID), nil
+	}
+	if actual.EnableSNAT != nil && *actual.EnableSNAT == network.DisableSNAT {
+		return fmt.Sprintf("SNAT of router %s is %v instead of %v",
+			network.Name, *actual.EnableSNAT, !network.DisableSNAT), nil
+	}
+
+	return "", nil
+}
+
+// updateRouterGateway updates the external gateway of the dedicated router to
+// the desired one.
+func (os *Client) updateRouterGateway(network *drivertypes.Network, routerID string) error {
+	opts := routerGatewayUpdateOpts{GatewayInfo: os.externalGateway(network)}
+	if _, err := routers.Update(os.Network, routerID, opts).Extract(); err != nil {
+		glog.Errorf("Update external gateway of router %s failed: %v", network.Name, err)
+		return err
+	}
+
+	return nil
+}
+
+// gatewayInfo is the external gateway of a router, routers.GatewayInfo has
+// no SNAT option.
+type gatewayInfo struct {
+	NetworkID  string `json:"network_id"`
+	EnableSNAT *bool  `json:"enable_snat,omitempty"`
+}
+
+func (info gatewayInfo) toMap() map[string]interface{} {
+	result := map[string]interface{}{
+		"network_id": info.NetworkID,
+	}
+	if info.EnableSNAT != nil {
+		result["enable_snat"] = *info.EnableSNAT
+	}
+	return result
+}
+
+// routerCreateOpts creates a router with SNAT option of the external gateway.
+type routerCreateOpts struct {
+	Name        string
+	TenantID    string
+	GatewayInfo gatewayInfo
+}
+
+// ToRouterCreateMap builds a create body based on routerCreateOpts.
+func (opts routerCreateOpts) ToRouterCreateMap() (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"router": map[string]interface{}{
+			"name":                  opts.Name,
+			"tenant_id":             opts.TenantID,
+			"external_gateway_info": opts.GatewayInfo.toMap(),
+		},
+	}, nil
+}
+
+// routerGatewayUpdateOpts updates the external gateway of a router only,
+// routers.UpdateOpts would clear the routes of router.
+type routerGatewayUpdateOpts struct {
+	GatewayInfo gatewayInfo
+}
+
+// ToRouterUpdateMap builds an update body based on routerGatewayUpdateOpts.
+func (opts routerGatewayUpdateOpts) ToRouterUpdateMap() (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"router": map[string]interface{}{
+			"external_gateway_info": opts.GatewayInfo.toMap(),
+		},
+	}, nil
+}
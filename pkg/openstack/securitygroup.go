@@ -0,0 +1,301 @@
+/*
+Copyright (c) 2017 OpenStack Foundation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	crv1 "git.openstack.org/openstack/stackube/pkg/apis/v1"
+	drivertypes "git.openstack.org/openstack/stackube/pkg/openstack/types"
+
+	"github.com/golang/glog"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/security/groups"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/extensions/security/rules"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"github.com/gophercloud/gophercloud/pagination"
+)
+
+// AllowAllRules returns the rules allowing all IPv4 and IPv6 traffic in direction.
+func AllowAllRules(direction string) []*drivertypes.SecurityGroupRule {
+	return []*drivertypes.SecurityGroupRule{
+		{Direction: direction, EtherType: string(rules.EtherType4)},
+		{Direction: direction, EtherType: string(rules.EtherType6)},
+	}
+}
+
+// NewSecurityGroupRules translates the rules of direction declared by tenant
+// spec to security group rules. All traffic of direction is allowed if specRules
+// is nil, while an empty one allows nothing.
+func NewSecurityGroupRules(direction string, specRules []crv1.SecurityGroupRule) ([]*drivertypes.SecurityGroupRule, error) {
+	if specRules == nil {
+		return AllowAllRules(direction), nil
+	}
+
+	sgRules := []*drivertypes.SecurityGroupRule{}
+	for _, rule := range specRules {
+		translated, err := newSecurityGroupRule(direction, rule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s rule %+v: %v", direction, rule, err)
+		}
+		sgRules = append(sgRules, translated...)
+	}
+	return sgRules, nil
+}
+
+// newSecurityGroupRule validates and translates a security group rule.
+// Rules without CIDR are translated for both IPv4 and IPv6.
+func newSecurityGroupRule(direction string, rule crv1.SecurityGroupRule) ([]*drivertypes.SecurityGroupRule, error) {
+	etherTypes := []string{string(rules.EtherType4), string(rules.EtherType6)}
+	cidr := rule.CIDR
+	if cidr != "" {
+		ip, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %v", cidr, err)
+		}
+		cidr = ipNet.String()
+		if ip.To4() != nil {
+			etherTypes = []string{string(rules.EtherType4)}
+		} else {
+			etherTypes = []string{string(rules.EtherType6)}
+		}
+	}
+
+	protocol := strings.ToLower(rule.Protocol)
+	switch protocol {
+	case "", string(rules.ProtocolTCP), string(rules.ProtocolUDP), string(rules.ProtocolICMP):
+	default:
+		return nil, fmt.Errorf("unsupported protocol %q", rule.Protocol)
+	}
+
+	portMin, portMax := rule.PortRangeMin, rule.PortRangeMax
+	if portMin != 0 || portMax != 0 {
+		if protocol != string(rules.ProtocolTCP) && protocol != string(rules.ProtocolUDP) {
+			return nil, fmt.Errorf("port range requires protocol tcp or udp")
+		}
+		if portMax == 0 {
+			portMax = portMin
+		}
+		if portMin < 1 || portMax > 65535 || portMin > portMax {
+			return nil, fmt.Errorf("invalid port range %d-%d", rule.PortRangeMin, rule.PortRangeMax)
+		}
+	}
+
+	var sgRules []*drivertypes.SecurityGroupRule
+	for _, etherType := range etherTypes {
+		sgRules = append(sgRules, &drivertypes.SecurityGroupRule{
+			Direction:      direction,
+			EtherType:      etherType,
+			Protocol:       protocol,
+			PortRangeMin:   portMin,
+			PortRangeMax:   portMax,
+			RemoteIPPrefix: cidr,
+		})
+	}
+	return sgRules, nil
+}
+
+// EnsureDefaultSecurityGroup ensures the default security group of the tenant,
+// which is attached to pod ports by default, and returns its ID. The group is
+// converged to rules, or created with all traffic allowed if rules is nil.
+func (os *Client) EnsureDefaultSecurityGroup(tenantID string, sgRules []*drivertypes.SecurityGroupRule) (string, error) {
+	if sgRules == nil {
+		group, err := os.getSecurityGroupByName(tenantID, securitygroupName)
+		if err == nil {
+			return group.ID, nil
+		} else if err != ErrNotFound {
+			return "", err
+		}
+		sgRules = append(AllowAllRules(string(rules.DirIngress)), AllowAllRules(string(rules.DirEgress))...)
+	}
+
+	return os.EnsureSecurityGroup(&drivertypes.SecurityGroup{
+		Name:     securitygroupName,
+		TenantID: tenantID,
+		Rules:    sgRules,
+	})
+}
+
+// EnsureSecurityGroup ensures the security group exists with exactly the
+// desired rules, missing rules are added and others are removed. The ID of
+// the security group is returned.
+func (os *Client) EnsureSecurityGroup(sg *drivertypes.SecurityGroup) (string, error) {
+	group, err := os.getSecurityGroupByName(sg.TenantID, sg.Name)
+	if err == ErrNotFound {
+		group, err = groups.Create(os.Network, groups.CreateOpts{
+			Name:        sg.Name,
+			TenantID:    sg.TenantID,
+			Description: sg.Description,
+		}).Extract()
+		if err != nil {
+			glog.Errorf("Create security group %s failed: %v", sg.Name, err)
+			return "", err
+		}
+	} else if err != nil {
+		return "", err
+	}
+
+	desired := make(map[drivertypes.SecurityGroupRule]bool)
+	for _, rule := range sg.Rules {
+		desired[*rule] = true
+	}
+
+	// Remove the rules not desired, including those created by Neutron along
+	// with the group.
+	for _, r := range group.Rules {
+		rule := toDriverSecurityGroupRule(&r)
+		if desired[*rule] {
+			delete(desired, *rule)
+			continue
+		}
+		if err := rules.Delete(os.Network, r.ID).ExtractErr(); err != nil && !isNotFound(err) {
+			glog.Errorf("Delete rule %s of security group %s failed: %v", r.ID, sg.Name, err)
+			return "", err
+		}
+	}
+
+	// Add the missing rules.
+	for rule := range desired {
+		_, err := rules.Create(os.Network, rules.CreateOpts{
+			Direction:      rules.RuleDirection(rule.Direction),
+			EtherType:      rules.RuleEtherType(rule.EtherType),
+			SecGroupID:     group.ID,
+			PortRangeMin:   rule.PortRangeMin,
+			PortRangeMax:   rule.PortRangeMax,
+			Protocol:       rules.RuleProtocol(rule.Protocol),
+			RemoteGroupID:  rule.RemoteGroupID,
+			RemoteIPPrefix: rule.RemoteIPPrefix,
+			TenantID:       sg.TenantID,
+		}).Extract()
+		if err != nil {
+			glog.Errorf("Create rule %+v of security group %s failed: %v", rule, sg.Name, err)
+			return "", err
+		}
+	}
+
+	return group.ID, nil
+}
+
+// ListSecurityGroups lists security groups of the tenant, without their rules.
+func (os *Client) ListSecurityGroups(tenantID string) ([]*drivertypes.SecurityGroup, error) {
+	var results []*drivertypes.SecurityGroup
+	err := groups.List(os.Network, groups.ListOpts{TenantID: tenantID}).EachPage(func(page pagination.Page) (bool, error) {
+		groupList, err := groups.ExtractGroups(page)
+		if err != nil {
+			return false, err
+		}
+		for _, group := range groupList {
+			results = append(results, &drivertypes.SecurityGroup{
+				Uid:         group.ID,
+				Name:        group.Name,
+				Description: group.Description,
+				TenantID:    group.TenantID,
+			})
+		}
+		return true, nil
+	})
+	if err != nil {
+		glog.Errorf("List security groups of tenant %s failed: %v", tenantID, err)
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// DeleteSecurityGroup deletes the security group by groupID.
+func (os *Client) DeleteSecurityGroup(groupID string) error {
+	err := groups.Delete(os.Network, groupID).ExtractErr()
+	if err != nil && !isNotFound(err) {
+		glog.Errorf("Delete security group %s failed: %v", groupID, err)
+		return err
+	}
+
+	return nil
+}
+
+// UpdatePortSecurityGroups replaces security groups of the port with groupIDs.
+func (os *Client) UpdatePortSecurityGroups(portID string, groupIDs []string) error {
+	_, err := ports.Update(os.Network, portID, portSecurityGroupsUpdateOpts{SecurityGroups: groupIDs}).Extract()
+	if err != nil {
+		glog.Errorf("Update security groups of port %s failed: %v", portID, err)
+		return err
+	}
+
+	return nil
+}
+
+func (os *Client) getSecurityGroupByName(tenantID, name string) (*groups.SecGroup, error) {
+	var results []groups.SecGroup
+	opts := groups.ListOpts{
+		TenantID: tenantID,
+		Name:     name,
+	}
+	err := groups.List(os.Network, opts).EachPage(func(page pagination.Page) (bool, error) {
+		groupList, err := groups.ExtractGroups(page)
+		if err != nil {
+			return false, err
+		}
+		results = append(results, groupList...)
+		return true, nil
+	})
+	if err != nil {
+		glog.Errorf("Get security group %s failed: %v", name, err)
+		return nil, err
+	}
+
+	switch len(results) {
+	case 0:
+		return nil, ErrNotFound
+	case 1:
+		return &results[0], nil
+	default:
+		return nil, fmt.Errorf("%d security groups named %s found: %v", len(results), name, ErrMultipleResults)
+	}
+}
+
+// toDriverSecurityGroupRule translates Neutron security group rule to driver's.
+func toDriverSecurityGroupRule(r *rules.SecGroupRule) *drivertypes.SecurityGroupRule {
+	return &drivertypes.SecurityGroupRule{
+		Direction:      r.Direction,
+		EtherType:      r.EtherType,
+		Protocol:       r.Protocol,
+		PortRangeMin:   r.PortRangeMin,
+		PortRangeMax:   r.PortRangeMax,
+		RemoteIPPrefix: r.RemoteIPPrefix,
+		RemoteGroupID:  r.RemoteGroupID,
+	}
+}
+
+// portSecurityGroupsUpdateOpts updates security groups of a port only,
+// ports.UpdateOpts would also reset other attributes of the port.
+type portSecurityGroupsUpdateOpts struct {
+	SecurityGroups []string
+}
+
+// ToPortUpdateMap builds an update body based on portSecurityGroupsUpdateOpts.
+func (opts portSecurityGroupsUpdateOpts) ToPortUpdateMap() (map[string]interface{}, error) {
+	securityGroups := opts.SecurityGroups
+	if securityGroups == nil {
+		securityGroups = []string{}
+	}
+	return map[string]interface{}{
+		"port": map[string]interface{}{
+			"security_groups": securityGroups,
+		},
+	}, nil
+}
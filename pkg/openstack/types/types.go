@@ -17,14 +17,30 @@ limitations under the License.
 package types
 
 type Network struct {
-	Name      string
-	Uid       string
-	TenantID  string
-	SegmentID int32
-	Subnets   []*Subnet
+	Name     string
+	Uid      string
+	TenantID string
+	// NetworkType, PhysicalNetwork and SegmentID are the provider attributes
+	// of the network. Neutron's defaults are used if they are empty.
+	NetworkType     string
+	PhysicalNetwork string
+	SegmentID       int32
+	Subnets         []*Subnet
 	// Status of network
 	// Valid value: Initializing, Active, Pending, Failed, Terminating
 	Status string
+	// NoRouter makes the network isolated, it is not connected to any router.
+	NoRouter bool
+	// RouterID is an existing router which the network is connected to,
+	// instead of a dedicated router named after the network.
+	RouterID string
+	// DisableSNAT turns off SNAT on the external gateway of dedicated router.
+	DisableSNAT bool
+	// ExtNetID is the external network of dedicated router, the global one
+	// is used if it is empty.
+	ExtNetID string
+	// MTU of the network reported by Neutron, zero if it is unknown.
+	MTU int
 }
 
 // Subnet is a representaion of a subnet
@@ -36,6 +52,14 @@ type Subnet struct {
 	Tenantid   string
 	Dnsservers []string
 	Routes     []*Route
+	// AllocationPools of the subnet, all addresses except gateway are
+	// allocatable if it is empty.
+	AllocationPools []*AllocationPool
+	// IPVersion of the subnet, 4 or 6.
+	IPVersion int
+	// IPv6AddressMode of the subnet, valid value: slaac, dhcpv6-stateful,
+	// dhcpv6-stateless. Only used by IPv6 subnets.
+	IPv6AddressMode string
 }
 
 // Route is a representation of an advanced routing rule.
@@ -44,3 +68,75 @@ type Route struct {
 	Nexthop         string
 	DestinationCIDR string
 }
+
+// AllocationPool is a representation of an IP allocation range of a subnet.
+type AllocationPool struct {
+	Start string
+	End   string
+}
+
+// Resources which may drift from the desired network.
+const (
+	DriftResourceNetwork         = "Network"
+	DriftResourceSubnet          = "Subnet"
+	DriftResourceRouter          = "Router"
+	DriftResourceRouterInterface = "RouterInterface"
+)
+
+// Drift is a difference found between the desired network and Neutron.
+type Drift struct {
+	// Resource which drifts, e.g. Network, Subnet, Router, RouterInterface.
+	Resource string
+	// Message describes the drift precisely.
+	Message string
+	// Repaired is true if the drift has been fixed.
+	Repaired bool
+}
+
+// SecurityGroup is a representation of a security group.
+type SecurityGroup struct {
+	Uid         string
+	Name        string
+	Description string
+	TenantID    string
+	Rules       []*SecurityGroupRule
+}
+
+// SecurityGroupRule is a representation of a security group rule.
+type SecurityGroupRule struct {
+	// Direction of the rule, ingress or egress.
+	Direction string
+	// EtherType of the rule, IPv4 or IPv6.
+	EtherType string
+	// Protocol of the rule, e.g. tcp, udp. Empty means any protocol.
+	Protocol string
+	// PortRangeMin and PortRangeMax are the port range of tcp or udp rules,
+	// zero means any port.
+	PortRangeMin int
+	PortRangeMax int
+	// RemoteIPPrefix is the CIDR of the remote peers, empty means any peer.
+	RemoteIPPrefix string
+	// RemoteGroupID is the security group of the remote peers.
+	RemoteGroupID string
+}
+
+// FloatingIP is a representation of a floating IP.
+type FloatingIP struct {
+	ID string
+	// FloatingIP is the address of the floating IP.
+	FloatingIP        string
+	FloatingNetworkID string
+	// PortID and FixedIP are the port and its address which the floating IP
+	// is associated with, they are empty if it is not associated.
+	PortID   string
+	FixedIP  string
+	TenantID string
+}
+
+// BandwidthLimit is the bandwidth limit of a port in kbps, zero means unlimited.
+type BandwidthLimit struct {
+	// IngressKbps limits traffic to the port.
+	IngressKbps int64
+	// EgressKbps limits traffic from the port.
+	EgressKbps int64
+}
@@ -0,0 +1,267 @@
+/*
+Copyright (c) 2017 OpenStack Foundation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	informersV1 "k8s.io/client-go/informers/core/v1"
+	networkinginformers "k8s.io/client-go/informers/networking/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"git.openstack.org/openstack/stackube/pkg/openstack"
+)
+
+const (
+	resyncPeriod = 5 * time.Minute
+
+	// How long to wait before retrying the processing of a namespace change.
+	minRetryDelay = 5 * time.Second
+	maxRetryDelay = 300 * time.Second
+)
+
+// PolicyController translates Kubernetes NetworkPolicies into Neutron security
+// groups. Each policy gets a security group with its ingress rules, and the
+// group is attached to all ports of pods selected by the policy in place of the
+// tenant's default security group. Namespaces are synced as a whole, so that
+// ports always get all the groups of the policies selecting them.
+type PolicyController struct {
+	kubeClient kubernetes.Interface
+	driver     openstack.Interface
+
+	factory           informers.SharedInformerFactory
+	policyInformer    networkinginformers.NetworkPolicyInformer
+	podInformer       informersV1.PodInformer
+	namespaceInformer informersV1.NamespaceInformer
+
+	// namespaces that need to be synced
+	queue workqueue.RateLimitingInterface
+
+	// tenantIDs caches tenant IDs of namespaces, so that resyncs don't look
+	// them up again. They are forgotten once namespaces are deleted.
+	tenantIDsLock sync.Mutex
+	tenantIDs     map[string]string
+}
+
+// NewPolicyController creates a new PolicyController.
+func NewPolicyController(kubeClient kubernetes.Interface, osClient openstack.Interface) (*PolicyController, error) {
+	factory := informers.NewSharedInformerFactory(kubeClient, resyncPeriod)
+	c := &PolicyController{
+		kubeClient:        kubeClient,
+		driver:            osClient,
+		factory:           factory,
+		policyInformer:    factory.Networking().V1().NetworkPolicies(),
+		podInformer:       factory.Core().V1().Pods(),
+		namespaceInformer: factory.Core().V1().Namespaces(),
+		queue: workqueue.NewNamedRateLimitingQueue(
+			workqueue.NewItemExponentialFailureRateLimiter(minRetryDelay, maxRetryDelay), "policy"),
+		tenantIDs: make(map[string]string),
+	}
+
+	c.policyInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueuePolicy,
+		UpdateFunc: func(old, cur interface{}) {
+			c.enqueuePolicy(cur)
+		},
+		DeleteFunc: c.enqueuePolicy,
+	})
+	c.podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.onPodChanged,
+		UpdateFunc: func(old, cur interface{}) {
+			oldPod, ok1 := old.(*v1.Pod)
+			curPod, ok2 := cur.(*v1.Pod)
+			if ok1 && ok2 && !podNeedsSync(oldPod, curPod) {
+				return
+			}
+			c.onPodChanged(cur)
+		},
+		DeleteFunc: c.onPodChanged,
+	})
+	c.namespaceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.onNamespaceChanged,
+		UpdateFunc: func(old, cur interface{}) {
+			oldNamespace, ok1 := old.(*v1.Namespace)
+			curNamespace, ok2 := cur.(*v1.Namespace)
+			if ok1 && ok2 && reflect.DeepEqual(oldNamespace.Labels, curNamespace.Labels) {
+				return
+			}
+			c.onNamespaceChanged(cur)
+		},
+		DeleteFunc: c.onNamespaceDeleted,
+	})
+
+	return c, nil
+}
+
+// Run starts the informers and workers, and blocks until stopCh is closed.
+func (c *PolicyController) Run(stopCh <-chan struct{}) error {
+	defer utilruntime.HandleCrash()
+	defer c.queue.ShutDown()
+
+	glog.Info("Starting network policy controller")
+	defer glog.Info("Shutting down network policy controller")
+
+	go c.factory.Start(stopCh)
+
+	if !cache.WaitForCacheSync(stopCh, c.policyInformer.Informer().HasSynced,
+		c.podInformer.Informer().HasSynced, c.namespaceInformer.Informer().HasSynced) {
+		return fmt.Errorf("failed to cache network policies, pods and namespaces")
+	}
+
+	go wait.Until(c.worker, time.Second, stopCh)
+
+	<-stopCh
+	return nil
+}
+
+// enqueuePolicy enqueues the namespace of network policy. obj could be an
+// *networkingv1.NetworkPolicy, or a DeletionFinalStateUnknown marker item.
+func (c *PolicyController) enqueuePolicy(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		glog.Errorf("Couldn't get key for object %#v: %v", obj, err)
+		return
+	}
+	namespace, _, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		glog.Errorf("Couldn't split key %q: %v", key, err)
+		return
+	}
+	c.queue.Add(namespace)
+}
+
+// onPodChanged enqueues the namespace of pod, and the namespaces whose
+// policies may select the pod by namespaceSelector.
+func (c *PolicyController) onPodChanged(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		glog.Errorf("Couldn't get key for object %#v: %v", obj, err)
+		return
+	}
+	namespace, _, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		glog.Errorf("Couldn't split key %q: %v", key, err)
+		return
+	}
+	c.queue.Add(namespace)
+	c.enqueueNamespaceSelectorPolicies()
+}
+
+// onNamespaceChanged enqueues the namespaces whose policies select peers by
+// namespaceSelector.
+func (c *PolicyController) onNamespaceChanged(obj interface{}) {
+	c.enqueueNamespaceSelectorPolicies()
+}
+
+// onNamespaceDeleted forgets the tenant ID of the namespace, and enqueues the
+// namespaces whose policies select peers by namespaceSelector.
+func (c *PolicyController) onNamespaceDeleted(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		glog.Errorf("Couldn't get key for object %#v: %v", obj, err)
+	} else {
+		c.tenantIDsLock.Lock()
+		delete(c.tenantIDs, key)
+		c.tenantIDsLock.Unlock()
+	}
+	c.onNamespaceChanged(obj)
+}
+
+// getTenantID gets tenant ID of the namespace, which is cached once found.
+func (c *PolicyController) getTenantID(namespace string) (string, error) {
+	c.tenantIDsLock.Lock()
+	defer c.tenantIDsLock.Unlock()
+	if tenantID, ok := c.tenantIDs[namespace]; ok {
+		return tenantID, nil
+	}
+
+	tenantID, err := c.driver.GetTenantIDFromName(namespace)
+	if err != nil || tenantID == "" {
+		return "", err
+	}
+	c.tenantIDs[namespace] = tenantID
+	return tenantID, nil
+}
+
+func (c *PolicyController) enqueueNamespaceSelectorPolicies() {
+	policies, err := c.policyInformer.Lister().List(labels.Everything())
+	if err != nil {
+		glog.Errorf("List network policies failed: %v", err)
+		return
+	}
+	for _, policy := range policies {
+		if hasNamespaceSelector(policy) {
+			c.queue.Add(policy.Namespace)
+		}
+	}
+}
+
+// worker runs a worker thread that just dequeues items, processes them, and marks them done.
+// It enforces that the syncNamespace is never invoked concurrently with the same key.
+func (c *PolicyController) worker() {
+	for c.processNextWorkItem() {
+	}
+}
+
+func (c *PolicyController) processNextWorkItem() bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	err := c.syncNamespace(key.(string))
+	if err == nil {
+		c.queue.Forget(key)
+		return true
+	}
+
+	glog.Errorf("Failed to sync network policies of namespace %q (retried %d times), will retry: %v", key, c.queue.NumRequeues(key), err)
+	c.queue.AddRateLimited(key)
+	return true
+}
+
+// podNeedsSync checks whether changes of the pod may affect security groups.
+func podNeedsSync(oldPod, curPod *v1.Pod) bool {
+	return oldPod.Status.PodIP != curPod.Status.PodIP ||
+		!reflect.DeepEqual(oldPod.Labels, curPod.Labels) ||
+		oldPod.ResourceVersion == curPod.ResourceVersion
+}
+
+// hasNamespaceSelector checks whether the policy selects peers by namespaceSelector.
+func hasNamespaceSelector(policy *networkingv1.NetworkPolicy) bool {
+	for _, rule := range policy.Spec.Ingress {
+		for _, peer := range rule.From {
+			if peer.NamespaceSelector != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
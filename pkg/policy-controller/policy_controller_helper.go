@@ -0,0 +1,428 @@
+/*
+Copyright (c) 2017 OpenStack Foundation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	crv1 "git.openstack.org/openstack/stackube/pkg/apis/v1"
+	"git.openstack.org/openstack/stackube/pkg/openstack"
+	drivertypes "git.openstack.org/openstack/stackube/pkg/openstack/types"
+	"git.openstack.org/openstack/stackube/pkg/util"
+)
+
+const (
+	// policyDescriptionPrefix is the description prefix of security groups
+	// created for network policies, which are followed by namespace/name.
+	policyDescriptionPrefix = "stackube network policy "
+
+	directionIngress = "ingress"
+	directionEgress  = "egress"
+	etherTypeIPv4    = "IPv4"
+	etherTypeIPv6    = "IPv6"
+)
+
+// syncNamespace syncs network policies of the namespace to Neutron:
+// 1. Ensure a security group with translated rules for each policy
+// 2. Attach groups of the selecting policies to each pod port
+// 3. Delete security groups of the policies which are gone
+// Ports of pods not selected by any policy get the tenant's default group.
+func (c *PolicyController) syncNamespace(namespace string) error {
+	startTime := time.Now()
+	defer func() {
+		glog.V(4).Infof("Finished syncing network policies of namespace %q (%v)", namespace, time.Now().Sub(startTime))
+	}()
+
+	policies, err := c.policyInformer.Lister().NetworkPolicies(namespace).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	pods, err := c.podInformer.Lister().Pods(namespace).List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	tenantID, err := c.getTenantID(namespace)
+	if err != nil || tenantID == "" {
+		if _, nsErr := c.namespaceInformer.Lister().Get(namespace); apierrors.IsNotFound(nsErr) {
+			// The tenant is gone along with the namespace.
+			glog.V(4).Infof("Namespace %s is deleted, skip syncing its network policies", namespace)
+			return nil
+		}
+		return fmt.Errorf("failed to fetch tenantID for tenantName: %v, error: %v", namespace, err)
+	}
+
+	// Policy groups replace the tenant's default group on pod ports, so they
+	// carry the egress rules declared by the tenant.
+	egressRules, err := c.tenantEgressRules(namespace)
+	if err != nil {
+		return err
+	}
+
+	// Ensure security groups of policies, and collect groups of the pods
+	// selected by them.
+	groupIDs := make(map[string]string)
+	podGroupIDs := make(map[string][]string)
+	for _, policy := range policies {
+		var selected []*v1.Pod
+		selector, err := metav1.LabelSelectorAsSelector(&policy.Spec.PodSelector)
+		if err != nil {
+			glog.Warningf("Invalid podSelector of network policy %s/%s: %v", policy.Namespace, policy.Name, err)
+		} else {
+			for _, pod := range pods {
+				if !pod.Spec.HostNetwork && selector.Matches(labels.Set(pod.Labels)) {
+					selected = append(selected, pod)
+				}
+			}
+		}
+
+		sg, err := c.buildSecurityGroup(policy, tenantID, selected, egressRules)
+		if err != nil {
+			return err
+		}
+		groupID, err := c.driver.EnsureSecurityGroup(sg)
+		if err != nil {
+			return fmt.Errorf("ensure security group %s failed: %v", sg.Name, err)
+		}
+		groupIDs[policy.Name] = groupID
+		for _, pod := range selected {
+			podGroupIDs[pod.Name] = append(podGroupIDs[pod.Name], groupID)
+		}
+	}
+
+	// Attach security groups to pod ports, including those of additional
+	// interfaces. Ports are listed once for the whole namespace.
+	podPorts, err := c.listPodPorts(tenantID)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	var defaultGroupID string
+	for _, pod := range pods {
+		if pod.Spec.HostNetwork {
+			continue
+		}
+
+		desired := podGroupIDs[pod.Name]
+		if len(desired) == 0 {
+			if defaultGroupID == "" {
+				defaultGroupID, err = c.driver.EnsureDefaultSecurityGroup(tenantID, nil)
+				if err != nil {
+					return fmt.Errorf("ensure default security group of tenant %s failed: %v", tenantID, err)
+				}
+			}
+			desired = []string{defaultGroupID}
+		}
+
+		// Ports not created yet are synced once the pod gets IP.
+		portList := podPorts[util.BuildPortName(pod.Namespace, pod.Name)]
+		for i := range portList {
+			if err := c.updatePodPort(pod, &portList[i], desired); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	// Delete security groups of policies which are gone.
+	groups, err := c.driver.ListSecurityGroups(tenantID)
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		if !isPolicyGroupOf(group, namespace) {
+			continue
+		}
+		if _, ok := groupIDs[policyNameOf(group)]; ok {
+			continue
+		}
+		glog.V(4).Infof("Deleting security group %s of removed network policy", group.Name)
+		if err := c.driver.DeleteSecurityGroup(group.Uid); err != nil {
+			errs = append(errs, fmt.Errorf("delete security group %s failed: %v", group.Name, err))
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// listPodPorts lists ports of the tenant created for pods, keyed by name of
+// the pod's primary port.
+func (c *PolicyController) listPodPorts(tenantID string) (map[string][]ports.Port, error) {
+	portList, err := c.driver.ListTenantPorts(tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("list ports of tenant %s failed: %v", tenantID, err)
+	}
+
+	results := make(map[string][]ports.Port)
+	for _, port := range portList {
+		if util.IsPodPortName(port.Name) {
+			primary := util.GetPrimaryPortName(port.Name)
+			results[primary] = append(results[primary], port)
+		}
+	}
+	return results, nil
+}
+
+// updatePodPort replaces security groups of the pod's port with groupIDs.
+func (c *PolicyController) updatePodPort(pod *v1.Pod, port *ports.Port, groupIDs []string) error {
+	if sets.NewString(port.SecurityGroups...).Equal(sets.NewString(groupIDs...)) {
+		return nil
+	}
+	if len(port.SecurityGroups) == 0 && util.IsPortSecurityDisabled(pod.Annotations) {
+		// Ports without port security can't have security groups.
+		glog.V(4).Infof("Port security of port %s is disabled, skip updating its security groups", port.Name)
+		return nil
+	}
+
+	glog.V(4).Infof("Updating security groups of port %s to %v", port.Name, groupIDs)
+	if err := c.driver.UpdatePortSecurityGroups(port.ID, groupIDs); err != nil {
+		return fmt.Errorf("update security groups of port %s failed: %v", port.Name, err)
+	}
+	return nil
+}
+
+// tenantEgressRules translates the egress rules declared by the tenant of the
+// namespace, all egress is allowed if the tenant declares none.
+func (c *PolicyController) tenantEgressRules(namespace string) ([]*drivertypes.SecurityGroupRule, error) {
+	var egress []crv1.SecurityGroupRule
+	tenant, err := c.driver.GetCRDClient().GetTenant(namespace)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("get tenant %s failed: %v", namespace, err)
+	}
+	if err == nil && tenant.Spec.SecurityGroup != nil {
+		egress = tenant.Spec.SecurityGroup.Egress
+	}
+
+	rules, err := openstack.NewSecurityGroupRules(directionEgress, egress)
+	if err != nil {
+		return nil, fmt.Errorf("invalid securityGroup of tenant %s: %v", namespace, err)
+	}
+	return rules, nil
+}
+
+// buildSecurityGroup translates network policy to security group, named ports
+// are resolved in the pods selected by the policy. Egress is translated from
+// egressRules, since the vendored networking/v1 API has neither egress rules
+// nor policy types.
+func (c *PolicyController) buildSecurityGroup(policy *networkingv1.NetworkPolicy, tenantID string, selected []*v1.Pod,
+	egressRules []*drivertypes.SecurityGroupRule) (*drivertypes.SecurityGroup, error) {
+	sg := &drivertypes.SecurityGroup{
+		Name:        util.BuildSecurityGroupName(policy.Namespace, policy.Name),
+		Description: policyDescriptionPrefix + policy.Namespace + "/" + policy.Name,
+		TenantID:    tenantID,
+	}
+	sg.Rules = append(sg.Rules, egressRules...)
+
+	for _, rule := range policy.Spec.Ingress {
+		policyPorts := resolveNamedPorts(rule.Ports, selected)
+		if len(rule.Ports) > 0 && len(policyPorts) == 0 {
+			// None of the named ports is found, which allows nothing.
+			continue
+		}
+		prefixes, err := c.resolvePeers(policy.Namespace, rule.From)
+		if err != nil {
+			return nil, err
+		}
+		sg.Rules = append(sg.Rules, buildIngressRules(policyPorts, prefixes)...)
+	}
+
+	return sg, nil
+}
+
+// resolvePeers resolves peers of network policy to IP prefixes of the selected
+// pods. An empty prefix is returned for any peer if peers is empty.
+func (c *PolicyController) resolvePeers(namespace string, peers []networkingv1.NetworkPolicyPeer) ([]string, error) {
+	if len(peers) == 0 {
+		return []string{""}, nil
+	}
+
+	prefixes := sets.NewString()
+	for _, peer := range peers {
+		var pods []*v1.Pod
+		switch {
+		case peer.PodSelector != nil:
+			selector, err := metav1.LabelSelectorAsSelector(peer.PodSelector)
+			if err != nil {
+				return nil, fmt.Errorf("invalid podSelector: %v", err)
+			}
+			pods, err = c.podInformer.Lister().Pods(namespace).List(selector)
+			if err != nil {
+				return nil, err
+			}
+		case peer.NamespaceSelector != nil:
+			selector, err := metav1.LabelSelectorAsSelector(peer.NamespaceSelector)
+			if err != nil {
+				return nil, fmt.Errorf("invalid namespaceSelector: %v", err)
+			}
+			namespaces, err := c.namespaceInformer.Lister().List(selector)
+			if err != nil {
+				return nil, err
+			}
+			for _, ns := range namespaces {
+				nsPods, err := c.podInformer.Lister().Pods(ns.Name).List(labels.Everything())
+				if err != nil {
+					return nil, err
+				}
+				pods = append(pods, nsPods...)
+			}
+		}
+
+		for _, pod := range pods {
+			if pod.Spec.HostNetwork || pod.Status.PodIP == "" {
+				continue
+			}
+			if prefix := ipPrefix(pod.Status.PodIP); prefix != "" {
+				prefixes.Insert(prefix)
+			}
+		}
+	}
+
+	return prefixes.List(), nil
+}
+
+// resolveNamedPorts replaces named ports with the numbers of container ports
+// of that name and protocol in pods. Pods sharing the security group may
+// number a named port differently, all of the numbers are allowed then. Named
+// ports not found in any pod are dropped.
+func resolveNamedPorts(policyPorts []networkingv1.NetworkPolicyPort, pods []*v1.Pod) []networkingv1.NetworkPolicyPort {
+	var results []networkingv1.NetworkPolicyPort
+	for _, port := range policyPorts {
+		if port.Port == nil || port.Port.Type != intstr.String {
+			results = append(results, port)
+			continue
+		}
+
+		protocol := v1.ProtocolTCP
+		if port.Protocol != nil {
+			protocol = *port.Protocol
+		}
+		numbers := sets.NewInt()
+		for _, pod := range pods {
+			for _, container := range pod.Spec.Containers {
+				for _, containerPort := range container.Ports {
+					containerProtocol := containerPort.Protocol
+					if containerProtocol == "" {
+						containerProtocol = v1.ProtocolTCP
+					}
+					if containerPort.Name == port.Port.StrVal && containerProtocol == protocol {
+						numbers.Insert(int(containerPort.ContainerPort))
+					}
+				}
+			}
+		}
+		if numbers.Len() == 0 {
+			glog.V(4).Infof("Named port %q is not found in pods selected by network policy", port.Port.StrVal)
+			continue
+		}
+
+		for _, number := range numbers.List() {
+			p, proto := intstr.FromInt(number), protocol
+			results = append(results, networkingv1.NetworkPolicyPort{Protocol: &proto, Port: &p})
+		}
+	}
+	return results
+}
+
+// buildIngressRules builds ingress rules allowing the ports from the IP prefixes.
+// Empty ports means all ports, and empty prefix means any peer.
+func buildIngressRules(ports []networkingv1.NetworkPolicyPort, prefixes []string) []*drivertypes.SecurityGroupRule {
+	var rules []*drivertypes.SecurityGroupRule
+	for _, prefix := range prefixes {
+		etherTypes := []string{etherTypeIPv4, etherTypeIPv6}
+		if prefix != "" {
+			etherTypes = []string{etherType(prefix)}
+		}
+
+		for _, et := range etherTypes {
+			if len(ports) == 0 {
+				rules = append(rules, &drivertypes.SecurityGroupRule{
+					Direction:      directionIngress,
+					EtherType:      et,
+					RemoteIPPrefix: prefix,
+				})
+				continue
+			}
+
+			for _, port := range ports {
+				rule := &drivertypes.SecurityGroupRule{
+					Direction:      directionIngress,
+					EtherType:      et,
+					Protocol:       strings.ToLower(string(v1.ProtocolTCP)),
+					RemoteIPPrefix: prefix,
+				}
+				if port.Protocol != nil {
+					rule.Protocol = strings.ToLower(string(*port.Protocol))
+				}
+				if port.Port != nil {
+					if port.Port.Type == intstr.String {
+						glog.Warningf("Named port %q is not supported in network policies, skip it", port.Port.StrVal)
+						continue
+					}
+					rule.PortRangeMin = int(port.Port.IntVal)
+					rule.PortRangeMax = int(port.Port.IntVal)
+				}
+				rules = append(rules, rule)
+			}
+		}
+	}
+
+	return rules
+}
+
+// isPolicyGroupOf checks whether the security group is created for network
+// policies of the namespace.
+func isPolicyGroupOf(group *drivertypes.SecurityGroup, namespace string) bool {
+	return strings.HasPrefix(group.Description, policyDescriptionPrefix+namespace+"/")
+}
+
+// policyNameOf gets the name of network policy which the security group is created for.
+func policyNameOf(group *drivertypes.SecurityGroup) string {
+	return group.Description[strings.LastIndex(group.Description, "/")+1:]
+}
+
+// ipPrefix returns the host prefix of the IP, e.g. 10.244.0.2/32.
+func ipPrefix(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if parsed.To4() != nil {
+		return ip + "/32"
+	}
+	return ip + "/128"
+}
+
+// etherType returns the ether type of the IP prefix.
+func etherType(prefix string) string {
+	if strings.Contains(prefix, ":") {
+		return etherTypeIPv6
+	}
+	return etherTypeIPv4
+}
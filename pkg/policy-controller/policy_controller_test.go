@@ -0,0 +1,458 @@
+/*
+Copyright (c) 2017 OpenStack Foundation.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	crv1 "git.openstack.org/openstack/stackube/pkg/apis/v1"
+	crdClient "git.openstack.org/openstack/stackube/pkg/kubecrd"
+	"git.openstack.org/openstack/stackube/pkg/openstack"
+	drivertypes "git.openstack.org/openstack/stackube/pkg/openstack/types"
+	"git.openstack.org/openstack/stackube/pkg/util"
+
+	"github.com/gophercloud/gophercloud/openstack/networking/v2/ports"
+	"k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	namespace = "test"
+	tenantID  = "123"
+	networkID = "456"
+)
+
+func newPod(name, ip string, labels map[string]string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Status: v1.PodStatus{
+			PodIP: ip,
+		},
+	}
+}
+
+func newPolicy(name string) *networkingv1.NetworkPolicy {
+	protocol := v1.ProtocolTCP
+	port := intstr.FromInt(5432)
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": "db"},
+			},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{
+					Ports: []networkingv1.NetworkPolicyPort{{Protocol: &protocol, Port: &port}},
+					From: []networkingv1.NetworkPolicyPeer{{
+						PodSelector: &metav1.LabelSelector{
+							MatchLabels: map[string]string{"app": "web"},
+						},
+					}},
+				},
+			},
+		},
+	}
+}
+
+func newPolicyController() (*PolicyController, *openstack.FakeOSClient, error) {
+	client := fake.NewSimpleClientset()
+	kubeCRDClient, err := crdClient.NewFake()
+	if err != nil {
+		return nil, nil, err
+	}
+	kubeCRDClient.SetTenants(&crv1.Tenant{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      namespace,
+			Namespace: namespace,
+		},
+		Spec: crv1.TenantSpec{
+			TenantID: tenantID,
+		},
+	})
+	osClient := openstack.NewFake(kubeCRDClient)
+
+	controller, err := NewPolicyController(client, osClient)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	namespaces := controller.namespaceInformer.Informer().GetIndexer()
+	namespaces.Add(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}})
+	pods := controller.podInformer.Informer().GetIndexer()
+	for _, pod := range []*v1.Pod{
+		newPod("db", "10.244.0.2", map[string]string{"app": "db"}),
+		newPod("web", "10.244.0.3", map[string]string{"app": "web"}),
+	} {
+		pods.Add(pod)
+		osClient.Ports[networkID] = append(osClient.Ports[networkID], ports.Port{
+			ID:        pod.Name,
+			Name:      util.BuildPortName(namespace, pod.Name),
+			NetworkID: networkID,
+			TenantID:  tenantID,
+		})
+	}
+
+	return controller, osClient, nil
+}
+
+func portSecurityGroups(osClient *openstack.FakeOSClient, portID string) []string {
+	for _, port := range osClient.Ports[networkID] {
+		if port.ID == portID {
+			return port.SecurityGroups
+		}
+	}
+	return nil
+}
+
+func TestSyncNamespace(t *testing.T) {
+	controller, osClient, err := newPolicyController()
+	if err != nil {
+		t.Fatalf("Failed start a new fake PolicyController: %v", err)
+	}
+	policy := newPolicy("db")
+	policies := controller.policyInformer.Informer().GetIndexer()
+
+	testCases := []struct {
+		testName   string
+		updateFn   func()
+		expectedFn func() error
+	}{
+		{
+			testName: "Add policy,selected pod isolated",
+			updateFn: func() {
+				policies.Add(policy)
+			},
+			expectedFn: func() error {
+				var group *drivertypes.SecurityGroup
+				for _, sg := range osClient.SecurityGroups {
+					if sg.Name == util.BuildSecurityGroupName(namespace, policy.Name) {
+						group = sg
+					}
+				}
+				if group == nil {
+					return fmt.Errorf("expected security group of policy to be created")
+				}
+				expected := &drivertypes.SecurityGroupRule{
+					Direction:      directionIngress,
+					EtherType:      etherTypeIPv4,
+					Protocol:       "tcp",
+					PortRangeMin:   5432,
+					PortRangeMax:   5432,
+					RemoteIPPrefix: "10.244.0.3/32",
+				}
+				if len(group.Rules) != 3 || !reflect.DeepEqual(group.Rules[2], expected) {
+					return fmt.Errorf("expected rules of security group to be %v, got %v", expected, group.Rules)
+				}
+				if groups := portSecurityGroups(osClient, "db"); !reflect.DeepEqual(groups, []string{group.Uid}) {
+					return fmt.Errorf("expected security groups of db port to be %v, got %v", group.Uid, groups)
+				}
+				if groups := portSecurityGroups(osClient, "web"); len(groups) != 1 || groups[0] == group.Uid {
+					return fmt.Errorf("expected web port to have default security group, got %v", groups)
+				}
+				return nil
+			},
+		},
+		{
+			testName: "Delete policy,pod back to default security group",
+			updateFn: func() {
+				policies.Delete(policy)
+			},
+			expectedFn: func() error {
+				if len(osClient.SecurityGroups) != 1 {
+					return fmt.Errorf("expected only default security group left, got %v", osClient.SecurityGroups)
+				}
+				dbGroups := portSecurityGroups(osClient, "db")
+				webGroups := portSecurityGroups(osClient, "web")
+				if !reflect.DeepEqual(dbGroups, webGroups) {
+					return fmt.Errorf("expected db port to have default security group %v, got %v", webGroups, dbGroups)
+				}
+				return nil
+			},
+		},
+	}
+
+	for tci, tc := range testCases {
+		tc.updateFn()
+		if err := controller.syncNamespace(namespace); err != nil {
+			t.Errorf("Case[%d]: %s unexpected error: %v", tci, tc.testName, err)
+		}
+		if err := tc.expectedFn(); err != nil {
+			t.Errorf("Case[%d]: %s %v", tci, tc.testName, err)
+		}
+	}
+}
+
+func TestBuildIngressRules(t *testing.T) {
+	udp := v1.ProtocolUDP
+	port := intstr.FromInt(53)
+	namedPort := intstr.FromString("dns")
+
+	testCases := []struct {
+		testName string
+		ports    []networkingv1.NetworkPolicyPort
+		prefixes []string
+		expected []*drivertypes.SecurityGroupRule
+	}{
+		{
+			testName: "all ports from any peer",
+			prefixes: []string{""},
+			expected: []*drivertypes.SecurityGroupRule{
+				{Direction: directionIngress, EtherType: etherTypeIPv4},
+				{Direction: directionIngress, EtherType: etherTypeIPv6},
+			},
+		},
+		{
+			testName: "udp port from IPv4 and IPv6 peers",
+			ports:    []networkingv1.NetworkPolicyPort{{Protocol: &udp, Port: &port}},
+			prefixes: []string{"10.244.0.3/32", "fd00::3/128"},
+			expected: []*drivertypes.SecurityGroupRule{
+				{Direction: directionIngress, EtherType: etherTypeIPv4, Protocol: "udp", PortRangeMin: 53, PortRangeMax: 53, RemoteIPPrefix: "10.244.0.3/32"},
+				{Direction: directionIngress, EtherType: etherTypeIPv6, Protocol: "udp", PortRangeMin: 53, PortRangeMax: 53, RemoteIPPrefix: "fd00::3/128"},
+			},
+		},
+		{
+			testName: "named port skipped",
+			ports:    []networkingv1.NetworkPolicyPort{{Port: &namedPort}},
+			prefixes: []string{"10.244.0.3/32"},
+		},
+		{
+			testName: "no peers selected",
+			ports:    []networkingv1.NetworkPolicyPort{{Port: &port}},
+		},
+	}
+
+	for tci, tc := range testCases {
+		rules := buildIngressRules(tc.ports, tc.prefixes)
+		if !reflect.DeepEqual(rules, tc.expected) {
+			t.Errorf("Case[%d]: %s expected rules %v, got %v", tci, tc.testName, tc.expected, rules)
+		}
+	}
+}
+
+func TestSyncNamespacePortSecurityDisabled(t *testing.T) {
+	controller, osClient, err := newPolicyController()
+	if err != nil {
+		t.Fatalf("Failed start a new fake PolicyController: %v", err)
+	}
+	pod := newPod("router", "10.244.0.4", nil)
+	pod.Annotations = map[string]string{util.PortSecurityAnnotation: "false"}
+	controller.podInformer.Informer().GetIndexer().Add(pod)
+	osClient.Ports[networkID] = append(osClient.Ports[networkID], ports.Port{
+		ID:        pod.Name,
+		Name:      util.BuildPortName(namespace, pod.Name),
+		NetworkID: networkID,
+		TenantID:  tenantID,
+	})
+
+	if err := controller.syncNamespace(namespace); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if groups := portSecurityGroups(osClient, "router"); len(groups) != 0 {
+		t.Errorf("Expected port without port security to have no security groups, got %v", groups)
+	}
+	if groups := portSecurityGroups(osClient, "web"); len(groups) != 1 {
+		t.Errorf("Expected web port to have default security group, got %v", groups)
+	}
+}
+
+func TestSyncNamespaceAdditionalInterface(t *testing.T) {
+	controller, osClient, err := newPolicyController()
+	if err != nil {
+		t.Fatalf("Failed start a new fake PolicyController: %v", err)
+	}
+	// db pod has an additional interface, and pod db-eth1 has a primary port
+	// with the same prefix.
+	other := newPod("db-eth1", "10.244.0.5", nil)
+	controller.podInformer.Informer().GetIndexer().Add(other)
+	for _, port := range []ports.Port{
+		{ID: "db_eth1", Name: util.BuildSecondaryPortName(namespace, "db", "eth1")},
+		{ID: other.Name, Name: util.BuildPortName(namespace, other.Name)},
+	} {
+		port.NetworkID = networkID
+		port.TenantID = tenantID
+		osClient.Ports[networkID] = append(osClient.Ports[networkID], port)
+	}
+	controller.policyInformer.Informer().GetIndexer().Add(newPolicy("db"))
+
+	// Resyncs don't look up tenant and ports of pods one by one.
+	for i := 0; i < 2; i++ {
+		if err := controller.syncNamespace(namespace); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	primaryGroups := portSecurityGroups(osClient, "db")
+	if len(primaryGroups) != 1 {
+		t.Fatalf("Expected db port to have security group of policy, got %v", primaryGroups)
+	}
+	if groups := portSecurityGroups(osClient, "db_eth1"); !reflect.DeepEqual(groups, primaryGroups) {
+		t.Errorf("Expected port of additional interface to have security groups %v, got %v", primaryGroups, groups)
+	}
+	if groups := portSecurityGroups(osClient, other.Name); len(groups) != 1 || groups[0] == primaryGroups[0] {
+		t.Errorf("Expected port of pod %s to have default security group, got %v", other.Name, groups)
+	}
+
+	var tenantLookups int
+	for _, name := range osClient.GetCalledNames() {
+		switch name {
+		case "GetTenantIDFromName":
+			tenantLookups++
+		case "GetPort":
+			t.Errorf("Expected ports not to be got one by one")
+		}
+	}
+	if tenantLookups != 1 {
+		t.Errorf("Expected tenant ID to be looked up once, got %d", tenantLookups)
+	}
+}
+
+func TestResolveNamedPorts(t *testing.T) {
+	udp := v1.ProtocolUDP
+	port := intstr.FromInt(5432)
+	namedPort := intstr.FromString("dns")
+	newNamedPod := func(number int32, protocol v1.Protocol) *v1.Pod {
+		pod := newPod("dns", "10.244.0.4", nil)
+		pod.Spec.Containers = []v1.Container{{
+			Ports: []v1.ContainerPort{{Name: "dns", ContainerPort: number, Protocol: protocol}},
+		}}
+		return pod
+	}
+
+	testCases := []struct {
+		testName string
+		ports    []networkingv1.NetworkPolicyPort
+		pods     []*v1.Pod
+		expected []string
+	}{
+		{
+			testName: "numeric port kept",
+			ports:    []networkingv1.NetworkPolicyPort{{Port: &port}},
+			expected: []string{"<nil>/5432"},
+		},
+		{
+			testName: "named port resolved",
+			ports:    []networkingv1.NetworkPolicyPort{{Protocol: &udp, Port: &namedPort}},
+			pods:     []*v1.Pod{newNamedPod(53, v1.ProtocolUDP)},
+			expected: []string{"UDP/53"},
+		},
+		{
+			testName: "named port numbered differently in pods",
+			ports:    []networkingv1.NetworkPolicyPort{{Port: &namedPort}},
+			pods:     []*v1.Pod{newNamedPod(5353, ""), newNamedPod(53, v1.ProtocolTCP)},
+			expected: []string{"TCP/53", "TCP/5353"},
+		},
+		{
+			testName: "named port with another protocol dropped",
+			ports:    []networkingv1.NetworkPolicyPort{{Port: &namedPort}},
+			pods:     []*v1.Pod{newNamedPod(53, v1.ProtocolUDP)},
+		},
+		{
+			testName: "named port not found dropped",
+			ports:    []networkingv1.NetworkPolicyPort{{Port: &namedPort}},
+		},
+	}
+
+	for tci, tc := range testCases {
+		var results []string
+		for _, p := range resolveNamedPorts(tc.ports, tc.pods) {
+			protocol := "<nil>"
+			if p.Protocol != nil {
+				protocol = string(*p.Protocol)
+			}
+			results = append(results, fmt.Sprintf("%s/%s", protocol, p.Port.String()))
+		}
+		if !reflect.DeepEqual(results, tc.expected) {
+			t.Errorf("Case[%d]: %s expected ports %v, got %v", tci, tc.testName, tc.expected, results)
+		}
+	}
+}
+
+func TestSyncNamespaceTenantEgress(t *testing.T) {
+	controller, osClient, err := newPolicyController()
+	if err != nil {
+		t.Fatalf("Failed start a new fake PolicyController: %v", err)
+	}
+	// The tenant only allows egress to 10.0.0.0/8.
+	osClient.CRDClient.(*crdClient.FakeCRDClient).SetTenants(&crv1.Tenant{
+		ObjectMeta: metav1.ObjectMeta{Name: namespace, Namespace: namespace},
+		Spec: crv1.TenantSpec{
+			TenantID: tenantID,
+			SecurityGroup: &crv1.SecurityGroupSpec{
+				Egress: []crv1.SecurityGroupRule{{CIDR: "10.0.0.0/8"}},
+			},
+		},
+	})
+	policy := newPolicy("db")
+	controller.policyInformer.Informer().GetIndexer().Add(policy)
+
+	if err := controller.syncNamespace(namespace); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var group *drivertypes.SecurityGroup
+	for _, sg := range osClient.SecurityGroups {
+		if sg.Name == util.BuildSecurityGroupName(namespace, policy.Name) {
+			group = sg
+		}
+	}
+	if group == nil {
+		t.Fatalf("Expected security group of policy to be created")
+	}
+	var egress []*drivertypes.SecurityGroupRule
+	for _, rule := range group.Rules {
+		if rule.Direction == directionEgress {
+			egress = append(egress, rule)
+		}
+	}
+	expected := []*drivertypes.SecurityGroupRule{
+		{Direction: directionEgress, EtherType: etherTypeIPv4, RemoteIPPrefix: "10.0.0.0/8"},
+	}
+	if !reflect.DeepEqual(egress, expected) {
+		t.Errorf("Expected egress rules of policy group to be %v, got %v", expected, egress)
+	}
+}
+
+func TestBuildSecurityGroupNamedPortNotFound(t *testing.T) {
+	controller, _, err := newPolicyController()
+	if err != nil {
+		t.Fatalf("Failed start a new fake PolicyController: %v", err)
+	}
+	policy := newPolicy("db")
+	namedPort := intstr.FromString("postgres")
+	policy.Spec.Ingress[0].Ports = []networkingv1.NetworkPolicyPort{{Port: &namedPort}}
+
+	sg, err := controller.buildSecurityGroup(policy, tenantID, nil, openstack.AllowAllRules(directionEgress))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, rule := range sg.Rules {
+		if rule.Direction == directionIngress {
+			t.Errorf("Expected no ingress rules for named port not found, got %v", rule)
+		}
+	}
+}
@@ -220,3 +220,9 @@ func getRouterNetns(routerID string) string {
 func probability(n int) string {
 	return fmt.Sprintf("%0.5f", 1.0/float64(n))
 }
+
+// isIPv6 returns true if ip is an IPv6 address.
+func isIPv6(ip string) bool {
+	netIP := net.ParseIP(ip)
+	return netIP != nil && netIP.To4() == nil
+}
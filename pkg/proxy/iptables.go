@@ -54,6 +54,8 @@ type iptablesInterface interface {
 type Iptables struct {
 	exec      utilexec.Interface
 	namespace string
+	// ipv6 is true if ip6tables is used.
+	ipv6 bool
 }
 
 func NewIptables(exec utilexec.Interface) iptablesInterface {
@@ -62,26 +64,42 @@ func NewIptables(exec utilexec.Interface) iptablesInterface {
 	}
 }
 
+// NewIp6tables returns an iptablesInterface which runs ip6tables commands.
+func NewIp6tables(exec utilexec.Interface) iptablesInterface {
+	return &Iptables{
+		exec: exec,
+		ipv6: true,
+	}
+}
+
+func (r *Iptables) iptablesCommand() string {
+	if r.ipv6 {
+		return "ip6tables"
+	}
+	return "iptables"
+}
+
 func (r *Iptables) setNetns(netns string) {
 	r.namespace = netns
 }
 
 // runInNat executes iptables command in nat table.
 func (r *Iptables) runInNat(op, chain string, args []string) ([]byte, error) {
-	fullArgs := []string{"netns", "exec", r.namespace, "iptables", "-t", TableNAT, op, chain}
+	fullArgs := []string{"netns", "exec", r.namespace, r.iptablesCommand(), "-t", TableNAT, op, chain}
 	fullArgs = append(fullArgs, args...)
 	return r.exec.Command("ip", fullArgs...).CombinedOutput()
 }
 
 func (r *Iptables) restoreAll(data []byte) error {
-	glog.V(3).Infof("running iptables-restore with data %s", data)
+	restoreCommand := r.iptablesCommand() + "-restore"
+	glog.V(3).Infof("running %s with data %s", restoreCommand, data)
 
-	fullArgs := []string{"netns", "exec", r.namespace, "iptables-restore", "--noflush", "--counters"}
+	fullArgs := []string{"netns", "exec", r.namespace, restoreCommand, "--noflush", "--counters"}
 	cmd := r.exec.Command("ip", fullArgs...)
 	cmd.SetStdin(bytes.NewBuffer(data))
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("iptables-restore failed: %s: %v", output, err)
+		return fmt.Errorf("%s failed: %s: %v", restoreCommand, output, err)
 	}
 
 	return nil
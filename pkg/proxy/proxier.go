@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"fmt"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -39,6 +40,7 @@ import (
 	utilexec "k8s.io/utils/exec"
 
 	"git.openstack.org/openstack/stackube/pkg/openstack"
+	drivertypes "git.openstack.org/openstack/stackube/pkg/openstack/types"
 	"git.openstack.org/openstack/stackube/pkg/util"
 	"github.com/golang/glog"
 )
@@ -48,6 +50,9 @@ const (
 	minSyncPeriod       = 5 * time.Second
 	syncPeriod          = 30 * time.Second
 	burstSyncs          = 2
+	// networks of namespaces are refreshed at this interval, so that new
+	// networks could be found.
+	networkRefreshPeriod = 1 * time.Minute
 )
 
 // Proxier is an iptables based proxy for connections between a localhost:port
@@ -57,6 +62,7 @@ type Proxier struct {
 	kubeClientset     *kubernetes.Clientset
 	osClient          openstack.Interface
 	iptables          iptablesInterface
+	ip6tables         iptablesInterface
 	factory           informers.SharedInformerFactory
 	namespaceInformer informersV1.NamespaceInformer
 	serviceInformer   informersV1.ServiceInformer
@@ -118,6 +124,7 @@ func NewProxier(kubeConfig, openstackConfig string) (*Proxier, error) {
 		kubeClientset:    clientset,
 		osClient:         osClient,
 		iptables:         NewIptables(execer),
+		ip6tables:        NewIp6tables(execer),
 		factory:          factory,
 		clusterDNS:       clusterDNS,
 		endpointsChanges: newEndpointsChangeMap(""),
@@ -197,28 +204,87 @@ func (p *Proxier) onServiceDeleted(obj interface{}) {
 	}
 }
 
-func (p *Proxier) getRouterForNamespace(namespace string) (string, error) {
-	// Only support one network and network's name is same with namespace.
-	// TODO: make it general after multi-network is supported.
-	networkName := util.BuildNetworkName(namespace, namespace)
-	network, err := p.osClient.GetNetworkByName(networkName)
+// getNetworksForNamespace gets all networks of namespace and their routers.
+func (p *Proxier) getNetworksForNamespace(namespace string) (map[string]*networkInfo, error) {
+	names := []string{namespace}
+	networkList, err := p.osClient.GetCRDClient().ListNetworks(namespace)
 	if err != nil {
-		glog.Errorf("Get network by name %q failed: %v", networkName, err)
-		return "", err
+		glog.Warningf("List networks for namespace %q failed: %v", namespace, err)
+	} else if len(networkList.Items) > 0 {
+		names = names[:0]
+		for _, network := range networkList.Items {
+			// Isolated networks have no router to proxy services.
+			if network.Spec.NoRouter {
+				glog.V(4).Infof("Network %s/%s has no router, omit it", namespace, network.Name)
+				continue
+			}
+			names = append(names, network.Name)
+		}
+	}
+
+	networks := make(map[string]*networkInfo)
+	for _, name := range names {
+		network, err := openstack.GetNetworkByCRDName(p.osClient, namespace, name)
+		if err != nil {
+			glog.Warningf("Get network %s/%s failed: %v", namespace, name, err)
+			continue
+		}
+		if _, ok := networks[network.Name]; ok {
+			continue
+		}
+
+		router, err := p.getRouterForNetwork(network)
+		if err != nil {
+			glog.Warningf("Get router for network %q failed: %v", network.Name, err)
+			continue
+		}
+		networks[network.Name] = router
+	}
+
+	if len(networks) == 0 {
+		return nil, fmt.Errorf("no router found for namespace %q", namespace)
 	}
 
+	return networks, nil
+}
+
+func (p *Proxier) getRouterForNetwork(network *drivertypes.Network) (*networkInfo, error) {
 	ports, err := p.osClient.ListPorts(network.Uid, "network:router_interface")
 	if err != nil {
-		glog.Errorf("Get port list for network %q failed: %v", networkName, err)
-		return "", err
+		glog.Errorf("Get port list for network %q failed: %v", network.Name, err)
+		return nil, err
 	}
 
 	if len(ports) == 0 {
-		glog.Errorf("Get zero router interface for network %q", networkName)
-		return "", fmt.Errorf("no router interface found")
+		glog.Errorf("Get zero router interface for network %q", network.Name)
+		return nil, fmt.Errorf("no router interface found")
 	}
 
-	return ports[0].DeviceID, nil
+	info := &networkInfo{router: ports[0].DeviceID}
+	for _, subnet := range network.Subnets {
+		_, cidr, err := net.ParseCIDR(subnet.Cidr)
+		if err == nil {
+			info.cidrs = append(info.cidrs, cidr)
+		}
+	}
+
+	return info, nil
+}
+
+// refreshNetworks gets networks for namespace if they are not found yet or out of date.
+func (p *Proxier) refreshNetworks(namespace string, nsInfo *namespaceInfo) {
+	if len(nsInfo.networks) > 0 && time.Since(nsInfo.refreshTime) < networkRefreshPeriod {
+		return
+	}
+
+	networks, err := p.getNetworksForNamespace(namespace)
+	if err != nil {
+		glog.Warningf("Get router for namespace %q failed: %v. This may be caused by network not ready yet.", namespace, err)
+		return
+	}
+
+	nsInfo.networks = networks
+	nsInfo.refreshTime = time.Now()
 }
 
 func (p *Proxier) onEndpointsAdded(obj interface{}) {
@@ -468,16 +534,8 @@ func (p *Proxier) updateCaches() {
 					p.namespaceMap[n] = change.current
 				}
 
-				// get router for the namespace
-				if p.namespaceMap[n].router == "" {
-					router, err := p.getRouterForNamespace(n)
-					if err != nil {
-						glog.Warningf("Get router for namespace %q failed: %v. This may be caused by network not ready yet.", n, err)
-						continue
-					}
-
-					p.namespaceMap[n].router = router
-				}
+				// get routers for the namespace
+				p.refreshNetworks(n, p.namespaceMap[n])
 			}
 		}
 
@@ -503,10 +561,12 @@ func (p *Proxier) syncProxyRules() {
 	// iptablesData contains the iptables rules for netns.
 	iptablesData := bytes.NewBuffer(nil)
 
-	// Sync iptables rules for services.
+	// Networks of different namespaces may be connected to the same router,
+	// so rules are grouped by router and restored together in its netns.
+	// Endpoints of a namespace are only filtered by the networks connected
+	// to the router if not all its networks are connected.
+	routerNamespaces := make(map[string]map[string]*routerNamespaceInfo)
 	for namespace := range p.serviceNSMap {
-		iptablesData.Reset()
-
 		// Step 1: get namespace info.
 		nsInfo, ok := p.namespaceMap[namespace]
 		if !ok {
@@ -515,55 +575,111 @@ func (p *Proxier) syncProxyRules() {
 		}
 		glog.V(3).Infof("Syncing iptables for namespace %q: %v", namespace, nsInfo)
 
-		// Step 2: try to get router again since router may be created late after namespaces.
-		if nsInfo.router == "" {
-			router, err := p.getRouterForNamespace(namespace)
-			if err != nil {
-				glog.Warningf("Get router for namespace %q failed: %v. This may be caused by network not ready yet.", namespace, err)
-				continue
+		// Step 2: try to get routers again since networks may be created late after namespaces.
+		p.refreshNetworks(namespace, nsInfo)
+		if len(nsInfo.networks) == 0 {
+			continue
+		}
+
+		for _, network := range nsInfo.networks {
+			namespaces, ok := routerNamespaces[network.router]
+			if !ok {
+				namespaces = make(map[string]*routerNamespaceInfo)
+				routerNamespaces[network.router] = namespaces
+			}
+			info, ok := namespaces[namespace]
+			if !ok {
+				info = &routerNamespaceInfo{
+					network:         &networkInfo{router: network.router},
+					filterEndpoints: !connectedToRouter(nsInfo.networks, network.router),
+				}
+				namespaces[namespace] = info
 			}
-			nsInfo.router = router
+			info.network.cidrs = append(info.network.cidrs, network.cidrs...)
 		}
+	}
 
+	for router, namespaces := range routerNamespaces {
 		// Step 3: compose iptables chain.
-		netns := getRouterNetns(nsInfo.router)
+		netns := getRouterNetns(router)
 
 		// populates netns to iptables.
 		p.iptables.setNetns(netns)
 		if !p.iptables.netnsExist() {
-			glog.V(3).Infof("Netns %q doesn't exist, omit the services in namespace %q", netns, namespace)
+			glog.V(3).Infof("Netns %q doesn't exist, omit the services in namespaces %v", netns, namespaces)
 			continue
 		}
 
-		// ensure chain STACKUBE-PREROUTING created.
-		err := p.iptables.ensureChain()
-		if err != nil {
-			glog.Errorf("EnsureChain %q in netns %q failed: %v", ChainSKPrerouting, netns, err)
-			continue
+		// Step 4: sync IPv4 rules by iptables and IPv6 rules by ip6tables.
+		p.ip6tables.setNetns(netns)
+		for _, ipt := range []struct {
+			iptables iptablesInterface
+			ipv6     bool
+		}{
+			{p.iptables, false},
+			{p.ip6tables, true},
+		} {
+			iptablesData.Reset()
+			err := p.syncRouterRules(ipt.iptables, iptablesData, namespaces, ipt.ipv6)
+			if err != nil {
+				glog.Errorf("Sync rules in netns %q failed: %v", netns, err)
+			}
 		}
-		// link STACKUBE-PREROUTING chain.
-		err = p.iptables.ensureRule(opAddpendRule, ChainPrerouting, []string{
-			"-m", "comment", "--comment", "stackube service portals", "-j", ChainSKPrerouting,
-		})
-		if err != nil {
-			glog.Errorf("Link chain %q in netns %q failed: %v", ChainSKPrerouting, netns, err)
-			continue
+	}
+}
+
+// connectedToRouter checks whether all the networks are connected to the router.
+func connectedToRouter(networks map[string]*networkInfo, router string) bool {
+	for _, network := range networks {
+		if network.router != router {
+			return false
 		}
+	}
+	return true
+}
+
+// syncRouterRules syncs rules of services in namespaces for an IP family in
+// the netns of a router.
+func (p *Proxier) syncRouterRules(ipt iptablesInterface, iptablesData *bytes.Buffer, namespaces map[string]*routerNamespaceInfo, ipv6 bool) error {
+	// ensure chain STACKUBE-PREROUTING created.
+	err := ipt.ensureChain()
+	if err != nil {
+		return fmt.Errorf("ensureChain %q failed: %v", ChainSKPrerouting, err)
+	}
+	// link STACKUBE-PREROUTING chain.
+	err = ipt.ensureRule(opAddpendRule, ChainPrerouting, []string{
+		"-m", "comment", "--comment", "stackube service portals", "-j", ChainSKPrerouting,
+	})
+	if err != nil {
+		return fmt.Errorf("link chain %q failed: %v", ChainSKPrerouting, err)
+	}
 
-		// Step 4: flush chain STACKUBE-PREROUTING.
-		writeLine(iptablesData, []string{"*nat"}...)
-		writeLine(iptablesData, []string{":" + ChainSKPrerouting, "-", "[0:0]"}...)
-		writeLine(iptablesData, []string{opFlushChain, ChainSKPrerouting}...)
-		writeLine(iptablesData, []string{"COMMIT"}...)
+	// Step 4.1: flush chain STACKUBE-PREROUTING.
+	writeLine(iptablesData, []string{"*nat"}...)
+	writeLine(iptablesData, []string{":" + ChainSKPrerouting, "-", "[0:0]"}...)
+	writeLine(iptablesData, []string{opFlushChain, ChainSKPrerouting}...)
+	writeLine(iptablesData, []string{"COMMIT"}...)
 
-		// Step 5: compose rules for each services.
+	// Step 4.2: compose rules for each services.
+	hostMask := "/32"
+	if ipv6 {
+		hostMask = "/128"
+	}
+	writeLine(iptablesData, []string{"*nat"}...)
+	// Namespaces are sorted to keep the rules stable.
+	names := make([]string, 0, len(namespaces))
+	for namespace := range namespaces {
+		names = append(names, namespace)
+	}
+	sort.Strings(names)
+	for _, namespace := range names {
+		info := namespaces[namespace]
 		glog.V(5).Infof("Syncing iptables for services %v", p.serviceNSMap[namespace])
-		writeLine(iptablesData, []string{"*nat"}...)
 		for svcName, svcInfo := range p.serviceNSMap[namespace] {
 			protocol := strings.ToLower(string(svcInfo.protocol))
 			svcNameString := svcInfo.serviceNameString
 
-			// Step 5.1: check service type.
+			// Step 4.2.1: check service type.
 			// Only ClusterIP service is supported. We also handles clusterIP for other typed services, but note that:
 			// - NodePort service is not supported since networks are L2 isolated.
 			// - LoadBalancer service is handled in service controller.
@@ -571,26 +687,58 @@ func (p *Proxier) syncProxyRules() {
 				glog.V(3).Infof("Only service's clusterIP is handled here, omitting other fields of service %q (type=%q)", svcName.NamespacedName, svcInfo.serviceType)
 			}
 
-			// Step 5.2: check endpoints.
-			// If the service has no endpoints then do nothing.
-			if len(p.endpointsMap[svcName]) == 0 {
+			// Step 4.2.2: check IP family of the service.
+			serviceIP := p.getServiceIP(svcInfo)
+			if isIPv6(serviceIP) != ipv6 {
+				continue
+			}
+
+			// Step 4.2.3: check endpoints.
+			// If the service has no endpoints of the same IP family in the network then do nothing.
+			var endpoints []*endpointsInfo
+			for _, ep := range p.endpointsMap[svcName] {
+				if isIPv6(ep.IPPart()) != ipv6 {
+					continue
+				}
+				if info.filterEndpoints && !info.network.contains(ep.IPPart()) {
+					continue
+				}
+				endpoints = append(endpoints, ep)
+			}
+			if len(endpoints) == 0 {
 				glog.V(3).Infof("No endpoints found for service %q", svcName.NamespacedName)
 				continue
 			}
 
-			// Step 5.3: Generate the per-endpoint rules.
+			// Step 4.2.4: match sources of kube-dns.
+			// kube-dns services of all namespaces share the cluster DNS IP,
+			// so its rules only match the subnets of the namespace, or pods
+			// would query kube-dns of another namespace on the same router.
+			var sources []string
+			if svcInfo.name == "kube-dns" {
+				sources = namespaceSources(info.network, ipv6)
+				if len(sources) == 0 {
+					glog.V(3).Infof("No subnets found for service %q", svcName.NamespacedName)
+					continue
+				}
+			}
+
+			// Step 4.2.5: Generate the per-endpoint rules.
 			// -A STACKUBE-PREROUTING -d 10.108.230.103  -m comment --comment "default/http: cluster IP"
 			// -m tcp -p tcp --dport 80 -m statistic --mode random --probability 1.0
 			// -j DNAT --to-destination 192.168.1.7:80
-			n := len(p.endpointsMap[svcName])
-			for i, ep := range p.endpointsMap[svcName] {
+			n := len(endpoints)
+			for i, ep := range endpoints {
 				args := []string{
 					"-A", ChainSKPrerouting,
 					"-m", "comment", "--comment", svcNameString,
 					"-m", protocol, "-p", protocol,
-					"-d", fmt.Sprintf("%s/32", p.getServiceIP(svcInfo)),
+					"-d", serviceIP + hostMask,
 					"--dport", strconv.Itoa(svcInfo.port),
 				}
+				if len(sources) > 0 {
+					args = append(args, "-s", strings.Join(sources, ","))
+				}
 
 				if i < (n - 1) {
 					// Each rule is a probabilistic match.
@@ -605,15 +753,27 @@ func (p *Proxier) syncProxyRules() {
 				writeLine(iptablesData, args...)
 			}
 		}
-		writeLine(iptablesData, []string{"COMMIT"}...)
+	}
+	writeLine(iptablesData, []string{"COMMIT"}...)
 
-		// Step 6: execute iptables-restore.
-		err = p.iptables.restoreAll(iptablesData.Bytes())
-		if err != nil {
-			glog.Errorf("Failed to execute iptables-restore: %v", err)
-			continue
+	// Step 4.3: execute iptables-restore.
+	err = ipt.restoreAll(iptablesData.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to execute iptables-restore: %v", err)
+	}
+
+	return nil
+}
+
+// namespaceSources returns the subnets of network in an IP family.
+func namespaceSources(network *networkInfo, ipv6 bool) []string {
+	var sources []string
+	for _, cidr := range network.cidrs {
+		if isIPv6(cidr.IP.String()) == ipv6 {
+			sources = append(sources, cidr.String())
 		}
 	}
+	return sources
 }
 
 func (p *Proxier) getServiceIP(serviceInfo *serviceInfo) string {
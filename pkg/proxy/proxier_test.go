@@ -24,6 +24,7 @@ import (
 
 	"github.com/davecgh/go-spew/spew"
 
+	crv1 "git.openstack.org/openstack/stackube/pkg/apis/v1"
 	crdClient "git.openstack.org/openstack/stackube/pkg/kubecrd"
 	"git.openstack.org/openstack/stackube/pkg/openstack"
 	drivertypes "git.openstack.org/openstack/stackube/pkg/openstack/types"
@@ -89,6 +90,7 @@ func NewFakeProxier(ipt iptablesInterface, osClient openstack.Interface) *Proxie
 		clusterDNS:       testclusterDNS,
 		osClient:         osClient,
 		iptables:         ipt,
+		ip6tables:        NewFake(),
 		endpointsChanges: newEndpointsChangeMap(""),
 		serviceChanges:   newServiceChangeMap(),
 		namespaceChanges: newNamespaceChangeMap(),
@@ -376,6 +378,161 @@ func TestClusterIPEndpointsJump(t *testing.T) {
 	}
 }
 
+func TestIPv6ClusterIPEndpointsJump(t *testing.T) {
+	testNamespace := "test"
+	svcIP := "fd00:10::10"
+	svcPort := 80
+	svcPortName := servicePortName{
+		NamespacedName: makeNSN(testNamespace, "svc1"),
+		Port:           "80",
+	}
+
+	// Creates fake iptables and ip6tables.
+	ipt := NewFake()
+	ip6t := NewFake()
+	// Creates fake CRD client.
+	crdClient, err := crdClient.NewFake()
+	if err != nil {
+		t.Fatal("Failed init fake CRD client")
+	}
+	// Create a fake openstack client.
+	osClient := openstack.NewFake(crdClient)
+	// Injects fake network.
+	networkName := util.BuildNetworkName(testNamespace, testNamespace)
+	osClient.SetNetwork(defaultNetwork(networkName, defaultNetworkID))
+	// Injects fake port.
+	osClient.SetPort(defaultNetworkID, deviceOwner, defaultPortID)
+	// Creates a new fake proxier.
+	fp := NewFakeProxier(ipt, osClient)
+	fp.ip6tables = ip6t
+
+	makeServiceMap(fp,
+		makeTestService(svcPortName.Namespace, svcPortName.Name, func(svc *v1.Service) {
+			svc.Spec.ClusterIP = svcIP
+			svc.Spec.Ports = []v1.ServicePort{{
+				Name:     svcPortName.Port,
+				Port:     int32(svcPort),
+				Protocol: v1.ProtocolTCP,
+			}}
+		}),
+	)
+
+	epIP := "fd00:20::1"
+	makeEndpointsMap(fp,
+		makeTestEndpoints(svcPortName.Namespace, svcPortName.Name, func(ept *v1.Endpoints) {
+			ept.Subsets = []v1.EndpointSubset{{
+				Addresses: []v1.EndpointAddress{{
+					IP: epIP,
+				}},
+				Ports: []v1.EndpointPort{{
+					Name: svcPortName.Port,
+					Port: int32(svcPort),
+				}},
+			}}
+		}),
+	)
+
+	makeNamespaceMap(fp, makeTestNamespace(svcPortName.Namespace))
+
+	fp.syncProxyRules()
+
+	epStr := fmt.Sprintf("[%s]:%d", epIP, svcPort)
+
+	stackubeRules := ipt.GetRules(string(ChainSKPrerouting), "qrouter-123")
+	if len(stackubeRules) != 0 {
+		errorf(fmt.Sprintf("Unexpected IPv4 rule for chain %v with IPv6 endpoints in namespace %v", ChainSKPrerouting, svcPortName.Namespace), stackubeRules, t)
+	}
+	stackubeRules = ip6t.GetRules(string(ChainSKPrerouting), "qrouter-123")
+	if len(stackubeRules) == 0 {
+		errorf(fmt.Sprintf("Unexpected IPv6 rule for chain %v with endpoints in namespace %v", ChainSKPrerouting, svcPortName.Namespace), stackubeRules, t)
+		return
+	}
+	if !hasDNAT(stackubeRules, epStr) {
+		errorf(fmt.Sprintf("Chain %v lacks DNAT to %v", ChainSKPrerouting, epStr), stackubeRules, t)
+	}
+	if stackubeRules[0][Destination] != svcIP+"/128" {
+		errorf(fmt.Sprintf("Chain %v has wrong destination, expected %v", ChainSKPrerouting, svcIP+"/128"), stackubeRules, t)
+	}
+}
+
+func TestMultiNetworksService(t *testing.T) {
+	testNamespace := "test"
+	svcIP := "1.2.3.4"
+	svcPort := 80
+	svcPortName := servicePortName{
+		NamespacedName: makeNSN(testNamespace, "svc1"),
+		Port:           "80",
+	}
+
+	// Creates fake iptables.
+	ipt := NewFake()
+	// Creates fake CRD client.
+	crdClient, err := crdClient.NewFake()
+	if err != nil {
+		t.Fatal("Failed init fake CRD client")
+	}
+	// Injects fake networks.
+	crdClient.SetNetworks(
+		&crv1.Network{ObjectMeta: metav1.ObjectMeta{Name: "net1", Namespace: testNamespace}},
+		&crv1.Network{ObjectMeta: metav1.ObjectMeta{Name: "net2", Namespace: testNamespace}},
+	)
+	// Create a fake openstack client.
+	osClient := openstack.NewFake(crdClient)
+	// Injects fake networks with different subnets.
+	network1 := defaultNetwork(util.BuildNetworkName(testNamespace, "net1"), "123")
+	network1.Subnets = []*drivertypes.Subnet{{Cidr: "10.244.0.0/16"}}
+	osClient.SetNetwork(network1)
+	network2 := defaultNetwork(util.BuildNetworkName(testNamespace, "net2"), "456")
+	network2.Subnets = []*drivertypes.Subnet{{Cidr: "10.245.0.0/16"}}
+	osClient.SetNetwork(network2)
+	// Injects fake ports.
+	osClient.SetPort("123", deviceOwner, "123")
+	osClient.SetPort("456", deviceOwner, "456")
+	// Creates a new fake proxier.
+	fp := NewFakeProxier(ipt, osClient)
+
+	makeServiceMap(fp,
+		makeTestService(svcPortName.Namespace, svcPortName.Name, func(svc *v1.Service) {
+			svc.Spec.ClusterIP = svcIP
+			svc.Spec.Ports = []v1.ServicePort{{
+				Name:     svcPortName.Port,
+				Port:     int32(svcPort),
+				Protocol: v1.ProtocolTCP,
+			}}
+		}),
+	)
+
+	epIP := "10.245.0.3"
+	makeEndpointsMap(fp,
+		makeTestEndpoints(svcPortName.Namespace, svcPortName.Name, func(ept *v1.Endpoints) {
+			ept.Subsets = []v1.EndpointSubset{{
+				Addresses: []v1.EndpointAddress{{
+					IP: epIP,
+				}},
+				Ports: []v1.EndpointPort{{
+					Name: svcPortName.Port,
+					Port: int32(svcPort),
+				}},
+			}}
+		}),
+	)
+
+	makeNamespaceMap(fp, makeTestNamespace(svcPortName.Namespace))
+
+	fp.syncProxyRules()
+
+	epStr := fmt.Sprintf("%s:%d", epIP, svcPort)
+
+	stackubeRules := ipt.GetRules(string(ChainSKPrerouting), "qrouter-123")
+	if len(stackubeRules) != 0 {
+		errorf(fmt.Sprintf("Unexpected rule for chain %v in network without endpoints", ChainSKPrerouting), stackubeRules, t)
+	}
+	stackubeRules = ipt.GetRules(string(ChainSKPrerouting), "qrouter-456")
+	if !hasDNAT(stackubeRules, epStr) {
+		errorf(fmt.Sprintf("Chain %v lacks DNAT to %v in network with endpoints", ChainSKPrerouting, epStr), stackubeRules, t)
+	}
+}
+
 func TestMultiNamespacesService(t *testing.T) {
 	ns1 := "ns1"
 	svcIP1 := "1.2.3.4"
@@ -486,6 +643,150 @@ func TestMultiNamespacesService(t *testing.T) {
 	}
 }
 
+func TestSharedRouterService(t *testing.T) {
+	ns1 := "ns1"
+	svcPortName1 := servicePortName{
+		NamespacedName: makeNSN(ns1, "svc1"),
+		Port:           "80",
+	}
+	ns2 := "ns2"
+	svcPortName2 := servicePortName{
+		NamespacedName: makeNSN(ns2, "svc1"),
+		Port:           "80",
+	}
+
+	// Creates fake iptables.
+	ipt := NewFake()
+	// Creates fake CRD client.
+	crdClient, err := crdClient.NewFake()
+	if err != nil {
+		t.Fatal("Failed init fake CRD client")
+	}
+	// Create a fake openstack client.
+	osClient := openstack.NewFake(crdClient)
+	// Injects fake networks connected to the same router.
+	osClient.SetNetwork(defaultNetwork(util.BuildNetworkName(ns1, ns1), "123"))
+	osClient.SetNetwork(defaultNetwork(util.BuildNetworkName(ns2, ns2), "456"))
+	osClient.SetPort("123", deviceOwner, "789")
+	osClient.SetPort("456", deviceOwner, "789")
+	// Creates a new fake proxier.
+	fp := NewFakeProxier(ipt, osClient)
+
+	var services []*v1.Service
+	var endpoints []*v1.Endpoints
+	for i, svcPortName := range []servicePortName{svcPortName1, svcPortName2} {
+		svcIP := fmt.Sprintf("1.2.3.%d", i+4)
+		epIP := fmt.Sprintf("192.168.%d.1", i)
+		services = append(services, makeTestService(svcPortName.Namespace, svcPortName.Name, func(svc *v1.Service) {
+			svc.Spec.ClusterIP = svcIP
+			svc.Spec.Ports = []v1.ServicePort{{
+				Name:     svcPortName.Port,
+				Port:     80,
+				Protocol: v1.ProtocolTCP,
+			}}
+		}))
+		endpoints = append(endpoints, makeTestEndpoints(svcPortName.Namespace, svcPortName.Name, func(ept *v1.Endpoints) {
+			ept.Subsets = []v1.EndpointSubset{{
+				Addresses: []v1.EndpointAddress{{
+					IP: epIP,
+				}},
+				Ports: []v1.EndpointPort{{
+					Name: svcPortName.Port,
+					Port: 80,
+				}},
+			}}
+		}))
+	}
+	makeServiceMap(fp, services...)
+	makeEndpointsMap(fp, endpoints...)
+	makeNamespaceMap(fp, makeTestNamespace(ns1), makeTestNamespace(ns2))
+
+	fp.syncProxyRules()
+
+	// Rules of both namespaces are kept in the netns of the shared router.
+	stackubeRules := ipt.GetRules(string(ChainSKPrerouting), "qrouter-789")
+	for _, epStr := range []string{"192.168.0.1:80", "192.168.1.1:80"} {
+		if !hasDNAT(stackubeRules, epStr) {
+			errorf(fmt.Sprintf("Chain %v lacks DNAT to %v", ChainSKPrerouting, epStr), stackubeRules, t)
+		}
+	}
+}
+
+func TestSharedRouterKubeDNS(t *testing.T) {
+	// Creates fake iptables.
+	ipt := NewFake()
+	// Creates fake CRD client.
+	crdClient, err := crdClient.NewFake()
+	if err != nil {
+		t.Fatal("Failed init fake CRD client")
+	}
+	// Create a fake openstack client.
+	osClient := openstack.NewFake(crdClient)
+	// Injects fake networks with their subnets connected to the same router.
+	namespaces := []struct {
+		name, uid, cidr, dnsIP string
+	}{
+		{name: "ns1", uid: "123", cidr: "10.0.0.0/24", dnsIP: "10.0.0.5"},
+		{name: "ns2", uid: "456", cidr: "10.0.1.0/24", dnsIP: "10.0.1.5"},
+	}
+	var services []*v1.Service
+	var endpoints []*v1.Endpoints
+	for i, ns := range namespaces {
+		network := defaultNetwork(util.BuildNetworkName(ns.name, ns.name), ns.uid)
+		network.Subnets = []*drivertypes.Subnet{{Cidr: ns.cidr}}
+		osClient.SetNetwork(network)
+		osClient.SetPort(ns.uid, deviceOwner, "789")
+
+		dnsIP := ns.dnsIP
+		services = append(services, makeTestService(ns.name, "kube-dns", func(svc *v1.Service) {
+			svc.Spec.ClusterIP = fmt.Sprintf("1.2.3.%d", i+4)
+			svc.Spec.Ports = []v1.ServicePort{{
+				Name:     "dns",
+				Port:     53,
+				Protocol: v1.ProtocolUDP,
+			}}
+		}))
+		endpoints = append(endpoints, makeTestEndpoints(ns.name, "kube-dns", func(ept *v1.Endpoints) {
+			ept.Subsets = []v1.EndpointSubset{{
+				Addresses: []v1.EndpointAddress{{
+					IP: dnsIP,
+				}},
+				Ports: []v1.EndpointPort{{
+					Name: "dns",
+					Port: 53,
+				}},
+			}}
+		}))
+	}
+	// Creates a new fake proxier.
+	fp := NewFakeProxier(ipt, osClient)
+	makeServiceMap(fp, services...)
+	makeEndpointsMap(fp, endpoints...)
+	makeNamespaceMap(fp, makeTestNamespace("ns1"), makeTestNamespace("ns2"))
+
+	fp.syncProxyRules()
+
+	// Both namespaces share the cluster DNS IP, each of them should only be
+	// DNATed to its own kube-dns.
+	stackubeRules := ipt.GetRules(string(ChainSKPrerouting), "qrouter-789")
+	for _, ns := range namespaces {
+		epStr := fmt.Sprintf("%s:53", ns.dnsIP)
+		found := false
+		for _, r := range stackubeRules {
+			if r[ToDest] != epStr {
+				continue
+			}
+			found = true
+			if r[Destination] != testclusterDNS+"/32" || r[Source] != ns.cidr {
+				errorf(fmt.Sprintf("DNAT to %v expected from %v to %v, got %v", epStr, ns.cidr, testclusterDNS, r), stackubeRules, t)
+			}
+		}
+		if !found {
+			errorf(fmt.Sprintf("Chain %v lacks DNAT to %v", ChainSKPrerouting, epStr), stackubeRules, t)
+		}
+	}
+}
+
 // This is a coarse test, but it offers some modicum of confidence as the code is evolved.
 func Test_endpointsToEndpointsMap(t *testing.T) {
 	testCases := []struct {
@@ -22,6 +22,7 @@ import (
 	"reflect"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/golang/glog"
 
@@ -76,14 +77,44 @@ type endpointsInfo struct {
 }
 
 type namespaceInfo struct {
-	network string
-	router  string
+	// networks of the namespace, keyed by network name.
+	networks map[string]*networkInfo
+	// refreshTime is the last time when networks are refreshed.
+	refreshTime time.Time
+}
+
+type networkInfo struct {
+	router string
+	cidrs  []*net.IPNet
+}
+
+// routerNamespaceInfo is a namespace whose networks are connected to a router.
+type routerNamespaceInfo struct {
+	// network holds the subnets of the namespace connected to the router.
+	network *networkInfo
+	// filterEndpoints is set if not all networks of the namespace are
+	// connected to the router, so that only endpoints in network are used.
+	filterEndpoints bool
+}
+
+// contains checks whether ip is in subnets of the network.
+func (n *networkInfo) contains(ip string) bool {
+	netIP := net.ParseIP(ip)
+	if netIP == nil {
+		return false
+	}
+	for _, cidr := range n.cidrs {
+		if cidr.Contains(netIP) {
+			return true
+		}
+	}
+	return false
 }
 
 // Returns just the IP part of the endpoint.
 func (e *endpointsInfo) IPPart() string {
-	if index := strings.Index(e.endpoint, ":"); index != -1 {
-		return e.endpoint[0:index]
+	if host, _, err := net.SplitHostPort(e.endpoint); err == nil {
+		return host
 	}
 	return e.endpoint
 }
@@ -288,11 +319,11 @@ func (ncm *namespaceChangeMap) update(name string, previous, current *v1.Namespa
 	change, exists := ncm.items[name]
 	if !exists {
 		change = &namespaceChange{}
-		change.previous = &namespaceInfo{network: name}
+		change.previous = &namespaceInfo{}
 		ncm.items[name] = change
 	}
 	if current != nil {
-		change.current = &namespaceInfo{network: name, router: change.previous.router}
+		change.current = &namespaceInfo{networks: change.previous.networks}
 	}
 
 	if previous != nil && current != nil {
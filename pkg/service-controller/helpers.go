@@ -18,7 +18,12 @@ package service
 
 import (
 	"fmt"
+	"net"
 
+	"git.openstack.org/openstack/stackube/pkg/openstack"
+	drivertypes "git.openstack.org/openstack/stackube/pkg/openstack/types"
+
+	"github.com/golang/glog"
 	"k8s.io/api/core/v1"
 )
 
@@ -33,3 +38,25 @@ func buildServiceName(service *v1.Service) string {
 func buildLoadBalancerName(service *v1.Service) string {
 	return fmt.Sprintf("%s_%s_%s", lbPrefix, service.Namespace, service.Name)
 }
+
+// getSubnetIDForEndpoints gets the subnet of network which endpoints are placed in.
+// The first subnet is used if it couldn't be determined by endpoints.
+func getSubnetIDForEndpoints(network *drivertypes.Network, endpoints []openstack.Endpoint) (string, error) {
+	if len(network.Subnets) == 0 {
+		return "", fmt.Errorf("network %s has no subnets", network.Name)
+	}
+	if len(endpoints) == 0 {
+		return network.Subnets[0].Uid, nil
+	}
+
+	ip := net.ParseIP(endpoints[0].Address)
+	for _, subnet := range network.Subnets {
+		_, cidr, err := net.ParseCIDR(subnet.Cidr)
+		if err == nil && ip != nil && cidr.Contains(ip) {
+			return subnet.Uid, nil
+		}
+	}
+
+	glog.Warningf("Endpoint %s is not in any subnet of network %s, using the first subnet", endpoints[0].Address, network.Name)
+	return network.Subnets[0].Uid, nil
+}
@@ -26,6 +26,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/informers"
 	informersV1 "k8s.io/client-go/informers/core/v1"
@@ -34,6 +35,7 @@ import (
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 
+	crdClient "git.openstack.org/openstack/stackube/pkg/kubecrd"
 	"git.openstack.org/openstack/stackube/pkg/openstack"
 	"git.openstack.org/openstack/stackube/pkg/util"
 	"github.com/golang/glog"
@@ -330,18 +332,22 @@ func (s *ServiceController) createLoadBalancer(service *v1.Service) (*v1.LoadBal
 		return nil, fmt.Errorf("multiple floatingips are not supported")
 	}
 
-	// Only support one network and network's name is same with namespace.
-	networkName := util.BuildNetworkName(service.Namespace, service.Namespace)
-	network, err := s.osClient.GetNetworkByName(networkName)
+	// get endpoints for the service.
+	endpoints, networkName, err := s.getEndpoints(service)
 	if err != nil {
-		glog.Errorf("Get network by name %q failed: %v", networkName, err)
+		glog.Errorf("Get endpoints for service %q failed: %v", buildServiceName(service), err)
 		return nil, err
 	}
 
-	// get endpoints for the service.
-	endpoints, err := s.getEndpoints(service)
+	// the loadbalancer is placed in the network of endpoints.
+	network, err := openstack.GetNetworkByCRDName(s.osClient, service.Namespace, networkName)
 	if err != nil {
-		glog.Errorf("Get endpoints for service %q failed: %v", buildServiceName(service), err)
+		glog.Errorf("Get network %s/%s failed: %v", service.Namespace, networkName, err)
+		return nil, err
+	}
+	subnetID, err := getSubnetIDForEndpoints(network, endpoints)
+	if err != nil {
+		glog.Errorf("Get subnet for service %q failed: %v", buildServiceName(service), err)
 		return nil, err
 	}
 
@@ -355,7 +361,7 @@ func (s *ServiceController) createLoadBalancer(service *v1.Service) (*v1.LoadBal
 		Endpoints:       endpoints,
 		ServicePort:     int(svcPort.Port),
 		TenantID:        network.TenantID,
-		SubnetID:        network.Subnets[0].Uid,
+		SubnetID:        subnetID,
 		Protocol:        string(svcPort.Protocol),
 		ExternalIP:      externalIP,
 		SessionAffinity: service.Spec.SessionAffinity != v1.ServiceAffinityNone,
@@ -371,16 +377,27 @@ func (s *ServiceController) createLoadBalancer(service *v1.Service) (*v1.LoadBal
 
 }
 
-func (s *ServiceController) getEndpoints(service *v1.Service) ([]openstack.Endpoint, error) {
+// getEndpoints gets endpoints of the service, and the name of network which
+// endpoints are placed in.
+func (s *ServiceController) getEndpoints(service *v1.Service) ([]openstack.Endpoint, string, error) {
 	endpoints, err := s.kubeClient.Core().Endpoints(service.Namespace).Get(service.Name, metav1.GetOptions{})
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	results := make([]openstack.Endpoint, 0)
+	networks := sets.NewString()
 	for i := range endpoints.Subsets {
 		ep := endpoints.Subsets[i]
 		for _, ip := range ep.Addresses {
+			if ip.TargetRef != nil && ip.TargetRef.Kind == "Pod" {
+				networkName, err := s.getPodNetworkName(ip.TargetRef.Namespace, ip.TargetRef.Name)
+				if err != nil {
+					return nil, "", err
+				}
+				networks.Insert(networkName)
+			}
+
 			for _, port := range ep.Ports {
 				results = append(results, openstack.Endpoint{
 					Address: ip.IP,
@@ -390,7 +407,29 @@ func (s *ServiceController) getEndpoints(service *v1.Service) ([]openstack.Endpo
 		}
 	}
 
-	return results, nil
+	switch networks.Len() {
+	case 0:
+		// Endpoints are not pods, use the default network of namespace.
+		name, err := crdClient.GetDefaultNetworkName(s.osClient.GetCRDClient(), service.Namespace)
+		if err != nil {
+			return nil, "", err
+		}
+		return results, name, nil
+	case 1:
+		return results, networks.List()[0], nil
+	default:
+		return nil, "", fmt.Errorf("endpoints are placed in multiple networks %v", networks.List())
+	}
+}
+
+// getPodNetworkName gets the name of network which pod is placed in.
+func (s *ServiceController) getPodNetworkName(namespace, name string) (string, error) {
+	pod, err := s.kubeClient.Core().Pods(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	return crdClient.GetPodNetworkName(s.osClient.GetCRDClient(), namespace, pod.Annotations)
 }
 
 // ListKeys implements the interface required by DeltaFIFO to list the keys we
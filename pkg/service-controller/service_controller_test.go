@@ -23,6 +23,7 @@ import (
 	"testing"
 	"time"
 
+	crdClient "git.openstack.org/openstack/stackube/pkg/kubecrd"
 	"git.openstack.org/openstack/stackube/pkg/openstack"
 	drivertypes "git.openstack.org/openstack/stackube/pkg/openstack/types"
 	"k8s.io/api/core/v1"
@@ -95,7 +96,8 @@ func makeTestServer(t *testing.T, namespace string) (*httptest.Server, *utiltest
 }
 
 func newController() (*ServiceController, *openstack.FakeOSClient, *fake.Clientset) {
-	osClient := openstack.NewFake(nil)
+	kubeCRDClient, _ := crdClient.NewFake()
+	osClient := openstack.NewFake(kubeCRDClient)
 
 	client := fake.NewSimpleClientset()
 
@@ -105,7 +107,8 @@ func newController() (*ServiceController, *openstack.FakeOSClient, *fake.Clients
 }
 
 func newControllerFakeHTTPServer(url, svcName, namespace string) (*ServiceController, *openstack.FakeOSClient) {
-	osClient := openstack.NewFake(nil)
+	kubeCRDClient, _ := crdClient.NewFake()
+	osClient := openstack.NewFake(kubeCRDClient)
 
 	client := kubernetes.NewForConfigOrDie(&restclient.Config{Host: url, ContentConfig: restclient.ContentConfig{GroupVersion: &api.Registry.GroupOrDie(v1.GroupName).GroupVersion}})
 
@@ -827,3 +830,42 @@ func TestServiceCache(t *testing.T) {
 		}
 	}
 }
+
+func TestGetSubnetIDForEndpoints(t *testing.T) {
+	network := &drivertypes.Network{
+		Name: "kube-foo-foo",
+		Subnets: []*drivertypes.Subnet{
+			{Uid: "123", Cidr: "10.244.0.0/16"},
+			{Uid: "456", Cidr: "10.245.0.0/16"},
+		},
+	}
+
+	testCases := []struct {
+		endpoints []openstack.Endpoint
+		expected  string
+	}{
+		{
+			// Case[0]: no endpoints, use the first subnet.
+			expected: "123",
+		},
+		{
+			// Case[1]: endpoints in the second subnet.
+			endpoints: []openstack.Endpoint{{Address: "10.245.0.3", Port: 80}},
+			expected:  "456",
+		},
+		{
+			// Case[2]: endpoints not in any subnet, use the first subnet.
+			endpoints: []openstack.Endpoint{{Address: "3.3.3.3", Port: 80}},
+			expected:  "123",
+		},
+	}
+
+	for tci, tc := range testCases {
+		subnetID, err := getSubnetIDForEndpoints(network, tc.endpoints)
+		if err != nil {
+			t.Errorf("Case[%d] unexpected error: %v", tci, err)
+		} else if subnetID != tc.expected {
+			t.Errorf("Case[%d] expected subnet %q, got %q", tci, tc.expected, subnetID)
+		}
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"fmt"
 	apiv1 "k8s.io/api/core/v1"
@@ -27,11 +28,40 @@ import (
 
 const (
 	namePrefix = "kube"
+	// secondaryPortSeparator separates the interface in names of ports for
+	// pod's additional interfaces.
+	secondaryPortSeparator = "_"
 
 	SystemTenant   = apiv1.NamespaceDefault
 	SystemPassword = "password"
 
 	SystemNetwork = apiv1.NamespaceDefault
+
+	// NetworkAnnotation is the pod annotation for selecting a network of the namespace.
+	NetworkAnnotation = "stackube.kubernetes.io/network"
+	// SubnetAnnotation is the pod annotation for selecting a subnet of the network.
+	SubnetAnnotation = "stackube.kubernetes.io/subnet"
+	// NetworksAnnotation is the pod annotation for listing networks of additional
+	// interfaces, e.g. "net1,net2".
+	NetworksAnnotation = "stackube.kubernetes.io/networks"
+	// IPAnnotation is the pod annotation for requesting a specific IP address.
+	IPAnnotation = "stackube.kubernetes.io/ip"
+	// IPRangeAnnotation is the pod annotation for requesting an IP address
+	// from a named IP range of the network.
+	IPRangeAnnotation = "stackube.kubernetes.io/ip-range"
+	// StickyPortAnnotation is the pod or namespace annotation for keeping ports
+	// of StatefulSet pods across restarts, e.g. "true".
+	StickyPortAnnotation = "stackube.kubernetes.io/sticky-port"
+	// AllowedAddressPairsAnnotation is the pod annotation for additional
+	// addresses the pod may send from, e.g. "10.244.0.100,10.244.1.0/24".
+	AllowedAddressPairsAnnotation = "stackube.kubernetes.io/allowed-address-pairs"
+	// PortSecurityAnnotation is the pod annotation for turning off port
+	// security of the pod's port by "false".
+	PortSecurityAnnotation = "stackube.kubernetes.io/port-security"
+	// IngressBandwidthAnnotation and EgressBandwidthAnnotation are the standard
+	// pod annotations for limiting bandwidth of the pod, e.g. "10M".
+	IngressBandwidthAnnotation = "kubernetes.io/ingress-bandwidth"
+	EgressBandwidthAnnotation  = "kubernetes.io/egress-bandwidth"
 )
 
 var ErrNotFound = errors.New("NotFound")
@@ -45,6 +75,15 @@ func BuildNetworkName(namespace, name string) string {
 	return namePrefix + "-" + namespace + "-" + name
 }
 
+// BuildSubnetName builds the name of a subnet in network networkName.
+// The default subnet of the network has an empty subnetName.
+func BuildSubnetName(networkName, subnetName string) string {
+	if subnetName == "" {
+		return networkName + "-subnet"
+	}
+	return networkName + "-subnet-" + subnetName
+}
+
 func BuildLoadBalancerName(namespace, name string) string {
 	if IsSystemNamespace(namespace) {
 		namespace = SystemTenant
@@ -59,6 +98,65 @@ func BuildPortName(namespace, podName string) string {
 	return namePrefix + "-" + namespace + "-" + podName
 }
 
+// BuildSecurityGroupName builds the name of security group for the network
+// policy of namespace.
+func BuildSecurityGroupName(namespace, policyName string) string {
+	return namePrefix + "-np-" + namespace + "-" + policyName
+}
+
+// IsPodPortName checks whether portName looks like a port built for pods by
+// BuildPortName or BuildSecondaryPortName.
+func IsPodPortName(portName string) bool {
+	return strings.HasPrefix(portName, namePrefix+"-")
+}
+
+// BuildSecondaryPortName builds the name of port for pod's additional interface
+// ifName. The interface is separated by "_", which is not allowed in pod names,
+// so that it never collides with the primary port of another pod.
+func BuildSecondaryPortName(namespace, podName, ifName string) string {
+	return BuildPortName(namespace, podName) + secondaryPortSeparator + ifName
+}
+
+// GetPrimaryPortName gets the name of pod's primary port from portName, which
+// is either the primary port or one built by BuildSecondaryPortName.
+func GetPrimaryPortName(portName string) string {
+	if i := strings.LastIndex(portName, secondaryPortSeparator); i >= 0 {
+		return portName[:i]
+	}
+	return portName
+}
+
+// BuildSecondaryIfName builds the name of pod's additional interface, e.g. eth1.
+func BuildSecondaryIfName(index int) string {
+	return fmt.Sprintf("eth%d", index)
+}
+
+// ParseNetworksAnnotation parses networks of additional interfaces from pod annotations.
+func ParseNetworksAnnotation(annotations map[string]string) []string {
+	var networks []string
+	for _, name := range strings.Split(annotations[NetworksAnnotation], ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			networks = append(networks, name)
+		}
+	}
+	return networks
+}
+
+// IsStickyPort checks whether ports of the pod should be kept when the pod is
+// stopped. The pod annotation takes precedence over the namespace one.
+func IsStickyPort(podAnnotations, namespaceAnnotations map[string]string) bool {
+	if value, ok := podAnnotations[StickyPortAnnotation]; ok {
+		return value == "true"
+	}
+	return namespaceAnnotations[StickyPortAnnotation] == "true"
+}
+
+// IsPortSecurityDisabled checks whether the pod asks for turning off port security.
+func IsPortSecurityDisabled(podAnnotations map[string]string) bool {
+	return podAnnotations[PortSecurityAnnotation] == "false"
+}
+
 func BuildFullPodName(namespace, name string) string {
 	return fmt.Sprintf("%s-%s", namespace, name)
 }
@@ -74,7 +172,8 @@ func IsSystemNamespace(ns string) bool {
 	return false
 }
 
-// NetnsSymlink make a symlink for a netns path.
+// NetnsSymlink make a symlink for a netns path. The stale symlink left by
+// previous runs is replaced.
 func NetnsSymlink(source, dest string) error {
 	dir := filepath.Dir(dest)
 	// create dst dir if not exist.
@@ -82,5 +181,8 @@ func NetnsSymlink(source, dest string) error {
 		os.MkdirAll(dir, 0750)
 	}
 
+	if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	return os.Symlink(source, dest)
 }